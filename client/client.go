@@ -0,0 +1,765 @@
+// Package client is a Go client for the Nim agent's WebSocket protocol, as
+// served by package server.
+//
+// A Conn runs one operation at a time: starting or resuming a conversation,
+// or a turn (a message, confirmation or cancellation) whose events are read
+// with an iterator:
+//
+//	conn, err := client.Dial(ctx, "ws://localhost:8080/ws")
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//
+//	if _, err := conn.NewConversation(ctx); err != nil {
+//		return err
+//	}
+//	for event, err := range conn.Send(ctx, "What's my balance?") {
+//		if err != nil {
+//			return err
+//		}
+//		switch event.Type {
+//		case "text_chunk":
+//			fmt.Print(event.Content)
+//		case "confirm_request":
+//			// Ask the user, then conn.Confirm or conn.Cancel
+//		}
+//	}
+//
+// A dropped connection is reconnected and resumed automatically: the turn
+// in flight carries on, with any events sent meanwhile replayed. If the
+// server can no longer resume it, the turn fails with ErrConnectionLost and
+// the conversation is resumed before the next operation.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// writeTimeout bounds each write to the server.
+const writeTimeout = 10 * time.Second
+
+// maxBackoff caps the delay between reconnection attempts.
+const maxBackoff = 30 * time.Second
+
+var (
+	// ErrClosed is returned by operations on a closed Conn.
+	ErrClosed = errors.New("client: connection closed")
+
+	// ErrConnectionLost is returned when the connection dropped and could
+	// not be resumed, so the outcome of the operation in flight is unknown.
+	ErrConnectionLost = errors.New("client: connection lost")
+)
+
+// Event is a message from the server.
+type Event = server.ServerMessage
+
+// ServerError is an "error" message from the server.
+type ServerError struct {
+	Code    string // one of the server.ErrCode constants
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "server error: " + e.Message
+}
+
+// turnEvents are the events an operation's iterator yields. Others, such as
+// conversation_titled, go to the event handler.
+var turnEvents = map[string]bool{
+	"message_saved":   true,
+	"text_chunk":      true,
+	"text":            true,
+	"confirm_request": true,
+	"complete":        true,
+	"stopped":         true,
+}
+
+// finalEvents end a turn.
+var finalEvents = map[string]bool{
+	"confirm_request": true,
+	"complete":        true,
+	"stopped":         true,
+}
+
+// TokenSource returns the bearer token to connect with. It is called
+// before every dial, so an expired token can be refreshed on reconnect.
+type TokenSource func(ctx context.Context) (string, error)
+
+type options struct {
+	dialer       *websocket.Dialer
+	header       http.Header
+	tokenSource  TokenSource
+	reconnects   int
+	backoff      time.Duration
+	pingTimeout  time.Duration
+	handler      func(Event)
+	onReconnect  func(resumed bool)
+	capabilities []string
+
+	encoding    string
+	compression bool
+}
+
+// Option configures a Conn.
+type Option func(*options)
+
+// WithDialer sets the WebSocket dialer. Defaults to websocket.DefaultDialer.
+func WithDialer(d *websocket.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithHeader adds headers to every dial.
+func WithHeader(h http.Header) Option {
+	return func(o *options) {
+		o.header = h.Clone()
+	}
+}
+
+// WithTokenSource authenticates every dial with a bearer token from ts.
+func WithTokenSource(ts TokenSource) Option {
+	return func(o *options) {
+		o.tokenSource = ts
+	}
+}
+
+// WithToken authenticates with a fixed bearer token.
+func WithToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithReconnect sets how many times in a row a dropped connection is
+// redialed, and the delay before the second attempt, which doubles after
+// each failure. Zero attempts disables reconnection. Defaults to 5 attempts
+// and 500 milliseconds.
+func WithReconnect(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.reconnects = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithPingTimeout reconnects when nothing, not even a ping, has arrived
+// from the server for d. It should exceed the server's ping interval.
+// Defaults to 90 seconds.
+func WithPingTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.pingTimeout = d
+	}
+}
+
+// WithCapabilities sets the protocol capabilities to ask the server for.
+// Defaults to all of server.Capabilities.
+func WithCapabilities(capabilities ...string) Option {
+	return func(o *options) {
+		o.capabilities = capabilities
+	}
+}
+
+// WithEncoding asks the server to encode messages with a subprotocol:
+// server.SubprotocolCBOR is more compact than JSON, the default. A server
+// that does not support it falls back to JSON.
+func WithEncoding(subprotocol string) Option {
+	return func(o *options) {
+		o.encoding = subprotocol
+	}
+}
+
+// WithCompression sets whether to offer permessage-deflate to the server.
+// Defaults to true.
+func WithCompression(enabled bool) Option {
+	return func(o *options) {
+		o.compression = enabled
+	}
+}
+
+// WithEventHandler receives events outside of an operation, such as
+// conversation_titled, and the rest of a turn whose iteration was stopped
+// early. It may be called from several goroutines.
+func WithEventHandler(fn func(Event)) Option {
+	return func(o *options) {
+		o.handler = fn
+	}
+}
+
+// WithReconnectHandler is called after each reconnection, reporting
+// whether the previous connection was resumed.
+func WithReconnectHandler(fn func(resumed bool)) Option {
+	return func(o *options) {
+		o.onReconnect = fn
+	}
+}
+
+// Conn is a connection to a Nim agent server. It is safe for concurrent
+// use; operations wait for the one in progress to finish.
+type Conn struct {
+	url  string
+	opts options
+
+	ctx    context.Context // canceled by Close, to stop reconnecting
+	cancel context.CancelFunc
+	sem    chan struct{} // held by the operation in progress
+	done   chan struct{} // closed when the reader exits
+	wmu    sync.Mutex    // serializes writes
+
+	mu             sync.Mutex
+	ws             *websocket.Conn
+	token          string // resume token
+	lastSeq        int64
+	conversationID string
+	stale          bool // the server lost the conversation with the connection
+	capabilities   []string
+	op             *operation
+	closed         bool
+	err            error // why the reader exited
+}
+
+// operation receives the events read while it is in progress.
+type operation struct {
+	events chan Event
+	done   chan struct{}
+}
+
+// Dial connects to the server's WebSocket endpoint at url.
+func Dial(ctx context.Context, url string, opts ...Option) (*Conn, error) {
+	o := options{
+		dialer:       websocket.DefaultDialer,
+		reconnects:   5,
+		backoff:      500 * time.Millisecond,
+		pingTimeout:  90 * time.Second,
+		capabilities: server.Capabilities,
+		compression:  true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Conn{
+		url:  url,
+		opts: o,
+		sem:  make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	ws, hs, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.ws = ws
+	c.token = hs.resumeToken
+	c.capabilities = hs.capabilities
+
+	go c.run(ws)
+	return c, nil
+}
+
+// Capabilities returns the capabilities agreed with the server.
+func (c *Conn) Capabilities() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capabilities
+}
+
+// ConversationID returns the current conversation's ID, or "" if there is
+// none.
+func (c *Conn) ConversationID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conversationID
+}
+
+// NewConversation starts a conversation and returns its ID.
+func (c *Conn) NewConversation(ctx context.Context) (string, error) {
+	op, err := c.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.end(op)
+
+	ev, err := c.call(ctx, op, server.ClientMessage{Type: "new_conversation"}, "conversation_started")
+	if err != nil {
+		return "", err
+	}
+	c.setConversation(ev.ConversationID)
+	return ev.ConversationID, nil
+}
+
+// Resume continues an existing conversation and returns its messages.
+func (c *Conn) Resume(ctx context.Context, conversationID string) ([]store.StoredMessage, error) {
+	op, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.end(op)
+
+	ev, err := c.call(ctx, op, server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID}, "conversation_resumed")
+	if err != nil {
+		return nil, err
+	}
+	c.setConversation(conversationID)
+	return ev.Messages, nil
+}
+
+// ListConversations returns a page of the user's conversations, most
+// recently updated first, and the cursor of the next page, or "" if this
+// is the last. A limit of zero uses the server's default page size.
+func (c *Conn) ListConversations(ctx context.Context, limit int, cursor string) ([]*store.Conversation, string, error) {
+	op, err := c.begin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer c.end(op)
+
+	ev, err := c.call(ctx, op, server.ClientMessage{Type: "list_conversations", Limit: limit, Cursor: cursor}, "conversations")
+	if err != nil {
+		return nil, "", err
+	}
+	return ev.Conversations, ev.NextCursor, nil
+}
+
+// Send sends a message in the current conversation and returns the turn's
+// events. The iterator ends after a complete, confirm_request or stopped
+// event, or an error; a server "error" message is yielded as a
+// *ServerError.
+func (c *Conn) Send(ctx context.Context, content string) iter.Seq2[Event, error] {
+	return c.turn(ctx, server.ClientMessage{Type: "message", Content: content})
+}
+
+// Confirm approves a pending action and returns the turn's events.
+func (c *Conn) Confirm(ctx context.Context, actionID string) iter.Seq2[Event, error] {
+	return c.turn(ctx, server.ClientMessage{Type: "confirm", ActionID: actionID})
+}
+
+// Cancel rejects a pending action and returns the turn's events.
+func (c *Conn) Cancel(ctx context.Context, actionID string) iter.Seq2[Event, error] {
+	return c.turn(ctx, server.ClientMessage{Type: "cancel", ActionID: actionID})
+}
+
+// Stop asks the server to stop the turn in flight, which then ends with a
+// stopped event. It does not wait for the operation lock.
+func (c *Conn) Stop() error {
+	return c.write(server.ClientMessage{Type: "stop"})
+}
+
+// Close closes the connection. The server discards it, stopping any turn
+// in flight.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.done
+		return nil
+	}
+	c.closed = true
+	ws := c.ws
+	c.mu.Unlock()
+
+	c.cancel()
+
+	// Best effort: the socket may already have dropped
+	c.wmu.Lock()
+	ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+	c.wmu.Unlock()
+	ws.Close()
+
+	<-c.done
+	return nil
+}
+
+// turn returns an iterator that runs msg as a turn.
+func (c *Conn) turn(ctx context.Context, msg server.ClientMessage) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		op, err := c.begin(ctx)
+		if err != nil {
+			yield(Event{}, err)
+			return
+		}
+		defer c.end(op)
+
+		if err := c.resumeStale(ctx, op); err != nil {
+			yield(Event{}, err)
+			return
+		}
+		if err := c.write(msg); err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		for {
+			ev, err := c.next(ctx, op)
+			if err != nil {
+				yield(ev, err)
+				return
+			}
+			if !turnEvents[ev.Type] {
+				c.handle(ev)
+				continue
+			}
+			if !yield(ev, nil) || finalEvents[ev.Type] {
+				return
+			}
+		}
+	}
+}
+
+// call writes msg and waits for an event of type want.
+func (c *Conn) call(ctx context.Context, op *operation, msg server.ClientMessage, want string) (Event, error) {
+	if err := c.write(msg); err != nil {
+		return Event{}, err
+	}
+	for {
+		ev, err := c.next(ctx, op)
+		if err != nil {
+			return ev, err
+		}
+		if ev.Type == want {
+			return ev, nil
+		}
+		c.handle(ev)
+	}
+}
+
+// resumeStale resumes the conversation if the server lost it along with a
+// connection that could not be resumed.
+func (c *Conn) resumeStale(ctx context.Context, op *operation) error {
+	c.mu.Lock()
+	stale, conversationID := c.stale, c.conversationID
+	c.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	if _, err := c.call(ctx, op, server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID}, "conversation_resumed"); err != nil {
+		return fmt.Errorf("resume conversation: %w", err)
+	}
+	c.setConversation(conversationID)
+	return nil
+}
+
+func (c *Conn) setConversation(conversationID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conversationID = conversationID
+	c.stale = false
+}
+
+// begin waits for the operation in progress, if any, and starts one.
+func (c *Conn) begin(ctx context.Context) (*operation, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.failure()
+	}
+
+	op := &operation{events: make(chan Event), done: make(chan struct{})}
+	c.mu.Lock()
+	c.op = op
+	c.mu.Unlock()
+	return op, nil
+}
+
+func (c *Conn) end(op *operation) {
+	c.mu.Lock()
+	c.op = nil
+	c.mu.Unlock()
+	close(op.done)
+	<-c.sem
+}
+
+// next waits for the operation's next event. A server "error" message is
+// returned as a *ServerError.
+func (c *Conn) next(ctx context.Context, op *operation) (Event, error) {
+	select {
+	case ev := <-op.events:
+		switch ev.Type {
+		case "error":
+			return ev, &ServerError{Code: ev.Code, Message: ev.Content}
+		case "resume_failed":
+			return ev, ErrConnectionLost
+		}
+		return ev, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	case <-c.done:
+		return Event{}, c.failure()
+	}
+}
+
+// failure returns why the reader exited.
+func (c *Conn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// write sends msg on the current socket.
+func (c *Conn) write(msg server.ClientMessage) error {
+	c.mu.Lock()
+	ws := c.ws
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writeMessage(ws, msg)
+}
+
+// writeMessage encodes msg for the socket's subprotocol and sends it.
+func writeMessage(ws *websocket.Conn, msg server.ClientMessage) error {
+	codec := server.CodecFor(ws.Subprotocol())
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return ws.WriteMessage(codec.FrameType, data)
+}
+
+// handshake is what the server said when a socket was opened.
+type handshake struct {
+	resumeToken  string
+	capabilities []string
+}
+
+// dial opens a socket, reads the server's greeting and negotiates the
+// protocol.
+func (c *Conn) dial(ctx context.Context) (*websocket.Conn, handshake, error) {
+	header := c.opts.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.opts.tokenSource != nil {
+		token, err := c.opts.tokenSource(ctx)
+		if err != nil {
+			return nil, handshake{}, fmt.Errorf("get token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	dialer := *c.opts.dialer
+	dialer.EnableCompression = c.opts.compression
+	if c.opts.encoding != "" {
+		dialer.Subprotocols = []string{c.opts.encoding}
+	}
+	ws, resp, err := dialer.DialContext(ctx, c.url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, handshake{}, fmt.Errorf("dial %s: %w (status %d)", c.url, err, resp.StatusCode)
+		}
+		return nil, handshake{}, fmt.Errorf("dial %s: %w", c.url, err)
+	}
+
+	// Answer pings, and treat silence as a dropped connection
+	ws.SetPingHandler(func(data string) error {
+		ws.SetReadDeadline(time.Now().Add(c.opts.pingTimeout))
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	hs, err := c.greet(ws)
+	if err != nil {
+		ws.Close()
+		return nil, handshake{}, err
+	}
+	return ws, hs, nil
+}
+
+// greet reads the server's greeting and says hello.
+func (c *Conn) greet(ws *websocket.Conn) (handshake, error) {
+	greeting, err := c.read(ws)
+	if err != nil {
+		return handshake{}, fmt.Errorf("read greeting: %w", err)
+	}
+	if greeting.Type != "connected" {
+		return handshake{}, fmt.Errorf("unexpected greeting %q", greeting.Type)
+	}
+
+	hello := server.ClientMessage{Type: "hello", Version: server.ProtocolVersion, Capabilities: c.opts.capabilities}
+	if err := writeMessage(ws, hello); err != nil {
+		return handshake{}, err
+	}
+	reply, err := c.read(ws)
+	if err != nil {
+		return handshake{}, fmt.Errorf("read hello: %w", err)
+	}
+	switch reply.Type {
+	case "hello":
+		return handshake{resumeToken: greeting.ResumeToken, capabilities: reply.Capabilities}, nil
+	case "error":
+		// Servers from before the handshake reject hello without a code,
+		// and treat every client as having all capabilities
+		if reply.Code == "" {
+			return handshake{resumeToken: greeting.ResumeToken, capabilities: server.Capabilities}, nil
+		}
+		return handshake{}, &ServerError{Code: reply.Code, Message: reply.Content}
+	default:
+		return handshake{}, fmt.Errorf("unexpected reply to hello %q", reply.Type)
+	}
+}
+
+// read reads the next event from ws.
+func (c *Conn) read(ws *websocket.Conn) (Event, error) {
+	ws.SetReadDeadline(time.Now().Add(c.opts.pingTimeout))
+	var ev Event
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return ev, err
+	}
+	if err := server.CodecFor(ws.Subprotocol()).Unmarshal(data, &ev); err != nil {
+		return ev, fmt.Errorf("decode event: %w", err)
+	}
+	return ev, nil
+}
+
+// run reads events until the connection is closed or cannot be
+// reconnected.
+func (c *Conn) run(ws *websocket.Conn) {
+	defer close(c.done)
+	defer c.cancel()
+
+	for {
+		ev, err := c.read(ws)
+		if err == nil {
+			c.dispatch(ev)
+			continue
+		}
+
+		ws.Close()
+		if c.isClosed() {
+			return
+		}
+		if ws, err = c.reconnect(err); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// dispatch passes ev to the operation in progress, or the event handler.
+// Replayed events already seen are dropped.
+func (c *Conn) dispatch(ev Event) {
+	c.mu.Lock()
+	if ev.Seq > 0 {
+		if ev.Seq <= c.lastSeq {
+			c.mu.Unlock()
+			return
+		}
+		c.lastSeq = ev.Seq
+	}
+	op := c.op
+	c.mu.Unlock()
+
+	if op != nil {
+		select {
+		case op.events <- ev:
+			return
+		case <-op.done:
+		}
+	}
+	c.handle(ev)
+}
+
+func (c *Conn) handle(ev Event) {
+	if c.opts.handler != nil {
+		c.opts.handler(ev)
+	}
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// reconnect dials a new socket and resumes the connection on it, retrying
+// with backoff. If the server cannot resume it, the new socket's connection
+// is used instead, and the operation in progress fails.
+func (c *Conn) reconnect(cause error) (*websocket.Conn, error) {
+	lastErr := cause
+	backoff := c.opts.backoff
+	for attempt := 0; attempt < c.opts.reconnects; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-c.ctx.Done():
+				return nil, ErrClosed
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		ws, hs, err := c.dial(c.ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		resume := server.ClientMessage{Type: "resume", ResumeToken: c.token, LastSeq: c.lastSeq}
+		c.mu.Unlock()
+		if err := writeMessage(ws, resume); err != nil {
+			ws.Close()
+			lastErr = err
+			continue
+		}
+		reply, err := c.read(ws)
+		if err != nil {
+			ws.Close()
+			lastErr = err
+			continue
+		}
+		resumed := reply.Type == "resumed"
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			ws.Close()
+			return nil, ErrClosed
+		}
+		c.ws = ws
+		c.capabilities = hs.capabilities
+		if !resumed {
+			c.token = hs.resumeToken
+			c.lastSeq = 0
+			c.stale = c.conversationID != ""
+		}
+		c.mu.Unlock()
+
+		if !resumed {
+			c.dispatch(reply)
+		}
+		if c.opts.onReconnect != nil {
+			c.opts.onReconnect(resumed)
+		}
+		return ws, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrConnectionLost, lastErr)
+}
@@ -0,0 +1,581 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/client"
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/tools"
+)
+
+// newServer starts a Nim server backed by llm, returning its WebSocket URL.
+func newServer(t *testing.T, llm *llmtest.Server, cfg server.Config) (*server.Server, string) {
+	t.Helper()
+
+	cfg.AnthropicKey = "test-key"
+	cfg.BaseURL = llm.URL
+	cfg.Titles.Disabled = true
+	cfg.AllowSharedUser = true
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	front := httptest.NewServer(srv.Handler())
+	t.Cleanup(front.Close)
+	return srv, "ws" + strings.TrimPrefix(front.URL, "http")
+}
+
+// dropper is a dialer whose connections the test can cut, like a network
+// failure.
+type dropper struct {
+	mu     sync.Mutex
+	conns  []net.Conn
+	refuse int // dials to fail
+}
+
+func (d *dropper) dialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d.mu.Lock()
+			if d.refuse > 0 {
+				d.refuse--
+				d.mu.Unlock()
+				return nil, errors.New("network unreachable")
+			}
+			d.mu.Unlock()
+
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err == nil {
+				d.mu.Lock()
+				d.conns = append(d.conns, conn)
+				d.mu.Unlock()
+			}
+			return conn, err
+		},
+	}
+}
+
+// drop cuts every connection and fails the next refuse dials.
+func (d *dropper) drop(refuse int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refuse = refuse
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
+
+// collect runs a turn to the end, returning its events by type and the
+// text streamed.
+func collect(t *testing.T, events func(func(client.Event, error) bool)) (map[string]client.Event, string, error) {
+	t.Helper()
+	got := map[string]client.Event{}
+	var text strings.Builder
+	for ev, err := range events {
+		if err != nil {
+			return got, text.String(), err
+		}
+		got[ev.Type] = ev
+		if ev.Type == "text_chunk" {
+			text.WriteString(ev.Content)
+		}
+	}
+	return got, text.String(), nil
+}
+
+func TestConn_SendStreamsTurn(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	llm.Script(llmtest.Reply{Text: "Your balance is $10."})
+	_, url := newServer(t, llm, server.Config{})
+
+	conn, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	id, err := conn.NewConversation(ctx)
+	if err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+	if id == "" || conn.ConversationID() != id {
+		t.Errorf("conversation ID = %q, ConversationID() = %q", id, conn.ConversationID())
+	}
+
+	got, text, err := collect(t, conn.Send(ctx, "What's my balance?"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if text != "Your balance is $10." {
+		t.Errorf("streamed text = %q", text)
+	}
+	for _, typ := range []string{"message_saved", "text", "complete"} {
+		if _, ok := got[typ]; !ok {
+			t.Errorf("no %s event in %v", typ, got)
+		}
+	}
+	if usage := got["complete"].TokenUsage; usage == nil {
+		t.Error("complete has no token usage")
+	} else if usage.InputTokens != 10 || usage.CacheCreationInputTokens != 3 || usage.CacheReadInputTokens != 7 {
+		t.Errorf("token usage = %+v, want 10 input tokens with 3 written to and 7 read from the cache", usage)
+	}
+
+	// Resuming on a new connection returns the stored history
+	other, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer other.Close()
+	messages, err := other.Resume(ctx, id)
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Content != "What's my balance?" {
+		t.Errorf("messages = %+v", messages)
+	}
+
+	conversations, next, err := other.ListConversations(ctx, 10, "")
+	if err != nil {
+		t.Fatalf("ListConversations: %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].ID != id || next != "" {
+		t.Errorf("conversations = %+v, next = %q", conversations, next)
+	}
+}
+
+func TestConn_CBORCompressedCoalesced(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	text := strings.Repeat("Your balance is $10. ", 20)
+	llm.Script(llmtest.Reply{Text: text})
+	_, url := newServer(t, llm, server.Config{
+		Connection: server.ConnectionConfig{CompressionThreshold: 1, ChunkFlushInterval: time.Hour, ChunkFlushSize: 100},
+	})
+
+	// The server picks CBOR, compressed, when offered
+	dialer := websocket.Dialer{Subprotocols: []string{server.SubprotocolJSON, server.SubprotocolCBOR}, EnableCompression: true}
+	ws, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	frameType, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read greeting: %v", err)
+	}
+	if ws.Subprotocol() != server.SubprotocolCBOR || !strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Errorf("subprotocol %q, extensions %q", ws.Subprotocol(), resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+	var greeting client.Event
+	if err := server.CodecFor(server.SubprotocolCBOR).Unmarshal(data, &greeting); frameType != websocket.BinaryMessage || err != nil || greeting.Type != "connected" {
+		t.Errorf("greeting: frame type %d, %+v, %v", frameType, greeting, err)
+	}
+
+	// A message that is not CBOR gets the same error code as invalid JSON
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte{0x1c}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, data, err = ws.ReadMessage()
+	ws.Close()
+	var reply client.Event
+	if err == nil {
+		err = server.CodecFor(server.SubprotocolCBOR).Unmarshal(data, &reply)
+	}
+	if err != nil || reply.Type != "error" || reply.Code != server.ErrCodeInvalidMessage {
+		t.Errorf("reply to invalid CBOR = %+v, %v, want error %s", reply, err, server.ErrCodeInvalidMessage)
+	}
+
+	conn, err := client.Dial(ctx, url, client.WithEncoding(server.SubprotocolCBOR))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.NewConversation(ctx); err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+
+	chunks := 0
+	var streamed strings.Builder
+	for ev, err := range conn.Send(ctx, "What's my balance?") {
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if ev.Type == "text_chunk" {
+			chunks++
+			streamed.WriteString(ev.Content)
+		}
+	}
+	if streamed.String() != text {
+		t.Errorf("streamed text = %q", streamed.String())
+	}
+	// 80 words, coalesced into chunks of at least 100 bytes
+	if chunks < 2 || chunks > len(text)/100+1 {
+		t.Errorf("got %d text_chunk events, want chunks of about 100 bytes", chunks)
+	}
+}
+
+func TestConn_ConfirmAndCancel(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{})
+
+	var paid atomic.Int32
+	srv.AddTool(tools.New("pay").
+		RequiresConfirmation().
+		SummaryTemplate("Pay someone").
+		HandlerFunc(func(ctx context.Context, input json.RawMessage) (interface{}, error) {
+			paid.Add(1)
+			return map[string]string{"status": "sent"}, nil
+		}).
+		Build())
+
+	conn, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.NewConversation(ctx); err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+
+	llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":5}`})
+	got, _, err := collect(t, conn.Send(ctx, "Pay Alice $5"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	request, ok := got["confirm_request"]
+	if !ok || request.ActionID == "" || request.Tool != "pay" {
+		t.Fatalf("confirm_request = %+v", request)
+	}
+
+	got, _, err = collect(t, conn.Confirm(ctx, request.ActionID))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if _, ok := got["complete"]; !ok || paid.Load() != 1 {
+		t.Errorf("confirm: events %v, paid %d times", got, paid.Load())
+	}
+	if tools := got["complete"].Tools; len(tools) != 1 || tools[0].Tool != "pay" {
+		t.Errorf("confirm: tools = %+v, want the pay execution", tools)
+	}
+
+	llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":7}`})
+	got, _, err = collect(t, conn.Send(ctx, "Pay Bob $7"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got, _, err = collect(t, conn.Cancel(ctx, got["confirm_request"].ActionID))
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if got["text"].Content != "Action cancelled." || paid.Load() != 1 {
+		t.Errorf("cancel: events %v, paid %d times", got, paid.Load())
+	}
+}
+
+func TestConn_ServerError(t *testing.T) {
+	ctx := context.Background()
+	_, url := newServer(t, llmtest.New(t), server.Config{})
+
+	conn, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	_, _, err = collect(t, conn.Send(ctx, "hello"))
+	var serverErr *client.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != server.ErrCodeNoConversation {
+		t.Fatalf("Send without a conversation: err = %v, want a *ServerError with code %s", err, server.ErrCodeNoConversation)
+	}
+
+	if _, err := conn.Resume(ctx, "missing"); !errors.As(err, &serverErr) || serverErr.Code != server.ErrCodeNotFound {
+		t.Errorf("Resume: err = %v, want a *ServerError with code %s", err, server.ErrCodeNotFound)
+	}
+	if _, err := conn.Resume(ctx, ""); !errors.As(err, &serverErr) || serverErr.Code != server.ErrCodeMissingField {
+		t.Errorf("Resume without an ID: err = %v, want a *ServerError with code %s", err, server.ErrCodeMissingField)
+	}
+}
+
+func TestConn_Capabilities(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	llm.Script(llmtest.Reply{Text: "Hi."})
+	_, url := newServer(t, llm, server.Config{})
+
+	conn, err := client.Dial(ctx, url, client.WithCapabilities(server.CapabilityEvents, "telepathy"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.Capabilities(); len(got) != 1 || got[0] != server.CapabilityEvents {
+		t.Errorf("Capabilities() = %v, want [%s]", got, server.CapabilityEvents)
+	}
+
+	if _, err := conn.NewConversation(ctx); err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+	got, _, err := collect(t, conn.Send(ctx, "hello"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, ok := got["text_chunk"]; ok {
+		t.Error("got text_chunk without the streaming capability")
+	}
+	if got["text"].Content != "Hi." {
+		t.Errorf("text = %q", got["text"].Content)
+	}
+}
+
+// TestProtocol_Validation checks the server's validation of raw messages:
+// strict for clients that said hello, lenient for those that did not.
+func TestProtocol_Validation(t *testing.T) {
+	_, url := newServer(t, llmtest.New(t), server.Config{})
+
+	// roundTrip sends each message and returns the reply to the last.
+	roundTrip := func(t *testing.T, messages ...string) client.Event {
+		t.Helper()
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer ws.Close()
+
+		var ev client.Event
+		if err := ws.ReadJSON(&ev); err != nil || ev.Type != "connected" {
+			t.Fatalf("greeting = %+v, %v", ev, err)
+		}
+		if ev.Version != server.ProtocolVersion {
+			t.Errorf("greeting version = %d", ev.Version)
+		}
+		for _, msg := range messages {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := ws.ReadJSON(&ev); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+		}
+		return ev
+	}
+
+	hello := `{"type":"hello","version":1}`
+	tests := []struct {
+		name     string
+		messages []string
+		code     string
+		field    string
+	}{
+		{"invalid JSON", []string{`{"type":`}, server.ErrCodeInvalidMessage, ""},
+		{"unknown type", []string{`{"type":"dance"}`}, server.ErrCodeUnknownType, "type"},
+		{"wrong field type", []string{`{"type":"message","content":42}`}, server.ErrCodeInvalidField, "content"},
+		{"missing field", []string{`{"type":"rename_conversation","conversationId":"c"}`}, server.ErrCodeMissingField, "title"},
+		{"bad enum", []string{`{"type":"list_conversations","sort":"random"}`}, server.ErrCodeInvalidField, "sort"},
+		{"hello without version", []string{`{"type":"hello"}`}, server.ErrCodeMissingField, "version"},
+		{"hello twice", []string{hello, hello}, server.ErrCodeOutOfOrder, ""},
+		{"resume late", []string{`{"type":"new_conversation"}`, `{"type":"resume","resumeToken":"x"}`}, server.ErrCodeOutOfOrder, ""},
+		{"unknown field", []string{hello, `{"type":"new_conversation","colour":"blue"}`}, server.ErrCodeUnknownField, "colour"},
+		{"unexpected field", []string{hello, `{"type":"stop","content":"now"}`}, server.ErrCodeUnexpectedField, "content"},
+		{"batch without capability", []string{hello, `{"type":"new_conversation"}`, `{"type":"confirm","actionIds":["a"]}`}, server.ErrCodeCapabilityRequired, ""},
+		{"repeated batch IDs", []string{`{"type":"confirm","actionIds":["a","a"]}`}, server.ErrCodeInvalidField, "actionIds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := roundTrip(t, tt.messages...)
+			if ev.Type != "error" || ev.Code != tt.code || ev.Field != tt.field {
+				t.Errorf("reply = %+v, want error %s on %q", ev, tt.code, tt.field)
+			}
+		})
+	}
+
+	t.Run("lenient without hello", func(t *testing.T) {
+		ev := roundTrip(t, `{"type":"new_conversation","colour":"blue"}`)
+		if ev.Type != "conversation_started" {
+			t.Errorf("reply = %+v, want conversation_started", ev)
+		}
+	})
+
+	t.Run("hello negotiates", func(t *testing.T) {
+		ev := roundTrip(t, `{"type":"hello","version":7,"capabilities":["streaming","telepathy"]}`)
+		if ev.Type != "hello" || ev.Seq != 0 || ev.Version != server.ProtocolVersion || len(ev.Capabilities) != 1 || ev.Capabilities[0] != server.CapabilityStreaming {
+			t.Errorf("reply = %+v", ev)
+		}
+	})
+}
+
+func TestConn_Stop(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	release := make(chan struct{})
+	defer close(release)
+	llm.Script(llmtest.Reply{Text: "A very long answer", Release: release})
+	_, url := newServer(t, llm, server.Config{})
+
+	conn, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.NewConversation(ctx); err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+
+	var types []string
+	for ev, err := range conn.Send(ctx, "Tell me everything") {
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		types = append(types, ev.Type)
+		if ev.Type == "text_chunk" {
+			if err := conn.Stop(); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+		}
+	}
+	if types[len(types)-1] != "stopped" {
+		t.Errorf("events = %v, want stopped last", types)
+	}
+}
+
+func TestConn_ReconnectResumesTurn(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	release := make(chan struct{})
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+
+	var tokens atomic.Int32
+	_, url := newServer(t, llm, server.Config{
+		AuthFunc: func(r *http.Request) (string, error) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+				return "", errors.New("missing token")
+			}
+			return "alice", nil
+		},
+	})
+
+	d := &dropper{}
+	reconnected := make(chan bool, 1)
+	conn, err := client.Dial(ctx, url,
+		client.WithDialer(d.dialer()),
+		client.WithReconnect(5, 10*time.Millisecond),
+		client.WithTokenSource(func(ctx context.Context) (string, error) {
+			return fmt.Sprintf("token-%d", tokens.Add(1)), nil
+		}),
+		client.WithReconnectHandler(func(resumed bool) { reconnected <- resumed }),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.NewConversation(ctx); err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+
+	var text strings.Builder
+	for ev, err := range conn.Send(ctx, "What's my balance?") {
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if ev.Type != "text_chunk" {
+			continue
+		}
+		text.WriteString(ev.Content)
+		if text.Len() == len(ev.Content) {
+			// Drop the connection mid-turn, then let the turn finish
+			// while the client reconnects
+			d.drop(0)
+			close(release)
+			if resumed := <-reconnected; !resumed {
+				t.Fatal("connection was not resumed")
+			}
+		}
+	}
+
+	if text.String() != "Your balance is $10." {
+		t.Errorf("streamed text = %q, want every chunk exactly once", text.String())
+	}
+	if tokens.Load() != 2 {
+		t.Errorf("token source called %d times, want once per dial", tokens.Load())
+	}
+}
+
+func TestConn_ReconnectAfterResumeWindow(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{
+		Connection: server.ConnectionConfig{ResumeWindow: time.Millisecond},
+	})
+
+	d := &dropper{}
+	reconnected := make(chan bool, 1)
+	conn, err := client.Dial(ctx, url,
+		client.WithDialer(d.dialer()),
+		client.WithReconnect(5, 50*time.Millisecond),
+		client.WithReconnectHandler(func(resumed bool) { reconnected <- resumed }),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	id, err := conn.NewConversation(ctx)
+	if err != nil {
+		t.Fatalf("NewConversation: %v", err)
+	}
+
+	// The server's connection expires while the client's first redial
+	// fails, so the conversation is resumed on a new one before the next
+	// message
+	d.drop(1)
+	if resumed := <-reconnected; resumed {
+		t.Fatal("connection was resumed after the resume window")
+	}
+
+	llm.Script(llmtest.Reply{Text: "Still here."})
+	_, text, err := collect(t, conn.Send(ctx, "Are you there?"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if text != "Still here." || conn.ConversationID() != id {
+		t.Errorf("text = %q, conversation = %q, want %q", text, conn.ConversationID(), id)
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	ctx := context.Background()
+	_, url := newServer(t, llmtest.New(t), server.Config{})
+
+	conn, err := client.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := conn.NewConversation(ctx); !errors.Is(err, client.ErrClosed) {
+		t.Errorf("NewConversation after Close: err = %v, want %v", err, client.ErrClosed)
+	}
+}
@@ -0,0 +1,148 @@
+// Command nim-chat is a terminal chat client for debugging Nim agents. It
+// streams replies, shows the tools each turn ran and how long they took,
+// and asks before running actions that need confirmation.
+//
+// Connect to a running server:
+//
+//	nim-chat -url ws://localhost:8080/ws -token $TOKEN
+//
+// Or run an agent in process, with the Liminal tools if LIMINAL_JWT is set:
+//
+//	ANTHROPIC_API_KEY=... nim-chat -embed
+//
+// Type /help at the prompt for commands. Ctrl-C stops a reply in progress,
+// or quits at the prompt.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/becomeliminal/nim-go-sdk/client"
+	"github.com/becomeliminal/nim-go-sdk/executor"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/tools"
+)
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "WebSocket URL of the server")
+	token := flag.String("token", os.Getenv("NIM_TOKEN"), "bearer token (default $NIM_TOKEN)")
+	conversation := flag.String("conversation", "", "resume this conversation instead of starting one")
+	embed := flag.Bool("embed", false, "run an agent in process instead of connecting to a server; needs ANTHROPIC_API_KEY")
+	model := flag.String("model", "", "model for -embed (default: the engine's)")
+	system := flag.String("system", "", "system prompt for -embed (default: the engine's)")
+	verbose := flag.Bool("v", false, "log the embedded server to stderr")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	if *embed {
+		addr, stop, err := startEmbedded(*model, *system, *verbose)
+		if err != nil {
+			fatal(err)
+		}
+		defer stop()
+		*url = "ws://" + addr + "/ws"
+	}
+
+	var opts []client.Option
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	conn, err := client.Dial(ctx, *url, opts...)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	r := newREPL(conn, bufio.NewScanner(os.Stdin), os.Stdout)
+
+	// Ctrl-C stops the reply in progress, or quits at the prompt
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			if r.busy.Load() {
+				conn.Stop()
+				continue
+			}
+			fmt.Fprintln(os.Stdout)
+			conn.Close()
+			os.Exit(130)
+		}
+	}()
+
+	if err := r.run(ctx, *conversation); err != nil {
+		fatal(err)
+	}
+}
+
+// startEmbedded serves an agent on a loopback port, returning its address
+// and a function that stops it.
+func startEmbedded(model, system string, verbose bool) (string, func(), error) {
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		return "", nil, fmt.Errorf("-embed needs ANTHROPIC_API_KEY")
+	}
+
+	// Logs would interleave with the chat
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if verbose {
+		logger = slog.New(logging.NewRedactingHandler(slog.NewTextHandler(os.Stderr, nil), logging.DefaultRedactionPolicy()))
+	}
+
+	cfg := server.Config{
+		AnthropicKey: key,
+		Model:        model,
+		SystemPrompt: system,
+		Logger:       logger,
+		// The terminal has one user
+		AllowSharedUser: true,
+	}
+
+	// With a token, the Liminal tools act as its user
+	var liminal *executor.HTTPExecutor
+	if jwt := os.Getenv("LIMINAL_JWT"); jwt != "" {
+		baseURL := os.Getenv("LIMINAL_BASE_URL")
+		if baseURL == "" {
+			baseURL = "https://api.liminal.cash"
+		}
+		liminal = executor.NewHTTPExecutor(executor.HTTPExecutorConfig{BaseURL: baseURL, JWTToken: jwt})
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	srv.AddTool(tools.NewThinkTool())
+	if liminal != nil {
+		srv.AddTools(tools.LiminalTools(liminal)...)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	httpServer := &http.Server{Handler: srv.Handler()}
+	go httpServer.Serve(ln)
+
+	stop := func() {
+		httpServer.Close()
+		srv.Close()
+	}
+	return ln.Addr().String(), stop, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "nim-chat:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/client"
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/server"
+)
+
+const help = `Commands:
+  /new            start a new conversation
+  /resume <id>    resume a conversation and print it
+  /list           list your conversations
+  /tokens         show tokens used in this conversation
+  /export [file]  save this conversation as JSON (default <id>.json, - for stdout)
+  /help           show this help
+  /quit           exit`
+
+// entry is a message in the transcript kept for /export.
+type entry struct {
+	Role    string               `json:"role"`
+	Content string               `json:"content"`
+	Tools   []core.ToolExecution `json:"tools,omitempty"`
+}
+
+// repl reads messages and commands, one line at a time, and renders the
+// agent's replies.
+type repl struct {
+	conn *client.Conn
+	in   *bufio.Scanner
+	out  io.Writer
+
+	// busy is set while a reply is streaming, so Ctrl-C stops it.
+	busy atomic.Bool
+
+	usage      server.TokenUsage
+	turns      int
+	transcript []entry
+}
+
+func newREPL(conn *client.Conn, in *bufio.Scanner, out io.Writer) *repl {
+	return &repl{conn: conn, in: in, out: out}
+}
+
+// run starts or resumes a conversation, then chats until /quit or the end
+// of input.
+func (r *repl) run(ctx context.Context, conversationID string) error {
+	if conversationID != "" {
+		if err := r.resume(ctx, conversationID); err != nil {
+			return err
+		}
+	} else if err := r.newConversation(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "Type a message, or /help for commands.")
+
+	for {
+		line, ok := r.prompt("> ")
+		if !ok {
+			return nil
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			quit, err := r.command(ctx, line)
+			if errors.Is(err, client.ErrClosed) || errors.Is(err, client.ErrConnectionLost) {
+				return err
+			}
+			if err != nil {
+				fmt.Fprintln(r.out, "error:", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		r.send(ctx, line)
+	}
+}
+
+// prompt prints p and reads a trimmed line. It returns false at the end of
+// input.
+func (r *repl) prompt(p string) (string, bool) {
+	fmt.Fprint(r.out, p)
+	if !r.in.Scan() {
+		fmt.Fprintln(r.out)
+		return "", false
+	}
+	return strings.TrimSpace(r.in.Text()), true
+}
+
+// command runs a slash command, reporting whether to quit.
+func (r *repl) command(ctx context.Context, line string) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/new":
+		return false, r.newConversation(ctx)
+
+	case "/resume":
+		if arg == "" {
+			return false, errors.New("usage: /resume <id>")
+		}
+		return false, r.resume(ctx, arg)
+
+	case "/list":
+		return false, r.list(ctx)
+
+	case "/tokens":
+		fmt.Fprintf(r.out, "%d turns: %d input + %d output = %d tokens\n",
+			r.turns, r.usage.InputTokens, r.usage.OutputTokens, r.usage.TotalTokens)
+		return false, nil
+
+	case "/export":
+		return false, r.export(arg)
+
+	case "/help":
+		fmt.Fprintln(r.out, help)
+		return false, nil
+
+	case "/quit", "/exit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command %s; try /help", name)
+	}
+}
+
+func (r *repl) newConversation(ctx context.Context) error {
+	id, err := r.conn.NewConversation(ctx)
+	if err != nil {
+		return err
+	}
+	r.reset()
+	fmt.Fprintf(r.out, "Started conversation %s\n", id)
+	return nil
+}
+
+func (r *repl) resume(ctx context.Context, id string) error {
+	messages, err := r.conn.Resume(ctx, id)
+	if err != nil {
+		return err
+	}
+	r.reset()
+
+	fmt.Fprintf(r.out, "Resumed conversation %s\n", id)
+	for _, msg := range messages {
+		// Tool results are stored as user messages without text
+		if msg.Content == "" {
+			continue
+		}
+		r.transcript = append(r.transcript, entry{Role: msg.Role, Content: msg.Content, Tools: msg.Tools})
+		if msg.Role == string(core.RoleUser) {
+			fmt.Fprintf(r.out, "> %s\n", msg.Content)
+		} else {
+			fmt.Fprintln(r.out, msg.Content)
+		}
+	}
+	return nil
+}
+
+func (r *repl) list(ctx context.Context) error {
+	conversations, _, err := r.conn.ListConversations(ctx, 20, "")
+	if err != nil {
+		return err
+	}
+	if len(conversations) == 0 {
+		fmt.Fprintln(r.out, "No conversations.")
+		return nil
+	}
+
+	current := r.conn.ConversationID()
+	for _, conv := range conversations {
+		marker := " "
+		if conv.ID == current {
+			marker = "*"
+		}
+		fmt.Fprintf(r.out, "%s %s  %s  %s\n", marker, conv.ID, conv.UpdatedAt.Local().Format(time.DateTime), conv.Title)
+	}
+	return nil
+}
+
+func (r *repl) export(path string) error {
+	id := r.conn.ConversationID()
+	data, err := json.MarshalIndent(struct {
+		ConversationID string  `json:"conversationId"`
+		Messages       []entry `json:"messages"`
+	}{id, r.transcript}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err := r.out.Write(data)
+		return err
+	}
+	if path == "" {
+		path = id + ".json"
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "Exported %d messages to %s\n", len(r.transcript), path)
+	return nil
+}
+
+func (r *repl) reset() {
+	r.usage = server.TokenUsage{}
+	r.turns = 0
+	r.transcript = nil
+}
+
+// send sends a message and renders the reply.
+func (r *repl) send(ctx context.Context, content string) {
+	r.transcript = append(r.transcript, entry{Role: string(core.RoleUser), Content: content})
+	r.render(ctx, r.conn.Send(ctx, content))
+}
+
+// render prints a turn's events as they arrive, then asks about the
+// action it ends with, if any.
+func (r *repl) render(ctx context.Context, events iter.Seq2[client.Event, error]) {
+	var request *client.Event
+	streamed := false
+	text := ""
+
+	r.busy.Store(true)
+	for ev, err := range events {
+		if err != nil {
+			if streamed {
+				fmt.Fprintln(r.out)
+			}
+			fmt.Fprintln(r.out, "error:", err)
+			break
+		}
+
+		switch ev.Type {
+		case "text_chunk":
+			fmt.Fprint(r.out, ev.Content)
+			streamed = true
+			text += ev.Content
+
+		case "text":
+			if !streamed {
+				fmt.Fprint(r.out, ev.Content)
+				text = ev.Content
+			}
+
+		case "complete", "stopped", "confirm_request":
+			// confirm_request carries the text said before the action
+			if ev.Type == "confirm_request" && !streamed && ev.Content != "" {
+				fmt.Fprint(r.out, ev.Content)
+				text = ev.Content
+			}
+			if streamed || text != "" {
+				fmt.Fprintln(r.out)
+			}
+			if ev.Type == "stopped" {
+				fmt.Fprintln(r.out, "[stopped]")
+			}
+			r.printTools(ev.Tools)
+			r.record(text, ev)
+			if ev.Type == "confirm_request" {
+				request = &ev
+			}
+		}
+	}
+	r.busy.Store(false)
+
+	// The turn has ended, so the connection is free for the answer
+	if request != nil {
+		r.confirm(ctx, *request)
+	}
+}
+
+// record adds the reply to the transcript and its tokens to the total.
+func (r *repl) record(text string, ev client.Event) {
+	if text != "" || len(ev.Tools) > 0 {
+		r.transcript = append(r.transcript, entry{Role: string(core.RoleAssistant), Content: text, Tools: ev.Tools})
+	}
+	if ev.TokenUsage != nil {
+		r.turns++
+		r.usage.InputTokens += ev.TokenUsage.InputTokens
+		r.usage.OutputTokens += ev.TokenUsage.OutputTokens
+		r.usage.TotalTokens += ev.TokenUsage.TotalTokens
+	}
+}
+
+func (r *repl) printTools(executions []core.ToolExecution) {
+	for _, exec := range executions {
+		duration := time.Duration(exec.DurationMs) * time.Millisecond
+		if exec.Error != "" {
+			fmt.Fprintf(r.out, "  [tool] %s failed after %s: %s\n", exec.Tool, duration, exec.Error)
+			continue
+		}
+		fmt.Fprintf(r.out, "  [tool] %s %s\n", exec.Tool, duration)
+	}
+}
+
+// confirm asks whether to run a pending action. Editing cancels it and
+// sends a correction instead.
+func (r *repl) confirm(ctx context.Context, request client.Event) {
+	summary := request.Summary
+	if summary == "" {
+		summary = request.Tool
+	}
+	fmt.Fprintf(r.out, "Confirm %s? (expires %s)\n", summary, request.ExpiresAt)
+
+	for {
+		answer, ok := r.prompt("[y]es / [n]o / [e]dit: ")
+		if !ok {
+			return
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			r.render(ctx, r.conn.Confirm(ctx, request.ActionID))
+			return
+
+		case "n", "no":
+			r.render(ctx, r.conn.Cancel(ctx, request.ActionID))
+			return
+
+		case "e", "edit":
+			r.render(ctx, r.conn.Cancel(ctx, request.ActionID))
+			correction, ok := r.prompt("edit> ")
+			if ok && correction != "" {
+				r.send(ctx, correction)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContext_TimeoutUsesClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := NewContextWithClock(clock, "user", "session", "conv", "req")
+	ctx.Limits.Timeout = time.Minute
+
+	clock.Advance(59 * time.Second)
+	if ctx.IsTimedOut() {
+		t.Errorf("IsTimedOut() = true after %v, want false", ctx.Elapsed())
+	}
+
+	sub := ctx.ForSubAgent("analyst")
+	if sub.Clock != clock || !sub.StartTime.Equal(clock.Now()) {
+		t.Errorf("ForSubAgent() clock = %v, start = %v", sub.Clock, sub.StartTime)
+	}
+
+	clock.Advance(time.Second)
+	if !ctx.IsTimedOut() {
+		t.Errorf("IsTimedOut() = false after %v, want true", ctx.Elapsed())
+	}
+}
@@ -0,0 +1,25 @@
+package core
+
+import "context"
+
+// Credentials carries the caller's authentication for downstream tool calls.
+// Servers bind credentials to each connection's context so that tool
+// executors act on behalf of the right user, even when a single executor
+// is shared between many concurrent users.
+type Credentials struct {
+	// Token is the bearer token (typically a JWT) forwarded to the tool backend.
+	Token string
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns a copy of ctx carrying the given credentials.
+func WithCredentials(ctx context.Context, creds *Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+// CredentialsFromContext returns the credentials bound to ctx, if any.
+func CredentialsFromContext(ctx context.Context) (*Credentials, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(*Credentials)
+	return creds, ok && creds != nil
+}
@@ -27,6 +27,10 @@ type Message struct {
 
 	// ContentBlocks contains structured content for complex messages.
 	ContentBlocks []ContentBlock `json:"content_blocks,omitempty"`
+
+	// Usage is the token usage of the API call that produced this message.
+	// Only set on assistant messages returned by the engine.
+	Usage *TokenUsage `json:"usage,omitempty"`
 }
 
 // ContentBlock represents a block of content in a message.
@@ -180,10 +184,21 @@ type Context struct {
 
 	// StartTime is when this execution started.
 	StartTime time.Time
+
+	// Clock is the time source for StartTime and timeouts.
+	// If nil, SystemClock is used.
+	Clock Clock
 }
 
 // NewContext creates a new Context with default values.
 func NewContext(userID, sessionID, conversationID, requestID string) *Context {
+	return NewContextWithClock(SystemClock, userID, sessionID, conversationID, requestID)
+}
+
+// NewContextWithClock creates a new Context whose StartTime and timeouts
+// are measured with the given clock.
+func NewContextWithClock(clock Clock, userID, sessionID, conversationID, requestID string) *Context {
+	clock = ClockOrDefault(clock)
 	return &Context{
 		UserID:         userID,
 		SessionID:      sessionID,
@@ -191,7 +206,8 @@ func NewContext(userID, sessionID, conversationID, requestID string) *Context {
 		RequestID:      requestID,
 		Preferences:    DefaultPreferences(),
 		Limits:         DefaultLimits(),
-		StartTime:      time.Now(),
+		StartTime:      clock.Now(),
+		Clock:          clock,
 	}
 }
 
@@ -304,13 +320,14 @@ func (c *Context) ForSubAgent(requestID string) *Context {
 		Preferences:    c.Preferences,
 		UserLimits:     c.UserLimits,
 		Limits:         SubAgentLimits(),
-		StartTime:      time.Now(),
+		StartTime:      ClockOrDefault(c.Clock).Now(),
+		Clock:          c.Clock,
 	}
 }
 
 // Elapsed returns the time elapsed since StartTime.
 func (c *Context) Elapsed() time.Duration {
-	return time.Since(c.StartTime)
+	return ClockOrDefault(c.Clock).Now().Sub(c.StartTime)
 }
 
 // IsTimedOut returns true if the context has exceeded its timeout.
@@ -366,6 +383,7 @@ type PendingAction struct {
 	Summary string `json:"summary"`
 
 	// BlockID is Claude's tool_use block ID for session reconstruction.
+	// It is only unique within a session; see store.Confirmations.GetByBlock.
 	BlockID string `json:"block_id"`
 
 	// CreatedAt is when the action was created (unix timestamp).
@@ -377,6 +395,9 @@ type PendingAction struct {
 
 // ToolExecution records a single tool invocation.
 type ToolExecution struct {
+	// ToolUseID references the tool_use block that requested this execution.
+	ToolUseID string `json:"tool_use_id,omitempty"`
+
 	// Tool is the name of the tool.
 	Tool string `json:"tool"`
 
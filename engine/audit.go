@@ -3,6 +3,10 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/privacy"
 )
 
 // AuditLogger logs tool executions for compliance and debugging.
@@ -13,6 +17,14 @@ type AuditLogger interface {
 	Log(ctx context.Context, entry *AuditEntry) error
 }
 
+// AuditPurger is implemented by audit loggers that can delete old entries,
+// for retention policies.
+type AuditPurger interface {
+	// PurgeAuditEntries deletes entries with a Timestamp before the cutoff
+	// and returns the number deleted.
+	PurgeAuditEntries(ctx context.Context, before time.Time) (int, error)
+}
+
 // AuditEntry represents a single audit log entry.
 type AuditEntry struct {
 	// ID is the unique identifier for this audit entry.
@@ -68,28 +80,111 @@ func (n *NoOpAuditLogger) Log(ctx context.Context, entry *AuditEntry) error {
 // MemoryAuditLogger stores audit entries in memory.
 // Useful for testing and debugging.
 type MemoryAuditLogger struct {
-	entries []*AuditEntry
+	mu         sync.RWMutex
+	entries    []*AuditEntry
+	pseudonyms *privacy.Pseudonymizer
 }
 
 // NewMemoryAuditLogger creates a new in-memory audit logger.
 func NewMemoryAuditLogger() *MemoryAuditLogger {
 	return &MemoryAuditLogger{
-		entries: make([]*AuditEntry, 0),
+		entries:    make([]*AuditEntry, 0),
+		pseudonyms: privacy.NewRandomPseudonymizer(),
 	}
 }
 
 // Log stores the audit entry in memory.
 func (m *MemoryAuditLogger) Log(ctx context.Context, entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.entries = append(m.entries, entry)
 	return nil
 }
 
 // Entries returns all stored audit entries.
 func (m *MemoryAuditLogger) Entries() []*AuditEntry {
-	return m.entries
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*AuditEntry(nil), m.entries...)
 }
 
 // Clear removes all stored entries.
 func (m *MemoryAuditLogger) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.entries = make([]*AuditEntry, 0)
 }
+
+// PurgeAuditEntries deletes entries older than the cutoff.
+func (m *MemoryAuditLogger) PurgeAuditEntries(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := before.Unix()
+	kept := make([]*AuditEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		if entry.Timestamp >= cutoff {
+			kept = append(kept, entry)
+		}
+	}
+	purged := len(m.entries) - len(kept)
+	m.entries = kept
+	return purged, nil
+}
+
+// ExportUserData returns the user's audit entries.
+func (m *MemoryAuditLogger) ExportUserData(ctx context.Context, userID string) (any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var export []AuditEntry
+	for _, entry := range m.entries {
+		if entry.UserID == userID {
+			export = append(export, *entry)
+		}
+	}
+	if len(export) == 0 {
+		return nil, nil
+	}
+	return export, nil
+}
+
+// EraseUserData pseudonymises the user's audit entries rather than deleting
+// them, so the record of what was executed and when survives erasure.
+func (m *MemoryAuditLogger) EraseUserData(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, entry := range m.entries {
+		if entry.UserID == userID {
+			m.entries[i] = PseudonymizeAuditEntry(entry, m.pseudonyms.Pseudonym(userID))
+		}
+	}
+	return nil
+}
+
+// PseudonymizeAuditEntry returns a copy of the entry attributed to pseudonym,
+// with the tool input, output and error, which may contain personal data,
+// replaced by a redaction marker. Use it to implement
+// privacy.UserDataHandler for persistent audit logs.
+func PseudonymizeAuditEntry(entry *AuditEntry, pseudonym string) *AuditEntry {
+	redacted := *entry
+	redacted.UserID = pseudonym
+	redacted.ToolInput = json.RawMessage(redactedJSON)
+	if entry.ToolOutput != nil {
+		redacted.ToolOutput = json.RawMessage(redactedJSON)
+	}
+	if entry.Error != nil {
+		msg := "[REDACTED]"
+		redacted.Error = &msg
+	}
+	return &redacted
+}
+
+const redactedJSON = `{"redacted":true}`
+
+// Verify MemoryAuditLogger implements privacy.UserDataHandler and AuditPurger.
+var (
+	_ privacy.UserDataHandler = (*MemoryAuditLogger)(nil)
+	_ AuditPurger             = (*MemoryAuditLogger)(nil)
+)
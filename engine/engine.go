@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/logging"
 	"github.com/google/uuid"
 )
 
@@ -17,6 +19,10 @@ type Engine struct {
 	registry   *ToolRegistry
 	guardrails Guardrails  // Optional: rate limiting and circuit breaker
 	audit      AuditLogger // Optional: audit logging
+	metrics    Metrics     // Optional: instrumentation
+	logger     *slog.Logger
+	clock      core.Clock
+	title      TitleConfig
 }
 
 // Option configures the engine.
@@ -36,11 +42,37 @@ func WithAudit(a AuditLogger) Option {
 	}
 }
 
+// WithMetrics sets the metrics implementation for instrumentation.
+func WithMetrics(m Metrics) Option {
+	return func(e *Engine) {
+		e.metrics = m
+	}
+}
+
+// WithLogger sets the structured logger.
+// If not set, logging.Default() is used, which redacts tokens and PII.
+func WithLogger(l *slog.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logging.OrDefault(l)
+	}
+}
+
+// WithClock sets the time source for confirmation expiry and idempotency keys.
+// If not set, core.SystemClock is used.
+func WithClock(c core.Clock) Option {
+	return func(e *Engine) {
+		e.clock = core.ClockOrDefault(c)
+	}
+}
+
 // NewEngine creates a new engine with the given Anthropic client and registry.
 func NewEngine(client *anthropic.Client, registry *ToolRegistry, opts ...Option) *Engine {
 	e := &Engine{
 		client:   client,
 		registry: registry,
+		logger:   logging.Default(),
+		clock:    core.SystemClock,
+		title:    DefaultTitleConfig(),
 	}
 	for _, opt := range opts {
 		opt(e)
@@ -102,6 +134,12 @@ type Output struct {
 	// ResponseBlocks contains the full response for persistence.
 	ResponseBlocks []core.ContentBlock
 
+	// Messages contains every message this run added to the conversation,
+	// in order: assistant responses with their tool_use blocks, the tool
+	// results sent back, and the final answer. The input user message is
+	// not included. Persist these to resume with full tool context.
+	Messages []core.Message
+
 	// TokensUsed tracks Claude API token consumption for this run.
 	TokensUsed core.TokenUsage
 
@@ -123,8 +161,47 @@ const (
 	OutputError
 )
 
+// String returns a stable name for the output type, suitable for logs and metric labels.
+func (t OutputType) String() string {
+	switch t {
+	case OutputComplete:
+		return "complete"
+	case OutputConfirmationNeeded:
+		return "confirmation_needed"
+	case OutputError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
 // Run executes the agent loop until completion or confirmation is needed.
-func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
+func (e *Engine) Run(ctx context.Context, input *Input) (output *Output, err error) {
+	logger := e.logger
+	if input.Context != nil {
+		logger = logger.With(
+			logging.User(input.Context.UserID),
+			logging.Conversation(input.Context.ConversationID),
+			logging.Request(input.Context.RequestID),
+		)
+	}
+
+	// Record the run outcome
+	turns := 0
+	defer func() {
+		if output == nil {
+			return
+		}
+		if e.metrics != nil {
+			e.metrics.RunCompleted(output.Type, turns)
+		}
+		logger.DebugContext(ctx, "engine run completed",
+			slog.String("outcome", output.Type.String()),
+			slog.Int("turns", turns),
+			logging.Error(output.Error),
+		)
+	}()
+
 	// Check guardrails if configured
 	if e.guardrails != nil && input.Context != nil {
 		result, err := e.guardrails.Check(ctx, input.Context.UserID)
@@ -135,6 +212,10 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 			}, nil
 		}
 		if !result.Allowed {
+			if e.metrics != nil {
+				e.metrics.GuardrailBlocked()
+			}
+			logger.WarnContext(ctx, "run blocked by guardrails", slog.String("warning", result.Warning))
 			return &Output{
 				Type:  OutputError,
 				Error: fmt.Errorf("request blocked by guardrails: %s", result.Warning),
@@ -177,9 +258,16 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 		conversationID = input.Context.ConversationID
 	}
 	session := NewSession(userID, conversationID)
+	session.CreatedAt = e.clock.Now()
+	if input.Context != nil && input.Context.SessionID != "" {
+		// Pending actions are looked up by the caller's session
+		session.ID = input.Context.SessionID
+	}
 
-	// Track cumulative token usage
+	// Track cumulative token usage, tool executions and the messages this run adds
 	var totalTokens core.TokenUsage
+	var newMessages []core.Message
+	var toolsUsed []core.ToolExecution
 
 	// Restore history
 	session.RestoreHistory(input.History)
@@ -216,6 +304,7 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 				Type:       OutputError,
 				Error:      fmt.Errorf("timed out: %w", ctx.Err()),
 				TokensUsed: totalTokens,
+				Messages:   newMessages,
 			}, nil
 		}
 
@@ -225,10 +314,11 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 				Type:       OutputError,
 				Error:      fmt.Errorf("exceeded maximum turns (%d)", maxTurns),
 				TokensUsed: totalTokens,
+				Messages:   newMessages,
 			}, nil
 		}
 
-		session.IncrementTurnCount()
+		turns = session.IncrementTurnCount()
 
 		// Build the message request
 		params := anthropic.MessageNewParams{
@@ -246,7 +336,6 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 
 		// Call Claude API
 		var resp *anthropic.Message
-		var err error
 
 		if input.StreamCallback != nil {
 			resp, err = e.createMessageStreaming(ctx, params, input.StreamCallback)
@@ -259,19 +348,32 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 				Type:       OutputError,
 				Error:      fmt.Errorf("claude API error: %w", err),
 				TokensUsed: totalTokens,
+				Messages:   newMessages,
 			}, err
 		}
 
 		// Accumulate token usage
-		totalTokens.InputTokens += int(resp.Usage.InputTokens)
-		totalTokens.OutputTokens += int(resp.Usage.OutputTokens)
+		usage := tokenUsage(resp.Usage)
+		totalTokens.InputTokens += usage.InputTokens
+		totalTokens.OutputTokens += usage.OutputTokens
+		totalTokens.CacheCreationInputTokens += usage.CacheCreationInputTokens
+		totalTokens.CacheReadInputTokens += usage.CacheReadInputTokens
+		if e.metrics != nil {
+			e.metrics.TokensUsed(model, usage)
+		}
 
-		// Process response blocks
+		// Process response blocks. Tool results are tracked both in API form
+		// and as core blocks for persistence.
 		var toolResults []anthropic.ContentBlockParamUnion
+		var resultBlocks []core.ContentBlock
 		var textResponse string
-		var toolsUsed []core.ToolExecution
 		var confirmationNeeded *core.PendingAction
 
+		addResult := func(toolUseID, content string, isError bool) {
+			toolResults = append(toolResults, anthropic.NewToolResultBlock(toolUseID, content, isError))
+			resultBlocks = append(resultBlocks, core.NewToolResultBlock(toolUseID, content, isError))
+		}
+
 		for _, block := range resp.Content {
 			switch block.Type {
 			case "text":
@@ -283,37 +385,30 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 
 				tool, ok := e.registry.Get(toolName)
 				if !ok {
-					toolResults = append(toolResults, anthropic.NewToolResultBlock(
-						block.ID,
-						fmt.Sprintf("unknown tool: %s", toolName),
-						true,
-					))
+					addResult(block.ID, fmt.Sprintf("unknown tool: %s", toolName), true)
 					continue
 				}
 
 				// Check if write operation requiring confirmation
 				if tool.RequiresConfirmation() {
 					if !canConfirm {
-						toolResults = append(toolResults, anthropic.NewToolResultBlock(
-							block.ID,
-							"error: this operation requires user confirmation",
-							true,
-						))
+						addResult(block.ID, "error: this operation requires user confirmation", true)
 						continue
 					}
 
 					inputBytes, _ := json.Marshal(toolInput)
+					now := e.clock.Now()
 					confirmationNeeded = &core.PendingAction{
 						ID:             uuid.New().String(),
-						IdempotencyKey: GenerateIdempotencyKey(session.UserID, toolName, inputBytes),
+						IdempotencyKey: GenerateIdempotencyKeyWithTime(session.UserID, toolName, inputBytes, now),
 						SessionID:      session.ID,
 						UserID:         session.UserID,
 						Tool:           toolName,
 						Input:          inputBytes,
 						Summary:        tool.GetSummary(inputBytes),
 						BlockID:        block.ID,
-						CreatedAt:      time.Now().Unix(),
-						ExpiresAt:      time.Now().Add(10 * time.Minute).Unix(),
+						CreatedAt:      now.Unix(),
+						ExpiresAt:      now.Add(10 * time.Minute).Unix(),
 					}
 					break
 				}
@@ -328,8 +423,19 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 					RequestID: session.ID,
 				})
 
-				durationMs := time.Since(startTime).Milliseconds()
+				duration := time.Since(startTime)
+				durationMs := duration.Milliseconds()
+				success := err == nil && result != nil && result.Success
+				if e.metrics != nil {
+					e.metrics.ToolExecuted(toolName, success, duration)
+				}
+				logger.DebugContext(ctx, "tool executed",
+					logging.Tool(toolName),
+					slog.Bool("success", success),
+					logging.Duration(duration),
+				)
 				execution := core.ToolExecution{
+					ToolUseID:  block.ID,
 					Tool:       toolName,
 					Input:      toolInput,
 					DurationMs: durationMs,
@@ -368,28 +474,16 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 
 				if err != nil {
 					execution.Error = err.Error()
-					toolResults = append(toolResults, anthropic.NewToolResultBlock(
-						block.ID,
-						err.Error(),
-						true,
-					))
+					addResult(block.ID, err.Error(), true)
 				} else if result != nil && !result.Success {
 					execution.Error = result.Error
-					toolResults = append(toolResults, anthropic.NewToolResultBlock(
-						block.ID,
-						result.Error,
-						true,
-					))
+					addResult(block.ID, result.Error, true)
 				} else {
 					if result != nil {
 						execution.Result = result.Data
 					}
 					resultBytes, _ := json.Marshal(result.Data)
-					toolResults = append(toolResults, anthropic.NewToolResultBlock(
-						block.ID,
-						string(resultBytes),
-						false,
-					))
+					addResult(block.ID, string(resultBytes), false)
 				}
 
 				toolsUsed = append(toolsUsed, execution)
@@ -402,6 +496,12 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 
 		// Build response blocks for persistence
 		responseBlocks := responseToBlocks(resp)
+		newMessages = append(newMessages, core.Message{
+			Role:          core.RoleAssistant,
+			Content:       textResponse,
+			ContentBlocks: responseBlocks,
+			Usage:         &usage,
+		})
 
 		// If confirmation needed, return for user approval
 		if confirmationNeeded != nil {
@@ -414,6 +514,7 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 				ToolsUsed:      toolsUsed,
 				ResponseBlocks: responseBlocks,
 				TokensUsed:     totalTokens,
+				Messages:       newMessages,
 			}, nil
 		}
 
@@ -431,16 +532,19 @@ func (e *Engine) Run(ctx context.Context, input *Input) (*Output, error) {
 			}
 
 			return &Output{
-				Type:       OutputComplete,
-				Text:       textResponse,
-				ToolsUsed:  toolsUsed,
-				TokensUsed: totalTokens,
+				Type:           OutputComplete,
+				Text:           textResponse,
+				ToolsUsed:      toolsUsed,
+				ResponseBlocks: responseBlocks,
+				TokensUsed:     totalTokens,
+				Messages:       newMessages,
 			}, nil
 		}
 
 		// Continue loop with tool results
 		session.AddAssistantResponse(resp)
 		session.AddToolResults(toolResults)
+		newMessages = append(newMessages, core.Message{Role: core.RoleUser, ContentBlocks: resultBlocks})
 	}
 }
 
@@ -451,12 +555,26 @@ func (e *Engine) ExecuteTool(ctx context.Context, userID, toolName string, input
 		return nil, fmt.Errorf("unknown tool: %s", toolName)
 	}
 
-	return tool.Execute(ctx, &core.ToolParams{
+	startTime := time.Now()
+	result, err := tool.Execute(ctx, &core.ToolParams{
 		UserID:         userID,
 		Input:          input,
 		ConfirmationID: confirmationID,
 		RequestID:      confirmationID,
 	})
+	duration := time.Since(startTime)
+	success := err == nil && result != nil && result.Success
+	if e.metrics != nil {
+		e.metrics.ToolExecuted(toolName, success, duration)
+	}
+	e.logger.DebugContext(ctx, "confirmed tool executed",
+		logging.User(userID),
+		logging.Tool(toolName),
+		logging.Action(confirmationID),
+		slog.Bool("success", success),
+		logging.Duration(duration),
+	)
+	return result, err
 }
 
 // createMessageStreaming handles streaming API calls.
@@ -473,6 +591,7 @@ func (e *Engine) createMessageStreaming(ctx context.Context, params anthropic.Me
 		// Accumulate into the message
 		if err := message.Accumulate(event); err != nil {
 			// Log but continue - accumulation errors are non-fatal
+			e.logger.DebugContext(ctx, "stream accumulation failed", logging.Error(err))
 		}
 
 		// Handle different event types
@@ -494,6 +613,16 @@ func (e *Engine) createMessageStreaming(ctx context.Context, params anthropic.Me
 	return &message, nil
 }
 
+// tokenUsage converts Claude API usage to core.TokenUsage.
+func tokenUsage(u anthropic.Usage) core.TokenUsage {
+	return core.TokenUsage{
+		InputTokens:              int(u.InputTokens),
+		OutputTokens:             int(u.OutputTokens),
+		CacheCreationInputTokens: int(u.CacheCreationInputTokens),
+		CacheReadInputTokens:     int(u.CacheReadInputTokens),
+	}
+}
+
 // responseToBlocks converts a Claude response to core.ContentBlock slice.
 func responseToBlocks(resp *anthropic.Message) []core.ContentBlock {
 	blocks := make([]core.ContentBlock, 0, len(resp.Content))
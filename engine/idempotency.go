@@ -18,34 +18,24 @@ const IdempotencyBucketDuration = 10 * time.Minute
 // a 10-minute time bucket. This prevents duplicate confirmations for the same
 // action within a short time window.
 func GenerateIdempotencyKey(userID, tool string, input json.RawMessage) string {
-	// Time bucket (10-minute windows)
-	bucket := time.Now().Unix() / int64(IdempotencyBucketDuration.Seconds())
-
-	// Canonicalize JSON by parsing and re-marshaling
-	var parsed interface{}
-	if err := json.Unmarshal(input, &parsed); err != nil {
-		// If parsing fails, use raw input
-		parsed = string(input)
-	}
-	canonical, _ := json.Marshal(parsed)
-
-	// SHA256 hash of combined data
-	data := fmt.Sprintf("%s:%s:%s:%d", userID, tool, string(canonical), bucket)
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+	return GenerateIdempotencyKeyWithTime(userID, tool, input, time.Now())
 }
 
 // GenerateIdempotencyKeyWithTime creates an idempotency key using a specific timestamp.
-// Useful for testing and replay scenarios.
+// Useful for testing and replay scenarios, and used by the engine with its Clock.
 func GenerateIdempotencyKeyWithTime(userID, tool string, input json.RawMessage, t time.Time) string {
+	// Time bucket (10-minute windows)
 	bucket := t.Unix() / int64(IdempotencyBucketDuration.Seconds())
 
+	// Canonicalize JSON by parsing and re-marshaling
 	var parsed interface{}
 	if err := json.Unmarshal(input, &parsed); err != nil {
+		// If parsing fails, use raw input
 		parsed = string(input)
 	}
 	canonical, _ := json.Marshal(parsed)
 
+	// SHA256 hash of combined data
 	data := fmt.Sprintf("%s:%s:%s:%d", userID, tool, string(canonical), bucket)
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// Metrics receives instrumentation events from the engine.
+// This is an interface - a Prometheus-backed implementation is provided by
+// the metrics package, and applications can plug in their own.
+type Metrics interface {
+	// RunCompleted records the outcome of a Run and the number of turns it took.
+	RunCompleted(outcome OutputType, turns int)
+
+	// ToolExecuted records a single tool execution and how long it took.
+	ToolExecuted(tool string, success bool, duration time.Duration)
+
+	// GuardrailBlocked records a run rejected by the guardrails.
+	GuardrailBlocked()
+
+	// TokensUsed records the token consumption of a single Claude API call.
+	TokensUsed(model string, usage core.TokenUsage)
+}
+
+// NoOpMetrics is a metrics implementation that discards all events.
+// Useful for development and testing.
+type NoOpMetrics struct{}
+
+// RunCompleted is a no-op.
+func (n *NoOpMetrics) RunCompleted(outcome OutputType, turns int) {}
+
+// ToolExecuted is a no-op.
+func (n *NoOpMetrics) ToolExecuted(tool string, success bool, duration time.Duration) {}
+
+// GuardrailBlocked is a no-op.
+func (n *NoOpMetrics) GuardrailBlocked() {}
+
+// TokensUsed is a no-op.
+func (n *NoOpMetrics) TokensUsed(model string, usage core.TokenUsage) {}
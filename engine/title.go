@@ -19,34 +19,73 @@ Examples of good titles:
 - Savings deposit question
 - Transaction history request`
 
+// DefaultTitle is returned when there is nothing to title.
+const DefaultTitle = "New conversation"
+
+// TitleConfig configures title generation.
+type TitleConfig struct {
+	// Model is the model used for titles. A small, fast model keeps titles cheap.
+	Model string
+
+	// Prompt is the system prompt. Defaults to TitleGenerationPrompt.
+	Prompt string
+
+	// MaxTokens bounds the title response.
+	MaxTokens int64
+}
+
+// DefaultTitleConfig returns the default title configuration.
+func DefaultTitleConfig() TitleConfig {
+	return TitleConfig{
+		Model:     string(anthropic.ModelClaude3_5HaikuLatest),
+		Prompt:    TitleGenerationPrompt,
+		MaxTokens: 50, // Titles are short
+	}
+}
+
+// WithTitleConfig sets the model, prompt and token limit used by
+// GenerateTitle. Zero fields keep their defaults.
+func WithTitleConfig(cfg TitleConfig) Option {
+	return func(e *Engine) {
+		def := DefaultTitleConfig()
+		if cfg.Model == "" {
+			cfg.Model = def.Model
+		}
+		if cfg.Prompt == "" {
+			cfg.Prompt = def.Prompt
+		}
+		if cfg.MaxTokens <= 0 {
+			cfg.MaxTokens = def.MaxTokens
+		}
+		e.title = cfg
+	}
+}
+
 // GenerateTitle creates a short title for a conversation based on its history.
-// Uses a small, fast model call to generate a 3-6 word summary.
+// Uses a small, fast model call (see WithTitleConfig) to generate a 3-6 word summary.
+// Tool calls and results are ignored; only the text of each message is used.
 func (e *Engine) GenerateTitle(ctx context.Context, history []core.Message) (string, error) {
 	if len(history) == 0 {
-		return "New conversation", nil
+		return DefaultTitle, nil
 	}
 
 	// Convert history to API format
 	messages := make([]anthropic.MessageParam, 0, len(history))
 	for _, msg := range history {
+		text := msg.GetText()
+		if text == "" {
+			continue
+		}
 		switch msg.Role {
 		case core.RoleUser:
-			if msg.Content != "" {
-				messages = append(messages, anthropic.NewUserMessage(
-					anthropic.NewTextBlock(msg.Content),
-				))
-			}
+			messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(text)))
 		case core.RoleAssistant:
-			if msg.Content != "" {
-				messages = append(messages, anthropic.NewAssistantMessage(
-					anthropic.NewTextBlock(msg.Content),
-				))
-			}
+			messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(text)))
 		}
 	}
 
 	if len(messages) == 0 {
-		return "New conversation", nil
+		return DefaultTitle, nil
 	}
 
 	// Add the title request
@@ -54,13 +93,12 @@ func (e *Engine) GenerateTitle(ctx context.Context, history []core.Message) (str
 		anthropic.NewTextBlock("Based on this conversation, generate a short title (3-6 words):"),
 	))
 
-	// Use a smaller model for cost efficiency
 	params := anthropic.MessageNewParams{
-		Model:     anthropic.ModelClaude3_5HaikuLatest,
-		MaxTokens: 50, // Titles are short
+		Model:     anthropic.Model(e.title.Model),
+		MaxTokens: e.title.MaxTokens,
 		Messages:  messages,
 		System: []anthropic.TextBlockParam{
-			{Text: TitleGenerationPrompt},
+			{Text: e.title.Prompt},
 		},
 	}
 
@@ -82,7 +120,7 @@ func (e *Engine) GenerateTitle(ctx context.Context, history []core.Message) (str
 		}
 	}
 
-	return "New conversation", nil
+	return DefaultTitle, nil
 }
 
 // GenerateTitleFromFirstMessage creates a title based on just the first user message.
@@ -24,6 +24,7 @@ func main() {
 		SystemPrompt: `You are a helpful assistant with access to a weather tool.
 When users ask about weather, use the get_weather tool.
 Be conversational and helpful.`,
+		AllowSharedUser: true, // single-user demo; set AuthFunc or JWTVerifier in production
 	})
 	if err != nil {
 		log.Fatal(err)
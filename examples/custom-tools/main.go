@@ -31,6 +31,7 @@ Available tools:
 - get_schedule: View today's schedule
 
 Be helpful and proactive about task management.`,
+		AllowSharedUser: true, // single-user demo; set AuthFunc or JWTVerifier in production
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/becomeliminal/nim-go-sdk/core"
 	"github.com/becomeliminal/nim-go-sdk/executor"
@@ -34,6 +36,11 @@ func main() {
 	})
 	log.Println("Liminal API configured")
 
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
 	// Create server with authentication
 	srv, err := server.New(server.Config{
 		AnthropicKey:    anthropicKey,
@@ -42,6 +49,10 @@ func main() {
 		MaxTokens:       4096,
 		LiminalExecutor: liminalExecutor, // SDK extracts JWT and forwards to Liminal
 		AuthFunc:        authenticateRequest,
+		HTTP: server.HTTPConfig{
+			Addr:           ":" + port,
+			AllowedOrigins: allowedOrigins(),
+		},
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -54,19 +65,26 @@ func main() {
 	srv.AddTool(createThinkTool())
 	srv.AddTool(createCalculateTool())
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
 	log.Printf("Starting Nim agent on :%s", port)
 	log.Printf("WebSocket endpoint: ws://localhost:%s/ws", port)
 	log.Printf("Health check: http://localhost:%s/health", port)
 
-	if err := srv.Run(":" + port); err != nil {
+	// Serve until interrupted, then let replies in progress finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
+	srv.Close()
+}
+
+// allowedOrigins returns the browser origins allowed to connect, from the
+// comma-separated ALLOWED_ORIGINS. If unset, any origin may connect.
+func allowedOrigins() []string {
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		return strings.Split(origins, ",")
+	}
+	return nil
 }
 
 // authenticateRequest validates the request and returns a user ID.
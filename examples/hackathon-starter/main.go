@@ -88,6 +88,7 @@ func main() {
 		Model:           "claude-sonnet-4-20250514",
 		MaxTokens:       4096,
 		LiminalExecutor: liminalExecutor, // SDK automatically handles JWT extraction and forwarding
+		AllowSharedUser: true,            // demo: callers share conversations, the gateway still checks each JWT
 	})
 	if err != nil {
 		log.Fatal(err)
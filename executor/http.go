@@ -7,21 +7,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/logging"
 )
 
 // HTTPExecutor implements ToolExecutor by calling the agent_gateway over HTTP.
 // This is the public implementation used by external developers.
+//
+// Requests are authenticated with the core.Credentials bound to the request
+// context (see core.WithCredentials), so one executor can safely be shared
+// between concurrent users. The configured JWTToken or APIKey is only used
+// when the context carries no credentials.
 type HTTPExecutor struct {
 	baseURL    string
 	apiKey     string // Deprecated: use jwtToken
-	jwtToken   string // JWT for Bearer authentication
 	httpClient *http.Client
+	logger     *slog.Logger
+
+	mu       sync.RWMutex
+	jwtToken string // Fallback JWT for Bearer authentication
 }
 
 // HTTPExecutorConfig configures the HTTP executor.
@@ -33,11 +43,17 @@ type HTTPExecutorConfig struct {
 	// APIKey is the Liminal API key for authentication.
 	APIKey string
 
-	// JWTToken is the JWT token for Bearer authentication.
+	// JWTToken is the fallback JWT token for Bearer authentication, used when
+	// the request context carries no core.Credentials.
 	JWTToken string
 
 	// Timeout is the HTTP request timeout.
 	Timeout time.Duration
+
+	// Logger receives request logs. Request and response bodies are only
+	// logged at debug level.
+	// If nil, logging.Default() is used, which redacts tokens and PII.
+	Logger *slog.Logger
 }
 
 // NewHTTPExecutor creates a new HTTP-based tool executor.
@@ -54,38 +70,59 @@ func NewHTTPExecutor(cfg HTTPExecutorConfig) *HTTPExecutor {
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		logger: logging.OrDefault(cfg.Logger),
 	}
 }
 
 // Execute runs a read-only tool via HTTP.
 func (e *HTTPExecutor) Execute(ctx context.Context, req *core.ExecuteRequest) (*core.ExecuteResponse, error) {
-	fmt.Printf("[HTTP] Execute called for tool: %s, user: %s\n", req.Tool, req.UserID)
 	endpoint := e.endpointForTool(req.Tool)
 	return e.doRequest(ctx, "GET", endpoint, req, req.Tool)
 }
 
 // ExecuteWrite runs a write tool that may require confirmation.
 func (e *HTTPExecutor) ExecuteWrite(ctx context.Context, req *core.ExecuteRequest) (*core.ExecuteResponse, error) {
-	fmt.Printf("[HTTP] ExecuteWrite called for tool: %s, user: %s\n", req.Tool, req.UserID)
 	endpoint := e.endpointForTool(req.Tool)
 	return e.doRequest(ctx, "POST", endpoint, req, req.Tool)
 }
 
 // Confirm executes a previously confirmed write operation.
 func (e *HTTPExecutor) Confirm(ctx context.Context, userID, confirmationID string) (*core.ExecuteResponse, error) {
-	fmt.Printf("[HTTP] Confirm called for confirmationID: %s, user: %s\n", confirmationID, userID)
 	endpoint := fmt.Sprintf("/nim/v1/agent/confirmations/%s/confirm", confirmationID)
 	return e.doRequest(ctx, "POST", endpoint, nil, "")
 }
 
 // Cancel cancels a pending confirmation.
 func (e *HTTPExecutor) Cancel(ctx context.Context, userID, confirmationID string) error {
-	fmt.Printf("[HTTP] Cancel called for confirmationID: %s, user: %s\n", confirmationID, userID)
 	endpoint := fmt.Sprintf("/nim/v1/agent/confirmations/%s/cancel", confirmationID)
 	_, err := e.doRequest(ctx, "POST", endpoint, nil, "")
 	return err
 }
 
+// BaseURL returns the agent_gateway URL the executor calls.
+func (e *HTTPExecutor) BaseURL() string {
+	return e.baseURL
+}
+
+// HealthCheck reports whether the agent_gateway is reachable. Any response
+// other than a server error counts, since the base URL itself needs no
+// authentication to answer.
+func (e *HTTPExecutor) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, e.baseURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid base URL: %w", err)
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("gateway unreachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
 // endpointForTool maps tool names to HTTP endpoints.
 func (e *HTTPExecutor) endpointForTool(tool string) string {
 	// Map tool names to nim_gateway endpoints
@@ -112,13 +149,12 @@ func (e *HTTPExecutor) endpointForTool(tool string) string {
 // doRequest performs an HTTP request to the agent_gateway.
 func (e *HTTPExecutor) doRequest(ctx context.Context, method, endpoint string, body interface{}, toolName string) (*core.ExecuteResponse, error) {
 	urlStr := e.baseURL + endpoint
-	fmt.Printf("[HTTP] %s %s\n", method, urlStr)
+	startTime := time.Now()
 
-	// Debug: Log request details
-	if toolName != "" {
-		log.Printf("[DEBUG] Executing tool: %s", toolName)
+	logger := e.logger.With(slog.String("method", method), slog.String("endpoint", endpoint))
+	if execReq, ok := body.(*core.ExecuteRequest); ok {
+		logger = logger.With(logging.Tool(toolName), logging.User(execReq.UserID), logging.Request(execReq.RequestID))
 	}
-	log.Printf("[DEBUG] Making %s request to %s", method, endpoint)
 
 	var bodyReader io.Reader
 
@@ -150,21 +186,18 @@ func (e *HTTPExecutor) doRequest(ctx context.Context, method, endpoint string, b
 					return nil, fmt.Errorf("failed to unmarshal input params: %w", err)
 				}
 				bodyToSend = params
-				fmt.Printf("[HTTP] Extracted params from ExecuteRequest: %+v\n", params)
 			} else {
 				bodyToSend = map[string]interface{}{}
-				fmt.Printf("[HTTP] Empty input, sending empty params\n")
 			}
 		} else {
 			bodyToSend = body // Non-ExecuteRequest bodies pass through
-			fmt.Printf("[HTTP] Non-ExecuteRequest body: %+v\n", body)
 		}
 
 		bodyBytes, err := json.Marshal(bodyToSend)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request: %w", err)
 		}
-		fmt.Printf("[HTTP] Request body: %s\n", string(bodyBytes))
+		logger.DebugContext(ctx, "executor request body", slog.String("body", string(bodyBytes)))
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
@@ -177,49 +210,38 @@ func (e *HTTPExecutor) doRequest(ctx context.Context, method, endpoint string, b
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	// Prefer JWT over API key
-	if e.jwtToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.jwtToken))
-		log.Printf("[DEBUG] Using JWT authentication")
-	} else if e.apiKey != "" {
-		// Fallback to API key for backward compatibility
-		req.Header.Set("X-API-Key", e.apiKey)
-		log.Printf("[DEBUG] Using API key authentication")
-		fmt.Printf("[HTTP] Using JWT auth (token: %s...)\n", e.jwtToken[:20])
+	// Prefer per-request credentials, then the configured JWT, then the API key
+	if token := e.tokenFor(ctx); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	} else if e.apiKey != "" {
 		// Fallback to API key for backward compatibility
 		req.Header.Set("X-API-Key", e.apiKey)
-		fmt.Printf("[HTTP] Using API key auth\n")
 	} else {
-		fmt.Printf("[HTTP] WARNING: No authentication configured!\n")
+		logger.WarnContext(ctx, "executor request has no authentication configured")
 	}
 
 	resp, err := e.httpClient.Do(req)
 	if err != nil {
-		fmt.Printf("[HTTP] Request failed: %v\n", err)
+		logger.ErrorContext(ctx, "executor request failed", logging.Error(err), logging.Duration(time.Since(startTime)))
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("[HTTP] Failed to read response: %v\n", err)
+		logger.ErrorContext(ctx, "executor response read failed", logging.Error(err))
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Debug: Log response status and body
-	log.Printf("[DEBUG] HTTP Response: %d", resp.StatusCode)
-	fmt.Printf("[HTTP] Response status: %d\n", resp.StatusCode)
-	fmt.Printf("[HTTP] Response body: %s\n", string(respBody))
+	logger = logger.With(slog.Int("status", resp.StatusCode), logging.Duration(time.Since(startTime)))
+	logger.DebugContext(ctx, "executor response body", slog.String("body", string(respBody)))
 
 	if resp.StatusCode >= 400 {
-		log.Printf("[DEBUG] Error response body: %s", string(respBody))
+		logger.WarnContext(ctx, "executor request returned error status")
 		return &core.ExecuteResponse{
 			Success: false,
 			Error:   fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
 		}, nil
-	} else {
-		log.Printf("[DEBUG] Success response body: %s", string(respBody))
 	}
 
 	// Gateway returns raw proto response (not wrapped in ExecuteResponse)
@@ -235,15 +257,30 @@ func (e *HTTPExecutor) doRequest(ctx context.Context, method, endpoint string, b
 		return nil, fmt.Errorf("failed to marshal %s response: %w", toolName, err)
 	}
 
-	log.Printf("[DEBUG] Tool execution completed successfully for: %s", toolName)
+	logger.InfoContext(ctx, "executor request completed")
 	return &core.ExecuteResponse{
 		Success: true,
 		Data:    json.RawMessage(dataBytes),
 	}, nil
 }
 
-// UpdateJWT updates the JWT token used for authentication.
-// This should be called when the token is refreshed.
+// UpdateJWT updates the fallback JWT token used for authentication.
+//
+// Deprecated: the token is shared by every caller of this executor. Bind
+// per-user tokens to the request context with core.WithCredentials instead.
 func (e *HTTPExecutor) UpdateJWT(jwt string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.jwtToken = jwt
 }
+
+// tokenFor returns the bearer token for a request: the context credentials
+// if present, otherwise the configured fallback JWT.
+func (e *HTTPExecutor) tokenFor(ctx context.Context) string {
+	if creds, ok := core.CredentialsFromContext(ctx); ok && creds.Token != "" {
+		return creds.Token
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.jwtToken
+}
@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// newEchoGateway returns a gateway that echoes the bearer token it received.
+func newEchoGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"token": strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPExecutor_ConcurrentUsersUseOwnCredentials(t *testing.T) {
+	gateway := newEchoGateway(t)
+	exec := NewHTTPExecutor(HTTPExecutorConfig{BaseURL: gateway.URL, JWTToken: "fallback"})
+
+	const users = 8
+	const callsPerUser = 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, users*callsPerUser)
+
+	for u := 0; u < users; u++ {
+		token := fmt.Sprintf("token-user-%d", u)
+		ctx := core.WithCredentials(context.Background(), &core.Credentials{Token: token})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < callsPerUser; i++ {
+				resp, err := exec.Execute(ctx, &core.ExecuteRequest{Tool: "echo_token", Input: json.RawMessage(`{}`)})
+				if err != nil {
+					errs <- err
+					return
+				}
+				var body map[string]string
+				json.Unmarshal(resp.Data, &body)
+				if body["token"] != token {
+					errs <- fmt.Errorf("request sent with token %q, want %q", body["token"], token)
+				}
+			}
+		}()
+	}
+
+	// Deprecated shared updates must not race with in-flight requests.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < callsPerUser; i++ {
+			exec.UpdateJWT(fmt.Sprintf("fallback-%d", i))
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHTTPExecutor_FallbackJWT(t *testing.T) {
+	gateway := newEchoGateway(t)
+	exec := NewHTTPExecutor(HTTPExecutorConfig{BaseURL: gateway.URL, JWTToken: "fallback"})
+
+	resp, err := exec.Execute(context.Background(), &core.ExecuteRequest{Tool: "echo_token"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	var body map[string]string
+	json.Unmarshal(resp.Data, &body)
+	if body["token"] != "fallback" {
+		t.Errorf("token = %q, want %q", body["token"], "fallback")
+	}
+}
+
+func TestHTTPExecutor_HealthCheck(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusNotFound)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	exec := NewHTTPExecutor(HTTPExecutorConfig{BaseURL: gateway.URL})
+
+	if err := exec.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() error = %v, want nil for a 404", err)
+	}
+	status.Store(http.StatusBadGateway)
+	if err := exec.HealthCheck(ctx); err == nil {
+		t.Error("HealthCheck() succeeded on a 502")
+	}
+	gateway.Close()
+	if err := exec.HealthCheck(ctx); err == nil {
+		t.Error("HealthCheck() succeeded with the gateway down")
+	}
+}
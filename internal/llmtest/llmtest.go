@@ -0,0 +1,165 @@
+// Package llmtest fakes the Anthropic Messages API for tests. A Server
+// answers each request with the next scripted reply, streamed like the real
+// API, so the server and client packages can be tested end to end.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Reply is a scripted model response: Text, or a call to Tool with Input.
+type Reply struct {
+	Text  string
+	Tool  string
+	Input string
+
+	// ToolUseID, if set, replaces the numbered ID of the tool call.
+	ToolUseID string
+
+	// Release, if set, holds the response after its first text chunk.
+	Release chan struct{}
+}
+
+// Server is a fake Messages API. Requests beyond the scripted replies fail
+// the test, unless Answer is set. Tool calls are numbered by request:
+// toolu_1, toolu_2, ..., unless the reply names its own.
+type Server struct {
+	// URL is the base URL to configure clients with.
+	URL string
+
+	// Answer, if set, replies to requests once the scripted replies run
+	// out, given the request body. Set it before the first request.
+	Answer func(request string) Reply
+
+	t testing.TB
+
+	mu       sync.Mutex
+	replies  []Reply
+	requests []string
+}
+
+// New starts a fake Messages API, closed when the test ends.
+func New(t testing.TB) *Server {
+	f := &Server{t: t}
+	backend := httptest.NewServer(f)
+	t.Cleanup(backend.Close)
+	f.URL = backend.URL
+	return f
+}
+
+// Script queues replies, answered in order.
+func (f *Server) Script(replies ...Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// Requests returns the bodies of the message requests received so far.
+func (f *Server) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func (f *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/models" {
+		// The server's readiness check
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":[{"id":"fake","type":"model","display_name":"Fake","created_at":"2025-01-01T00:00:00Z"}],"has_more":false,"first_id":"fake","last_id":"fake"}`)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var params struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(body, &params)
+
+	f.mu.Lock()
+	var rep Reply
+	switch {
+	case len(f.replies) > 0:
+		rep = f.replies[0]
+		f.replies = f.replies[1:]
+	case f.Answer != nil:
+		rep = f.Answer(string(body))
+	default:
+		f.mu.Unlock()
+		f.t.Errorf("unexpected model request: %s", body)
+		http.Error(w, "no reply scripted", http.StatusInternalServerError)
+		return
+	}
+	f.requests = append(f.requests, string(body))
+	toolUseID := fmt.Sprintf("toolu_%d", len(f.requests))
+	if rep.ToolUseID != "" {
+		toolUseID = rep.ToolUseID
+	}
+	f.mu.Unlock()
+
+	if !params.Stream {
+		message(w, rep, toolUseID)
+		return
+	}
+	stream(w, rep, toolUseID)
+}
+
+const usage = `{"input_tokens":10,"output_tokens":0,"cache_creation_input_tokens":3,"cache_read_input_tokens":7}`
+
+// message answers a request made without streaming.
+func message(w http.ResponseWriter, rep Reply, toolUseID string) {
+	if rep.Release != nil {
+		<-rep.Release
+	}
+	content, stopReason := fmt.Sprintf(`[{"type":"text","text":%s}]`, quote(rep.Text)), "end_turn"
+	if rep.Tool != "" {
+		input := rep.Input
+		if input == "" {
+			input = "{}"
+		}
+		content = fmt.Sprintf(`[{"type":"tool_use","id":%q,"name":%q,"input":%s}]`, toolUseID, rep.Tool, input)
+		stopReason = "tool_use"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"msg","type":"message","role":"assistant","model":"fake","content":%s,"stop_reason":%q,"usage":%s}`, content, stopReason, usage)
+}
+
+// stream answers a streaming request with server-sent events, a word of
+// text at a time.
+func stream(w http.ResponseWriter, rep Reply, toolUseID string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	event := func(name, data string) {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		w.(http.Flusher).Flush()
+	}
+
+	event("message_start", `{"type":"message_start","message":{"id":"msg","type":"message","role":"assistant","model":"fake","content":[],"stop_reason":null,"usage":`+usage+`}}`)
+	stopReason := "end_turn"
+	if rep.Tool != "" {
+		stopReason = "tool_use"
+		event("content_block_start", fmt.Sprintf(`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":%q,"name":%q,"input":{}}}`, toolUseID, rep.Tool))
+		event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":%s}}`, quote(rep.Input)))
+	} else {
+		event("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+		for i, word := range strings.SplitAfter(rep.Text, " ") {
+			event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":%s}}`, quote(word)))
+			if i == 0 && rep.Release != nil {
+				<-rep.Release
+			}
+		}
+	}
+	event("content_block_stop", `{"type":"content_block_stop","index":0}`)
+	event("message_delta", fmt.Sprintf(`{"type":"message_delta","delta":{"stop_reason":%q},"usage":{"output_tokens":5}}`, stopReason))
+	event("message_stop", `{"type":"message_stop"}`)
+}
+
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
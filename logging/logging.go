@@ -0,0 +1,81 @@
+// Package logging provides structured logging helpers for the Nim agent SDK.
+//
+// The SDK logs through *slog.Logger values injected via server.Config,
+// engine.WithLogger and executor.HTTPExecutorConfig. Attributes use the
+// consistent keys defined here so logs from every layer can be correlated,
+// and NewRedactingHandler masks secrets and PII before records are written.
+package logging
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Attribute keys used consistently across the SDK.
+const (
+	UserKey         = "user"
+	ConversationKey = "conversation"
+	RequestKey      = "request"
+	ToolKey         = "tool"
+	ActionKey       = "action"
+	MessageKey      = "message"
+	DurationKey     = "duration_ms"
+	ErrorKey        = "error"
+)
+
+// User returns the attribute identifying the authenticated user.
+func User(id string) slog.Attr {
+	return slog.String(UserKey, id)
+}
+
+// Conversation returns the attribute identifying a conversation.
+func Conversation(id string) slog.Attr {
+	return slog.String(ConversationKey, id)
+}
+
+// Request returns the attribute identifying a single request or agent run.
+func Request(id string) slog.Attr {
+	return slog.String(RequestKey, id)
+}
+
+// Tool returns the attribute identifying a tool.
+func Tool(name string) slog.Attr {
+	return slog.String(ToolKey, name)
+}
+
+// Action returns the attribute identifying a pending action.
+func Action(id string) slog.Attr {
+	return slog.String(ActionKey, id)
+}
+
+// Message returns the attribute identifying a stored conversation message.
+func Message(id string) slog.Attr {
+	return slog.String(MessageKey, id)
+}
+
+// Duration returns the attribute recording an elapsed time in milliseconds.
+func Duration(d time.Duration) slog.Attr {
+	return slog.Int64(DurationKey, d.Milliseconds())
+}
+
+// Error returns the attribute recording an error. A nil error yields an empty attribute.
+func Error(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{}
+	}
+	return slog.String(ErrorKey, err.Error())
+}
+
+// Default returns the logger used when none is configured: slog.Default()
+// wrapped with the default redaction policy.
+func Default() *slog.Logger {
+	return slog.New(NewRedactingHandler(slog.Default().Handler(), DefaultRedactionPolicy()))
+}
+
+// OrDefault returns logger if non-nil, otherwise Default().
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return Default()
+}
@@ -0,0 +1,228 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// RedactionPolicy controls which kinds of sensitive data are masked.
+type RedactionPolicy struct {
+	// Tokens masks bearer tokens, JWTs and API keys.
+	Tokens bool
+
+	// Emails masks email addresses.
+	Emails bool
+
+	// Phones masks phone numbers.
+	Phones bool
+
+	// Amounts masks monetary amounts (e.g., "$50", "50.00 USD", "amount": "50").
+	Amounts bool
+
+	// SensitiveKeys are attribute keys whose values are always masked,
+	// regardless of content. Matching is case-insensitive.
+	SensitiveKeys []string
+}
+
+// DefaultRedactionPolicy masks everything the SDK knows how to detect.
+func DefaultRedactionPolicy() *RedactionPolicy {
+	return &RedactionPolicy{
+		Tokens:  true,
+		Emails:  true,
+		Phones:  true,
+		Amounts: true,
+		SensitiveKeys: []string{
+			"token", "jwt", "authorization", "api_key", "apikey", "password", "secret",
+		},
+	}
+}
+
+var (
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+	apiKeyPattern = regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern  = regexp.MustCompile(`(^|[^\w.-])(\+?\(?\d[\d\s().-]{7,}\d)\b`)
+
+	// A phone candidate that starts with a date, e.g. "2026-10-18 12:30",
+	// is checked without it.
+	datePrefixPattern = regexp.MustCompile(`^(\d{4}[-.]\d{1,2}[-.]\d{1,2}|\d{1,2}[-.]\d{1,2}[-.]\d{4})\s*`)
+	decimalPattern    = regexp.MustCompile(`\.\d{1,2}$`)
+
+	// Amount patterns: currency symbols, amounts followed by a currency code,
+	// and JSON "amount" fields.
+	symbolAmountPattern = regexp.MustCompile(`[$€£]\s?\d[\d,]*(\.\d+)?`)
+	codeAmountPattern   = regexp.MustCompile(`(?i)\b\d[\d,]*(\.\d+)?\s?(USD|EUR|GBP|USDC|EURC|LIL|ETH|BTC)\b`)
+	jsonAmountPattern   = regexp.MustCompile(`("(?:amount|value|balance|totalUsd|usdValue)"\s*:\s*)("[^"]*"|[\d.]+)`)
+)
+
+// Redact masks sensitive data in s according to the policy.
+func (p *RedactionPolicy) Redact(s string) string {
+	if p == nil || s == "" {
+		return s
+	}
+	if p.Tokens {
+		s = jwtPattern.ReplaceAllString(s, Mask)
+		s = bearerPattern.ReplaceAllString(s, "Bearer "+Mask)
+		s = apiKeyPattern.ReplaceAllString(s, Mask)
+	}
+	if p.Emails {
+		s = emailPattern.ReplaceAllString(s, Mask)
+	}
+	if p.Amounts {
+		s = jsonAmountPattern.ReplaceAllString(s, `${1}"`+Mask+`"`)
+		s = symbolAmountPattern.ReplaceAllString(s, Mask)
+		s = codeAmountPattern.ReplaceAllString(s, Mask)
+	}
+	if p.Phones {
+		s = redactPhones(s)
+	}
+	return s
+}
+
+// redactPhones masks the phone numbers among the phonePattern matches in s.
+func redactPhones(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range phonePattern.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[4], m[5]
+		if date := datePrefixPattern.FindStringIndex(s[start:end]); date != nil {
+			start += date[1]
+		}
+		if !isPhone(s[start:end], s[end:]) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(Mask)
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// isPhone reports whether candidate, followed in the text by after, looks
+// like a phone number: 8-15 digits after a leading "+", or 10-15 digits
+// with separators that are not a time ("12:30") or a decimal amount
+// ("1 234 567.89"). This keeps UUIDs, dates, times, amounts and unix
+// timestamps readable.
+func isPhone(candidate, after string) bool {
+	digits := 0
+	for _, r := range candidate {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if strings.HasPrefix(candidate, "+") {
+		return digits >= 8 && digits <= 15
+	}
+	if digits < 10 || digits > 15 || !strings.ContainsAny(candidate, " ().-") {
+		return false
+	}
+	return !strings.HasPrefix(after, ":") && !decimalPattern.MatchString(candidate)
+}
+
+func (p *RedactionPolicy) isSensitiveKey(key string) bool {
+	for _, k := range p.SensitiveKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return p.Amounts && strings.EqualFold(key, "amount")
+}
+
+// redactAttr masks an attribute's value, descending into groups.
+func (p *RedactionPolicy) redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if p.isSensitiveKey(a.Key) {
+		return slog.String(a.Key, Mask)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, p.Redact(a.Value.String()))
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		redacted := make([]any, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = p.redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case json.RawMessage:
+			return slog.String(a.Key, p.Redact(string(v)))
+		case []byte:
+			return slog.String(a.Key, p.Redact(string(v)))
+		case error:
+			return slog.String(a.Key, p.Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, p.Redact(v.String()))
+		default:
+			// Structured values are rendered to JSON so their contents can be inspected.
+			b, err := json.Marshal(v)
+			if err != nil {
+				return slog.String(a.Key, Mask)
+			}
+			return slog.String(a.Key, p.Redact(string(b)))
+		}
+	}
+	return a
+}
+
+// RedactingHandler is an slog.Handler that masks sensitive data in messages
+// and attribute values before passing records to the next handler.
+type RedactingHandler struct {
+	next   slog.Handler
+	policy *RedactionPolicy
+}
+
+// NewRedactingHandler wraps next with the given redaction policy.
+// If policy is nil, DefaultRedactionPolicy is used.
+func NewRedactingHandler(next slog.Handler, policy *RedactionPolicy) *RedactingHandler {
+	if policy == nil {
+		policy = DefaultRedactionPolicy()
+	}
+	return &RedactingHandler{next: next, policy: policy}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle redacts the record and passes it to the next handler.
+func (h *RedactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, h.policy.Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.policy.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs redacts the attributes and returns a new handler.
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.policy.redactAttr(a)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted), policy: h.policy}
+}
+
+// WithGroup returns a new handler with the given group name.
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name), policy: h.policy}
+}
+
+// Verify RedactingHandler implements slog.Handler.
+var _ slog.Handler = (*RedactingHandler)(nil)
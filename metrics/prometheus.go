@@ -0,0 +1,251 @@
+// Package metrics provides Prometheus instrumentation for the Nim agent.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/retention"
+)
+
+// Confirmation outcomes recorded by Prometheus.Confirmation.
+const (
+	ConfirmationRequested = "requested"
+	ConfirmationConfirmed = "confirmed"
+	ConfirmationCancelled = "cancelled"
+	ConfirmationExpired   = "expired"
+)
+
+// Prometheus collects agent server and engine metrics.
+// It implements engine.Metrics and is used directly by the server.
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	activeConnections prometheus.Gauge
+	messages          *prometheus.CounterVec
+	runs              *prometheus.CounterVec
+	turns             prometheus.Histogram
+	toolDuration      *prometheus.HistogramVec
+	confirmations     *prometheus.CounterVec
+	guardrailBlocks   prometheus.Counter
+	tokens            *prometheus.CounterVec
+	retentionRuns     *prometheus.CounterVec
+	retentionRemoved  *prometheus.CounterVec
+	retentionLastRun  *prometheus.GaugeVec
+}
+
+// PrometheusConfig configures the Prometheus collector.
+type PrometheusConfig struct {
+	// Namespace prefixes every metric name (e.g., "nim" -> "nim_engine_runs_total").
+	Namespace string
+
+	// Registry is the registry metrics are registered with and served from.
+	// If nil, a new private registry is created so multiple servers can
+	// coexist in one process.
+	Registry *prometheus.Registry
+
+	// ToolBuckets are the histogram buckets for tool latency, in seconds.
+	ToolBuckets []float64
+}
+
+// DefaultPrometheusConfig returns sensible defaults for the collector.
+func DefaultPrometheusConfig() *PrometheusConfig {
+	return &PrometheusConfig{
+		Namespace:   "nim",
+		ToolBuckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}
+}
+
+// NewPrometheus creates a collector and registers its metrics.
+func NewPrometheus(cfg *PrometheusConfig) (*Prometheus, error) {
+	if cfg == nil {
+		cfg = DefaultPrometheusConfig()
+	}
+
+	registry := cfg.Registry
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+
+	buckets := cfg.ToolBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultPrometheusConfig().ToolBuckets
+	}
+
+	p := &Prometheus{
+		registry: registry,
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "server",
+			Name:      "active_connections",
+			Help:      "Number of open WebSocket connections.",
+		}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "server",
+			Name:      "messages_total",
+			Help:      "WebSocket messages by direction and type.",
+		}, []string{"direction", "type"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "engine",
+			Name:      "runs_total",
+			Help:      "Engine runs by outcome.",
+		}, []string{"outcome"}),
+		turns: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "engine",
+			Name:      "run_turns",
+			Help:      "Number of Claude API round-trips per engine run.",
+			Buckets:   []float64{1, 2, 3, 5, 8, 13, 20},
+		}),
+		toolDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "engine",
+			Name:      "tool_duration_seconds",
+			Help:      "Tool execution latency by tool and success.",
+			Buckets:   buckets,
+		}, []string{"tool", "success"}),
+		confirmations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "server",
+			Name:      "confirmations_total",
+			Help:      "Confirmation lifecycle events by outcome (requested, confirmed, cancelled, expired).",
+		}, []string{"outcome"}),
+		guardrailBlocks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "engine",
+			Name:      "guardrail_blocks_total",
+			Help:      "Engine runs rejected by guardrails.",
+		}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "engine",
+			Name:      "tokens_total",
+			Help:      "Claude API tokens by model and type (input, output, cache_creation, cache_read).",
+		}, []string{"model", "type"}),
+		retentionRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "retention",
+			Name:      "runs_total",
+			Help:      "Retention policy runs by policy and success.",
+		}, []string{"policy", "success"}),
+		retentionRemoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "retention",
+			Name:      "removed_total",
+			Help:      "Records removed by retention policies.",
+		}, []string{"policy"}),
+		retentionLastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: "retention",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of each retention policy's last successful run.",
+		}, []string{"policy"}),
+	}
+
+	collectors := []prometheus.Collector{
+		p.activeConnections,
+		p.messages,
+		p.runs,
+		p.turns,
+		p.toolDuration,
+		p.confirmations,
+		p.guardrailBlocks,
+		p.tokens,
+		p.retentionRuns,
+		p.retentionRemoved,
+		p.retentionLastRun,
+	}
+	for _, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Handler returns an HTTP handler that serves the registry in the
+// Prometheus exposition format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{Registry: p.registry})
+}
+
+// Registry returns the underlying registry, so applications can add their
+// own collectors next to the SDK's.
+func (p *Prometheus) Registry() *prometheus.Registry {
+	return p.registry
+}
+
+// ConnectionOpened records a new WebSocket connection.
+func (p *Prometheus) ConnectionOpened() {
+	p.activeConnections.Inc()
+}
+
+// ConnectionClosed records a closed WebSocket connection.
+func (p *Prometheus) ConnectionClosed() {
+	p.activeConnections.Dec()
+}
+
+// MessageReceived records an inbound client message of the given type.
+func (p *Prometheus) MessageReceived(msgType string) {
+	p.messages.WithLabelValues("inbound", msgType).Inc()
+}
+
+// MessageSent records an outbound server message of the given type.
+func (p *Prometheus) MessageSent(msgType string) {
+	p.messages.WithLabelValues("outbound", msgType).Inc()
+}
+
+// Confirmation records a confirmation lifecycle event.
+// Outcome is one of the Confirmation* constants.
+func (p *Prometheus) Confirmation(outcome string) {
+	p.confirmations.WithLabelValues(outcome).Inc()
+}
+
+// RunCompleted implements engine.Metrics.
+func (p *Prometheus) RunCompleted(outcome engine.OutputType, turns int) {
+	p.runs.WithLabelValues(outcome.String()).Inc()
+	p.turns.Observe(float64(turns))
+}
+
+// ToolExecuted implements engine.Metrics.
+func (p *Prometheus) ToolExecuted(tool string, success bool, duration time.Duration) {
+	p.toolDuration.WithLabelValues(tool, fmt.Sprintf("%t", success)).Observe(duration.Seconds())
+}
+
+// GuardrailBlocked implements engine.Metrics.
+func (p *Prometheus) GuardrailBlocked() {
+	p.guardrailBlocks.Inc()
+}
+
+// TokensUsed implements engine.Metrics.
+func (p *Prometheus) TokensUsed(model string, usage core.TokenUsage) {
+	p.tokens.WithLabelValues(model, "input").Add(float64(usage.InputTokens))
+	p.tokens.WithLabelValues(model, "output").Add(float64(usage.OutputTokens))
+	p.tokens.WithLabelValues(model, "cache_creation").Add(float64(usage.CacheCreationInputTokens))
+	p.tokens.WithLabelValues(model, "cache_read").Add(float64(usage.CacheReadInputTokens))
+}
+
+// RetentionRun implements retention.Metrics.
+func (p *Prometheus) RetentionRun(policy string, removed int, err error) {
+	p.retentionRuns.WithLabelValues(policy, fmt.Sprintf("%t", err == nil)).Inc()
+	if err != nil {
+		return
+	}
+	p.retentionRemoved.WithLabelValues(policy).Add(float64(removed))
+	p.retentionLastRun.WithLabelValues(policy).SetToCurrentTime()
+}
+
+// Verify Prometheus implements engine.Metrics and retention.Metrics.
+var (
+	_ engine.Metrics    = (*Prometheus)(nil)
+	_ retention.Metrics = (*Prometheus)(nil)
+)
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+)
+
+func TestPrometheus_Handler(t *testing.T) {
+	p, err := NewPrometheus(nil)
+	if err != nil {
+		t.Fatalf("NewPrometheus() error = %v", err)
+	}
+
+	p.ConnectionOpened()
+	p.MessageReceived("message")
+	p.RunCompleted(engine.OutputConfirmationNeeded, 2)
+	p.ToolExecuted("get_balance", true, 150*time.Millisecond)
+	p.Confirmation(ConfirmationExpired)
+	p.GuardrailBlocked()
+	p.TokensUsed("claude-sonnet-4-20250514", core.TokenUsage{InputTokens: 100, OutputTokens: 20})
+	p.RetentionRun("conversations", 3, nil)
+	p.RetentionRun("audit_log", 0, errors.New("unavailable"))
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	want := []string{
+		`nim_server_active_connections 1`,
+		`nim_server_messages_total{direction="inbound",type="message"} 1`,
+		`nim_engine_runs_total{outcome="confirmation_needed"} 1`,
+		`nim_engine_run_turns_sum 2`,
+		`nim_engine_tool_duration_seconds_count{success="true",tool="get_balance"} 1`,
+		`nim_server_confirmations_total{outcome="expired"} 1`,
+		`nim_engine_guardrail_blocks_total 1`,
+		`nim_engine_tokens_total{model="claude-sonnet-4-20250514",type="input"} 100`,
+		`nim_retention_runs_total{policy="conversations",success="true"} 1`,
+		`nim_retention_runs_total{policy="audit_log",success="false"} 1`,
+		`nim_retention_removed_total{policy="conversations"} 3`,
+		`nim_retention_last_success_timestamp_seconds{policy="conversations"}`,
+	}
+	for _, line := range want {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
+
+func TestNewPrometheus_PrivateRegistries(t *testing.T) {
+	// Two collectors with default config must not collide.
+	if _, err := NewPrometheus(nil); err != nil {
+		t.Fatalf("first NewPrometheus() error = %v", err)
+	}
+	if _, err := NewPrometheus(nil); err != nil {
+		t.Fatalf("second NewPrometheus() error = %v", err)
+	}
+}
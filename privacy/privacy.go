@@ -0,0 +1,187 @@
+// Package privacy exports and erases everything the SDK stores about a user,
+// for data subject requests such as GDPR access and erasure.
+//
+// Each store that holds user data implements UserDataHandler. The built-in
+// conversation and confirmation stores and engine.MemoryAuditLogger already
+// do; register your own stores (preferences, memory, custom audit logs)
+// alongside them:
+//
+//	reg := srv.Privacy() // the server's stores, already registered
+//	reg.Register("preferences", myPreferences)
+//
+//	export, err := reg.ExportUser(ctx, userID) // zip of JSON files
+//	err = reg.EraseUser(ctx, userID)
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// UserDataHandler is implemented by stores that hold user data.
+type UserDataHandler interface {
+	// ExportUserData returns all of the user's data in a JSON-serializable
+	// form. It returns nil if the store holds nothing for the user.
+	ExportUserData(ctx context.Context, userID string) (any, error)
+
+	// EraseUserData removes the user's data. Records that must be kept,
+	// such as audit entries, are pseudonymised instead. Erasing a user
+	// with no data is not an error.
+	EraseUserData(ctx context.Context, userID string) error
+}
+
+// Manifest describes an export. It is written as manifest.json.
+type Manifest struct {
+	UserID     string    `json:"user_id"`
+	ExportedAt time.Time `json:"exported_at"`
+	Files      []string  `json:"files"`
+}
+
+// validName keeps handler names usable as file names.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type registered struct {
+	name    string
+	handler UserDataHandler
+}
+
+// Registry coordinates export and erasure across registered handlers.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers []registered
+	clock    core.Clock
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithClock sets the time source for export timestamps.
+func WithClock(c core.Clock) Option {
+	return func(r *Registry) {
+		r.clock = core.ClockOrDefault(c)
+	}
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{clock: core.SystemClock}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register adds a handler. The name becomes the handler's file name in
+// exports (name.json) and must be unique, lower-case letters, digits,
+// '-' or '_'.
+func (r *Registry) Register(name string, h UserDataHandler) error {
+	if !validName.MatchString(name) || name == "manifest" {
+		return fmt.Errorf("invalid handler name %q", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reg := range r.handlers {
+		if reg.name == name {
+			return fmt.Errorf("handler %q already registered", name)
+		}
+	}
+	r.handlers = append(r.handlers, registered{name: name, handler: h})
+	return nil
+}
+
+// Names returns the registered handler names in registration order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, len(r.handlers))
+	for i, reg := range r.handlers {
+		names[i] = reg.name
+	}
+	return names
+}
+
+// ExportUser collects the user's data from every handler and returns it as
+// a zip archive with one JSON file per handler and a manifest.json.
+// Handlers with no data for the user are omitted. Any handler error fails
+// the export, so a partial export is never mistaken for a complete one.
+func (r *Registry) ExportUser(ctx context.Context, userID string) (io.Reader, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifest := Manifest{UserID: userID, ExportedAt: r.clock.Now().UTC(), Files: []string{}}
+
+	for _, reg := range r.snapshot() {
+		data, err := reg.handler.ExportUserData(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", reg.name, err)
+		}
+		if data == nil {
+			continue
+		}
+
+		file := reg.name + ".json"
+		if err := writeJSON(zw, file, data); err != nil {
+			return nil, fmt.Errorf("export %s: %w", reg.name, err)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if err := writeJSON(zw, "manifest.json", manifest); err != nil {
+		return nil, fmt.Errorf("export manifest: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	return &buf, nil
+}
+
+// EraseUser erases the user's data from every handler. It keeps going
+// after a failure so one broken store does not block the others, and
+// returns the joined errors. Erasure is idempotent, so a failed call can
+// simply be retried.
+func (r *Registry) EraseUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("user ID is required")
+	}
+
+	var errs []error
+	for _, reg := range r.snapshot() {
+		if err := reg.handler.EraseUserData(ctx, userID); err != nil {
+			errs = append(errs, fmt.Errorf("erase %s: %w", reg.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registry) snapshot() []registered {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]registered(nil), r.handlers...)
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
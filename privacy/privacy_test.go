@@ -0,0 +1,231 @@
+package privacy_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+func TestRegistry_ExportAndErase(t *testing.T) {
+	ctx := context.Background()
+	clock := core.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	conversations := store.NewMemoryConversations(store.WithClock(clock))
+	confirmations := store.NewMemoryConfirmations(store.WithClock(clock))
+	audit := engine.NewMemoryAuditLogger()
+
+	reg := privacy.NewRegistry(privacy.WithClock(clock))
+	mustRegister(t, reg, "conversations", conversations)
+	mustRegister(t, reg, "confirmations", confirmations)
+	mustRegister(t, reg, "audit", audit)
+
+	for _, userID := range []string{"alice", "bob"} {
+		conv, err := conversations.Create(ctx, userID)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := conversations.Append(ctx, &store.AppendMessage{
+			ConversationID: conv.ID,
+			UserID:         userID,
+			Role:           "user",
+			Content:        "hello from " + userID,
+		}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := confirmations.Store(ctx, &core.PendingAction{
+			ID:        "action-" + userID,
+			UserID:    userID,
+			Tool:      "send_money",
+			Input:     json.RawMessage(`{"to":"carol"}`),
+			ExpiresAt: clock.Now().Add(time.Hour).Unix(),
+		}); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	if err := audit.Log(ctx, &engine.AuditEntry{ID: "entry-1", UserID: "alice", ToolName: "send_money", ToolInput: json.RawMessage(`{"to":"carol"}`)}); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+
+	files := exportFiles(t, reg, "alice")
+	for _, name := range []string{"manifest.json", "conversations.json", "confirmations.json", "audit.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export missing %s", name)
+		}
+	}
+	if strings.Contains(files["conversations.json"], "bob") {
+		t.Error("export contains another user's conversations")
+	}
+	if !strings.Contains(files["conversations.json"], "hello from alice") {
+		t.Error("export missing the user's messages")
+	}
+
+	var manifest privacy.Manifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if manifest.UserID != "alice" || !manifest.ExportedAt.Equal(clock.Now()) || len(manifest.Files) != 3 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	if err := reg.EraseUser(ctx, "alice"); err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+
+	files = exportFiles(t, reg, "alice")
+	if len(files) != 1 {
+		t.Errorf("export after erasure has %d files, want only the manifest", len(files))
+	}
+
+	entries := audit.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("audit entries = %d, want 1 kept after erasure", len(entries))
+	}
+	if !strings.HasPrefix(entries[0].UserID, privacy.PseudonymPrefix) || strings.Contains(string(entries[0].ToolInput), "carol") {
+		t.Errorf("audit entry not pseudonymised: %+v", entries[0])
+	}
+
+	page, err := conversations.List(ctx, "bob", store.ListOptions{})
+	if err != nil || len(page.Conversations) != 1 {
+		t.Errorf("erasure affected another user: %v, %v", page, err)
+	}
+	if _, err := confirmations.Get(ctx, "bob", "action-bob"); err != nil {
+		t.Errorf("erasure affected another user's actions: %v", err)
+	}
+
+	// Erasure is idempotent
+	if err := reg.EraseUser(ctx, "alice"); err != nil {
+		t.Errorf("second EraseUser: %v", err)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	reg := privacy.NewRegistry()
+	h := engine.NewMemoryAuditLogger()
+
+	mustRegister(t, reg, "audit", h)
+	if err := reg.Register("audit", h); err == nil {
+		t.Error("duplicate name accepted")
+	}
+	for _, name := range []string{"", "manifest", "Audit", "../audit", "a.json"} {
+		if err := reg.Register(name, h); err == nil {
+			t.Errorf("invalid name %q accepted", name)
+		}
+	}
+	if got := reg.Names(); len(got) != 1 || got[0] != "audit" {
+		t.Errorf("Names() = %v", got)
+	}
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	ctx := context.Background()
+	errExport := errors.New("export failed")
+	errErase := errors.New("erase failed")
+
+	ok := &fakeHandler{}
+	reg := privacy.NewRegistry()
+	mustRegister(t, reg, "broken", &fakeHandler{exportErr: errExport, eraseErr: errErase})
+	mustRegister(t, reg, "ok", ok)
+
+	if _, err := reg.ExportUser(ctx, "alice"); !errors.Is(err, errExport) {
+		t.Errorf("ExportUser error = %v, want %v", err, errExport)
+	}
+
+	err := reg.EraseUser(ctx, "alice")
+	if !errors.Is(err, errErase) {
+		t.Errorf("EraseUser error = %v, want %v", err, errErase)
+	}
+	if !ok.erased {
+		t.Error("a failing handler stopped erasure of the others")
+	}
+
+	if _, err := reg.ExportUser(ctx, ""); err == nil {
+		t.Error("ExportUser accepted an empty user ID")
+	}
+	if err := reg.EraseUser(ctx, ""); err == nil {
+		t.Error("EraseUser accepted an empty user ID")
+	}
+}
+
+func TestPseudonymizer(t *testing.T) {
+	p := privacy.NewPseudonymizer([]byte("key"))
+
+	a := p.Pseudonym("alice")
+	if a != p.Pseudonym("alice") {
+		t.Error("pseudonym is not stable")
+	}
+	if a == p.Pseudonym("bob") {
+		t.Error("different users share a pseudonym")
+	}
+	if a == privacy.NewPseudonymizer([]byte("other")).Pseudonym("alice") {
+		t.Error("pseudonym does not depend on the key")
+	}
+	if !strings.HasPrefix(a, privacy.PseudonymPrefix) || strings.Contains(a, "alice") {
+		t.Errorf("Pseudonym() = %q", a)
+	}
+}
+
+type fakeHandler struct {
+	exportErr error
+	eraseErr  error
+	erased    bool
+}
+
+func (h *fakeHandler) ExportUserData(ctx context.Context, userID string) (any, error) {
+	return nil, h.exportErr
+}
+
+func (h *fakeHandler) EraseUserData(ctx context.Context, userID string) error {
+	h.erased = true
+	return h.eraseErr
+}
+
+func mustRegister(t *testing.T, reg *privacy.Registry, name string, h privacy.UserDataHandler) {
+	t.Helper()
+	if err := reg.Register(name, h); err != nil {
+		t.Fatalf("Register(%q): %v", name, err)
+	}
+}
+
+// exportFiles exports the user and returns the archive's files by name.
+func exportFiles(t *testing.T, reg *privacy.Registry, userID string) map[string]string {
+	t.Helper()
+
+	r, err := reg.ExportUser(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ExportUser: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open export: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(b)
+	}
+	return files
+}
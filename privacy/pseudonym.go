@@ -0,0 +1,42 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// PseudonymPrefix marks user IDs that have been pseudonymised.
+const PseudonymPrefix = "erased-"
+
+// Pseudonymizer replaces user IDs with stable pseudonyms, so records such
+// as audit entries can be kept after erasure without identifying the user.
+// Pseudonyms are a keyed hash: the same user always gets the same pseudonym
+// under one key, but it cannot be reversed or recomputed without the key.
+type Pseudonymizer struct {
+	key []byte
+}
+
+// NewPseudonymizer creates a pseudonymizer with the given secret key.
+// Keep the key secret and stable if pseudonyms must match across restarts.
+func NewPseudonymizer(key []byte) *Pseudonymizer {
+	return &Pseudonymizer{key: append([]byte(nil), key...)}
+}
+
+// NewRandomPseudonymizer creates a pseudonymizer with a random key.
+// Pseudonyms are consistent for the life of the process only.
+func NewRandomPseudonymizer() *Pseudonymizer {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("privacy: failed to generate pseudonym key: " + err.Error())
+	}
+	return &Pseudonymizer{key: key}
+}
+
+// Pseudonym returns the pseudonym for a user ID.
+func (p *Pseudonymizer) Pseudonym(userID string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(userID))
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:24]
+}
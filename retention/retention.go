@@ -0,0 +1,249 @@
+// Package retention deletes data once it is past its retention period.
+//
+// A Policy removes one kind of expired data; a Scheduler applies policies
+// periodically in the background. The server builds a scheduler from
+// server.Config.Retention, but applications can schedule their own
+// policies alongside:
+//
+//	sched := retention.NewScheduler([]retention.Policy{
+//		retention.PendingActions(confirmations),
+//		retention.Conversations(conversations, 90*24*time.Hour),
+//		retention.NewPolicy("preferences", prefs.PurgeStale),
+//	}, retention.WithInterval(time.Hour))
+//	sched.Start()
+//	defer sched.Stop()
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// DefaultInterval is how often a Scheduler applies its policies by default.
+const DefaultInterval = time.Hour
+
+// Policy removes data that is past its retention period.
+type Policy interface {
+	// Name identifies the policy in logs and metrics.
+	Name() string
+
+	// Apply removes the data that has expired as of now and returns the
+	// number of records removed.
+	Apply(ctx context.Context, now time.Time) (int, error)
+}
+
+// NewPolicy creates a policy from a function.
+func NewPolicy(name string, apply func(ctx context.Context, now time.Time) (int, error)) Policy {
+	return policyFunc{name: name, apply: apply}
+}
+
+type policyFunc struct {
+	name  string
+	apply func(ctx context.Context, now time.Time) (int, error)
+}
+
+func (p policyFunc) Name() string { return p.name }
+
+func (p policyFunc) Apply(ctx context.Context, now time.Time) (int, error) {
+	return p.apply(ctx, now)
+}
+
+// PendingActions expires pending actions past their ExpiresAt by calling
+// Confirmations.Cleanup. Stores that keep history mark them expired; others
+// delete them.
+func PendingActions(s store.Confirmations) Policy {
+	return NewPolicy("pending_actions", func(ctx context.Context, now time.Time) (int, error) {
+		return s.Cleanup(ctx)
+	})
+}
+
+// Conversations deletes conversations with no activity for longer than maxAge.
+func Conversations(p store.ConversationPurger, maxAge time.Duration) Policy {
+	return NewPolicy("conversations", func(ctx context.Context, now time.Time) (int, error) {
+		return p.PurgeConversations(ctx, now.Add(-maxAge))
+	})
+}
+
+// ActionHistory deletes actions resolved more than maxAge ago from stores
+// that keep them as history.
+func ActionHistory(p store.ActionPurger, maxAge time.Duration) Policy {
+	return NewPolicy("action_history", func(ctx context.Context, now time.Time) (int, error) {
+		return p.PurgeActions(ctx, now.Add(-maxAge))
+	})
+}
+
+// AuditLog deletes audit entries older than maxAge.
+func AuditLog(p engine.AuditPurger, maxAge time.Duration) Policy {
+	return NewPolicy("audit_log", func(ctx context.Context, now time.Time) (int, error) {
+		return p.PurgeAuditEntries(ctx, now.Add(-maxAge))
+	})
+}
+
+// Metrics records the outcome of each policy run.
+type Metrics interface {
+	// RetentionRun records that a policy removed the given number of
+	// records, or failed with err.
+	RetentionRun(policy string, removed int, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RetentionRun(string, int, error) {}
+
+// Scheduler applies retention policies periodically.
+type Scheduler struct {
+	policies []Policy
+	interval time.Duration
+	clock    core.Clock
+	logger   *slog.Logger
+	metrics  Metrics
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped bool
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithInterval sets how often policies are applied. Defaults to DefaultInterval.
+func WithInterval(d time.Duration) Option {
+	return func(s *Scheduler) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// WithClock sets the time source policies measure ages against.
+func WithClock(c core.Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = core.ClockOrDefault(c)
+	}
+}
+
+// WithLogger sets the logger for run results and failures.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = logging.OrDefault(l)
+	}
+}
+
+// WithMetrics sets the metrics recorder, e.g. *metrics.Prometheus.
+func WithMetrics(m Metrics) Option {
+	return func(s *Scheduler) {
+		if m != nil {
+			s.metrics = m
+		}
+	}
+}
+
+// NewScheduler creates a scheduler for the given policies. Call Start to
+// begin applying them.
+func NewScheduler(policies []Policy, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		policies: policies,
+		interval: DefaultInterval,
+		clock:    core.SystemClock,
+		logger:   logging.Default(),
+		metrics:  noopMetrics{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Policies returns the scheduler's policies.
+func (s *Scheduler) Policies() []Policy {
+	return append([]Policy(nil), s.policies...)
+}
+
+// Start applies the policies immediately and then every interval, in a
+// background goroutine, until Stop is called. Calling Start again, or after
+// Stop, has no effect.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil || s.stopped {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.RunOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels any run in progress and waits for the scheduler to exit.
+// It is safe to call more than once.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// RunOnce applies every policy once. A failing policy does not stop the
+// others; their errors are joined.
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	var errs []error
+	for _, p := range s.policies {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+
+		removed, err := p.Apply(ctx, s.clock.Now())
+		logger := s.logger.With(slog.String("policy", p.Name()))
+		if err != nil && ctx.Err() != nil {
+			// Interrupted by Stop; not a failure of the policy
+			logger.Debug("retention policy interrupted", logging.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			break
+		}
+		s.metrics.RetentionRun(p.Name(), removed, err)
+
+		switch {
+		case err != nil:
+			logger.Error("retention policy failed", logging.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		case removed > 0:
+			logger.Info("retention policy removed records", slog.Int("removed", removed))
+		default:
+			logger.Debug("retention policy removed nothing")
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,181 @@
+package retention_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/retention"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+type run struct {
+	policy  string
+	removed int
+	err     error
+}
+
+type recordingMetrics struct {
+	mu   sync.Mutex
+	runs []run
+}
+
+func (m *recordingMetrics) RetentionRun(policy string, removed int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, run{policy, removed, err})
+}
+
+func (m *recordingMetrics) Runs() []run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]run(nil), m.runs...)
+}
+
+func TestScheduler_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	clock := core.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	conversations := store.NewMemoryConversations(store.WithClock(clock))
+	confirmations := store.NewMemoryConfirmations(store.WithClock(clock))
+	audit := engine.NewMemoryAuditLogger()
+
+	old, err := conversations.Create(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := audit.Log(ctx, &engine.AuditEntry{ID: "old", UserID: "alice", Timestamp: clock.Now().Unix()}); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+
+	clock.Advance(31 * 24 * time.Hour)
+
+	recent, err := conversations.Create(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := audit.Log(ctx, &engine.AuditEntry{ID: "recent", UserID: "alice", Timestamp: clock.Now().Unix()}); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+	if err := confirmations.Store(ctx, &core.PendingAction{
+		ID:        "expired",
+		UserID:    "alice",
+		ExpiresAt: clock.Now().Add(-time.Minute).Unix(),
+	}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	m := &recordingMetrics{}
+	sched := retention.NewScheduler([]retention.Policy{
+		retention.PendingActions(confirmations),
+		retention.Conversations(conversations, 30*24*time.Hour),
+		retention.AuditLog(audit, 30*24*time.Hour),
+	}, retention.WithClock(clock), retention.WithMetrics(m))
+
+	if err := sched.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	want := []run{
+		{"pending_actions", 1, nil},
+		{"conversations", 1, nil},
+		{"audit_log", 1, nil},
+	}
+	got := m.Runs()
+	if len(got) != len(want) {
+		t.Fatalf("runs = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("run %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := conversations.Get(ctx, "alice", old.ID); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("old conversation: err = %v, want %v", err, store.ErrConversationNotFound)
+	}
+	if _, err := conversations.Get(ctx, "alice", recent.ID); err != nil {
+		t.Errorf("recent conversation: %v", err)
+	}
+	if entries := audit.Entries(); len(entries) != 1 || entries[0].ID != "recent" {
+		t.Errorf("audit entries = %+v, want only recent", entries)
+	}
+}
+
+func TestScheduler_FailingPolicyDoesNotStopOthers(t *testing.T) {
+	failure := errors.New("database unavailable")
+	applied := false
+
+	m := &recordingMetrics{}
+	sched := retention.NewScheduler([]retention.Policy{
+		retention.NewPolicy("broken", func(ctx context.Context, now time.Time) (int, error) {
+			return 0, failure
+		}),
+		retention.NewPolicy("working", func(ctx context.Context, now time.Time) (int, error) {
+			applied = true
+			return 2, nil
+		}),
+	}, retention.WithMetrics(m))
+
+	err := sched.RunOnce(context.Background())
+	if !errors.Is(err, failure) {
+		t.Errorf("RunOnce error = %v, want %v", err, failure)
+	}
+	if !applied {
+		t.Error("working policy was not applied")
+	}
+
+	got := m.Runs()
+	if len(got) != 2 || got[0].err != failure || got[1] != (run{"working", 2, nil}) {
+		t.Errorf("runs = %+v", got)
+	}
+}
+
+func TestScheduler_StartAndStop(t *testing.T) {
+	applied := make(chan struct{}, 1)
+	sched := retention.NewScheduler([]retention.Policy{
+		retention.NewPolicy("test", func(ctx context.Context, now time.Time) (int, error) {
+			select {
+			case applied <- struct{}{}:
+			default:
+			}
+			return 0, nil
+		}),
+	}, retention.WithInterval(time.Hour))
+
+	sched.Start()
+	sched.Start()
+
+	select {
+	case <-applied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("policy was not applied on Start")
+	}
+
+	sched.Stop()
+	sched.Stop()
+}
+
+func TestScheduler_StopInterruptsRun(t *testing.T) {
+	started := make(chan struct{})
+	m := &recordingMetrics{}
+	sched := retention.NewScheduler([]retention.Policy{
+		retention.NewPolicy("slow", func(ctx context.Context, now time.Time) (int, error) {
+			close(started)
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}),
+	}, retention.WithMetrics(m))
+
+	sched.Start()
+	<-started
+	sched.Stop()
+
+	if got := m.Runs(); len(got) != 0 {
+		t.Errorf("interrupted run recorded metrics: %+v", got)
+	}
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// jwk is a single JSON Web Key as found in a JWKS file.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	// Symmetric
+	K string `json:"k"`
+}
+
+// jwksKey is a parsed key with the algorithm it verifies.
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// jwksCache holds the keys of a local JWKS file and reloads them when the
+// file changes.
+type jwksCache struct {
+	path     string
+	interval time.Duration
+	clock    core.Clock
+
+	mu        sync.RWMutex
+	keys      map[string]jwksKey
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func newJWKSCache(path string, interval time.Duration, clock core.Clock) *jwksCache {
+	return &jwksCache{path: path, interval: interval, clock: clock}
+}
+
+// key returns the key with the given ID for the algorithm. Unknown IDs and
+// stale caches trigger a check of the file for rotated keys.
+func (c *jwksCache) key(alg, kid string) (interface{}, error) {
+	if k, ok := c.lookup(alg, kid); ok && !c.stale() {
+		return k, nil
+	}
+
+	if err := c.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	if k, ok := c.lookup(alg, kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, kid)
+}
+
+func (c *jwksCache) lookup(alg, kid string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if kid != "" {
+		k, ok := c.keys[kid]
+		if !ok || (k.alg != "" && k.alg != alg) {
+			return nil, false
+		}
+		return k.key, true
+	}
+
+	// Tokens without a kid are accepted only if exactly one key can verify them.
+	var found interface{}
+	matches := 0
+	for _, k := range c.keys {
+		if k.alg == alg {
+			found = k.key
+			matches++
+		}
+	}
+	return found, matches == 1
+}
+
+func (c *jwksCache) stale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clock.Now().Sub(c.checkedAt) >= c.interval
+}
+
+// reloadIfChanged re-reads the file if its modification time or size changed.
+func (c *jwksCache) reloadIfChanged() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat JWKS file: %w", err)
+	}
+
+	c.mu.Lock()
+	c.checkedAt = c.clock.Now()
+	changed := !info.ModTime().Equal(c.modTime) || info.Size() != c.size
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return c.load()
+}
+
+// load reads and parses the JWKS file.
+func (c *jwksCache) load() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat JWKS file: %w", err)
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+	c.checkedAt = c.clock.Now()
+	return nil
+}
+
+// parseJWKS parses a JWKS document into keys by key ID.
+// Keys with an unsupported type or curve are skipped.
+func parseJWKS(data []byte) (map[string]jwksKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]jwksKey, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS key %q: invalid modulus: %w", kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS key %q: invalid exponent: %w", kid, err)
+			}
+			keys[kid] = jwksKey{alg: RS256, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}
+
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS key %q: invalid x: %w", kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS key %q: invalid y: %w", kid, err)
+			}
+			keys[kid] = jwksKey{alg: ES256, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}
+
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS key %q: invalid secret: %w", kid, err)
+			}
+			keys[kid] = jwksKey{alg: HS256, key: secret}
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ParsePublicKeyPEM parses a PEM-encoded PKIX public key (RSA or ECDSA).
+func ParsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,417 @@
+// Package auth verifies JWTs for the Nim agent server.
+//
+// A Verifier checks HS256, RS256 and ES256 signatures against a static key
+// or a local JWKS file, validates the exp, nbf, aud and iss claims, and
+// extracts the user ID from a configurable claim. Plug it into the server
+// with server.Config.JWTVerifier.
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Verification errors. Errors returned by Verify wrap one of these.
+var (
+	ErrMissingToken         = errors.New("missing token")
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrTokenExpired         = errors.New("token expired")
+	ErrTokenNotYetValid     = errors.New("token not yet valid")
+	ErrInvalidIssuer        = errors.New("invalid issuer")
+	ErrInvalidAudience      = errors.New("invalid audience")
+	ErrMissingUserID        = errors.New("missing user ID claim")
+)
+
+// Config configures a Verifier.
+// Exactly one key source should be set: HMACSecret, PublicKey or JWKSFile.
+type Config struct {
+	// HMACSecret is the shared secret for HS256 tokens.
+	HMACSecret []byte
+
+	// PublicKey is a static *rsa.PublicKey (RS256) or *ecdsa.PublicKey (ES256).
+	// Use ParsePublicKeyPEM to load one from PEM.
+	PublicKey crypto.PublicKey
+
+	// JWKSFile is the path to a local JWKS file. Keys are selected by the
+	// token's "kid" header. The file is re-read when it changes, so keys can
+	// be rotated by rewriting it.
+	JWKSFile string
+
+	// JWKSRefreshInterval is how often the JWKS file is checked for changes.
+	// Unknown key IDs always trigger an immediate check. Defaults to 1 minute.
+	JWKSRefreshInterval time.Duration
+
+	// Algorithms restricts the accepted algorithms.
+	// Defaults to all supported algorithms the key source can verify.
+	Algorithms []string
+
+	// Issuer is the required "iss" claim. Not checked if empty.
+	Issuer string
+
+	// Audience is a required entry of the "aud" claim. Not checked if empty.
+	Audience string
+
+	// UserIDClaim is the claim holding the user ID. Nested claims use dots
+	// (e.g., "user.id"). Defaults to "sub".
+	UserIDClaim string
+
+	// Leeway is the allowed clock skew when checking exp and nbf.
+	// Defaults to 30 seconds.
+	Leeway time.Duration
+
+	// Clock is the time source for exp and nbf checks and JWKS refreshes.
+	// Defaults to core.SystemClock.
+	Clock core.Clock
+}
+
+// Claims contains the verified claims of a token.
+type Claims struct {
+	// UserID is the value of the configured user ID claim.
+	UserID string
+
+	// Subject is the "sub" claim.
+	Subject string
+
+	// Issuer is the "iss" claim.
+	Issuer string
+
+	// Audience is the "aud" claim, normalized to a list.
+	Audience []string
+
+	// ExpiresAt is the "exp" claim, zero if absent.
+	ExpiresAt time.Time
+
+	// NotBefore is the "nbf" claim, zero if absent.
+	NotBefore time.Time
+
+	// IssuedAt is the "iat" claim, zero if absent.
+	IssuedAt time.Time
+
+	// Raw contains all claims as decoded from the payload.
+	Raw map[string]interface{}
+}
+
+// Verifier verifies JWTs. It is safe for concurrent use.
+type Verifier struct {
+	config     Config
+	algorithms map[string]bool
+	jwks       *jwksCache
+}
+
+// NewVerifier creates a verifier from the given configuration.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	sources := 0
+	if len(cfg.HMACSecret) > 0 {
+		sources++
+	}
+	if cfg.PublicKey != nil {
+		sources++
+	}
+	if cfg.JWKSFile != "" {
+		sources++
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of HMACSecret, PublicKey or JWKSFile is required")
+	}
+
+	if cfg.UserIDClaim == "" {
+		cfg.UserIDClaim = "sub"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = 30 * time.Second
+	}
+	if cfg.JWKSRefreshInterval == 0 {
+		cfg.JWKSRefreshInterval = time.Minute
+	}
+	cfg.Clock = core.ClockOrDefault(cfg.Clock)
+
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		switch key := cfg.PublicKey.(type) {
+		case nil:
+			if len(cfg.HMACSecret) > 0 {
+				algorithms = []string{HS256}
+			} else {
+				algorithms = []string{HS256, RS256, ES256}
+			}
+		case *rsa.PublicKey:
+			algorithms = []string{RS256}
+		case *ecdsa.PublicKey:
+			algorithms = []string{ES256}
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+
+	v := &Verifier{
+		config:     cfg,
+		algorithms: make(map[string]bool, len(algorithms)),
+	}
+	for _, alg := range algorithms {
+		switch alg {
+		case HS256, RS256, ES256:
+			v.algorithms[alg] = true
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+		}
+	}
+
+	if cfg.JWKSFile != "" {
+		v.jwks = newJWKSCache(cfg.JWKSFile, cfg.JWKSRefreshInterval, cfg.Clock)
+		if err := v.jwks.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// Verify checks the token's signature and claims and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+
+	key, err := v.keyFor(header.Alg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+
+	claims := &Claims{
+		Subject:   stringClaim(raw, "sub"),
+		Issuer:    stringClaim(raw, "iss"),
+		Audience:  audienceClaim(raw["aud"]),
+		ExpiresAt: timeClaim(raw, "exp"),
+		NotBefore: timeClaim(raw, "nbf"),
+		IssuedAt:  timeClaim(raw, "iat"),
+		Raw:       raw,
+	}
+
+	now := v.config.Clock.Now()
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(v.config.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.config.Leeway).Before(claims.NotBefore) {
+		return nil, ErrTokenNotYetValid
+	}
+	if v.config.Issuer != "" && claims.Issuer != v.config.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if v.config.Audience != "" && !contains(claims.Audience, v.config.Audience) {
+		return nil, ErrInvalidAudience
+	}
+
+	claims.UserID = lookupClaim(raw, v.config.UserIDClaim)
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrMissingUserID, v.config.UserIDClaim)
+	}
+
+	return claims, nil
+}
+
+// AuthFunc returns a server AuthFunc that verifies the request's bearer
+// token and returns the user ID from the configured claim.
+func (v *Verifier) AuthFunc() func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		claims, err := v.Verify(TokenFromRequest(r))
+		if err != nil {
+			return "", err
+		}
+		return claims.UserID, nil
+	}
+}
+
+// TokenFromRequest extracts the bearer token from the "token" query
+// parameter (used by browser WebSocket clients) or the Authorization header.
+func TokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return ""
+}
+
+// keyFor returns the verification key for the algorithm and key ID.
+func (v *Verifier) keyFor(alg, kid string) (interface{}, error) {
+	if v.jwks != nil {
+		return v.jwks.key(alg, kid)
+	}
+	if alg == HS256 {
+		return v.config.HMACSecret, nil
+	}
+	return v.config.PublicKey, nil
+}
+
+// verifySignature checks the signature, making sure the key type matches
+// the algorithm so an HMAC secret can never be confused with a public key.
+func verifySignature(alg string, key interface{}, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return fmt.Errorf("%w: no HMAC secret for %s", ErrUnknownKey, alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: no RSA key for %s", ErrUnknownKey, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return fmt.Errorf("%w: no P-256 key for %s", ErrUnknownKey, alg)
+		}
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func stringClaim(raw map[string]interface{}, name string) string {
+	s, _ := raw[name].(string)
+	return s
+}
+
+func timeClaim(raw map[string]interface{}, name string) time.Time {
+	n, ok := raw[name].(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+func audienceClaim(v interface{}) []string {
+	switch aud := v.(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		result := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// lookupClaim resolves a dotted claim path to a string.
+func lookupClaim(raw map[string]interface{}, path string) string {
+	var current interface{} = raw
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		current = m[part]
+	}
+	switch v := current.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
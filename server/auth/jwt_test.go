@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+var testNow = time.Unix(1760000000, 0)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign builds a compact JWT with the given header fields and claims.
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case RS256:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "user_123",
+		"iss": "https://issuer.example",
+		"aud": []string{"nim"},
+		"exp": testNow.Add(time.Hour).Unix(),
+		"nbf": testNow.Add(-time.Minute).Unix(),
+	}
+}
+
+func TestVerifier_Algorithms(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		alg     string
+		signKey interface{}
+	}{
+		{"HS256", Config{HMACSecret: secret, Clock: core.NewFakeClock(testNow)}, HS256, secret},
+		{"RS256", Config{PublicKey: &rsaKey.PublicKey, Clock: core.NewFakeClock(testNow)}, RS256, rsaKey},
+		{"ES256", Config{PublicKey: &ecKey.PublicKey, Clock: core.NewFakeClock(testNow)}, ES256, ecKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVerifier(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewVerifier() error = %v", err)
+			}
+			claims, err := v.Verify(sign(t, tt.alg, "", tt.signKey, validClaims()))
+			if err != nil {
+				t.Fatalf("verify error = %v", err)
+			}
+			if claims.UserID != "user_123" {
+				t.Errorf("UserID = %q, want %q", claims.UserID, "user_123")
+			}
+		})
+	}
+}
+
+func TestVerifier_RejectsInvalidTokens(t *testing.T) {
+	secret := []byte("test-secret")
+	v, err := NewVerifier(Config{
+		HMACSecret: secret,
+		Issuer:     "https://issuer.example",
+		Audience:   "nim",
+		Clock:      core.NewFakeClock(testNow),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := validClaims()
+		c[key] = value
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"missing", "", ErrMissingToken},
+		{"malformed", "not-a-jwt", ErrMalformedToken},
+		{"expired", sign(t, HS256, "", secret, with("exp", testNow.Add(-time.Hour).Unix())), ErrTokenExpired},
+		{"not yet valid", sign(t, HS256, "", secret, with("nbf", testNow.Add(time.Hour).Unix())), ErrTokenNotYetValid},
+		{"wrong issuer", sign(t, HS256, "", secret, with("iss", "https://evil.example")), ErrInvalidIssuer},
+		{"wrong audience", sign(t, HS256, "", secret, with("aud", "other")), ErrInvalidAudience},
+		{"wrong secret", sign(t, HS256, "", []byte("other-secret"), validClaims()), ErrInvalidSignature},
+		{"missing subject", sign(t, HS256, "", secret, with("sub", nil)), ErrMissingUserID},
+		{"alg none", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"user_123"}`)) + ".", ErrUnsupportedAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifier_LeewayAndUserIDClaim(t *testing.T) {
+	secret := []byte("test-secret")
+	v, err := NewVerifier(Config{HMACSecret: secret, UserIDClaim: "liminal.user_id", Leeway: time.Minute, Clock: core.NewFakeClock(testNow)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := validClaims()
+	claims["exp"] = testNow.Add(-30 * time.Second).Unix()
+	claims["liminal"] = map[string]interface{}{"user_id": "usr_nested"}
+
+	got, err := v.Verify(sign(t, HS256, "", secret, claims))
+	if err != nil {
+		t.Fatalf("verify error = %v", err)
+	}
+	if got.UserID != "usr_nested" {
+		t.Errorf("UserID = %q, want %q", got.UserID, "usr_nested")
+	}
+}
+
+func TestVerifier_ExpiresWithClock(t *testing.T) {
+	secret := []byte("test-secret")
+	clock := core.NewFakeClock(testNow)
+	v, err := NewVerifier(Config{HMACSecret: secret, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := sign(t, HS256, "", secret, validClaims())
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("verify error = %v", err)
+	}
+	// Valid until an hour later, plus the default 30 seconds of leeway
+	clock.Advance(time.Hour + 30*time.Second)
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("verify within leeway error = %v", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := v.Verify(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("verify after expiry error = %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestVerifier_RejectsHMACWithPublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	v, err := NewVerifier(Config{PublicKey: &rsaKey.PublicKey, Algorithms: []string{RS256, HS256}, Clock: core.NewFakeClock(testNow)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Classic key confusion: sign HS256 with the public key's bytes.
+	token := sign(t, HS256, "", rsaKey.PublicKey.N.Bytes(), validClaims())
+	if _, err := v.Verify(token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("verify error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PrivateKey, modTime time.Time) {
+	t.Helper()
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   b64(key.PublicKey.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		})
+	}
+	data, _ := json.Marshal(set)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifier_JWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"old": oldKey}, time.Now().Add(-time.Hour))
+
+	v, err := NewVerifier(Config{JWKSFile: path, JWKSRefreshInterval: time.Hour, Clock: core.NewFakeClock(testNow)})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	if _, err := v.Verify(sign(t, RS256, "old", oldKey, validClaims())); err != nil {
+		t.Fatalf("verify with old key error = %v", err)
+	}
+
+	newToken := sign(t, RS256, "new", newKey, validClaims())
+	if _, err := v.Verify(newToken); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("verify before rotation error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// Rotate: the unknown kid triggers an immediate reload.
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"new": newKey}, time.Now())
+
+	if _, err := v.Verify(newToken); err != nil {
+		t.Errorf("verify after rotation error = %v", err)
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws?token=from-query", nil)
+	r.Header.Set("Authorization", "Bearer from-header")
+	if got := TokenFromRequest(r); got != "from-query" {
+		t.Errorf("TokenFromRequest() = %q, want %q", got, "from-query")
+	}
+
+	r = httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Authorization", "Bearer from-header")
+	if got := TokenFromRequest(r); got != "from-header" {
+		t.Errorf("TokenFromRequest() = %q, want %q", got, "from-header")
+	}
+}
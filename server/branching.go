@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/metrics"
+)
+
+// handleEditMessage replaces one of the user's earlier messages and reruns
+// the agent from there. The old message and everything after it stay in
+// the store on an abandoned branch.
+func (s *Server) handleEditMessage(ctx context.Context, c client, sess *session, messageID, content string) {
+	if content == "" {
+		s.sendError(c, ErrCodeInvalidField, "Message content cannot be empty")
+		return
+	}
+
+	i := sess.indexOf(messageID)
+	if i < 0 || !isUserText(sess.History[i]) {
+		s.sendError(c, ErrCodeNotFound, "Message not found")
+		return
+	}
+
+	if err := s.rewind(ctx, sess, i); err != nil {
+		s.sendStoreError(c, sess.UserID, sess.ConversationID, "Failed to edit message", err)
+		return
+	}
+	s.sessionLogger(sess).Info("editing message", logging.Message(messageID))
+
+	s.handleMessage(ctx, c, sess, content)
+}
+
+// handleRegenerate reruns the agent for a user message, replacing its reply.
+// messageID may name the user message or any message in its reply; if empty,
+// the last user message is used.
+func (s *Server) handleRegenerate(ctx context.Context, c client, sess *session, messageID string) {
+	from := len(sess.History) - 1
+	if messageID != "" {
+		from = sess.indexOf(messageID)
+		if from < 0 {
+			s.sendError(c, ErrCodeNotFound, "Message not found")
+			return
+		}
+	}
+
+	i := from
+	for i >= 0 && !isUserText(sess.History[i]) {
+		i--
+	}
+	if i < 0 {
+		s.sendError(c, ErrCodeNotFound, "Nothing to regenerate")
+		return
+	}
+
+	if err := s.rewind(ctx, sess, i+1); err != nil {
+		s.sendStoreError(c, sess.UserID, sess.ConversationID, "Failed to regenerate", err)
+		return
+	}
+	s.sessionLogger(sess).Info("regenerating reply", logging.Message(sess.MessageIDs[i]))
+
+	s.runTurn(ctx, c, sess, sess.History[i].GetText())
+}
+
+// rewind truncates the session to its first n messages and moves the
+// stored active branch to match. Actions awaiting confirmation on the
+// abandoned part are cancelled.
+func (s *Server) rewind(ctx context.Context, sess *session, n int) error {
+	if err := s.conversations.SetActiveLeaf(ctx, sess.UserID, sess.ConversationID, leafOf(sess.MessageIDs[:n])); err != nil {
+		return err
+	}
+
+	// Actions are found by their tool call, so this finds them whether or
+	// not they were requested on this connection
+	logger := s.sessionLogger(sess)
+	for _, msg := range sess.History[n:] {
+		for _, block := range msg.ContentBlocks {
+			if block.ToolUse == nil {
+				continue
+			}
+
+			// Calls that ran, or whose action is already resolved or
+			// expired, have nothing to cancel
+			action, err := s.confirmations.GetByBlock(ctx, sess.UserID, sess.ID, block.ToolUse.ID)
+			if err != nil || action == nil {
+				continue
+			}
+			if err := s.confirmations.Cancel(ctx, sess.UserID, action.ID); err == nil {
+				s.metrics.Confirmation(metrics.ConfirmationCancelled)
+				logger.Info("cancelled action on abandoned branch", logging.Action(action.ID))
+			}
+		}
+	}
+
+	// Cap the slices so later appends never write into the abandoned tail
+	sess.History = sess.History[:n:n]
+	sess.MessageIDs = sess.MessageIDs[:n:n]
+	return nil
+}
+
+// leafID returns the ID of the last message in the session, or "" if empty.
+func (sess *session) leafID() string {
+	return leafOf(sess.MessageIDs)
+}
+
+func leafOf(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
+
+// indexOf returns the position of the message in the session's history, or -1.
+func (sess *session) indexOf(messageID string) int {
+	for i, id := range sess.MessageIDs {
+		if id == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// awaitingResult reports whether the tool call is on the session's branch
+// and has no result yet, i.e. whether its action can still be resolved.
+func (sess *session) awaitingResult(toolUseID string) bool {
+	found := false
+	for _, msg := range sess.History {
+		for _, block := range msg.ContentBlocks {
+			if block.ToolUse != nil && block.ToolUse.ID == toolUseID {
+				found = true
+			}
+			if block.ToolResult != nil && block.ToolResult.ToolUseID == toolUseID {
+				return false
+			}
+		}
+	}
+	return found
+}
+
+// isUserText reports whether msg was typed by the user, as opposed to a
+// user-role message carrying tool results.
+func isUserText(msg core.Message) bool {
+	if msg.Role != core.RoleUser {
+		return false
+	}
+	for _, block := range msg.ContentBlocks {
+		if block.ToolResult != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// offBranchReply answers a confirm or cancel refused by offBranch.
+const offBranchReply = "That action is no longer valid because the conversation changed."
+
+// offBranch reports whether an action must be refused because its tool
+// call is not awaiting a result on the session's branch, e.g. because the
+// message that led to it was edited or it was requested in another
+// conversation with the same tool_use ID. The action is left in the store: it
+// may belong to another conversation, and actions this session abandoned
+// were already cancelled by rewind. Unknown or expired actions are left to
+// the caller.
+func (s *Server) offBranch(ctx context.Context, sess *session, userID, actionID string) bool {
+	action, err := s.confirmations.Get(ctx, userID, actionID)
+	if err != nil || action.SessionID == sess.ID && sess.awaitingResult(action.BlockID) {
+		return false
+	}
+
+	s.sessionLogger(sess).Info("refused action not on the active branch", logging.Action(actionID))
+	return true
+}
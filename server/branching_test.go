@@ -0,0 +1,227 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// sharedUser is the user ID of every caller of a server without auth.
+const sharedUser = "default-user"
+
+// requestPayment starts a conversation on c in which the agent asks to
+// confirm a payment, returning the conversation, the user message and the
+// action IDs.
+func requestPayment(t *testing.T, c *wsConn, llm *llmtest.Server) (conversationID, messageID, actionID string) {
+	t.Helper()
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID = c.last("conversation_started").ConversationID
+
+	llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":"5"}`})
+	c.send(server.ClientMessage{Type: "message", Content: "Pay Alice $5"})
+	msgs := c.until("confirm_request")
+	saved, ok := find(msgs, "message_saved")
+	if !ok {
+		t.Fatalf("no message_saved in %v", types(msgs))
+	}
+	return conversationID, saved.MessageID, msgs[len(msgs)-1].ActionID
+}
+
+// lastRequest returns the body of the model's latest request.
+func lastRequest(llm *llmtest.Server) string {
+	requests := llm.Requests()
+	return requests[len(requests)-1]
+}
+
+func TestEditMessage(t *testing.T) {
+	ctx := context.Background()
+	confirmations := store.NewMemoryConfirmations()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{Confirmations: confirmations})
+	var runs atomic.Int32
+	srv.AddTool(payTool(&runs))
+
+	c := dial(t, url)
+	_, messageID, actionID := requestPayment(t, c, llm)
+
+	llm.Script(llmtest.Reply{Text: "Which Bob?"})
+	c.send(server.ClientMessage{Type: "edit_message", MessageID: messageID, Content: "Pay Bob $5"})
+	msgs := c.until("complete")
+	if saved, _ := find(msgs, "message_saved"); saved.MessageID == "" || saved.MessageID == messageID {
+		t.Errorf("message_saved = %+v, want a new message replacing %s", saved, messageID)
+	}
+	if text, _ := find(msgs, "text"); text.Content != "Which Bob?" {
+		t.Errorf("text = %q, want the reply to the edit", text.Content)
+	}
+	if req := lastRequest(llm); !strings.Contains(req, "Pay Bob") || strings.Contains(req, "Pay Alice") {
+		t.Errorf("model request = %s, want only the edited message", req)
+	}
+
+	// The action on the abandoned branch can no longer run
+	if _, err := confirmations.Get(ctx, sharedUser, actionID); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("action after edit: err = %v, want ErrActionNotFound", err)
+	}
+	c.send(server.ClientMessage{Type: "confirm", ActionID: actionID})
+	c.until("complete")
+	if runs.Load() != 0 {
+		t.Errorf("pay ran %d times after its message was edited", runs.Load())
+	}
+
+	c.send(server.ClientMessage{Type: "edit_message", MessageID: "unknown", Content: "Hi"})
+	if msg := c.last("error"); msg.Code != server.ErrCodeNotFound {
+		t.Errorf("editing an unknown message: code = %q, want %q", msg.Code, server.ErrCodeNotFound)
+	}
+}
+
+func TestEditMessage_AfterResume(t *testing.T) {
+	ctx := context.Background()
+	confirmations := store.NewMemoryConfirmations()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{Confirmations: confirmations})
+	var runs atomic.Int32
+	srv.AddTool(payTool(&runs))
+
+	first := dial(t, url)
+	conversationID, messageID, actionID := requestPayment(t, first, llm)
+
+	// A new connection knows nothing of the actions requested on the first
+	second := dial(t, url)
+	second.send(server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID})
+	second.last("conversation_resumed")
+
+	llm.Script(llmtest.Reply{Text: "Which Bob?"})
+	second.send(server.ClientMessage{Type: "edit_message", MessageID: messageID, Content: "Pay Bob $5"})
+	second.until("complete")
+
+	if _, err := confirmations.Get(ctx, sharedUser, actionID); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("action after edit on another connection: err = %v, want ErrActionNotFound", err)
+	}
+	first.send(server.ClientMessage{Type: "confirm", ActionID: actionID})
+	first.until("complete")
+	if runs.Load() != 0 {
+		t.Errorf("pay ran %d times after its message was edited", runs.Load())
+	}
+}
+
+func TestEditMessage_SameToolUseIDs(t *testing.T) {
+	ctx := context.Background()
+	confirmations := store.NewMemoryConfirmations()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{Confirmations: confirmations})
+	var runs atomic.Int32
+	srv.AddTool(payTool(&runs))
+
+	// Gateways may number tool calls per conversation, so two
+	// conversations can hold the same tool_use ID
+	c := dial(t, url)
+	request := func() (conversationID, messageID, actionID string) {
+		c.send(server.ClientMessage{Type: "new_conversation"})
+		conversationID = c.last("conversation_started").ConversationID
+		llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":"5"}`, ToolUseID: "toolu_01"})
+		c.send(server.ClientMessage{Type: "message", Content: "Pay Alice $5"})
+		msgs := c.until("confirm_request")
+		saved, _ := find(msgs, "message_saved")
+		return conversationID, saved.MessageID, msgs[len(msgs)-1].ActionID
+	}
+	first, messageID, firstAction := request()
+	second, _, secondAction := request()
+	if firstAction == secondAction {
+		t.Fatalf("both actions have ID %s", firstAction)
+	}
+
+	// Editing the first conversation cancels only its own action
+	c.send(server.ClientMessage{Type: "resume_conversation", ConversationID: first})
+	c.last("conversation_resumed")
+	llm.Script(llmtest.Reply{Text: "Which Bob?"})
+	c.send(server.ClientMessage{Type: "edit_message", MessageID: messageID, Content: "Pay Bob $5"})
+	c.until("complete")
+	if _, err := confirmations.Get(ctx, sharedUser, firstAction); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("edited conversation's action: err = %v, want ErrActionNotFound", err)
+	}
+	if _, err := confirmations.Get(ctx, sharedUser, secondAction); err != nil {
+		t.Fatalf("other conversation's action: err = %v", err)
+	}
+
+	c.send(server.ClientMessage{Type: "resume_conversation", ConversationID: second})
+	c.last("conversation_resumed")
+	llm.Script(llmtest.Reply{Text: "Paid."})
+	c.send(server.ClientMessage{Type: "confirm", ActionID: secondAction})
+	c.until("complete")
+	if runs.Load() != 1 {
+		t.Errorf("pay ran %d times, want once for the other conversation", runs.Load())
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID := c.last("conversation_started").ConversationID
+
+	llm.Script(llmtest.Reply{Text: "Hello."})
+	c.send(server.ClientMessage{Type: "message", Content: "Hi"})
+	c.until("complete")
+
+	llm.Script(llmtest.Reply{Text: "Hey there."})
+	c.send(server.ClientMessage{Type: "regenerate"})
+	msgs := c.until("complete")
+	if _, ok := find(msgs, "message_saved"); ok {
+		t.Error("regenerate saved the user message again")
+	}
+	if text, _ := find(msgs, "text"); text.Content != "Hey there." {
+		t.Errorf("text = %q, want the new reply", text.Content)
+	}
+	if req := lastRequest(llm); strings.Contains(req, "Hello.") {
+		t.Errorf("model request = %s, want the old reply left out", req)
+	}
+
+	// The active branch has the new reply only
+	other := dial(t, url)
+	other.send(server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID})
+	resumed := other.last("conversation_resumed").Messages
+	if len(resumed) != 2 || resumed[0].Content != "Hi" || resumed[1].Content != "Hey there." {
+		t.Errorf("resumed messages = %+v, want Hi and the new reply", resumed)
+	}
+
+	c.send(server.ClientMessage{Type: "regenerate", MessageID: "unknown"})
+	if msg := c.last("error"); msg.Code != server.ErrCodeNotFound {
+		t.Errorf("regenerating an unknown message: code = %q, want %q", msg.Code, server.ErrCodeNotFound)
+	}
+}
+
+func TestRegenerate_CancelsPendingAction(t *testing.T) {
+	ctx := context.Background()
+	confirmations := store.NewMemoryConfirmations()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{Confirmations: confirmations})
+	var runs atomic.Int32
+	srv.AddTool(payTool(&runs))
+
+	first := dial(t, url)
+	conversationID, _, actionID := requestPayment(t, first, llm)
+
+	second := dial(t, url)
+	second.send(server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID})
+	second.last("conversation_resumed")
+
+	llm.Script(llmtest.Reply{Text: "Who is Alice?"})
+	second.send(server.ClientMessage{Type: "regenerate"})
+	second.until("complete")
+
+	if _, err := confirmations.Get(ctx, sharedUser, actionID); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("action after regenerate: err = %v, want ErrActionNotFound", err)
+	}
+	second.send(server.ClientMessage{Type: "confirm", ActionID: actionID})
+	second.until("complete")
+	if runs.Load() != 0 {
+		t.Errorf("pay ran %d times after its reply was regenerated", runs.Load())
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols, which select how messages are encoded. Without
+// one, messages are JSON.
+const (
+	// SubprotocolJSON sends messages as JSON text frames.
+	SubprotocolJSON = "nim.json"
+
+	// SubprotocolCBOR sends messages as CBOR (RFC 8949) binary frames,
+	// with the same structure as the JSON.
+	SubprotocolCBOR = "nim.cbor"
+)
+
+// Subprotocols lists the subprotocols the server accepts, most preferred
+// first.
+var Subprotocols = []string{SubprotocolCBOR, SubprotocolJSON}
+
+// Codec encodes protocol messages for the subprotocol a WebSocket agreed.
+type Codec struct {
+	// FrameType is the WebSocket message type to send: websocket.TextMessage
+	// or websocket.BinaryMessage.
+	FrameType int
+
+	// fromJSON and toJSON convert between JSON and the wire encoding; nil
+	// for JSON itself.
+	fromJSON func([]byte) ([]byte, error)
+	toJSON   func([]byte) ([]byte, error)
+}
+
+// CodecFor returns the codec of a subprotocol, as returned by
+// websocket.Conn.Subprotocol. Unknown subprotocols, and none, use JSON.
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolCBOR {
+		return Codec{FrameType: websocket.BinaryMessage, fromJSON: cborFromJSON, toJSON: cborToJSON}
+	}
+	return Codec{FrameType: websocket.TextMessage}
+}
+
+// Marshal encodes a message.
+func (c Codec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || c.fromJSON == nil {
+		return data, err
+	}
+	return c.fromJSON(data)
+}
+
+// Unmarshal decodes a message into v.
+func (c Codec) Unmarshal(data []byte, v any) error {
+	data, err := c.JSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// JSON converts an encoded message to JSON.
+func (c Codec) JSON(data []byte) ([]byte, error) {
+	if c.toJSON == nil {
+		return data, nil
+	}
+	return c.toJSON(data)
+}
+
+// CBOR messages have the structure of the JSON ones. Keys are sorted and
+// floats written in the shortest form that keeps their value, and only
+// items JSON can represent are decoded: maps with text keys, arrays, text,
+// numbers, booleans and null.
+var (
+	cborEnc = mustMode(cbor.CoreDetEncOptions().EncMode())
+	cborDec = mustMode(cbor.DecOptions{
+		DupMapKey:             cbor.DupMapKeyEnforcedAPF,
+		MaxNestedLevels:       64,
+		TagsMd:                cbor.TagsForbidden,
+		DefaultMapType:        reflect.TypeOf(map[string]any(nil)),
+		DefaultByteStringType: reflect.TypeOf(""),
+		NaN:                   cbor.NaNDecodeForbidden,
+		Inf:                   cbor.InfDecodeForbidden,
+		UTF8:                  cbor.UTF8RejectInvalid,
+		SimpleValues:          mustMode(jsonSimpleValues()),
+	}.DecMode())
+)
+
+// jsonSimpleValues rejects the CBOR simple values other than false, true,
+// null and undefined.
+func jsonSimpleValues() (*cbor.SimpleValueRegistry, error) {
+	var reject []func(*cbor.SimpleValueRegistry) error
+	for sv := 0; sv <= 255; sv++ {
+		if sv < 20 || sv > 31 {
+			reject = append(reject, cbor.WithRejectedSimpleValue(cbor.SimpleValue(sv)))
+		}
+	}
+	return cbor.NewSimpleValueRegistryFromDefaults(reject...)
+}
+
+func mustMode[M any](mode M, err error) M {
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+// cborFromJSON encodes a JSON document as CBOR. Integers become CBOR
+// integers and other numbers floats.
+func cborFromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data after JSON document")
+	}
+	v, err := cborNumbers(v)
+	if err != nil {
+		return nil, err
+	}
+	return cborEnc.Marshal(v)
+}
+
+// cborNumbers replaces the json.Numbers in v with the integers or floats
+// they hold.
+func cborNumbers(v any) (any, error) {
+	var err error
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, nil
+		}
+		return v.Float64()
+	case []any:
+		for i := range v {
+			if v[i], err = cborNumbers(v[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]any:
+		for k := range v {
+			if v[k], err = cborNumbers(v[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// cborToJSON decodes a CBOR item as a JSON document.
+func cborToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := cborDec.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
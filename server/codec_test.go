@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/metrics"
+)
+
+// benchTurn is a typical streamed reply: a few hundred short text chunks,
+// then the full text and a complete with a tool execution.
+func benchTurn() []ServerMessage {
+	words := strings.Fields(strings.Repeat("Your balance is $1,250.00 and your savings earn 4.5% APY, paid monthly. ", 30))
+	var msgs []ServerMessage
+	for i, word := range words {
+		if i > 0 {
+			word = " " + word
+		}
+		msgs = append(msgs, ServerMessage{Type: "text_chunk", Content: word})
+	}
+	msgs = append(msgs,
+		ServerMessage{Type: "text", Content: strings.Join(words, " ")},
+		ServerMessage{
+			Type:       "complete",
+			TokenUsage: &TokenUsage{InputTokens: 1834, OutputTokens: 412, TotalTokens: 2246},
+			Tools: []core.ToolExecution{{
+				ToolUseID:  "toolu_01A09q90qw90lq917835lq9",
+				Tool:       "get_balance",
+				Input:      map[string]any{"currency": "USD"},
+				Result:     map[string]any{"balance": "1250.00", "currency": "USD", "savings": map[string]any{"balance": "5000.00", "apy": 4.5}},
+				DurationMs: 120,
+			}},
+		},
+	)
+	return msgs
+}
+
+// countingConn counts the bytes read from a connection.
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// BenchmarkWire streams a turn to a client per iteration, reporting the
+// bytes on the wire and the frames each turn takes. The time includes
+// encoding, compression and decoding on both ends.
+func BenchmarkWire(b *testing.B) {
+	coalesce := ConnectionConfig{ChunkFlushInterval: time.Hour, ChunkFlushSize: 256}
+	tests := []struct {
+		name        string
+		subprotocol string
+		compress    bool
+		config      ConnectionConfig
+	}{
+		{"json", SubprotocolJSON, false, ConnectionConfig{}},
+		{"json+deflate", SubprotocolJSON, true, ConnectionConfig{CompressionThreshold: 1}},
+		{"json+deflate>256", SubprotocolJSON, true, ConnectionConfig{}},
+		{"cbor", SubprotocolCBOR, false, ConnectionConfig{}},
+		{"cbor+deflate", SubprotocolCBOR, true, ConnectionConfig{CompressionThreshold: 1}},
+		{"cbor+deflate>256", SubprotocolCBOR, true, ConnectionConfig{}},
+		{"json+coalesce", SubprotocolJSON, false, coalesce},
+		{"json+deflate>256+coalesce", SubprotocolJSON, true, coalesce},
+		{"cbor+deflate>256+coalesce", SubprotocolCBOR, true, coalesce},
+	}
+
+	turn := benchTurn()
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			cfg := tt.config.withDefaults()
+			m, err := metrics.NewPrometheus(nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			s := &Server{metrics: m}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			conns := make(chan *connection, 1)
+			upgrader := websocket.Upgrader{Subprotocols: Subprotocols, EnableCompression: true}
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				ws.SetCompressionLevel(cfg.CompressionLevel)
+				sock := newSocket(ws, len(turn)+sendQueueSize, cfg.CompressionThreshold)
+				go sock.writeLoop(s, time.Hour, logger)
+
+				c := newConnection("bench", "user", cfg)
+				c.attach(sock, 0, &ServerMessage{Type: "connected"})
+				conns <- c
+				// Keep reading so control frames are handled
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						sock.close()
+						return
+					}
+				}
+			}))
+			defer backend.Close()
+
+			var wire atomic.Int64
+			dialer := websocket.Dialer{
+				Subprotocols:      []string{tt.subprotocol},
+				EnableCompression: tt.compress,
+				NetDial: func(network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					return countingConn{conn, &wire}, err
+				},
+			}
+			ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(backend.URL, "http"), nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ws.Close()
+			codec := CodecFor(ws.Subprotocol())
+
+			c := <-conns
+			read := func() ServerMessage {
+				var msg ServerMessage
+				_, data, err := ws.ReadMessage()
+				if err == nil {
+					err = codec.Unmarshal(data, &msg)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				return msg
+			}
+			read() // greeting
+
+			b.ResetTimer()
+			wire.Store(0)
+			frames := 0
+			for i := 0; i < b.N; i++ {
+				for _, msg := range turn {
+					c.send(msg)
+				}
+				for read().Type != "complete" {
+					frames++
+				}
+				frames++
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(wire.Load())/float64(b.N), "wire-B/op")
+			b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		})
+	}
+}
+
+// BenchmarkCodec measures the CPU a turn's messages take to encode, and
+// to decode, in each encoding, with and without deflate. Like
+// permessage-deflate, each message is compressed on its own at the default
+// level. The network is left out, so ns/op is CPU time.
+func BenchmarkCodec(b *testing.B) {
+	turn := benchTurn()
+	level := ConnectionConfig{}.withDefaults().CompressionLevel
+
+	for _, subprotocol := range []string{SubprotocolJSON, SubprotocolCBOR} {
+		codec := CodecFor(subprotocol)
+		for _, compress := range []bool{false, true} {
+			name := strings.TrimPrefix(subprotocol, "nim.")
+			if compress {
+				name += "+deflate"
+			}
+
+			var buf bytes.Buffer
+			fw, err := flate.NewWriter(&buf, level)
+			if err != nil {
+				b.Fatal(err)
+			}
+			encode := func(msg ServerMessage) []byte {
+				data, err := codec.Marshal(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if !compress {
+					return data
+				}
+				buf.Reset()
+				fw.Reset(&buf)
+				fw.Write(data)
+				fw.Flush()
+				return buf.Bytes()
+			}
+
+			b.Run(name+"/encode", func(b *testing.B) {
+				b.ReportAllocs()
+				payload := 0
+				for i := 0; i < b.N; i++ {
+					for _, msg := range turn {
+						payload += len(encode(msg))
+					}
+				}
+				b.ReportMetric(float64(payload)/float64(b.N), "payload-B/op")
+			})
+
+			var encoded [][]byte
+			for _, msg := range turn {
+				encoded = append(encoded, bytes.Clone(encode(msg)))
+			}
+			fr := flate.NewReader(nil)
+			b.Run(name+"/decode", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for _, data := range encoded {
+						if compress {
+							// Flush leaves the stream without a final block, so
+							// it ends unexpectedly
+							fr.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+							if data, err = io.ReadAll(fr); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+								b.Fatal(err)
+							}
+						}
+						var out ServerMessage
+						if err := codec.Unmarshal(data, &out); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCBOR_RoundTrip(t *testing.T) {
+	tests := []string{
+		`null`,
+		`true`,
+		`0`,
+		`-1000`,
+		`18446744073709551615`,
+		`1.5`,
+		`0.1`,
+		`-4.25e-9`,
+		`"héllo ☃"`,
+		`[1,[2,3],{"a":[4,5]}]`,
+		`{"type":"text_chunk","seq":42,"content":"Hello"}`,
+	}
+	codec := CodecFor(SubprotocolCBOR)
+	for _, in := range tests {
+		var v any
+		if err := json.Unmarshal([]byte(in), &v); err != nil {
+			t.Fatal(err)
+		}
+		data, err := codec.Marshal(v)
+		if err != nil {
+			t.Errorf("Marshal(%s): %v", in, err)
+			continue
+		}
+		out, err := codec.JSON(data)
+		if err != nil {
+			t.Errorf("JSON(%x), from %s: %v", data, in, err)
+			continue
+		}
+		if !jsonEqual(t, in, string(out)) {
+			t.Errorf("round trip of %s = %s", in, out)
+		}
+	}
+
+	// Keys are sorted, and integers stay integers
+	data, err := codec.Marshal(map[string]any{"b": []int{2, 3}, "a": 1})
+	if err != nil || hex.EncodeToString(data) != "a26161016162820203" {
+		t.Errorf("Marshal = %x, %v, want a26161016162820203", data, err)
+	}
+}
+
+// TestCBOR_Rejects checks that items without a JSON equivalent are not
+// decoded.
+func TestCBOR_Rejects(t *testing.T) {
+	tests := []struct {
+		name string
+		cbor string
+	}{
+		{"empty", ""},
+		{"truncated string", "6449"},
+		{"trailing data", "0000"},
+		{"integer key", "a10102"},
+		{"tag", "c074323031332d30332d32315432303a30343a30305a"},
+		{"infinity", "f97c00"},
+		{"NaN", "f97e00"},
+		{"simple value", "f0"},
+		{"deep nesting", strings.Repeat("81", 65) + "00"},
+	}
+	codec := CodecFor(SubprotocolCBOR)
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.cbor)
+		if out, err := codec.JSON(data); err == nil {
+			t.Errorf("%s: JSON(%s) = %s, want an error", tt.name, tt.cbor, out)
+		}
+	}
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("unmarshal %s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("unmarshal %s: %v", b, err)
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
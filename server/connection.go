@@ -0,0 +1,494 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/logging"
+)
+
+// sendQueueSize is how many live messages may wait for a socket's writer
+// on top of a full replay. A client that falls further behind is
+// disconnected, and can resume.
+const sendQueueSize = 256
+
+// writeTimeout bounds each write, so a stalled client cannot hold a
+// connection open forever.
+const writeTimeout = 10 * time.Second
+
+// errTurnInProgress is sent when a client starts a turn, or switches
+// conversation, while another turn is running.
+const errTurnInProgress = "A response is already in progress. Send 'stop' to cancel it."
+
+// errConversationBusy answers a turn refused because another connection or
+// HTTP request is running one on the same conversation.
+const errConversationBusy = "A response is already in progress in this conversation elsewhere. Try again once it finishes."
+
+// stoppedMarker ends the assistant message recorded for a stopped turn.
+const stoppedMarker = "[Stopped by user]"
+
+// ConnectionConfig configures WebSocket connections: keepalives,
+// resumption of dropped connections, compression and chunk coalescing.
+type ConnectionConfig struct {
+	// PingInterval is how often the server pings each client.
+	// Defaults to 30 seconds.
+	PingInterval time.Duration
+
+	// PongTimeout closes a connection that has sent nothing, not even a
+	// pong, for this long. Defaults to 60 seconds.
+	PongTimeout time.Duration
+
+	// ResumeWindow is how long a dropped connection is kept, with its
+	// conversation and any running turn, for the client to resume.
+	// Defaults to 2 minutes.
+	ResumeWindow time.Duration
+
+	// ReplayBufferSize is how many recent messages each connection keeps
+	// for replay on resume. Defaults to 1000.
+	ReplayBufferSize int
+
+	// DisableCompression turns off permessage-deflate, which is otherwise
+	// negotiated with clients that offer it.
+	DisableCompression bool
+
+	// CompressionLevel is the deflate level, from 1 (fastest) to 9
+	// (smallest). Defaults to 1.
+	CompressionLevel int
+
+	// CompressionThreshold sends messages smaller than this many bytes
+	// uncompressed, as deflate barely shrinks them. Defaults to 256.
+	CompressionThreshold int
+
+	// ChunkFlushInterval coalesces text_chunk messages: chunks are merged
+	// for up to this long before being sent as one. Zero, the default,
+	// sends each chunk as it arrives.
+	ChunkFlushInterval time.Duration
+
+	// ChunkFlushSize sends coalesced chunks as soon as they reach this
+	// many bytes. Defaults to 512.
+	ChunkFlushSize int
+}
+
+func (cfg ConnectionConfig) withDefaults() ConnectionConfig {
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.PongTimeout <= 0 {
+		cfg.PongTimeout = 60 * time.Second
+	}
+	if cfg.ResumeWindow <= 0 {
+		cfg.ResumeWindow = 2 * time.Minute
+	}
+	if cfg.ReplayBufferSize <= 0 {
+		cfg.ReplayBufferSize = 1000
+	}
+	if cfg.CompressionLevel < 1 || cfg.CompressionLevel > 9 {
+		cfg.CompressionLevel = 1
+	}
+	if cfg.CompressionThreshold <= 0 {
+		cfg.CompressionThreshold = 256
+	}
+	if cfg.ChunkFlushSize <= 0 {
+		cfg.ChunkFlushSize = 512
+	}
+	return cfg
+}
+
+// connection is a client's state across WebSockets: its conversation, the
+// turn in flight and the messages sent to it. Each message gets the next
+// sequence number and is kept in a bounded buffer, so a client that
+// reconnects can resume with the last sequence it saw and have the rest
+// replayed. A dropped connection is kept for ResumeWindow, with its turn
+// still running, before it is discarded.
+//
+// At most one turn (an engine run, confirmation or cancellation) is in
+// flight at a time, and the read loop keeps reading while it runs so the
+// client can stop it.
+type connection struct {
+	id     string
+	userID string
+	config ConnectionConfig
+
+	mu       sync.Mutex
+	seq      int64
+	buffer   []ServerMessage
+	sock     *socket
+	session  *session
+	turn     *turn
+	expiry   *time.Timer
+	closed   bool
+	protocol protocol
+
+	// chunks holds text_chunk content being coalesced until chunkTimer
+	// fires, it grows past ChunkFlushSize or another message is sent.
+	chunks     strings.Builder
+	chunkTimer *time.Timer
+}
+
+// protocol is what a connection's client negotiated with hello.
+type protocol struct {
+	version      int
+	capabilities map[string]bool
+
+	// strict rejects fields the protocol or message type does not define.
+	strict bool
+}
+
+// legacyProtocol is used for clients that send no hello: they get every
+// capability, and lenient decoding.
+var legacyProtocol = protocol{version: ProtocolVersion, capabilities: map[string]bool{
+	CapabilityStreaming:          true,
+	CapabilityEvents:             true,
+	CapabilityBatchConfirmations: true,
+}}
+
+// wants reports whether the client negotiated the capability a message
+// type needs.
+func (p protocol) wants(msgType string) bool {
+	switch msgType {
+	case "text_chunk":
+		return p.capabilities[CapabilityStreaming]
+	case "conversation_titled":
+		return p.capabilities[CapabilityEvents]
+	}
+	return true
+}
+
+// turn is a unit of work running in the background for a connection.
+type turn struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newConnection(id, userID string, cfg ConnectionConfig) *connection {
+	return &connection{id: id, userID: userID, config: cfg, protocol: legacyProtocol}
+}
+
+// send numbers a message, buffers it for replay and queues it for the
+// attached socket, if any. It never blocks. It returns false if the
+// connection has been discarded.
+func (c *connection) send(msg ServerMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	if !c.protocol.wants(msg.Type) {
+		return true
+	}
+
+	if msg.Type == "text_chunk" && c.config.ChunkFlushInterval > 0 {
+		c.chunks.WriteString(msg.Content)
+		if c.chunks.Len() >= c.config.ChunkFlushSize {
+			c.flushChunksLocked()
+		} else if c.chunkTimer == nil {
+			c.chunkTimer = time.AfterFunc(c.config.ChunkFlushInterval, c.flushChunks)
+		}
+		return true
+	}
+
+	// Coalesced chunks go first, to keep the order
+	c.flushChunksLocked()
+	c.deliverLocked(msg)
+	return true
+}
+
+// flushChunks sends the coalesced chunks, if any.
+func (c *connection) flushChunks() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.flushChunksLocked()
+	}
+}
+
+func (c *connection) flushChunksLocked() {
+	if c.chunkTimer != nil {
+		c.chunkTimer.Stop()
+		c.chunkTimer = nil
+	}
+	if c.chunks.Len() == 0 {
+		return
+	}
+	c.deliverLocked(ServerMessage{Type: "text_chunk", Content: c.chunks.String()})
+	c.chunks.Reset()
+}
+
+// deliverLocked numbers, buffers and queues a message.
+func (c *connection) deliverLocked(msg ServerMessage) {
+	c.seq++
+	msg.Seq = c.seq
+	c.buffer = append(c.buffer, msg)
+	if len(c.buffer) > c.config.ReplayBufferSize {
+		c.buffer = c.buffer[len(c.buffer)-c.config.ReplayBufferSize:]
+	}
+
+	if c.sock != nil && !c.sock.enqueue(&msg) {
+		// Too far behind; it can resume from the buffer
+		c.sock.close()
+	}
+}
+
+// attach makes sock the connection's socket and queues greeting, then the
+// buffered messages after lastSeq, on it. The greeting is not numbered or
+// buffered. Any previous socket is closed. It returns false if the
+// connection has been discarded or messages after lastSeq are no longer
+// buffered.
+func (c *connection) attach(sock *socket, lastSeq int64, greeting *ServerMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || lastSeq < 0 || lastSeq > c.seq || lastSeq < c.seq-int64(len(c.buffer)) {
+		return false
+	}
+
+	if c.sock != nil {
+		c.sock.close()
+	}
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+	c.sock = sock
+
+	sock.enqueue(greeting)
+	for i := len(c.buffer) - int(c.seq-lastSeq); i < len(c.buffer); i++ {
+		sock.enqueue(&c.buffer[i])
+	}
+	return true
+}
+
+// detach removes sock from the connection, if it is still attached, and
+// calls expire once the resume window passes without a new socket.
+func (c *connection) detach(sock *socket, expire func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sock != sock || c.closed {
+		return
+	}
+	c.sock = nil
+	c.expiry = time.AfterFunc(c.config.ResumeWindow, expire)
+}
+
+// discard marks the connection closed, unless detached is set and a
+// socket has attached since. It returns whether the connection was
+// discarded by this call.
+func (c *connection) discard(detached bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || (detached && c.sock != nil) {
+		return false
+	}
+	c.closed = true
+	if c.expiry != nil {
+		c.expiry.Stop()
+	}
+	return true
+}
+
+// goAway closes the attached socket, if any, once the messages queued on
+// it are written, telling the client the server is going away.
+func (c *connection) goAway() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.flushChunksLocked()
+	if c.sock != nil {
+		c.sock.goAway()
+	}
+}
+
+func (c *connection) currentProtocol() protocol {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+func (c *connection) setProtocol(p protocol) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protocol = p
+}
+
+// currentSession returns the conversation session, or nil.
+func (c *connection) currentSession() *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+func (c *connection) setSession(sess *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = sess
+}
+
+// startTurn runs fn in the background with a context that stopTurn
+// cancels. It returns false, without running fn, if a turn is already in
+// flight. The turn outlives the request that started it, so it keeps
+// running while the client reconnects.
+func (c *connection) startTurn(ctx context.Context, fn func(ctx context.Context)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.turn != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	t := &turn{cancel: cancel, done: make(chan struct{})}
+	c.turn = t
+
+	go func() {
+		defer func() {
+			cancel()
+			c.mu.Lock()
+			c.turn = nil
+			c.mu.Unlock()
+			close(t.done)
+		}()
+		fn(ctx)
+	}()
+	return true
+}
+
+// busy reports whether a turn is in flight.
+func (c *connection) busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.turn != nil
+}
+
+// stopTurn cancels the turn in flight, if any, and returns a channel that
+// is closed once it has finished. The channel is nil if nothing was running.
+func (c *connection) stopTurn() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.turn == nil {
+		return nil
+	}
+	c.turn.cancel()
+	return c.turn.done
+}
+
+// socket is one WebSocket attached to a connection. Gorilla allows a
+// single concurrent writer, so every message, and every ping, is written
+// by the socket's writer goroutine.
+type socket struct {
+	ws        *websocket.Conn
+	codec     Codec
+	out       chan *ServerMessage
+	closed    chan struct{}
+	goingAway chan struct{} // closed to close once the queue is written
+	done      chan struct{} // closed when the writer exits
+
+	// compressionThreshold is the size from which messages are compressed,
+	// if the client negotiated compression.
+	compressionThreshold int
+
+	closeOnce  sync.Once
+	goAwayOnce sync.Once
+}
+
+func newSocket(ws *websocket.Conn, queueSize, compressionThreshold int) *socket {
+	return &socket{
+		ws:                   ws,
+		codec:                CodecFor(ws.Subprotocol()),
+		out:                  make(chan *ServerMessage, queueSize),
+		closed:               make(chan struct{}),
+		goingAway:            make(chan struct{}),
+		done:                 make(chan struct{}),
+		compressionThreshold: compressionThreshold,
+	}
+}
+
+// enqueue queues a message for the writer without blocking. It returns
+// false if the queue is full.
+func (k *socket) enqueue(msg *ServerMessage) bool {
+	select {
+	case k.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the writer and closes the WebSocket, which ends its read
+// loop. Queued messages are dropped.
+func (k *socket) close() {
+	k.closeOnce.Do(func() {
+		close(k.closed)
+		k.ws.Close()
+	})
+}
+
+// goAway makes the writer write the queued messages, then a close frame
+// with status 1001 (going away), and close the socket.
+func (k *socket) goAway() {
+	k.goAwayOnce.Do(func() { close(k.goingAway) })
+}
+
+// writeLoop writes queued messages and pings until the socket closes or a
+// write fails.
+func (k *socket) writeLoop(s *Server, pingInterval time.Duration, logger *slog.Logger) {
+	defer close(k.done)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.closed:
+			return
+
+		case msg := <-k.out:
+			if !k.write(s, msg, logger) {
+				return
+			}
+
+		case <-k.goingAway:
+			for len(k.out) > 0 {
+				if !k.write(s, <-k.out, logger) {
+					return
+				}
+			}
+			k.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			k.ws.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			k.close()
+			return
+
+		case <-ticker.C:
+			k.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := k.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logger.Debug("failed to send ping", logging.Error(err))
+				k.close()
+				return
+			}
+		}
+	}
+}
+
+// write writes a message. It closes the socket and returns false if the
+// write fails.
+func (k *socket) write(s *Server, msg *ServerMessage, logger *slog.Logger) bool {
+	data, err := k.codec.Marshal(msg)
+	if err != nil {
+		logger.Error("failed to encode message", slog.String("type", msg.Type), logging.Error(err))
+		return true
+	}
+	k.ws.EnableWriteCompression(len(data) >= k.compressionThreshold)
+	k.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := k.ws.WriteMessage(k.codec.FrameType, data); err != nil {
+		logger.Debug("failed to send message", slog.String("type", msg.Type), logging.Error(err))
+		k.close()
+		return false
+	}
+	s.metrics.MessageSent(msg.Type)
+	return true
+}
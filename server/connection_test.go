@@ -0,0 +1,156 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+)
+
+func TestStop(t *testing.T) {
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID := c.last("conversation_started").ConversationID
+
+	release := make(chan struct{})
+	defer close(release)
+	llm.Script(llmtest.Reply{Text: "A very long answer", Release: release})
+	c.send(server.ClientMessage{Type: "message", Content: "Tell me everything"})
+	c.until("text_chunk")
+
+	// The connection keeps reading while the turn runs
+	c.send(server.ClientMessage{Type: "message", Content: "Hello?"})
+	if msg := c.last("error"); msg.Code != server.ErrCodeTurnInProgress {
+		t.Errorf("message during a turn: code = %q, want %q", msg.Code, server.ErrCodeTurnInProgress)
+	}
+	c.send(server.ClientMessage{Type: "stop"})
+	if msgs := c.until("stopped"); len(msgs) != 1 {
+		t.Errorf("messages before stopped = %v", types(msgs))
+	}
+
+	// The history keeps what was streamed, marked as cut short
+	other := dial(t, url)
+	other.send(server.ClientMessage{Type: "resume_conversation", ConversationID: conversationID})
+	resumed, _ := json.Marshal(other.last("conversation_resumed").Messages)
+	if !strings.Contains(string(resumed), `A\n\n[Stopped by user]`) {
+		t.Errorf("resumed messages = %s, want the partial reply marked stopped", resumed)
+	}
+
+	llm.Script(llmtest.Reply{Text: "Sorry, go on."})
+	c.send(server.ClientMessage{Type: "message", Content: "Never mind"})
+	c.until("complete")
+	if req := lastRequest(llm); !strings.Contains(req, "[Stopped by user]") {
+		t.Errorf("model request = %s, want the stopped reply", req)
+	}
+}
+
+func TestResume(t *testing.T) {
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{})
+
+	first := connect(t, url)
+	token := first.last("connected").ResumeToken
+	first.send(server.ClientMessage{Type: "new_conversation"})
+	first.until("conversation_started")
+
+	release := make(chan struct{})
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+	first.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	chunk := first.last("text_chunk")
+
+	// Drop the connection mid-turn, then let the turn finish while the
+	// client is away
+	first.ws.UnderlyingConn().Close()
+	close(release)
+
+	second := connect(t, url)
+	second.send(server.ClientMessage{Type: "resume", ResumeToken: token, LastSeq: chunk.Seq})
+	if msg := second.last("resumed"); msg.ResumeToken != token {
+		t.Fatalf("resumed token = %q, want %q", msg.ResumeToken, token)
+	}
+	msgs := second.until("complete")
+
+	// Everything after the last message seen is replayed, in order
+	text := chunk.Content
+	for i, msg := range msgs {
+		if msg.Seq != chunk.Seq+int64(i)+1 {
+			t.Errorf("%s seq = %d, want %d", msg.Type, msg.Seq, chunk.Seq+int64(i)+1)
+		}
+		if msg.Type == "text_chunk" {
+			text += msg.Content
+		}
+	}
+	if text != "Your balance is $10." {
+		t.Errorf("streamed text = %q, want every chunk exactly once", text)
+	}
+
+	// The resumed connection carries on with its conversation
+	llm.Script(llmtest.Reply{Text: "You're welcome."})
+	second.send(server.ClientMessage{Type: "message", Content: "Thanks"})
+	second.until("complete")
+}
+
+func TestResume_Failed(t *testing.T) {
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{
+		Connection: server.ConnectionConfig{ResumeWindow: time.Millisecond},
+	})
+
+	c := connect(t, url)
+	c.send(server.ClientMessage{Type: "resume", ResumeToken: "unknown"})
+	if msg := c.last("resume_failed"); msg.Seq != 0 {
+		t.Errorf("resume_failed seq = %d, want unnumbered", msg.Seq)
+	}
+
+	// The client carries on with a new connection
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	c.until("conversation_started")
+	c.send(server.ClientMessage{Type: "resume", ResumeToken: "unknown"})
+	if msg := c.last("error"); msg.Code != server.ErrCodeOutOfOrder {
+		t.Errorf("late resume: code = %q, want %q", msg.Code, server.ErrCodeOutOfOrder)
+	}
+
+	// A dropped connection is gone once the resume window passes
+	first := connect(t, url)
+	token := first.last("connected").ResumeToken
+	first.ws.UnderlyingConn().Close()
+	time.Sleep(50 * time.Millisecond)
+	second := connect(t, url)
+	second.send(server.ClientMessage{Type: "resume", ResumeToken: token})
+	second.until("resume_failed")
+}
+
+func TestKeepalive(t *testing.T) {
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{
+		Connection: server.ConnectionConfig{PingInterval: 10 * time.Millisecond, PongTimeout: 50 * time.Millisecond},
+	})
+
+	// A client that answers pings outlives the pong timeout
+	live := dial(t, url)
+	live.ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := live.ws.ReadMessage()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("idle connection answering pings: read = %v, want a timeout", err)
+	}
+
+	// One that does not is closed
+	silent := connect(t, url)
+	silent.ws.SetPingHandler(func(string) error { return nil })
+	silent.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := silent.ws.ReadMessage(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("connection without pongs was not closed")
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// maxTitleLength bounds user-supplied conversation titles, in characters.
+const maxTitleLength = 200
+
+func (s *Server) handleListConversations(ctx context.Context, c client, userID string, msg ClientMessage) {
+	page, err := s.conversations.List(ctx, userID, listOptions(msg))
+	if err != nil {
+		s.sendStoreError(c, userID, "", "Failed to list conversations", err)
+		return
+	}
+
+	s.send(c, ServerMessage{
+		Type:          "conversations",
+		Conversations: page.Conversations,
+		NextCursor:    page.NextCursor,
+	})
+}
+
+func (s *Server) handleSearchConversations(ctx context.Context, c client, userID string, msg ClientMessage) {
+	query := strings.TrimSpace(msg.Query)
+	if query == "" {
+		s.sendError(c, ErrCodeMissingField, "Search query cannot be empty")
+		return
+	}
+
+	page, err := s.conversations.Search(ctx, userID, query, listOptions(msg))
+	if err != nil {
+		s.sendStoreError(c, userID, "", "Failed to search conversations", err)
+		return
+	}
+
+	s.send(c, ServerMessage{
+		Type:          "search_results",
+		Query:         query,
+		Conversations: page.Conversations,
+		NextCursor:    page.NextCursor,
+	})
+}
+
+// handleRenameConversation sets a user-chosen title and reports whether it succeeded.
+func (s *Server) handleRenameConversation(ctx context.Context, c client, userID, conversationID, title string) bool {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		s.sendError(c, ErrCodeMissingField, "Title cannot be empty")
+		return false
+	}
+	title = truncate(title, maxTitleLength)
+
+	if err := s.conversations.SetTitle(ctx, userID, conversationID, title); err != nil {
+		s.sendStoreError(c, userID, conversationID, "Failed to rename conversation", err)
+		return false
+	}
+	s.sendConversationUpdated(ctx, c, userID, conversationID)
+	return true
+}
+
+func (s *Server) handleArchiveConversation(ctx context.Context, c client, userID, conversationID string, archived bool) {
+	if err := s.conversations.SetArchived(ctx, userID, conversationID, archived); err != nil {
+		s.sendStoreError(c, userID, conversationID, "Failed to update conversation", err)
+		return
+	}
+	s.sendConversationUpdated(ctx, c, userID, conversationID)
+}
+
+func (s *Server) handlePinConversation(ctx context.Context, c client, userID, conversationID string, pinned bool) {
+	if err := s.conversations.SetPinned(ctx, userID, conversationID, pinned); err != nil {
+		s.sendStoreError(c, userID, conversationID, "Failed to update conversation", err)
+		return
+	}
+	s.sendConversationUpdated(ctx, c, userID, conversationID)
+}
+
+// handleDeleteConversation deletes the conversation and reports whether it succeeded.
+func (s *Server) handleDeleteConversation(ctx context.Context, c client, userID, conversationID string) bool {
+	if err := s.conversations.Delete(ctx, userID, conversationID); err != nil {
+		s.sendStoreError(c, userID, conversationID, "Failed to delete conversation", err)
+		return false
+	}
+
+	s.send(c, ServerMessage{
+		Type:           "conversation_deleted",
+		ConversationID: conversationID,
+	})
+	s.logger.Info("deleted conversation", logging.User(userID), logging.Conversation(conversationID))
+	return true
+}
+
+// sendConversationUpdated sends the conversation's current metadata.
+func (s *Server) sendConversationUpdated(ctx context.Context, c client, userID, conversationID string) {
+	conv, err := s.conversations.Get(ctx, userID, conversationID)
+	if err != nil {
+		s.sendStoreError(c, userID, conversationID, "Failed to load conversation", err)
+		return
+	}
+
+	s.send(c, ServerMessage{
+		Type:           "conversation_updated",
+		ConversationID: conversationID,
+		Conversation:   &conv.Conversation,
+	})
+}
+
+// sendStoreError reports a store failure to the client. Missing and
+// foreign conversations get the same response so IDs cannot be probed,
+// bad list options are echoed back, and anything else is logged.
+func (s *Server) sendStoreError(c client, userID, conversationID, content string, err error) {
+	switch {
+	case errors.Is(err, store.ErrConversationNotFound):
+		s.sendError(c, ErrCodeNotFound, "Conversation not found")
+	case errors.Is(err, store.ErrMessageNotFound):
+		s.sendError(c, ErrCodeNotFound, "Message not found")
+	case errors.Is(err, store.ErrInvalidCursor), errors.Is(err, store.ErrInvalidListOptions):
+		s.sendError(c, ErrCodeInvalidField, err.Error())
+	default:
+		s.logger.Error(strings.ToLower(content), logging.User(userID), logging.Conversation(conversationID), logging.Error(err))
+		s.sendError(c, ErrCodeInternal, content)
+	}
+}
+
+// listOptions maps a client message onto store list options.
+func listOptions(msg ClientMessage) store.ListOptions {
+	return store.ListOptions{
+		Limit:    msg.Limit,
+		Cursor:   msg.Cursor,
+		Sort:     store.SortField(msg.Sort),
+		Archived: store.ArchiveFilter(msg.Archived),
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"Send money", 60, "Send money"},
+		{"Send money to Alice", 10, "Send mo..."},
+		{"Café crème", 10, "Café crème"},
+		{"Paiement à Zoë 💸💸", 10, "Paiemen..."},
+		{"日本円を送金してください", 8, "日本円を送..."},
+		{"💸💸💸💸💸💸", 5, "💸💸..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.in, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, not valid UTF-8", tt.in, tt.max, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// HealthConfig configures the readiness checks and the debug endpoint.
+type HealthConfig struct {
+	// Timeout bounds each readiness check. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// CacheFor is how long readiness results are reused, so frequent
+	// probes do not load the dependencies. Defaults to 10 seconds;
+	// negative disables caching.
+	CacheFor time.Duration
+
+	// SkipAnthropic leaves the Anthropic API out of readiness, so an
+	// outage there does not take every replica out of rotation at once.
+	SkipAnthropic bool
+
+	// Debug serves /debug/agent from Start. It describes the tools and
+	// configuration, with secrets redacted, to anyone who can reach it, so
+	// only enable it where the server is not public; otherwise mount
+	// DebugHandler behind your own access control.
+	Debug bool
+}
+
+func (cfg HealthConfig) withDefaults() HealthConfig {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.CacheFor == 0 {
+		cfg.CacheFor = 10 * time.Second
+	}
+	return cfg
+}
+
+// healthCheck is a named readiness check.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readiness is the result of the readiness checks, as /readyz serves it.
+// Check errors are logged rather than served, since they can describe
+// the infrastructure.
+type readiness struct {
+	Status string                 `json:"status"` // "ok", "unavailable" or "shutting_down"
+	Checks map[string]checkResult `json:"checks,omitempty"`
+
+	checkedAt time.Time
+}
+
+type checkResult struct {
+	Status     string `json:"status"` // "ok" or "error"
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// AddHealthCheck registers a readiness check, run by /readyz alongside the
+// built-in ones: the conversation and confirmation stores, if they
+// implement store.HealthChecker, the Liminal executor, if configured, and
+// the Anthropic API, unless Config.Health.SkipAnthropic is set. The server
+// is ready only while every check returns nil.
+func (s *Server) AddHealthCheck(name string, check func(ctx context.Context) error) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.healthChecks = append(s.healthChecks, healthCheck{name: name, check: check})
+}
+
+// addBuiltinHealthChecks registers the checks of the server's
+// dependencies.
+func (s *Server) addBuiltinHealthChecks(client anthropic.Client) {
+	if h, ok := s.conversations.(store.HealthChecker); ok {
+		s.AddHealthCheck("conversations", h.HealthCheck)
+	}
+	if h, ok := s.confirmations.(store.HealthChecker); ok {
+		s.AddHealthCheck("confirmations", h.HealthCheck)
+	}
+	if s.config.LiminalExecutor != nil {
+		s.AddHealthCheck("executor", s.config.LiminalExecutor.HealthCheck)
+	}
+	if !s.healthConfig.SkipAnthropic {
+		// Listing models checks the API is reachable and the key valid,
+		// without spending tokens
+		s.AddHealthCheck("anthropic", func(ctx context.Context) error {
+			_, err := client.Models.List(ctx, anthropic.ModelListParams{Limit: anthropic.Int(1)}, option.WithMaxRetries(0))
+			return err
+		})
+	}
+}
+
+// LivenessHandler returns an HTTP handler that reports the process is up.
+// Start serves it at /livez, and /health.
+func (s *Server) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+}
+
+// ReadinessHandler returns an HTTP handler that runs the readiness checks
+// (see AddHealthCheck) and replies 200 if they all pass, or 503 with the
+// failing ones marked, as JSON. It also replies 503 once Start is
+// shutting down. Start serves it at /readyz.
+func (s *Server) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := s.readiness(r.Context())
+		status := http.StatusOK
+		if report.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+// readiness runs the checks concurrently, or returns the last results if
+// they are recent enough.
+func (s *Server) readiness(ctx context.Context) *readiness {
+	if s.turns.isDraining() {
+		return &readiness{Status: "shutting_down"}
+	}
+
+	// Holding the lock while checking makes concurrent probes share one run
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	cfg := s.healthConfig
+	if last := s.lastReadiness; last != nil && s.clock.Now().Sub(last.checkedAt) < cfg.CacheFor {
+		return last
+	}
+
+	s.healthMu.Lock()
+	checks := slices.Clone(s.healthChecks)
+	s.healthMu.Unlock()
+
+	report := &readiness{Status: "ok", Checks: make(map[string]checkResult, len(checks)), checkedAt: s.clock.Now()}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.check(ctx)
+			result := checkResult{Status: "ok", DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "error"
+				s.logger.Warn("readiness check failed", slog.String("check", hc.name), logging.Error(err))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[hc.name] = result
+			if err != nil {
+				report.Status = "unavailable"
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A probe that gave up says nothing about the dependencies
+	if ctx.Err() == nil {
+		s.lastReadiness = report
+	}
+	return report
+}
+
+// DebugHandler returns an HTTP handler that describes the agent as JSON:
+// its tools with their input schemas, its configuration with secrets
+// redacted, its readiness checks and the binary's build information. Start
+// serves it at /debug/agent when Config.Health.Debug is set.
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.debugInfo())
+	})
+}
+
+type debugTool struct {
+	Name                 string         `json:"name"`
+	Description          string         `json:"description"`
+	RequiresConfirmation bool           `json:"requires_confirmation"`
+	Schema               map[string]any `json:"schema"`
+}
+
+type debugBuild struct {
+	GoVersion  string            `json:"go_version"`
+	Path       string            `json:"path,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	SDKVersion string            `json:"sdk_version,omitempty"`
+	Settings   map[string]string `json:"settings,omitempty"` // vcs.revision, vcs.time, vcs.modified
+}
+
+func (s *Server) debugInfo() map[string]any {
+	names := s.registry.List()
+	slices.Sort(names)
+	tools := make([]debugTool, 0, len(names))
+	for _, name := range names {
+		tool, ok := s.registry.Get(name)
+		if !ok {
+			continue
+		}
+		tools = append(tools, debugTool{
+			Name:                 name,
+			Description:          tool.Description(),
+			RequiresConfirmation: tool.RequiresConfirmation(),
+			Schema:               tool.Schema(),
+		})
+	}
+
+	s.healthMu.Lock()
+	checks := make([]string, 0, len(s.healthChecks))
+	for _, hc := range s.healthChecks {
+		checks = append(checks, hc.name)
+	}
+	s.healthMu.Unlock()
+
+	return map[string]any{
+		"tools":         tools,
+		"config":        s.debugConfig(),
+		"health_checks": checks,
+		"build":         buildInfo(),
+	}
+}
+
+// debugConfig describes the configuration. Secrets, and the system
+// prompt, are left out; only whether they are set is shown.
+func (s *Server) debugConfig() map[string]any {
+	cfg := s.config
+
+	auth := "shared user"
+	switch {
+	case cfg.AuthFunc != nil:
+		auth = "AuthFunc"
+	case cfg.JWTVerifier != nil:
+		auth = "JWTVerifier"
+	}
+	liminalURL := ""
+	if cfg.LiminalExecutor != nil {
+		liminalURL = cfg.LiminalExecutor.BaseURL()
+	}
+
+	return map[string]any{
+		"AnthropicKey":      redacted(cfg.AnthropicKey),
+		"BaseURL":           cfg.BaseURL,
+		"SystemPrompt":      fmt.Sprintf("(%d characters)", len(cfg.SystemPrompt)),
+		"Model":             cfg.Model,
+		"MaxTokens":         cfg.MaxTokens,
+		"LiminalBaseURL":    liminalURL,
+		"Auth":              auth,
+		"Conversations":     fmt.Sprintf("%T", s.conversations),
+		"Confirmations":     fmt.Sprintf("%T", s.confirmations),
+		"Guardrails":        typeName(cfg.Guardrails),
+		"AuditLogger":       typeName(cfg.AuditLogger),
+		"Titles":            describe(cfg.Titles),
+		"Connection":        describe(s.connConfig),
+		"Retention":         describe(cfg.Retention),
+		"HTTP":              describe(s.httpConfig),
+		"Health":            describe(s.healthConfig),
+		"OpenAICompatible":  cfg.OpenAICompatible,
+		"AnthropicOptions":  len(cfg.AnthropicOptions),
+		"DisableStreaming":  cfg.DisableStreaming,
+		"LoggerConfigured":  cfg.Logger != nil,
+		"MetricsConfigured": cfg.Metrics != nil,
+	}
+}
+
+// redacted masks a secret, showing only whether it is set.
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return logging.Mask
+}
+
+// typeName returns the dynamic type of v, or "" if it is nil.
+func typeName(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// describe flattens a config struct into a map of its exported fields,
+// with durations written like "30s".
+func describe(v any) map[string]any {
+	rv := reflect.ValueOf(v)
+	out := make(map[string]any, rv.NumField())
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		value := rv.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		out[f.Name] = value
+	}
+	return out
+}
+
+// buildInfo reports how the binary was built, and which version of this
+// SDK it includes.
+func buildInfo() debugBuild {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return debugBuild{}
+	}
+	build := debugBuild{
+		GoVersion: info.GoVersion,
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  map[string]string{},
+	}
+
+	// The SDK is the module this package is in: a dependency, or the main
+	// module when built from this repository
+	pkg := reflect.TypeOf(Server{}).PkgPath()
+	for _, mod := range append([]*debug.Module{&info.Main}, info.Deps...) {
+		if mod.Path != "" && strings.HasPrefix(pkg, mod.Path+"/") {
+			build.SDKVersion = mod.Version
+		}
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			build.Settings[setting.Key] = setting.Value
+		}
+	}
+	return build
+}
@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/executor"
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/tools"
+)
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Status string `json:"status"`
+	} `json:"checks"`
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+func TestServe_Readiness(t *testing.T) {
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	defer gateway.Close()
+
+	clock := core.NewFakeClock(time.Now())
+	srv, addr, _, _ := serve(t, llmtest.New(t), server.Config{
+		Clock:           clock,
+		LiminalExecutor: executor.NewHTTPExecutor(executor.HTTPExecutorConfig{BaseURL: gateway.URL}),
+	})
+	var failing atomic.Bool
+	srv.AddHealthCheck("cache", func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("cache down")
+		}
+		return nil
+	})
+
+	resp, err := http.Get("http://" + addr + "/livez")
+	if err != nil {
+		t.Fatalf("GET /livez: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/livez status = %d, want 200", resp.StatusCode)
+	}
+
+	var ready readiness
+	if status := getJSON(t, "http://"+addr+"/readyz", &ready); status != http.StatusOK || ready.Status != "ok" {
+		t.Fatalf("/readyz = %d %+v, want 200 ok", status, ready)
+	}
+	for _, name := range []string{"anthropic", "executor", "cache"} {
+		if ready.Checks[name].Status != "ok" {
+			t.Errorf("check %q = %q, want ok", name, ready.Checks[name].Status)
+		}
+	}
+
+	// Results are reused until they are 10 seconds old
+	failing.Store(true)
+	if status := getJSON(t, "http://"+addr+"/readyz", &ready); status != http.StatusOK {
+		t.Errorf("cached /readyz status = %d, want 200", status)
+	}
+	clock.Advance(10 * time.Second)
+	ready = readiness{}
+	if status := getJSON(t, "http://"+addr+"/readyz", &ready); status != http.StatusServiceUnavailable || ready.Status != "unavailable" {
+		t.Errorf("/readyz = %d %q, want 503 unavailable", status, ready.Status)
+	}
+	if ready.Checks["cache"].Status != "error" || ready.Checks["anthropic"].Status != "ok" {
+		t.Errorf("checks = %+v, want only cache failing", ready.Checks)
+	}
+
+	// A gateway that is down fails readiness too
+	failing.Store(false)
+	gateway.Close()
+	clock.Advance(10 * time.Second)
+	ready = readiness{}
+	if status := getJSON(t, "http://"+addr+"/readyz", &ready); status != http.StatusServiceUnavailable || ready.Checks["executor"].Status != "error" {
+		t.Errorf("/readyz with the gateway down = %d %+v, want 503 with executor failing", status, ready)
+	}
+}
+
+func TestServe_DebugAgent(t *testing.T) {
+	srv, addr, _, _ := serve(t, llmtest.New(t), server.Config{
+		SystemPrompt: "You are a secret agent.",
+		Health:       server.HealthConfig{Debug: true},
+	})
+	srv.AddTool(tools.New("pay").
+		Description("Pay someone").
+		Schema(tools.ObjectSchema(map[string]interface{}{"amount": tools.StringProperty("Amount")}, "amount")).
+		RequiresConfirmation().
+		HandlerFunc(func(ctx context.Context, input json.RawMessage) (interface{}, error) { return nil, nil }).
+		Build())
+
+	resp, err := http.Get("http://" + addr + "/debug/agent")
+	if err != nil {
+		t.Fatalf("GET /debug/agent: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	for _, secret := range []string{"test-key", "secret agent"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("/debug/agent leaks %q: %s", secret, body)
+		}
+	}
+
+	var info struct {
+		Tools []struct {
+			Name                 string         `json:"name"`
+			RequiresConfirmation bool           `json:"requires_confirmation"`
+			Schema               map[string]any `json:"schema"`
+		} `json:"tools"`
+		Config map[string]any `json:"config"`
+		Checks []string       `json:"health_checks"`
+		Build  struct {
+			GoVersion string `json:"go_version"`
+		} `json:"build"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(info.Tools) != 1 || info.Tools[0].Name != "pay" || !info.Tools[0].RequiresConfirmation || info.Tools[0].Schema["properties"] == nil {
+		t.Errorf("tools = %+v, want pay with its schema", info.Tools)
+	}
+	if info.Config["AnthropicKey"] != "[REDACTED]" {
+		t.Errorf("AnthropicKey = %v, want it redacted", info.Config["AnthropicKey"])
+	}
+	if httpCfg, ok := info.Config["HTTP"].(map[string]any); !ok || httpCfg["ShutdownTimeout"] != "30s" {
+		t.Errorf("HTTP config = %v, want defaults with readable durations", info.Config["HTTP"])
+	}
+	if len(info.Checks) != 1 || info.Checks[0] != "anthropic" {
+		t.Errorf("health checks = %v, want [anthropic]", info.Checks)
+	}
+	if info.Build.GoVersion == "" {
+		t.Error("build info has no Go version")
+	}
+}
+
+func TestServe_DebugAgentDisabled(t *testing.T) {
+	_, addr, _, _ := serve(t, llmtest.New(t), server.Config{})
+
+	resp, err := http.Get("http://" + addr + "/debug/agent")
+	if err != nil {
+		t.Fatalf("GET /debug/agent: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 without Health.Debug", resp.StatusCode)
+	}
+}
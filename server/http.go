@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+)
+
+// HTTPConfig configures the HTTP server Start runs, and the origin and
+// per-IP connection checks every handler applies.
+type HTTPConfig struct {
+	// Addr is the address Start listens on. Defaults to ":8080".
+	Addr string
+
+	// ReadHeaderTimeout bounds reading a request's headers. Defaults to 10
+	// seconds.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout bounds reading a whole request, body included. It does
+	// not apply to WebSockets once upgraded. Defaults to 30 seconds.
+	ReadTimeout time.Duration
+
+	// WriteTimeout bounds writing a response. Streamed replies last as long
+	// as the turn, so by default there is none.
+	WriteTimeout time.Duration
+
+	// IdleTimeout closes keep-alive connections idle for this long.
+	// Defaults to 2 minutes.
+	IdleTimeout time.Duration
+
+	// ShutdownTimeout is how long Start waits, once its context is done,
+	// for turns in flight to finish before stopping them. Defaults to 30
+	// seconds.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile are PEM files of the certificate and key
+	// to serve HTTPS, and so wss, with. If empty, Start serves plain HTTP.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSReloadInterval is how often the certificate files are checked for
+	// changes, so renewed certificates are picked up without a restart.
+	// Defaults to 1 minute.
+	TLSReloadInterval time.Duration
+
+	// AllowedOrigins lists the browser origins WebSocket connections are
+	// accepted from, such as "https://app.example.com", or
+	// "https://*.example.com" for its subdomains. "*" allows any origin.
+	// Connections without an Origin header, which browsers always send,
+	// are allowed. If empty, any origin is allowed, which suits
+	// development only.
+	AllowedOrigins []string
+
+	// MaxConnectionsPerIP bounds the WebSockets and HTTP API requests open
+	// at once from one client IP. Further ones are refused with 429 Too
+	// Many Requests. Zero means no limit.
+	MaxConnectionsPerIP int
+
+	// ClientIPHeader names a header carrying the client's IP, set by a
+	// reverse proxy, such as "X-Real-IP". For X-Forwarded-For, the last
+	// address, added by the nearest proxy, is used. Only set it behind a
+	// proxy that overwrites the header, since clients can send anything.
+	// If empty, the connection's remote address is used.
+	ClientIPHeader string
+}
+
+func (cfg HTTPConfig) withDefaults() HTTPConfig {
+	if cfg.Addr == "" {
+		cfg.Addr = ":8080"
+	}
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = 10 * time.Second
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = 30 * time.Second
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = 2 * time.Minute
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.TLSReloadInterval == 0 {
+		cfg.TLSReloadInterval = time.Minute
+	}
+	return cfg
+}
+
+// Start listens on Config.HTTP.Addr and serves until ctx is done; see
+// Serve.
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.httpConfig.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve serves the server on ln until ctx is done, then shuts down
+// gracefully. It serves:
+//
+//	/ws            WebSocket connections (Handler)
+//	/v1/           the HTTP API (APIHandler)
+//	/metrics       Prometheus metrics (MetricsHandler)
+//	/livez         liveness (LivenessHandler), also at /health
+//	/readyz        readiness (ReadinessHandler)
+//	/debug/agent   tools, configuration and build (DebugHandler), if
+//	               Config.Health.Debug is set
+//
+// When ctx is done, Serve stops accepting connections and new turns, and
+// waits up to Config.HTTP.ShutdownTimeout for turns in flight, and HTTP
+// requests, to finish. Turns still running are then stopped. Finally
+// WebSockets are closed with status 1001 (going away), once the messages
+// queued on them are written, the retention scheduler is stopped, and
+// Serve returns nil. Close the server afterwards as usual.
+//
+// It returns any other error serving, such as a TLS certificate that
+// cannot be loaded.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	cfg := s.httpConfig
+	httpServer := &http.Server{
+		Handler:           s.mux(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(s.logger.Handler(), slog.LevelDebug),
+	}
+
+	useTLS := cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""
+	if useTLS {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSReloadInterval, s.clock, s.logger)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		s.logger.Warn("no HTTP.AllowedOrigins configured; WebSocket connections are accepted from any origin")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if useTLS {
+			errc <- httpServer.ServeTLS(ln, "", "")
+		} else {
+			errc <- httpServer.Serve(ln)
+		}
+	}()
+	s.logger.Info("starting Nim agent server", slog.String("addr", ln.Addr().String()), slog.Bool("tls", useTLS))
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.shutdown(httpServer, cfg.ShutdownTimeout)
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Run serves on addr until it fails. Prefer Start, which can be shut down.
+func (s *Server) Run(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(context.Background(), ln)
+}
+
+// mux routes the endpoints Serve serves.
+func (s *Server) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", s.Handler())
+	mux.Handle("/v1/", s.APIHandler())
+	mux.Handle("/metrics", s.MetricsHandler())
+	mux.Handle("/livez", s.LivenessHandler())
+	mux.Handle("/health", s.LivenessHandler())
+	mux.Handle("/readyz", s.ReadinessHandler())
+	if s.healthConfig.Debug {
+		mux.Handle("/debug/agent", s.DebugHandler())
+	}
+	return mux
+}
+
+// shutdown stops httpServer, waiting up to timeout for HTTP requests and
+// WebSocket turns to finish, stops the rest, closes the WebSockets and
+// stops the retention scheduler.
+func (s *Server) shutdown(httpServer *http.Server, timeout time.Duration) {
+	s.logger.Info("shutting down; draining turns in flight", slog.Duration("timeout", timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Refuse new turns, then stop listening and wait for HTTP requests,
+	// which hold their turns
+	idle := s.turns.drain()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		s.logger.Warn("HTTP requests still open after shutdown timeout; closing them", logging.Error(err))
+		httpServer.Close()
+	}
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		s.logger.Warn("stopping turns still running after shutdown timeout")
+		s.connections.Range(func(_, v any) bool {
+			v.(*connection).stopTurn()
+			return true
+		})
+		<-idle
+	}
+
+	// Hijacked WebSockets are not closed by the HTTP server
+	s.connections.Range(func(_, v any) bool {
+		v.(*connection).goAway()
+		return true
+	})
+	if s.retention != nil {
+		s.retention.Stop()
+	}
+	s.logger.Info("shutdown complete")
+}
+
+// turnTracker counts the turns in flight across connections, so shutdown
+// can wait for them. Its zero value is ready to use.
+type turnTracker struct {
+	mu       sync.Mutex
+	running  int
+	draining bool
+	idle     chan struct{} // closed once draining and no turn is running
+}
+
+// start records a new turn. It returns false, and records nothing, once
+// draining.
+func (t *turnTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.running++
+	return true
+}
+
+// done records the end of a turn.
+func (t *turnTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running--
+	if t.draining && t.running == 0 {
+		close(t.idle)
+	}
+}
+
+// isDraining reports whether drain has been called.
+func (t *turnTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// drain refuses new turns and returns a channel that is closed once those
+// running have finished.
+func (t *turnTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.draining {
+		t.draining = true
+		t.idle = make(chan struct{})
+		if t.running == 0 {
+			close(t.idle)
+		}
+	}
+	return t.idle
+}
+
+// checkOrigin reports whether a WebSocket upgrade request comes from an
+// allowed origin.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(s.httpConfig.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, pattern := range s.httpConfig.AllowedOrigins {
+		if originAllowed(pattern, origin) {
+			return true
+		}
+	}
+	s.logger.Debug("rejecting WebSocket from disallowed origin", slog.String("origin", origin))
+	return false
+}
+
+// originAllowed reports whether origin matches pattern: "*", an exact
+// origin, or one with a "*." wildcard for subdomains, such as
+// "https://*.example.com". Matching is case-insensitive.
+func originAllowed(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	scheme, domain, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	host, ok := strings.CutPrefix(origin, scheme+"://")
+	if !ok {
+		return false
+	}
+	sub, ok := strings.CutSuffix(host, "."+domain)
+	return ok && sub != "" && !strings.ContainsAny(sub, "/:@")
+}
+
+// connLimiter counts the connections open from each client IP.
+type connLimiter struct {
+	max int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{max: max, conns: map[string]int{}}
+}
+
+// acquire records a connection from ip. It returns false, and records
+// nothing, if ip has reached the limit.
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// acquireConnection records a connection from the request's client IP,
+// returning the function that releases it, or false if the IP has too
+// many open.
+func (s *Server) acquireConnection(r *http.Request) (release func(), ok bool) {
+	if s.limiter == nil {
+		return func() {}, true
+	}
+	ip := clientIP(r, s.httpConfig.ClientIPHeader)
+	if !s.limiter.acquire(ip) {
+		s.logger.Warn("refusing connection; too many open from client IP", slog.String("ip", ip))
+		return nil, false
+	}
+	return func() { s.limiter.release(ip) }, true
+}
+
+// clientIP returns the request's client IP, from header if it is set.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		if v := r.Header.Get(header); v != "" {
+			if i := strings.LastIndexByte(v, ','); i >= 0 {
+				v = v[i+1:]
+			}
+			return strings.TrimSpace(v)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// certReloader serves a certificate loaded from files, reloading it when
+// they change.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+	clock             core.Clock
+	logger            *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate, so a bad one fails at startup.
+func newCertReloader(certFile, keyFile string, interval time.Duration, clock core.Clock, logger *slog.Logger) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both TLSCertFile and TLSKeyFile are required for TLS")
+	}
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, clock: clock, logger: logger}
+	modTime, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// getCertificate is the tls.Config hook. At most once per interval it
+// checks whether the files changed, and reloads them if so. A certificate
+// that fails to load is logged and the previous one kept.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if now.Sub(c.checkedAt) >= c.interval {
+		c.checkedAt = now
+		modTime, err := c.stat()
+		if err == nil && !modTime.Equal(c.modTime) {
+			err = c.load(modTime)
+			if err == nil {
+				c.logger.Info("reloaded TLS certificate", slog.String("cert_file", c.certFile))
+			}
+		}
+		if err != nil {
+			c.logger.Error("failed to reload TLS certificate; keeping the current one", logging.Error(err))
+		}
+	}
+	return c.cert, nil
+}
+
+// stat returns the later modification time of the two files.
+func (c *certReloader) stat() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.cert, c.modTime, c.checkedAt = &cert, modTime, c.clock.Now()
+	return nil
+}
@@ -0,0 +1,334 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// serve runs a Nim server backed by llm on a local port until the
+// returned cancel is called, returning its address and a channel with
+// Serve's result.
+func serve(t *testing.T, llm *llmtest.Server, cfg server.Config) (srv *server.Server, addr string, cancel context.CancelFunc, result <-chan error) {
+	t.Helper()
+
+	cfg.AnthropicKey = "test-key"
+	cfg.BaseURL = llm.URL
+	cfg.Titles.Disabled = true
+	cfg.AllowSharedUser = true
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errc <- srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return srv, ln.Addr().String(), cancel, errc
+}
+
+// countingConfirmations counts the retention scheduler's cleanups.
+type countingConfirmations struct {
+	store.Confirmations
+	cleanups atomic.Int32
+}
+
+func (c *countingConfirmations) Cleanup(ctx context.Context) (int, error) {
+	c.cleanups.Add(1)
+	return c.Confirmations.Cleanup(ctx)
+}
+
+func TestServe_ShutdownStopsRetention(t *testing.T) {
+	confirmations := &countingConfirmations{Confirmations: store.NewMemoryConfirmations()}
+	_, _, shutdown, result := serve(t, llmtest.New(t), server.Config{
+		Confirmations: confirmations,
+		Retention:     server.RetentionConfig{Interval: 5 * time.Millisecond},
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); confirmations.cleanups.Load() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("retention scheduler is not running")
+		}
+	}
+
+	shutdown()
+	if err := <-result; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	runs := confirmations.cleanups.Load()
+	time.Sleep(50 * time.Millisecond)
+	if got := confirmations.cleanups.Load(); got != runs {
+		t.Errorf("retention ran %d more times after Serve returned", got-runs)
+	}
+}
+
+func TestServe_ShutdownDrainsTurns(t *testing.T) {
+	llm := llmtest.New(t)
+	release := make(chan struct{})
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+	_, addr, shutdown, result := serve(t, llm, server.Config{})
+
+	c := dial(t, "http://"+addr)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	c.until("conversation_started")
+	c.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	c.until("text_chunk")
+
+	// Shut down mid-turn; Serve waits for the turn
+	shutdown()
+	select {
+	case err := <-result:
+		t.Fatalf("Serve returned %v with a turn in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := http.Get("http://" + addr + "/health"); err == nil {
+		t.Error("server accepted a request while shutting down")
+	}
+	close(release)
+	c.until("complete")
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the turn finished")
+	}
+	if _, _, err := c.ws.ReadMessage(); err == nil {
+		t.Error("connection still open after shutdown")
+	}
+}
+
+func TestServe_ShutdownTimeoutStopsTurns(t *testing.T) {
+	llm := llmtest.New(t)
+	release := make(chan struct{})
+	defer close(release)
+	llm.Script(llmtest.Reply{Text: "A very long answer", Release: release})
+	_, addr, shutdown, result := serve(t, llm, server.Config{
+		HTTP: server.HTTPConfig{ShutdownTimeout: 100 * time.Millisecond},
+	})
+
+	c := dial(t, "http://"+addr)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	c.until("conversation_started")
+	c.send(server.ClientMessage{Type: "message", Content: "Tell me everything"})
+	c.until("text_chunk")
+
+	shutdown()
+	c.until("stopped")
+	if err := <-result; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestServe_GoingAway(t *testing.T) {
+	_, addr, shutdown, result := serve(t, llmtest.New(t), server.Config{})
+	c := dial(t, "http://"+addr)
+
+	shutdown()
+	if err := <-result; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	_, _, err := c.ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after shutdown: err = %v, want close 1001", err)
+	}
+}
+
+func TestServe_AllowedOrigins(t *testing.T) {
+	_, url := newServer(t, llmtest.New(t), server.Config{
+		HTTP: server.HTTPConfig{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}},
+	})
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://eu.example.org", true},
+		{"https://evil.example.com", false},
+		{"http://app.example.com", false},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"https://a.example.org.evil.com", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL(url), header)
+		if (err == nil) != tt.ok {
+			t.Errorf("Dial from origin %q: err = %v, want ok = %v", tt.origin, err, tt.ok)
+		}
+		if err == nil {
+			ws.Close()
+		}
+	}
+}
+
+func TestServe_MaxConnectionsPerIP(t *testing.T) {
+	_, url := newServer(t, llmtest.New(t), server.Config{
+		HTTP: server.HTTPConfig{MaxConnectionsPerIP: 1, ClientIPHeader: "X-Forwarded-For"},
+	})
+	dialFrom := func(ip string) (*websocket.Conn, *http.Response, error) {
+		return websocket.DefaultDialer.Dial(wsURL(url), http.Header{"X-Forwarded-For": {"203.0.113.1, " + ip}})
+	}
+
+	first, _, err := dialFrom("198.51.100.1")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, resp, err := dialFrom("198.51.100.1"); err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second Dial from the same IP: err = %v, want status 429", err)
+	}
+	other, _, err := dialFrom("198.51.100.2")
+	if err != nil {
+		t.Fatalf("Dial from another IP: %v", err)
+	}
+	other.Close()
+
+	// Closing a connection frees its slot
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ws, _, err := dialFrom("198.51.100.1")
+		if err == nil {
+			ws.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dial after closing the first connection: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe_TLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1, time.Now())
+
+	clock := core.NewFakeClock(time.Now())
+	_, addr, _, _ := serve(t, llmtest.New(t), server.Config{
+		Clock: clock,
+		HTTP: server.HTTPConfig{
+			TLSCertFile:       certFile,
+			TLSKeyFile:        keyFile,
+			TLSReloadInterval: time.Minute,
+		},
+	})
+
+	serial := func() int64 {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("tls.Dial: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	writeCert(t, certFile, keyFile, 2, time.Now().Add(time.Hour))
+	if got := serial(); got != 1 {
+		t.Errorf("serial before the reload interval = %d, want 1", got)
+	}
+	clock.Advance(time.Minute)
+	if got := serial(); got != 2 {
+		t.Errorf("serial after the reload interval = %d, want 2", got)
+	}
+
+	// A broken certificate keeps the last good one
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	os.Chtimes(certFile, time.Now().Add(2*time.Hour), time.Now().Add(2*time.Hour))
+	clock.Advance(time.Minute)
+	if got := serial(); got != 2 {
+		t.Errorf("serial after a failed reload = %d, want 2", got)
+	}
+
+	// WebSockets are served over TLS too
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	ws, _, err := dialer.Dial("wss://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial over TLS: %v", err)
+	}
+	ws.Close()
+}
+
+// writeCert writes a self-signed certificate with the given serial number,
+// and its key, setting both files' modification times.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Command genschema writes the JSON Schema of the WebSocket protocol's
+// messages to the server's schema directory. Run it with go generate in
+// the server package.
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/becomeliminal/nim-go-sdk/server"
+)
+
+// schemas maps each file in the schema directory to its contents.
+var schemas = map[string]func() []byte{
+	"client_message.schema.json": server.ClientMessageSchema,
+	"server_message.schema.json": server.ServerMessageSchema,
+}
+
+func main() {
+	dir := "schema"
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	for name, schema := range schemas {
+		if err := os.WriteFile(filepath.Join(dir, name), schema(), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSchemasUpToDate fails if the protocol types changed without running
+// go generate in the server package.
+func TestSchemasUpToDate(t *testing.T) {
+	for name, schema := range schemas {
+		path := filepath.Join("..", "..", "schema", name)
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if !bytes.Equal(got, schema()) {
+			t.Errorf("%s is out of date; run go generate ./server", path)
+		}
+	}
+}
@@ -0,0 +1,540 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/metrics"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// confirmReply is the tool message content that confirms a pending action
+// on the OpenAI-compatible endpoint. Any other content cancels it.
+const confirmReply = "confirm"
+
+// OpenAIHandler returns an HTTP handler for an OpenAI-compatible chat
+// completions endpoint, so off-the-shelf chat UIs and eval tools can talk
+// to the agent:
+//
+//	POST /v1/chat/completions
+//
+// The endpoint is stateless: clients send the whole conversation with each
+// request, and nothing is stored in the conversation store. The agent's
+// own model, tools and system prompt are used; system messages are appended
+// to the prompt, and the request's model and tools are ignored.
+//
+// Read-only tools run on the server, and a completed response has only the
+// agent's final reply, with finish_reason "stop". The tools that ran are not
+// reported: in the OpenAI format, tool_calls and finish_reason "tool_calls"
+// ask the client to run the tools, and there is no standard field for calls
+// that already ran.
+//
+// An action that needs confirmation ends the response with finish_reason
+// "tool_calls", a single tool call whose ID is the action ID, and a
+// nim_action object describing it. The client confirms by sending the
+// conversation back with a tool message for that call whose content is
+// "confirm"; any other content cancels the action. The agent then
+// continues from the result.
+//
+// Requests are authenticated like WebSocket connections. APIHandler serves
+// this endpoint too when Config.OpenAICompatible is set.
+func (s *Server) OpenAIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.withAuth(s.handleChatCompletions))
+	return mux
+}
+
+// chatRequest is an OpenAI chat completions request. Only the fields the
+// agent uses are decoded.
+type chatRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	MaxTokens           int64 `json:"max_tokens"`
+	MaxCompletionTokens int64 `json:"max_completion_tokens"`
+}
+
+// chatMessage is a message in a chat completions request.
+type chatMessage struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"` // a string or an array of content parts
+	ToolCalls  []chatToolCall  `json:"tool_calls"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+// text returns the message's text, joining text content parts.
+func (m chatMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("invalid %s message content", m.Role)
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+		b.WriteString(part.Text)
+	}
+	return b.String(), nil
+}
+
+type chatToolCall struct {
+	Index    *int             `json:"index,omitempty"` // set in stream chunks
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON-encoded
+}
+
+// chatCompletion is a chat completions response, or a chunk of a streamed
+// response.
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int                  `json:"index"`
+	Message      *chatResponseMessage `json:"message,omitempty"`
+	Delta        *chatResponseMessage `json:"delta,omitempty"`
+	FinishReason *string              `json:"finish_reason"`
+}
+
+type chatResponseMessage struct {
+	Role      string         `json:"role,omitempty"`
+	Content   *string        `json:"content,omitempty"`
+	ToolCalls []chatToolCall `json:"tool_calls,omitempty"`
+
+	// NimAction describes an action awaiting confirmation.
+	NimAction *chatAction `json:"nim_action,omitempty"`
+}
+
+type chatAction struct {
+	ID        string `json:"id"`
+	Tool      string `json:"tool"`
+	Summary   string `json:"summary"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request, userID string) {
+	var req chatRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	system, history, err := chatHistory(req.Messages)
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if len(history) == 0 {
+		writeChatError(w, http.StatusBadRequest, "invalid_request_error", "messages must include a user message")
+		return
+	}
+
+	// Disconnecting stops the run, except for a confirmed action
+	ctx := requestContext(r)
+	requestID := uuid.New().String()
+	completionID := "chatcmpl-" + requestID
+	logger := s.logger.With(logging.User(userID), logging.Request(requestID))
+
+	input := &engine.Input{
+		Context:      core.NewContextWithClock(s.clock, userID, completionID, "", requestID),
+		SystemPrompt: s.config.SystemPrompt,
+		Model:        s.config.Model,
+		MaxTokens:    s.config.MaxTokens,
+	}
+	if system != "" {
+		// Request system messages add to the agent's prompt, not replace it
+		if input.SystemPrompt == "" {
+			input.SystemPrompt = engine.DefaultSystemPrompt
+		}
+		input.SystemPrompt += "\n\n" + system
+	}
+	if req.MaxCompletionTokens > 0 {
+		input.MaxTokens = req.MaxCompletionTokens
+	} else if req.MaxTokens > 0 {
+		input.MaxTokens = req.MaxTokens
+	}
+
+	last := history[len(history)-1]
+	switch {
+	case last.Role == core.RoleUser && len(last.ContentBlocks) == 0:
+		input.UserMessage = last.Content
+		input.History = history[:len(history)-1]
+	case last.Role == core.RoleUser:
+		s.resolveChatActions(ctx, logger, userID, last.ContentBlocks)
+		input.History = history
+	default:
+		writeChatError(w, http.StatusBadRequest, "invalid_request_error", "the last message must be from the user or a tool")
+		return
+	}
+
+	completion := &chatCompletion{
+		ID:      completionID,
+		Created: s.clock.Now().Unix(),
+		Model:   req.Model,
+	}
+	if completion.Model == "" {
+		completion.Model = s.config.Model
+	}
+
+	if req.Stream {
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		s.streamChatCompletion(ctx, w, logger, input, completion, includeUsage)
+		return
+	}
+
+	output, err := s.engine.Run(ctx, input)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logger.Error("agent run failed", logging.Error(err))
+		writeChatError(w, http.StatusInternalServerError, "server_error", fmt.Sprintf("Agent error: %v", err))
+		return
+	}
+	if output.Type == engine.OutputError {
+		logger.Warn("agent run returned error", logging.Error(output.Error))
+		writeChatError(w, http.StatusInternalServerError, "server_error", output.Error.Error())
+		return
+	}
+
+	message, finish := s.chatResponse(ctx, logger, output)
+	message.Role = "assistant"
+	completion.Object = "chat.completion"
+	completion.Choices = []chatChoice{{Message: message, FinishReason: &finish}}
+	completion.Usage = chatUsageOf(output)
+	writeJSON(w, http.StatusOK, completion)
+}
+
+// streamChatCompletion runs the agent, streaming its reply as chat
+// completion chunks.
+func (s *Server) streamChatCompletion(ctx context.Context, w http.ResponseWriter, logger *slog.Logger, input *engine.Input, completion *chatCompletion, includeUsage bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeChatError(w, http.StatusInternalServerError, "server_error", "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	completion.Object = "chat.completion.chunk"
+	write := func(v any) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	writeDelta := func(delta *chatResponseMessage, finish *string) {
+		chunk := *completion
+		chunk.Choices = []chatChoice{{Delta: delta, FinishReason: finish}}
+		write(chunk)
+	}
+
+	writeDelta(&chatResponseMessage{Role: "assistant", Content: new(string)}, nil)
+
+	streamed := false
+	if !s.config.DisableStreaming {
+		input.StreamCallback = func(chunk string, done bool) {
+			if !done && chunk != "" {
+				streamed = true
+				writeDelta(&chatResponseMessage{Content: &chunk}, nil)
+			}
+		}
+	}
+
+	output, err := s.engine.Run(ctx, input)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && output.Type == engine.OutputError {
+		err = output.Error
+	}
+	if err != nil {
+		logger.Error("agent run failed", logging.Error(err))
+		write(map[string]any{"error": chatErrorBody("server_error", err.Error())})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+		return
+	}
+
+	message, finish := s.chatResponse(ctx, logger, output)
+	if !streamed && message.Content != nil && *message.Content != "" {
+		writeDelta(&chatResponseMessage{Content: message.Content}, nil)
+	}
+	if len(message.ToolCalls) > 0 {
+		for i := range message.ToolCalls {
+			message.ToolCalls[i].Index = &i
+		}
+		writeDelta(&chatResponseMessage{ToolCalls: message.ToolCalls, NimAction: message.NimAction}, nil)
+	}
+	writeDelta(&chatResponseMessage{}, &finish)
+
+	if includeUsage {
+		chunk := *completion
+		chunk.Choices = []chatChoice{}
+		chunk.Usage = chatUsageOf(output)
+		write(chunk)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// chatResponse builds the assistant message for a finished run and its
+// finish reason, storing any action that needs confirmation.
+func (s *Server) chatResponse(ctx context.Context, logger *slog.Logger, output *engine.Output) (*chatResponseMessage, string) {
+	text := output.Text
+
+	if output.Type == engine.OutputConfirmationNeeded {
+		pending := output.PendingAction
+		if err := s.confirmations.Store(context.WithoutCancel(ctx), pending); err != nil {
+			logger.Error("failed to store confirmation", logging.Action(pending.ID), logging.Error(err))
+		}
+		s.metrics.Confirmation(metrics.ConfirmationRequested)
+
+		if text == "" {
+			text = pending.Summary
+		}
+		return &chatResponseMessage{
+			Content: &text,
+			ToolCalls: []chatToolCall{{
+				ID:       pending.ID,
+				Type:     "function",
+				Function: chatFunctionCall{Name: pending.Tool, Arguments: string(pending.Input)},
+			}},
+			NimAction: &chatAction{
+				ID:        pending.ID,
+				Tool:      pending.Tool,
+				Summary:   pending.Summary,
+				ExpiresAt: time.Unix(pending.ExpiresAt, 0).Format(time.RFC3339),
+			},
+		}, "tool_calls"
+	}
+
+	return &chatResponseMessage{Content: &text}, "stop"
+}
+
+// resolveChatActions confirms or cancels the pending actions that results
+// answer, replacing each result's content with the outcome. Results for
+// other tool calls are left as the client sent them.
+func (s *Server) resolveChatActions(ctx context.Context, logger *slog.Logger, userID string, results []core.ContentBlock) {
+	// The user has decided; money may move, so the client going away does
+	// not interrupt it
+	ctx = context.WithoutCancel(ctx)
+
+	for _, block := range results {
+		result := block.ToolResult
+		if result == nil {
+			continue
+		}
+		actionID := result.ToolUseID
+		if _, err := s.confirmations.Get(ctx, userID, actionID); errors.Is(err, store.ErrActionNotFound) {
+			continue
+		}
+		logger := logger.With(logging.Action(actionID))
+
+		if !strings.EqualFold(strings.TrimSpace(result.Content), confirmReply) {
+			if err := s.confirmations.Cancel(ctx, userID, actionID); err != nil {
+				logger.Info("confirmation not found or expired", logging.Error(err))
+				if errors.Is(err, store.ErrActionExpired) {
+					s.metrics.Confirmation(metrics.ConfirmationExpired)
+				}
+			} else {
+				s.metrics.Confirmation(metrics.ConfirmationCancelled)
+			}
+			result.Content, result.IsError = "Cancelled by user", true
+			continue
+		}
+
+		logger.Info("processing confirmation")
+		action, err := s.confirmations.Confirm(ctx, userID, actionID)
+		if err != nil {
+			logger.Info("confirmation not found or expired", logging.Error(err))
+			if errors.Is(err, store.ErrActionExpired) {
+				s.metrics.Confirmation(metrics.ConfirmationExpired)
+			}
+			result.Content, result.IsError = "Error: the action expired before it was confirmed", true
+			continue
+		}
+		s.metrics.Confirmation(metrics.ConfirmationConfirmed)
+
+		_, result.Content, result.IsError = s.executeAction(ctx, logger.With(logging.Tool(action.Tool)), userID, action)
+	}
+}
+
+// chatHistory converts chat completion messages to the agent's history and
+// system prompt. Tool calls without a tool message ran on the server in an
+// earlier request, so they are dropped along with results for unknown calls.
+func chatHistory(messages []chatMessage) (string, []core.Message, error) {
+	var system []string
+	var history []core.Message
+	var results []core.ContentBlock
+
+	flushResults := func() {
+		if len(results) > 0 {
+			history = append(history, core.Message{Role: core.RoleUser, ContentBlocks: results})
+			results = nil
+		}
+	}
+
+	for _, m := range messages {
+		text, err := m.text()
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch m.Role {
+		case "system", "developer":
+			if text != "" {
+				system = append(system, text)
+			}
+
+		case "user":
+			flushResults()
+			if text != "" {
+				history = append(history, core.NewUserMessage(text))
+			}
+
+		case "assistant":
+			flushResults()
+			var blocks []core.ContentBlock
+			if text != "" {
+				blocks = append(blocks, core.NewTextBlock(text))
+			}
+			for _, call := range m.ToolCalls {
+				args := json.RawMessage(call.Function.Arguments)
+				if len(args) == 0 {
+					args = json.RawMessage("{}")
+				}
+				if !json.Valid(args) {
+					return "", nil, fmt.Errorf("invalid arguments for tool call %q", call.ID)
+				}
+				blocks = append(blocks, core.NewToolUseBlock(call.ID, call.Function.Name, args))
+			}
+			history = append(history, core.NewAssistantMessageWithBlocks(blocks))
+
+		case "tool":
+			if m.ToolCallID == "" {
+				return "", nil, errors.New("tool message without tool_call_id")
+			}
+			results = append(results, core.NewToolResultBlock(m.ToolCallID, text, false))
+
+		default:
+			return "", nil, fmt.Errorf("unsupported message role %q", m.Role)
+		}
+	}
+	flushResults()
+
+	return strings.Join(system, "\n\n"), pairToolCalls(history), nil
+}
+
+// pairToolCalls drops tool_use blocks without a result in the next message,
+// results without a matching tool_use, and messages left empty.
+func pairToolCalls(history []core.Message) []core.Message {
+	paired := make([]core.Message, 0, len(history))
+	var calls map[string]bool
+
+	for i, msg := range history {
+		var blocks []core.ContentBlock
+		switch {
+		case msg.Role == core.RoleAssistant:
+			answered := map[string]bool{}
+			if i+1 < len(history) {
+				for _, b := range history[i+1].ContentBlocks {
+					if b.ToolResult != nil {
+						answered[b.ToolResult.ToolUseID] = true
+					}
+				}
+			}
+			calls = map[string]bool{}
+			for _, b := range msg.ContentBlocks {
+				if b.ToolUse != nil {
+					if !answered[b.ToolUse.ID] {
+						continue
+					}
+					calls[b.ToolUse.ID] = true
+				}
+				blocks = append(blocks, b)
+			}
+
+		case len(msg.ContentBlocks) > 0:
+			for _, b := range msg.ContentBlocks {
+				if b.ToolResult != nil && calls[b.ToolResult.ToolUseID] {
+					blocks = append(blocks, b)
+				}
+			}
+			calls = nil
+
+		default:
+			calls = nil
+			paired = append(paired, msg)
+			continue
+		}
+
+		if len(blocks) > 0 {
+			msg.ContentBlocks = blocks
+			paired = append(paired, msg)
+		}
+	}
+	return paired
+}
+
+func chatUsageOf(output *engine.Output) *chatUsage {
+	return &chatUsage{
+		PromptTokens:     output.TokensUsed.InputTokens,
+		CompletionTokens: output.TokensUsed.OutputTokens,
+		TotalTokens:      output.TokensUsed.TotalTokens(),
+	}
+}
+
+// writeChatError writes an error response in the OpenAI format.
+func writeChatError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]any{"error": chatErrorBody(errType, message)})
+}
+
+func chatErrorBody(errType, message string) map[string]any {
+	return map[string]any{"message": message, "type": errType, "code": nil}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/store"
+	"github.com/becomeliminal/nim-go-sdk/tools"
+)
+
+// chatUser is the user ID of every caller of the test server.
+const chatUser = "default-user"
+
+// newChatServer returns a server backed by llm, with a read-only balance
+// tool and a pay tool that needs confirmation and counts its runs, and
+// the URL of its OpenAI-compatible endpoint.
+func newChatServer(t *testing.T, llm *llmtest.Server, confirmations store.Confirmations, runs *atomic.Int32) (*Server, string) {
+	t.Helper()
+	srv, err := New(Config{
+		AnthropicKey:    "test-key",
+		BaseURL:         llm.URL,
+		AllowSharedUser: true,
+		Confirmations:   confirmations,
+		Titles:          TitleConfig{Disabled: true},
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	srv.AddTool(tools.New("balance").
+		Description("Get the balance").
+		Schema(tools.ObjectSchema(map[string]interface{}{})).
+		HandlerFunc(func(ctx context.Context, input json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"balance": "10"}, nil
+		}).
+		Build())
+	srv.AddTool(tools.New("pay").
+		Description("Pay someone").
+		Schema(tools.ObjectSchema(map[string]interface{}{"amount": tools.StringProperty("Amount")}, "amount")).
+		RequiresConfirmation().
+		HandlerFunc(func(ctx context.Context, input json.RawMessage) (interface{}, error) {
+			runs.Add(1)
+			return map[string]interface{}{"message": "Paid."}, nil
+		}).
+		Build())
+
+	front := httptest.NewServer(srv.OpenAIHandler())
+	t.Cleanup(front.Close)
+	return srv, front.URL + "/v1/chat/completions"
+}
+
+// chatMsg returns a chat message with string content.
+func chatMsg(role, content string) chatMessage {
+	data, _ := json.Marshal(content)
+	return chatMessage{Role: role, Content: data}
+}
+
+func call(id, name, args string) chatToolCall {
+	return chatToolCall{ID: id, Type: "function", Function: chatFunctionCall{Name: name, Arguments: args}}
+}
+
+// postChat sends req to the endpoint at url, returning the response.
+func postChat(t *testing.T, url string, req map[string]any) *http.Response {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// complete sends a non-streaming request, returning its only choice.
+func complete(t *testing.T, url string, messages []chatMessage) chatChoice {
+	t.Helper()
+	resp := postChat(t, url, map[string]any{"model": "nim", "messages": messages})
+	var completion chatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, %v", resp.StatusCode, err)
+	}
+	if completion.Object != "chat.completion" || len(completion.Choices) != 1 || completion.Choices[0].Message == nil {
+		t.Fatalf("completion = %+v, want one message", completion)
+	}
+	return completion.Choices[0]
+}
+
+func TestChatHistory(t *testing.T) {
+	answered := chatMsg("assistant", "")
+	answered.ToolCalls = []chatToolCall{call("call_1", "balance", "{}"), call("call_2", "balance", "")}
+	unanswered := chatMsg("assistant", "")
+	unanswered.ToolCalls = []chatToolCall{call("call_3", "balance", "{}")}
+	result := chatMsg("tool", "$10")
+	result.ToolCallID = "call_1"
+	stray := chatMsg("tool", "stray")
+	stray.ToolCallID = "call_9"
+
+	system, history, err := chatHistory([]chatMessage{
+		chatMsg("system", "Be brief."),
+		chatMsg("user", "What's my balance?"),
+		answered,
+		result,
+		stray,
+		chatMsg("assistant", "You have $10."),
+		unanswered,
+		chatMsg("developer", "Use dollars."),
+		{Role: "user", Content: json.RawMessage(`[{"type":"text","text":"Pay "},{"type":"text","text":"Alice"}]`)},
+	})
+	if err != nil {
+		t.Fatalf("chatHistory: %v", err)
+	}
+	if system != "Be brief.\n\nUse dollars." {
+		t.Errorf("system = %q", system)
+	}
+
+	// Only call_1 is answered; the rest ran on the server earlier, and the
+	// stray result answers nothing
+	want := []core.Message{
+		core.NewUserMessage("What's my balance?"),
+		core.NewAssistantMessageWithBlocks([]core.ContentBlock{core.NewToolUseBlock("call_1", "balance", json.RawMessage("{}"))}),
+		{Role: core.RoleUser, ContentBlocks: []core.ContentBlock{core.NewToolResultBlock("call_1", "$10", false)}},
+		core.NewAssistantMessageWithBlocks([]core.ContentBlock{core.NewTextBlock("You have $10.")}),
+		core.NewUserMessage("Pay Alice"),
+	}
+	got, _ := json.Marshal(history)
+	wanted, _ := json.Marshal(want)
+	if string(got) != string(wanted) {
+		t.Errorf("history = %s\nwant %s", got, wanted)
+	}
+}
+
+func TestChatHistory_Invalid(t *testing.T) {
+	badArgs := chatMsg("assistant", "")
+	badArgs.ToolCalls = []chatToolCall{call("call_1", "balance", "{")}
+	tests := map[string]chatMessage{
+		"tool message without call": chatMsg("tool", "$10"),
+		"unknown role":              chatMsg("narrator", "Meanwhile"),
+		"invalid arguments":         badArgs,
+		"unsupported content part":  {Role: "user", Content: json.RawMessage(`[{"type":"image_url"}]`)},
+		"content of the wrong type": {Role: "user", Content: json.RawMessage(`5`)},
+	}
+	for name, msg := range tests {
+		if _, _, err := chatHistory([]chatMessage{msg}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestResolveChatActions(t *testing.T) {
+	ctx := context.Background()
+	confirmations := store.NewMemoryConfirmations()
+	var runs atomic.Int32
+	srv, _ := newChatServer(t, llmtest.New(t), confirmations, &runs)
+
+	expires := time.Now().Add(time.Hour).Unix()
+	for id, user := range map[string]string{"confirmed": chatUser, "cancelled": chatUser, "bobs": "bob"} {
+		action := &core.PendingAction{ID: id, UserID: user, Tool: "pay", Input: json.RawMessage(`{"amount":"5"}`), ExpiresAt: expires}
+		if err := confirmations.Store(ctx, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := []core.ContentBlock{
+		core.NewToolResultBlock("confirmed", " Confirm\n", false),
+		core.NewToolResultBlock("cancelled", "no thanks", false),
+		core.NewToolResultBlock("bobs", "confirm", false),
+		core.NewToolResultBlock("call_1", "$10", false),
+		core.NewTextBlock("Thanks"),
+	}
+	srv.resolveChatActions(ctx, srv.logger, chatUser, results)
+
+	if r := results[0].ToolResult; r.IsError || !strings.Contains(r.Content, "Paid.") {
+		t.Errorf("confirmed result = %+v, want the tool's result", r)
+	}
+	if r := results[1].ToolResult; !r.IsError || r.Content != "Cancelled by user" {
+		t.Errorf("cancelled result = %+v, want a cancellation", r)
+	}
+	if r := results[2].ToolResult; r.IsError || r.Content != "confirm" {
+		t.Errorf("another user's action: result = %+v, want it left alone", r)
+	}
+	if r := results[3].ToolResult; r.IsError || r.Content != "$10" {
+		t.Errorf("unknown call: result = %+v, want it left alone", r)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("pay ran %d times, want 1", runs.Load())
+	}
+
+	for _, id := range []string{"confirmed", "cancelled"} {
+		if _, err := confirmations.Get(ctx, chatUser, id); !errors.Is(err, store.ErrActionNotFound) {
+			t.Errorf("%s action: err = %v, want it resolved", id, err)
+		}
+	}
+	if _, err := confirmations.Get(ctx, "bob", "bobs"); err != nil {
+		t.Errorf("another user's action: %v, want it pending", err)
+	}
+}
+
+func TestChatCompletions_ServerTools(t *testing.T) {
+	llm := llmtest.New(t)
+	var runs atomic.Int32
+	_, url := newChatServer(t, llm, store.NewMemoryConfirmations(), &runs)
+
+	llm.Script(llmtest.Reply{Tool: "balance", Input: "{}"}, llmtest.Reply{Text: "You have $10."})
+	choice := complete(t, url, []chatMessage{chatMsg("user", "What's my balance?")})
+
+	// Tools that ran are not reported as calls for the client to execute
+	msg := choice.Message
+	if *choice.FinishReason != "stop" || msg.Content == nil || *msg.Content != "You have $10." {
+		t.Errorf("choice = %+v, want the reply with finish_reason stop", choice)
+	}
+	if len(msg.ToolCalls) != 0 {
+		t.Errorf("tool_calls = %+v, want none", msg.ToolCalls)
+	}
+}
+
+func TestChatCompletions_Confirmation(t *testing.T) {
+	ctx := context.Background()
+	llm := llmtest.New(t)
+	confirmations := store.NewMemoryConfirmations()
+	var runs atomic.Int32
+	_, url := newChatServer(t, llm, confirmations, &runs)
+
+	llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":"5"}`})
+	messages := []chatMessage{chatMsg("user", "Pay Alice $5")}
+	choice := complete(t, url, messages)
+
+	msg := choice.Message
+	if *choice.FinishReason != "tool_calls" || len(msg.ToolCalls) != 1 || msg.NimAction == nil {
+		t.Fatalf("choice = %+v, want one tool call awaiting confirmation", choice)
+	}
+	toolCall := msg.ToolCalls[0]
+	if toolCall.ID != msg.NimAction.ID || toolCall.Function.Name != "pay" || toolCall.Function.Arguments != `{"amount":"5"}` {
+		t.Errorf("tool call = %+v, want the pay action %s", toolCall, msg.NimAction.ID)
+	}
+	if msg.NimAction.Tool != "pay" || msg.NimAction.ExpiresAt == "" {
+		t.Errorf("nim_action = %+v", msg.NimAction)
+	}
+	if _, err := confirmations.Get(ctx, chatUser, toolCall.ID); err != nil {
+		t.Fatalf("stored action: %v", err)
+	}
+	if runs.Load() != 0 {
+		t.Fatal("pay ran before it was confirmed")
+	}
+
+	// Confirming runs it, and the agent carries on from the result
+	assistant := chatMsg("assistant", *msg.Content)
+	assistant.ToolCalls = msg.ToolCalls
+	confirm := chatMsg("tool", "confirm")
+	confirm.ToolCallID = toolCall.ID
+	llm.Script(llmtest.Reply{Text: "Paid Alice."})
+	choice = complete(t, url, append(messages, assistant, confirm))
+
+	if *choice.FinishReason != "stop" || *choice.Message.Content != "Paid Alice." {
+		t.Errorf("choice = %+v, want the reply after paying", choice)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("pay ran %d times, want 1", runs.Load())
+	}
+	requests := llm.Requests()
+	if req := requests[len(requests)-1]; !strings.Contains(req, "Paid.") {
+		t.Errorf("model request = %s, want the pay result", req)
+	}
+}
+
+func TestChatCompletions_Stream(t *testing.T) {
+	llm := llmtest.New(t)
+	var runs atomic.Int32
+	_, url := newChatServer(t, llm, store.NewMemoryConfirmations(), &runs)
+
+	llm.Script(llmtest.Reply{Tool: "balance", Input: "{}"}, llmtest.Reply{Text: "You have $10."})
+	resp := postChat(t, url, map[string]any{
+		"model":          "nim",
+		"messages":       []chatMessage{chatMsg("user", "What's my balance?")},
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	})
+	if got := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || got != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q, want an event stream", resp.StatusCode, got)
+	}
+
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, line)
+		}
+	}
+	if len(data) < 4 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("stream = %v, want chunks ending with [DONE]", data)
+	}
+
+	var chunks []chatCompletion
+	for _, d := range data[:len(data)-1] {
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(d), &chunk); err != nil {
+			t.Fatalf("chunk %s: %v", d, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || chunk.ID == "" || len(chunks) > 0 && chunk.ID != chunks[0].ID {
+			t.Errorf("chunk = %s, want chunks of one completion", d)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// The role, the text, the finish reason, then usage
+	if first := chunks[0].Choices[0].Delta; first.Role != "assistant" {
+		t.Errorf("first delta = %+v, want the assistant role", first)
+	}
+	usage := chunks[len(chunks)-1]
+	if len(usage.Choices) != 0 || usage.Usage == nil || usage.Usage.TotalTokens == 0 {
+		t.Errorf("last chunk = %+v, want usage only", usage)
+	}
+	finish := chunks[len(chunks)-2].Choices[0]
+	if finish.FinishReason == nil || *finish.FinishReason != "stop" {
+		t.Errorf("final choice = %+v, want finish_reason stop", finish)
+	}
+
+	var text string
+	for _, chunk := range chunks[:len(chunks)-2] {
+		choice := chunk.Choices[0]
+		if choice.FinishReason != nil {
+			t.Errorf("finish_reason %q before the last choice", *choice.FinishReason)
+		}
+		if len(choice.Delta.ToolCalls) > 0 {
+			t.Errorf("tool_calls = %+v, want none", choice.Delta.ToolCalls)
+		}
+		if choice.Delta.Content != nil {
+			text += *choice.Delta.Content
+		}
+	}
+	if text != "You have $10." {
+		t.Errorf("streamed text = %q, want the reply", text)
+	}
+}
@@ -1,25 +1,141 @@
 // Package server provides a ready-to-run WebSocket server for the Nim agent.
 package server
 
-// ClientMessage is a message from the client.
+import (
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+//go:generate go run ./internal/genschema
+
+// ProtocolVersion is the version of the WebSocket protocol the server
+// speaks. Clients declare theirs in a "hello" message; the server answers
+// with the version both will use.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest protocol version the server accepts.
+const MinProtocolVersion = 1
+
+// Capabilities a client can ask for in its "hello". A client that sends no
+// hello gets all of them.
+const (
+	// CapabilityStreaming sends text_chunk messages as the reply streams.
+	CapabilityStreaming = "streaming"
+
+	// CapabilityEvents sends events not caused by the client's own
+	// messages, such as conversation_titled.
+	CapabilityEvents = "events"
+
+	// CapabilityBatchConfirmations lets confirm and cancel resolve several
+	// actions at once, with actionIds.
+	CapabilityBatchConfirmations = "batch_confirmations"
+)
+
+// Capabilities lists every capability the server supports.
+var Capabilities = []string{CapabilityStreaming, CapabilityEvents, CapabilityBatchConfirmations}
+
+// Error codes, sent in the Code of "error" messages.
+const (
+	ErrCodeInvalidMessage     = "invalid_message"     // the message cannot be decoded, or is not an object
+	ErrCodeUnknownType        = "unknown_type"        // Type is missing or not a client message type
+	ErrCodeUnknownField       = "unknown_field"       // a field the protocol does not define
+	ErrCodeUnexpectedField    = "unexpected_field"    // a field this message type does not take
+	ErrCodeMissingField       = "missing_field"       // a required field is missing or empty
+	ErrCodeInvalidField       = "invalid_field"       // a field has the wrong type or value
+	ErrCodeUnsupportedVersion = "unsupported_version" // hello asked for a version older than MinProtocolVersion
+	ErrCodeCapabilityRequired = "capability_required" // the message needs a capability not negotiated
+	ErrCodeOutOfOrder         = "out_of_order"        // hello or resume after the handshake
+	ErrCodeNoConversation     = "no_conversation"     // the message needs an active conversation
+	ErrCodeTurnInProgress     = "turn_in_progress"    // another reply is in progress
+	ErrCodeShuttingDown       = "shutting_down"       // the server is shutting down and starts no new turns
+	ErrCodeNotFound           = "not_found"           // the conversation, message or action does not exist
+	ErrCodeAgentError         = "agent_error"         // the agent failed to reply
+	ErrCodeInternal           = "internal_error"      // the server failed
+)
+
+// ServerMessageTypes lists the types of server messages.
+var ServerMessageTypes = []string{
+	"connected", "hello", "resumed", "resume_failed",
+	"conversation_started", "conversation_resumed",
+	"message_saved", "text_chunk", "text", "confirm_request", "complete", "stopped", "error",
+	"conversation_titled", "conversations", "search_results", "conversation_updated", "conversation_deleted",
+}
+
+// ClientMessage is a message from the client. The fields each Type takes
+// are listed in the JSON Schema in the schema directory.
 type ClientMessage struct {
-	Type           string `json:"type"` // "new_conversation", "resume_conversation", "message", "confirm", "cancel"
+	Type           string `json:"type"` // "hello", "resume", "new_conversation", "resume_conversation", "message", "confirm", "cancel", "stop", plus the branching and conversation management types below
 	Content        string `json:"content,omitempty"`
 	ActionID       string `json:"actionId,omitempty"`
 	ConversationID string `json:"conversationId,omitempty"`
+
+	// ActionIDs resolves several actions with one confirm or cancel. It
+	// needs the batch_confirmations capability.
+	ActionIDs []string `json:"actionIds,omitempty"`
+
+	// Handshake: "hello", sent first, declares the client's protocol
+	// version and the capabilities it wants.
+	Version      int      `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Conversation management: "list_conversations", "search_conversations",
+	// "rename_conversation", "delete_conversation", "archive_conversation",
+	// "unarchive_conversation", "pin_conversation", "unpin_conversation".
+	Title    string `json:"title,omitempty"`    // rename_conversation
+	Query    string `json:"query,omitempty"`    // search_conversations
+	Limit    int    `json:"limit,omitempty"`    // list/search page size
+	Cursor   string `json:"cursor,omitempty"`   // nextCursor of the previous page
+	Sort     string `json:"sort,omitempty"`     // "updated" (default) or "created"
+	Archived string `json:"archived,omitempty"` // "" (exclude), "only" or "include"
+
+	// Branching: "edit_message" replaces MessageID with Content and reruns
+	// the agent; "regenerate" reruns the reply to MessageID (default: the last turn).
+	MessageID string `json:"messageId,omitempty"`
+
+	// Reconnecting: "resume" must be the first message on a new WebSocket.
+	// The server replays the messages after LastSeq sent on the connection
+	// ResumeToken identifies.
+	ResumeToken string `json:"resumeToken,omitempty"`
+	LastSeq     int64  `json:"lastSeq,omitempty"`
 }
 
-// ServerMessage is a message to the client.
+// ServerMessage is a message to the client. The fields each Type carries
+// are listed in the JSON Schema in the schema directory.
 type ServerMessage struct {
-	Type           string      `json:"type"` // "conversation_started", "conversation_resumed", "text", "text_chunk", "confirm_request", "complete", "error"
-	Content        string      `json:"content,omitempty"`
-	ActionID       string      `json:"actionId,omitempty"`
-	Tool           string      `json:"tool,omitempty"`
-	Summary        string      `json:"summary,omitempty"`
-	ExpiresAt      string      `json:"expiresAt,omitempty"`
-	ConversationID string      `json:"conversationId,omitempty"`
-	Messages       interface{} `json:"messages,omitempty"`
-	TokenUsage     *TokenUsage `json:"tokenUsage,omitempty"`
+	Seq            int64                 `json:"seq,omitempty"` // per connection, from 1; absent on connected, hello, resumed and resume_failed
+	Type           string                `json:"type"`          // one of ServerMessageTypes
+	Content        string                `json:"content,omitempty"`
+	ActionID       string                `json:"actionId,omitempty"`
+	Tool           string                `json:"tool,omitempty"`
+	Summary        string                `json:"summary,omitempty"`
+	ExpiresAt      string                `json:"expiresAt,omitempty"`
+	ConversationID string                `json:"conversationId,omitempty"`
+	Messages       []store.StoredMessage `json:"messages,omitempty"` // conversation_resumed
+	TokenUsage     *TokenUsage           `json:"tokenUsage,omitempty"`
+	MessageID      string                `json:"messageId,omitempty"`   // message_saved: the user message's stored ID
+	ParentID       string                `json:"parentId,omitempty"`    // message_saved: the message it follows
+	Title          string                `json:"title,omitempty"`       // conversation_titled
+	ResumeToken    string                `json:"resumeToken,omitempty"` // connected, resumed
+
+	// Version and Capabilities are the server's on connected, and those
+	// agreed with the client on hello.
+	Version      int      `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Code is the error's code, one of the ErrCode constants; Field names
+	// the offending field of the client's message, if any.
+	Code  string `json:"code,omitempty"`
+	Field string `json:"field,omitempty"`
+
+	// Tools lists the tools executed during the turn, with their results
+	// and durations: on complete, and on confirm_request for those run
+	// before the action.
+	Tools []core.ToolExecution `json:"tools,omitempty"`
+
+	Conversation  *store.Conversation   `json:"conversation,omitempty"`  // conversation_updated
+	Conversations []*store.Conversation `json:"conversations,omitempty"` // conversations, search_results
+	NextCursor    string                `json:"nextCursor,omitempty"`
+	Query         string                `json:"query,omitempty"`
 }
 
 // TokenUsage tracks Claude API token consumption.
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/metrics"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// maxRequestBody bounds HTTP API request bodies.
+const maxRequestBody = 1 << 20
+
+// APIHandler returns an HTTP handler for the REST API, for clients that
+// cannot hold a WebSocket. It serves:
+//
+//	POST /v1/conversations                 create a conversation
+//	GET  /v1/conversations/{id}            get a conversation with its messages
+//	POST /v1/conversations/{id}/messages   send a message; streams events
+//	POST /v1/actions/{id}/confirm          confirm an action; streams events
+//	POST /v1/actions/{id}/cancel           cancel an action; streams events
+//
+// Streaming endpoints reply with Server-Sent Events whose event names and
+// JSON payloads are the WebSocket protocol's server messages. Requests are
+// authenticated like WebSocket connections, and use the same stores.
+//
+// With Config.OpenAICompatible set, it also serves the OpenAI-compatible
+// POST /v1/chat/completions; see OpenAIHandler.
+func (s *Server) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/conversations", s.withAuth(s.handleCreateConversationHTTP))
+	mux.HandleFunc("GET /v1/conversations/{id}", s.withAuth(s.handleGetConversationHTTP))
+	mux.HandleFunc("POST /v1/conversations/{id}/messages", s.withAuth(s.handleMessageHTTP))
+	mux.HandleFunc("POST /v1/actions/{id}/confirm", s.withAuth(s.handleConfirmHTTP))
+	mux.HandleFunc("POST /v1/actions/{id}/cancel", s.withAuth(s.handleCancelHTTP))
+	if s.config.OpenAICompatible {
+		mux.HandleFunc("POST /v1/chat/completions", s.withAuth(s.handleChatCompletions))
+	}
+	return mux
+}
+
+// withAuth authenticates the request, and applies the per-IP connection
+// limit, before calling h.
+func (s *Server) withAuth(h func(w http.ResponseWriter, r *http.Request, userID string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		release, ok := s.acquireConnection(r)
+		if !ok {
+			writeError(w, http.StatusTooManyRequests, "too many connections")
+			return
+		}
+		defer release()
+		h(w, r, userID)
+	}
+}
+
+func (s *Server) handleCreateConversationHTTP(w http.ResponseWriter, r *http.Request, userID string) {
+	conv, err := s.conversations.Create(r.Context(), userID)
+	if err != nil {
+		s.logger.Error("failed to create conversation", logging.User(userID), logging.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to create conversation")
+		return
+	}
+	s.logger.Info("started conversation", logging.User(userID), logging.Conversation(conv.ID))
+	writeJSON(w, http.StatusCreated, conv)
+}
+
+func (s *Server) handleGetConversationHTTP(w http.ResponseWriter, r *http.Request, userID string) {
+	conv, ok := s.loadConversationHTTP(r.Context(), w, userID, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, conv)
+}
+
+func (s *Server) handleMessageHTTP(w http.ResponseWriter, r *http.Request, userID string) {
+	var body struct {
+		Content string `json:"content"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.Content == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	// Disconnecting stops the turn, like a WebSocket "stop"
+	ctx := requestContext(r)
+	s.streamTurn(ctx, w, userID, r.PathValue("id"), func(stream *eventStream, sess *session) {
+		s.handleMessage(ctx, stream, sess, body.Content)
+	})
+}
+
+func (s *Server) handleConfirmHTTP(w http.ResponseWriter, r *http.Request, userID string) {
+	s.handleActionHTTP(w, r, userID, s.handleConfirm)
+}
+
+func (s *Server) handleCancelHTTP(w http.ResponseWriter, r *http.Request, userID string) {
+	s.handleActionHTTP(w, r, userID, s.handleCancel)
+}
+
+// handleActionHTTP resolves an action with handle. Actions do not record
+// their conversation, so the client names it.
+func (s *Server) handleActionHTTP(w http.ResponseWriter, r *http.Request, userID string,
+	handle func(ctx context.Context, c client, sess *session, userID, actionID string)) {
+	var body struct {
+		ConversationID string `json:"conversationId"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.ConversationID == "" {
+		writeError(w, http.StatusBadRequest, "conversationId is required")
+		return
+	}
+
+	ctx := requestContext(r)
+	actionID := r.PathValue("id")
+	s.streamTurn(ctx, w, userID, body.ConversationID, func(stream *eventStream, sess *session) {
+		handle(ctx, stream, sess, userID, actionID)
+	})
+}
+
+// streamTurn loads the conversation and runs fn with an event stream for
+// the response. Only one turn per conversation runs at a time, and none
+// start once the server is shutting down.
+func (s *Server) streamTurn(ctx context.Context, w http.ResponseWriter, userID, conversationID string, fn func(stream *eventStream, sess *session)) {
+	if !s.turns.start() {
+		writeError(w, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+	defer s.turns.done()
+
+	// Claim the conversation before loading it, so the history is not
+	// stale by the time the turn runs
+	if !s.claimConversation(conversationID) {
+		writeError(w, http.StatusConflict, "a response is already in progress")
+		return
+	}
+	defer s.releaseConversation(conversationID)
+
+	conv, ok := s.loadConversationHTTP(ctx, w, userID, conversationID)
+	if !ok {
+		return
+	}
+
+	stream, err := newEventStream(w, s.metrics)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer stream.close()
+
+	fn(stream, sessionFor(conv))
+}
+
+// loadConversationHTTP gets the user's conversation, writing an error
+// response if it cannot.
+func (s *Server) loadConversationHTTP(ctx context.Context, w http.ResponseWriter, userID, conversationID string) (*store.ConversationWithMessages, bool) {
+	conv, err := s.conversations.Get(ctx, userID, conversationID)
+	if err != nil {
+		// Same response whether the conversation is missing or owned by
+		// someone else, so IDs cannot be probed.
+		if errors.Is(err, store.ErrConversationNotFound) {
+			writeError(w, http.StatusNotFound, "conversation not found")
+		} else {
+			s.logger.Error("failed to load conversation", logging.User(userID), logging.Conversation(conversationID), logging.Error(err))
+			writeError(w, http.StatusInternalServerError, "failed to load conversation")
+		}
+		return nil, false
+	}
+	return conv, true
+}
+
+// eventStream is a client that writes server messages as Server-Sent
+// Events. Messages sent after the response has finished, such as a title
+// generated in the background, are dropped.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	metrics *metrics.Prometheus
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newEventStream(w http.ResponseWriter, m *metrics.Prometheus) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming unsupported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{w: w, flusher: flusher, metrics: m}, nil
+}
+
+func (e *eventStream) send(msg ServerMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return false
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", msg.Type, data); err != nil {
+		e.closed = true
+		return false
+	}
+	e.flusher.Flush()
+	e.metrics.MessageSent(msg.Type)
+	return true
+}
+
+// close ends the stream; later sends are dropped.
+func (e *eventStream) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+}
+
+// readJSON decodes the request body into v, writing an error response if
+// it cannot. An empty body leaves v unchanged.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,457 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// serveAPI serves srv's HTTP API, returning its base URL.
+func serveAPI(t *testing.T, srv *server.Server) string {
+	t.Helper()
+	front := httptest.NewServer(srv.APIHandler())
+	t.Cleanup(front.Close)
+	return front.URL
+}
+
+// post sends body to url as user, if set, returning the response.
+func post(t *testing.T, url, user, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// createConversation starts a conversation for user over the HTTP API.
+func createConversation(t *testing.T, api, user string) string {
+	t.Helper()
+	resp := post(t, api+"/v1/conversations", user, "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create conversation: status %d, want 201", resp.StatusCode)
+	}
+	var conv store.Conversation
+	if err := json.NewDecoder(resp.Body).Decode(&conv); err != nil {
+		t.Fatalf("decode conversation: %v", err)
+	}
+	if conv.ID == "" || conv.UserID != user {
+		t.Fatalf("conversation = %+v, want one owned by %s", conv, user)
+	}
+	return conv.ID
+}
+
+// sseReader reads the Server-Sent Events of a streaming response.
+type sseReader struct {
+	t       *testing.T
+	scanner *bufio.Scanner
+}
+
+func readEvents(t *testing.T, resp *http.Response) *sseReader {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	return &sseReader{t: t, scanner: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the next event's message, checking the event is named
+// after its type, or false at the end of the stream.
+func (e *sseReader) next() (server.ServerMessage, bool) {
+	e.t.Helper()
+	var name, data string
+	for e.scanner.Scan() {
+		line := e.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			var msg server.ServerMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				e.t.Fatalf("event data %s: %v", data, err)
+			}
+			if msg.Type != name {
+				e.t.Errorf("event %q carries a %q message", name, msg.Type)
+			}
+			return msg, true
+		}
+	}
+	return server.ServerMessage{}, false
+}
+
+// rest reads the remaining events.
+func (e *sseReader) rest() []server.ServerMessage {
+	e.t.Helper()
+	var msgs []server.ServerMessage
+	for msg, ok := e.next(); ok; msg, ok = e.next() {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// holdReply returns a channel to hold a scripted reply with, and a
+// function that releases it, safe to call more than once.
+func holdReply() (chan struct{}, func()) {
+	release := make(chan struct{})
+	var once sync.Once
+	return release, func() { once.Do(func() { close(release) }) }
+}
+
+// userHeader authenticates requests by their X-User header.
+func userHeader(r *http.Request) (string, error) {
+	if user := r.Header.Get("X-User"); user != "" {
+		return user, nil
+	}
+	return "", errors.New("no user")
+}
+
+func TestAPI_Conversations(t *testing.T) {
+	srv, _ := newServer(t, llmtest.New(t), server.Config{AuthFunc: userHeader})
+	api := serveAPI(t, srv)
+
+	id := createConversation(t, api, "alice")
+
+	get := func(user string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, api+"/v1/conversations/"+id, nil)
+		req.Header.Set("X-User", user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	resp := get("alice")
+	var conv store.ConversationWithMessages
+	if err := json.NewDecoder(resp.Body).Decode(&conv); err != nil || resp.StatusCode != http.StatusOK || conv.ID != id {
+		t.Errorf("GET own conversation: status %d, %+v, %v", resp.StatusCode, conv, err)
+	}
+
+	// Another user's conversation looks missing
+	if resp := get("bob"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET another user's conversation: status %d, want 404", resp.StatusCode)
+	}
+	if resp := post(t, api+"/v1/conversations/"+id+"/messages", "bob", `{"content":"Hi"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("message to another user's conversation: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAPI_Errors(t *testing.T) {
+	srv, _ := newServer(t, llmtest.New(t), server.Config{AuthFunc: userHeader})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, "alice")
+
+	tests := []struct {
+		name, path, user, body string
+		want                   int
+	}{
+		{"no auth", "/v1/conversations", "", "", http.StatusUnauthorized},
+		{"no auth for messages", "/v1/conversations/" + id + "/messages", "", `{"content":"Hi"}`, http.StatusUnauthorized},
+		{"invalid body", "/v1/conversations/" + id + "/messages", "alice", `{"content":`, http.StatusBadRequest},
+		{"wrong body type", "/v1/conversations/" + id + "/messages", "alice", `{"content":5}`, http.StatusBadRequest},
+		{"no content", "/v1/conversations/" + id + "/messages", "alice", `{}`, http.StatusBadRequest},
+		{"no conversation for action", "/v1/actions/a1/confirm", "alice", `{}`, http.StatusBadRequest},
+		{"unknown conversation", "/v1/conversations/unknown/messages", "alice", `{"content":"Hi"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(t, api+tt.path, tt.user, tt.body)
+			var body struct{ Error string }
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.want || body.Error == "" {
+				t.Errorf("status %d with error %q, want %d with an error", resp.StatusCode, body.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_Message(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, _ := newServer(t, llm, server.Config{})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	llm.Script(llmtest.Reply{Text: "Your balance is $10."})
+	msgs := readEvents(t, post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"What's my balance?"}`)).rest()
+
+	if len(msgs) == 0 || msgs[0].Type != "message_saved" || msgs[0].MessageID == "" || msgs[len(msgs)-1].Type != "complete" {
+		t.Fatalf("events = %v, want message_saved first and complete last", types(msgs))
+	}
+	var text string
+	for _, msg := range msgs {
+		if msg.Type == "text_chunk" {
+			text += msg.Content
+		}
+	}
+	if text != "Your balance is $10." {
+		t.Errorf("text chunks = %q, want the reply", text)
+	}
+	if complete := msgs[len(msgs)-1]; complete.TokenUsage == nil || complete.TokenUsage.OutputTokens == 0 {
+		t.Errorf("complete = %+v, want token usage", complete)
+	}
+}
+
+func TestAPI_ConfirmAndCancel(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, _ := newServer(t, llm, server.Config{})
+	var runs atomic.Int32
+	srv.AddTool(payTool(&runs))
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	requestPayment := func() string {
+		t.Helper()
+		llm.Script(llmtest.Reply{Tool: "pay", Input: `{"amount":"5"}`})
+		msgs := readEvents(t, post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"Pay Alice $5"}`)).rest()
+		request, ok := find(msgs, "confirm_request")
+		if !ok || request.ActionID == "" {
+			t.Fatalf("events = %v, want a confirm_request", types(msgs))
+		}
+		return request.ActionID
+	}
+	body := `{"conversationId":"` + id + `"}`
+
+	actionID := requestPayment()
+	msgs := readEvents(t, post(t, api+"/v1/actions/"+actionID+"/confirm", "", body)).rest()
+	complete, ok := find(msgs, "complete")
+	if !ok || len(complete.Tools) != 1 || complete.Tools[0].Tool != "pay" || complete.Tools[0].Error != "" {
+		t.Errorf("confirm events = %+v, want complete with the pay execution", msgs)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("pay ran %d times after confirm, want 1", runs.Load())
+	}
+
+	actionID = requestPayment()
+	msgs = readEvents(t, post(t, api+"/v1/actions/"+actionID+"/cancel", "", body)).rest()
+	if got := types(msgs); len(got) != 2 || got[0] != "text" || got[1] != "complete" {
+		t.Errorf("cancel events = %v, want text and complete", got)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("pay ran after cancel")
+	}
+
+	// A resolved action cannot run again
+	msgs = readEvents(t, post(t, api+"/v1/actions/"+actionID+"/confirm", "", body)).rest()
+	if complete, _ := find(msgs, "complete"); len(complete.Tools) != 0 || runs.Load() != 1 {
+		t.Errorf("confirming a cancelled action: events = %v, pay ran %d times, want 1", types(msgs), runs.Load())
+	}
+}
+
+func TestAPI_OneTurnPerConversation(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, _ := newServer(t, llm, server.Config{})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	release, unblock := holdReply()
+	defer unblock()
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+	events := readEvents(t, post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"What's my balance?"}`))
+	for msg, ok := events.next(); msg.Type != "text_chunk"; msg, ok = events.next() {
+		if !ok {
+			t.Fatal("stream ended before the reply")
+		}
+	}
+
+	if resp := post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"Hello?"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second turn: status %d, want 409", resp.StatusCode)
+	}
+	other := createConversation(t, api, sharedUser)
+	llm.Script(llmtest.Reply{Text: "Hi."})
+	if msgs := readEvents(t, post(t, api+"/v1/conversations/"+other+"/messages", "", `{"content":"Hi"}`)).rest(); msgs[len(msgs)-1].Type != "complete" {
+		t.Errorf("turn in another conversation: events = %v", types(msgs))
+	}
+
+	unblock()
+	if msgs := events.rest(); msgs[len(msgs)-1].Type != "complete" {
+		t.Errorf("first turn ended with %v", types(msgs))
+	}
+	llm.Script(llmtest.Reply{Text: "Yes?"})
+	if msgs := readEvents(t, post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"Hello?"}`)).rest(); msgs[len(msgs)-1].Type != "complete" {
+		t.Errorf("turn after the first finished: events = %v", types(msgs))
+	}
+}
+
+func TestAPI_ConcurrentMessages(t *testing.T) {
+	ctx := context.Background()
+	conversations := store.NewMemoryConversations()
+	llm := llmtest.New(t)
+	srv, _ := newServer(t, llm, server.Config{Conversations: conversations})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	// Each reply echoes the message it answers, and every request must
+	// carry the whole conversation so far. Answer calls are serialized.
+	var stale []int
+	answered := 0
+	llm.Answer = func(request string) llmtest.Reply {
+		var req struct {
+			Messages []struct {
+				Content []struct{ Text string } `json:"content"`
+			} `json:"messages"`
+		}
+		json.Unmarshal([]byte(request), &req)
+		if len(req.Messages) != 2*answered+1 {
+			stale = append(stale, len(req.Messages))
+		}
+		answered++
+		last := req.Messages[len(req.Messages)-1].Content
+		return llmtest.Reply{Text: "Re: " + last[len(last)-1].Text}
+	}
+
+	// Clients retry while the conversation is busy, so requests keep
+	// arriving as turns finish
+	var wg sync.WaitGroup
+	var completed atomic.Int32
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; {
+				resp := post(t, api+"/v1/conversations/"+id+"/messages", "", fmt.Sprintf(`{"content":"Message %d.%d"}`, i, j))
+				if resp.StatusCode == http.StatusConflict {
+					continue
+				}
+				if msgs := readEvents(t, resp).rest(); len(msgs) > 0 && msgs[len(msgs)-1].Type == "complete" {
+					completed.Add(1)
+				}
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(stale) > 0 {
+		t.Errorf("model requests with stale history: %v messages", stale)
+	}
+	conv, err := conversations.Get(ctx, sharedUser, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conv.Messages) != 2*int(completed.Load()) {
+		t.Fatalf("stored %d messages for %d completed turns", len(conv.Messages), completed.Load())
+	}
+	for i := 0; i < len(conv.Messages); i += 2 {
+		question, answer := conv.Messages[i], conv.Messages[i+1]
+		if question.Role != "user" || answer.Role != "assistant" || answer.Content != "Re: "+question.Content {
+			t.Errorf("stored exchange %d = %q, %q; want a message and its reply", i/2, question.Content, answer.Content)
+		}
+	}
+}
+
+func TestAPI_SharesTurnsWithWebSocket(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "resume_conversation", ConversationID: id})
+	c.last("conversation_resumed")
+
+	// A WebSocket turn holds the conversation against the HTTP API
+	release, unblock := holdReply()
+	defer unblock()
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+	c.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	c.until("text_chunk")
+	if resp := post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"Hello?"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("HTTP turn during a WebSocket turn: status %d, want 409", resp.StatusCode)
+	}
+	unblock()
+	c.until("complete")
+
+	// And the other way round
+	release, unblock = holdReply()
+	defer unblock()
+	llm.Script(llmtest.Reply{Text: "It was $12.", Release: release})
+	events := readEvents(t, post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"And yesterday?"}`))
+	for msg, ok := events.next(); msg.Type != "text_chunk"; msg, ok = events.next() {
+		if !ok {
+			t.Fatal("stream ended before the reply")
+		}
+	}
+	c.send(server.ClientMessage{Type: "message", Content: "Hello?"})
+	if msg := c.last("error"); msg.Code != server.ErrCodeTurnInProgress {
+		t.Errorf("WebSocket turn during an HTTP turn: code = %q, want %q", msg.Code, server.ErrCodeTurnInProgress)
+	}
+	unblock()
+	events.rest()
+
+	// The WebSocket session picks up the HTTP turn before its next one
+	llm.Script(llmtest.Reply{Text: "You're welcome."})
+	c.send(server.ClientMessage{Type: "message", Content: "Thanks"})
+	c.until("complete")
+	if req := lastRequest(llm); !strings.Contains(req, "And yesterday?") || !strings.Contains(req, "It was $12.") {
+		t.Errorf("model request = %s, want the HTTP exchange", req)
+	}
+}
+
+func TestAPI_ShuttingDown(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, addr, shutdown, result := serve(t, llm, server.Config{})
+	api := serveAPI(t, srv)
+	id := createConversation(t, api, sharedUser)
+
+	// A turn in flight holds Serve's shutdown open
+	release, unblock := holdReply()
+	defer unblock()
+	llm.Script(llmtest.Reply{Text: "Your balance is $10.", Release: release})
+	events := readEvents(t, post(t, "http://"+addr+"/v1/conversations/"+id+"/messages", "", `{"content":"What's my balance?"}`))
+	for msg, ok := events.next(); msg.Type != "text_chunk"; msg, ok = events.next() {
+		if !ok {
+			t.Fatal("stream ended before the reply")
+		}
+	}
+	shutdown()
+
+	// Busy until the server starts draining, then refused
+	other := createConversation(t, api, sharedUser)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		resp := post(t, api+"/v1/conversations/"+id+"/messages", "", `{"content":"Hello?"}`)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if resp.StatusCode != http.StatusConflict || time.Now().After(deadline) {
+			t.Fatalf("turn while shutting down: status %d, want 503", resp.StatusCode)
+		}
+	}
+	if resp := post(t, api+"/v1/conversations/"+other+"/messages", "", `{"content":"Hi"}`); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("turn in another conversation while shutting down: status %d, want 503", resp.StatusCode)
+	}
+
+	// The turn in flight finishes
+	unblock()
+	if msgs := events.rest(); len(msgs) == 0 || msgs[len(msgs)-1].Type != "complete" {
+		t.Errorf("turn in flight ended with %v", types(msgs))
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
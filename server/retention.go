@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/retention"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// RetentionConfig configures the background retention scheduler. Expired
+// pending actions are always cleaned up unless Disabled is set; the other
+// policies run only when their max age is set.
+type RetentionConfig struct {
+	// Disabled turns the retention scheduler off.
+	Disabled bool
+
+	// ConversationMaxAge deletes conversations with no activity for longer
+	// than this. The conversation store must implement
+	// store.ConversationPurger.
+	ConversationMaxAge time.Duration
+
+	// ActionHistoryMaxAge deletes resolved actions older than this from
+	// confirmation stores that keep them as history. The store must
+	// implement store.ActionPurger.
+	ActionHistoryMaxAge time.Duration
+
+	// AuditMaxAge deletes audit entries older than this. The audit logger
+	// must implement engine.AuditPurger.
+	AuditMaxAge time.Duration
+
+	// Interval is how often the policies run. Defaults to
+	// retention.DefaultInterval.
+	Interval time.Duration
+}
+
+// retentionPolicies builds the policies enabled by cfg, warning about those
+// the configured stores cannot apply.
+func retentionPolicies(cfg RetentionConfig, logger *slog.Logger, conversations store.Conversations, confirmations store.Confirmations, audit engine.AuditLogger) []retention.Policy {
+	policies := []retention.Policy{retention.PendingActions(confirmations)}
+
+	if cfg.ConversationMaxAge > 0 {
+		if p, ok := conversations.(store.ConversationPurger); ok {
+			policies = append(policies, retention.Conversations(p, cfg.ConversationMaxAge))
+		} else {
+			logger.Warn("conversation store does not implement store.ConversationPurger; conversations will not expire")
+		}
+	}
+
+	if cfg.ActionHistoryMaxAge > 0 {
+		if p, ok := confirmations.(store.ActionPurger); ok {
+			policies = append(policies, retention.ActionHistory(p, cfg.ActionHistoryMaxAge))
+		} else {
+			logger.Warn("confirmation store does not implement store.ActionPurger; action history will not expire")
+		}
+	}
+
+	if cfg.AuditMaxAge > 0 {
+		if p, ok := audit.(engine.AuditPurger); ok {
+			policies = append(policies, retention.AuditLog(p, cfg.AuditMaxAge))
+		} else {
+			logger.Warn("audit logger does not implement engine.AuditPurger; audit entries will not expire")
+		}
+	}
+
+	return policies
+}
+
+// Retention returns the server's retention scheduler, or nil if retention
+// is disabled.
+func (s *Server) Retention() *retention.Scheduler {
+	return s.retention
+}
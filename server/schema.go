@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// schemaDialect is the JSON Schema version the schemas are written in.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// errorCodes lists the ErrCode constants, for the schema.
+var errorCodes = []string{
+	ErrCodeInvalidMessage, ErrCodeUnknownType, ErrCodeUnknownField, ErrCodeUnexpectedField,
+	ErrCodeMissingField, ErrCodeInvalidField, ErrCodeUnsupportedVersion, ErrCodeCapabilityRequired,
+	ErrCodeOutOfOrder, ErrCodeNoConversation, ErrCodeTurnInProgress, ErrCodeShuttingDown, ErrCodeNotFound,
+	ErrCodeAgentError, ErrCodeInternal,
+}
+
+// ClientMessageSchema returns the JSON Schema of client messages, with one
+// alternative per message type listing the fields it takes. It is what
+// strict validation enforces.
+func ClientMessageSchema() []byte {
+	g := newSchemaGenerator()
+	t := reflect.TypeOf(ClientMessage{})
+
+	var variants []any
+	for _, msgType := range sortedKeys(clientMessageSpecs) {
+		spec := clientMessageSpecs[msgType]
+
+		properties := map[string]any{"type": map[string]any{"const": msgType}}
+		for _, field := range slices.Concat(spec.required, spec.optional, spec.oneOf) {
+			properties[field] = g.clientField(t.Field(clientFields[field]), field, !slices.Contains(spec.optional, field))
+		}
+
+		variant := map[string]any{
+			"title":                msgType,
+			"type":                 "object",
+			"properties":           properties,
+			"required":             append([]string{"type"}, spec.required...),
+			"additionalProperties": false,
+		}
+		if len(spec.oneOf) > 0 {
+			var alternatives []any
+			for _, field := range spec.oneOf {
+				alternatives = append(alternatives, map[string]any{"required": []string{field}})
+			}
+			variant["oneOf"] = alternatives
+		}
+		variants = append(variants, variant)
+	}
+
+	return g.document("ClientMessage", "A message from the client to the server.", map[string]any{"oneOf": variants})
+}
+
+// ServerMessageSchema returns the JSON Schema of server messages.
+func ServerMessageSchema() []byte {
+	g := newSchemaGenerator()
+	schema := g.object(reflect.TypeOf(ServerMessage{}))
+
+	properties := schema["properties"].(map[string]any)
+	properties["type"] = map[string]any{"enum": ServerMessageTypes}
+	properties["code"] = map[string]any{"enum": errorCodes}
+	properties["capabilities"] = map[string]any{"type": "array", "items": map[string]any{"enum": Capabilities}}
+
+	return g.document("ServerMessage", "A message from the server to the client.", schema)
+}
+
+// schemaGenerator derives JSON Schema from Go types the way encoding/json
+// marshals them. Named struct types become $defs.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{defs: map[string]any{}}
+}
+
+func (g *schemaGenerator) document(title, description string, schema map[string]any) []byte {
+	schema["$schema"] = schemaDialect
+	schema["title"] = title
+	schema["description"] = description
+	schema["x-protocol-version"] = ProtocolVersion
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic(err) // the schema is built from plain maps and slices
+	}
+	return append(data, '\n')
+}
+
+// clientField describes a ClientMessage field, with the constraints
+// decodeClientMessage checks. Required fields, and those in a oneOf, must
+// not be empty.
+func (g *schemaGenerator) clientField(f reflect.StructField, name string, nonEmpty bool) map[string]any {
+	schema := g.schema(f.Type)
+	if values, ok := fieldEnums[name]; ok {
+		schema["enum"] = values
+	}
+	if minimum, ok := fieldMinimums[name]; ok {
+		schema["minimum"] = minimum
+	}
+	switch {
+	case name == "capabilities":
+		schema["items"] = map[string]any{"type": "string", "examples": Capabilities}
+	case f.Type.Kind() == reflect.Slice:
+		schema["minItems"] = 1
+		schema["uniqueItems"] = true
+		schema["items"] = map[string]any{"type": "string", "minLength": 1}
+	case nonEmpty && f.Type.Kind() == reflect.String:
+		schema["minLength"] = 1
+	case nonEmpty && f.Type.Kind() == reflect.Int:
+		schema["minimum"] = 1
+	}
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema describes a value of type t.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// json.RawMessage is any JSON; other byte slices are base64
+			if t.Name() == "RawMessage" {
+				return map[string]any{}
+			}
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := g.defName(t)
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = true // placeholder, for recursive types
+			g.defs[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	default:
+		// interface{}: any JSON value
+		return map[string]any{}
+	}
+}
+
+// defName names a struct type's $def, qualified by its package unless it
+// is this one.
+func (g *schemaGenerator) defName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(ServerMessage{}).PkgPath() {
+		return t.Name()
+	}
+	return t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:] + "." + t.Name()
+}
+
+// object describes a struct's fields. Fields without omitempty are
+// required, since they are always marshalled.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	g.fields(t, properties, &required)
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		// Embedded structs are flattened, as encoding/json does
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = g.schema(f.Type)
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -3,19 +3,30 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"github.com/becomeliminal/nim-go-sdk/core"
 	"github.com/becomeliminal/nim-go-sdk/engine"
 	"github.com/becomeliminal/nim-go-sdk/executor"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+	"github.com/becomeliminal/nim-go-sdk/metrics"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/retention"
+	"github.com/becomeliminal/nim-go-sdk/server/auth"
 	"github.com/becomeliminal/nim-go-sdk/store"
 )
 
@@ -39,15 +50,27 @@ type Config struct {
 	MaxTokens int64
 
 	// LiminalExecutor is the executor for Liminal API calls.
-	// If provided, the server will automatically extract JWT tokens from requests
-	// and forward them to the executor for authenticated API calls.
+	// The caller's bearer token is bound to each connection's context
+	// (see core.WithCredentials), so tool calls made on behalf of one user
+	// are always authenticated with that user's token.
 	LiminalExecutor *executor.HTTPExecutor
 
 	// AuthFunc validates requests and returns a user ID.
-	// If nil, a default handler is used that extracts JWT tokens for Liminal authentication.
-	// Most users should leave this nil.
+	// Takes precedence over JWTVerifier. Most users should leave this nil.
 	AuthFunc func(r *http.Request) (userID string, err error)
 
+	// JWTVerifier verifies the caller's bearer token and extracts the user ID
+	// from its claims. Used when AuthFunc is nil. One of the two is required
+	// unless AllowSharedUser is set.
+	JWTVerifier *auth.Verifier
+
+	// AllowSharedUser serves every caller as one user when neither AuthFunc
+	// nor JWTVerifier is set, so they all share conversations and pending
+	// actions. The ID is the placeholder "user" if LiminalExecutor is set,
+	// the gateway alone validating the token, and "default-user" otherwise.
+	// Only for local development and single-user deployments.
+	AllowSharedUser bool
+
 	// Conversations persists conversations.
 	// If nil, an in-memory store is used.
 	Conversations store.Conversations
@@ -64,6 +87,45 @@ type Config struct {
 	// If nil, no audit logging is performed.
 	AuditLogger engine.AuditLogger
 
+	// Metrics collects Prometheus metrics for the server and engine.
+	// If nil, a collector with a private registry is created.
+	// Metrics are served at /metrics by Start, or via MetricsHandler.
+	Metrics *metrics.Prometheus
+
+	// Logger is the structured logger for the server and engine.
+	// Tool inputs and results are only logged at debug level.
+	// If nil, logging.Default() is used, which redacts tokens and PII.
+	Logger *slog.Logger
+
+	// Clock is the time source for confirmation expiry, idempotency keys and
+	// timeouts. It is passed to the engine and the default in-memory stores.
+	// If nil, core.SystemClock is used.
+	Clock core.Clock
+
+	// Titles configures automatic conversation titles.
+	Titles TitleConfig
+
+	// Connection configures keepalives and resumption of dropped
+	// connections.
+	Connection ConnectionConfig
+
+	// Retention configures background cleanup of expired data. The
+	// scheduler starts with the server and stops on Close.
+	Retention RetentionConfig
+
+	// HTTP configures the HTTP server Start runs: its address, timeouts,
+	// graceful shutdown and TLS, plus the allowed WebSocket origins and
+	// per-IP connection limits.
+	HTTP HTTPConfig
+
+	// Health configures the readiness checks served at /readyz and the
+	// /debug/agent endpoint.
+	Health HealthConfig
+
+	// OpenAICompatible serves the OpenAI-compatible chat completions
+	// endpoint (see OpenAIHandler) from APIHandler, and so from Start.
+	OpenAICompatible bool
+
 	// AnthropicOptions are additional options for the Anthropic client.
 	// This can be used to customize the HTTP client for testing.
 	AnthropicOptions []option.RequestOption
@@ -83,7 +145,30 @@ type Server struct {
 
 	conversations store.Conversations
 	confirmations store.Confirmations
-	sessions      sync.Map // *websocket.Conn -> *session
+	metrics       *metrics.Prometheus
+	logger        *slog.Logger
+	clock         core.Clock
+	privacy       *privacy.Registry
+	retention     *retention.Scheduler
+	connConfig    ConnectionConfig
+	httpConfig    HTTPConfig
+	limiter       *connLimiter // nil without a per-IP limit
+	turns         turnTracker
+	healthConfig  HealthConfig
+
+	healthMu      sync.Mutex
+	healthChecks  []healthCheck
+	readyMu       sync.Mutex // serializes readiness runs
+	lastReadiness *readiness
+
+	connections sync.Map // resume token -> *connection
+	// conversationTurns holds the conversations with a turn in flight, on
+	// any connection or HTTP request, so each runs one turn at a time.
+	conversationTurns sync.Map // conversation ID -> struct{}
+	background  sync.WaitGroup
+
+	closeMu sync.Mutex // guards closed against background.Add
+	closed  bool
 }
 
 type session struct {
@@ -92,14 +177,34 @@ type session struct {
 	ConversationID string
 	History        []core.Message
 	TurnCount      int
+
+	// MessageIDs holds the stored ID of each message in History.
+	MessageIDs []string
+
+	// TitledAtTurn is the TurnCount when a title was last generated for
+	// this session, or -1 if none was. TitleLocked stops automatic titles,
+	// e.g. once the user has renamed the conversation.
+	TitledAtTurn int
+	TitleLocked  atomic.Bool
+
+	// titleMu is held while a title is saved, so a rename and a generated
+	// title never interleave.
+	titleMu sync.Mutex
 }
 
 // New creates a new server with the given configuration.
 // Returns an error if AnthropicKey is not provided.
+//
+// Unless Config.Retention.Disabled is set, New starts the retention
+// scheduler, which runs until Start or Serve shuts down or Close is called.
+// Servers used only through their handlers must be closed.
 func New(cfg Config) (*Server, error) {
 	if cfg.AnthropicKey == "" {
 		return nil, fmt.Errorf("AnthropicKey is required")
 	}
+	if cfg.AuthFunc == nil && cfg.JWTVerifier == nil && !cfg.AllowSharedUser {
+		return nil, fmt.Errorf("AuthFunc or JWTVerifier is required; set AllowSharedUser to serve every caller as one user")
+	}
 
 	// Build Anthropic client options
 	opts := make([]option.RequestOption, 0, len(cfg.AnthropicOptions)+2)
@@ -117,8 +222,35 @@ func New(cfg Config) (*Server, error) {
 	// Create registry
 	registry := engine.NewToolRegistry()
 
+	// Default to a private metrics registry if not provided
+	m := cfg.Metrics
+	if m == nil {
+		var err error
+		m, err = metrics.NewPrometheus(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metrics: %w", err)
+		}
+	}
+
+	logger := logging.OrDefault(cfg.Logger)
+
+	if cfg.AuthFunc == nil && cfg.JWTVerifier == nil {
+		logger.Warn("no AuthFunc or JWTVerifier configured; all connections share one user ID")
+	}
+
+	clock := core.ClockOrDefault(cfg.Clock)
+
 	// Build engine options
-	var engineOpts []engine.Option
+	engineOpts := []engine.Option{
+		engine.WithMetrics(m),
+		engine.WithLogger(logger),
+		engine.WithClock(clock),
+		engine.WithTitleConfig(engine.TitleConfig{
+			Model:     cfg.Titles.Model,
+			Prompt:    cfg.Titles.Prompt,
+			MaxTokens: cfg.Titles.MaxTokens,
+		}),
+	}
 	if cfg.Guardrails != nil {
 		engineOpts = append(engineOpts, engine.WithGuardrails(cfg.Guardrails))
 	}
@@ -132,26 +264,80 @@ func New(cfg Config) (*Server, error) {
 	// Default to in-memory stores if not provided
 	conversations := cfg.Conversations
 	if conversations == nil {
-		conversations = store.NewMemoryConversations()
+		conversations = store.NewMemoryConversations(store.WithClock(clock))
 	}
 
 	confirmations := cfg.Confirmations
 	if confirmations == nil {
-		confirmations = store.NewMemoryConfirmations()
+		confirmations = store.NewMemoryConfirmations(store.WithClock(clock))
 	}
 
-	return &Server{
+	// Cover every store that holds user data in exports and erasure
+	reg := privacy.NewRegistry(privacy.WithClock(clock))
+	registerUserData(reg, logger, "conversations", conversations)
+	registerUserData(reg, logger, "confirmations", confirmations)
+	if cfg.AuditLogger != nil {
+		registerUserData(reg, logger, "audit", cfg.AuditLogger)
+	}
+
+	var sched *retention.Scheduler
+	if !cfg.Retention.Disabled {
+		sched = retention.NewScheduler(
+			retentionPolicies(cfg.Retention, logger, conversations, confirmations, cfg.AuditLogger),
+			retention.WithInterval(cfg.Retention.Interval),
+			retention.WithClock(clock),
+			retention.WithLogger(logger),
+			retention.WithMetrics(m),
+		)
+		sched.Start()
+	}
+
+	s := &Server{
 		config:        cfg,
 		engine:        eng,
 		registry:      registry,
 		conversations: conversations,
 		confirmations: confirmations,
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in development
-			},
-		},
-	}, nil
+		metrics:       m,
+		logger:        logger,
+		clock:         clock,
+		privacy:       reg,
+		retention:     sched,
+		connConfig:    cfg.Connection.withDefaults(),
+		httpConfig:    cfg.HTTP.withDefaults(),
+		healthConfig:  cfg.Health.withDefaults(),
+	}
+	s.upgrader = websocket.Upgrader{
+		Subprotocols:      Subprotocols,
+		EnableCompression: !cfg.Connection.DisableCompression,
+		CheckOrigin:       s.checkOrigin,
+	}
+	if cfg.HTTP.MaxConnectionsPerIP > 0 {
+		s.limiter = newConnLimiter(cfg.HTTP.MaxConnectionsPerIP)
+	}
+	s.addBuiltinHealthChecks(client)
+	return s, nil
+}
+
+// registerUserData adds a store to the privacy registry if it can export
+// and erase user data, and warns otherwise.
+func registerUserData(reg *privacy.Registry, logger *slog.Logger, name string, v any) {
+	h, ok := v.(privacy.UserDataHandler)
+	if !ok {
+		logger.Warn("store does not implement privacy.UserDataHandler; user data exports and erasure will not cover it",
+			slog.String("store", name))
+		return
+	}
+	if err := reg.Register(name, h); err != nil {
+		logger.Error("failed to register user data handler", slog.String("store", name), logging.Error(err))
+	}
+}
+
+// Privacy returns the registry that exports and erases user data across the
+// server's stores. Register application stores that hold user data, such as
+// preferences, on it too.
+func (s *Server) Privacy() *privacy.Registry {
+	return s.privacy
 }
 
 // AddTool registers a custom tool with the server.
@@ -174,127 +360,405 @@ func (s *Server) Handler() http.Handler {
 	return http.HandlerFunc(s.handleWebSocket)
 }
 
-// Run starts the server on the given address.
-func (s *Server) Run(addr string) error {
-	http.Handle("/ws", s.Handler())
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
+// MetricsHandler returns an HTTP handler that serves Prometheus metrics.
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.Handler()
+}
+
+// Close stops the retention scheduler, discards dropped connections
+// waiting to be resumed, stopping their turns, and waits for background
+// work, such as title generation, to finish. It does not close open
+// connections; see Serve for shutting those down gracefully.
+func (s *Server) Close() error {
+	s.closeMu.Lock()
+	s.closed = true
+	s.closeMu.Unlock()
+
+	if s.retention != nil {
+		s.retention.Stop()
+	}
+	s.connections.Range(func(_, v any) bool {
+		s.discardConnection(v.(*connection), true)
+		return true
 	})
+	s.background.Wait()
+	return nil
+}
 
-	log.Printf("Starting Nim agent server on %s", addr)
-	return http.ListenAndServe(addr, nil)
+// goBackground runs fn in a goroutine that Close waits for, unless the
+// server is closing, in which case fn is skipped and goBackground returns
+// false.
+func (s *Server) goBackground(fn func()) bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+	return true
 }
 
 // defaultLiminalAuthFunc returns a default authentication function for Liminal.
-// It extracts JWT tokens from requests and forwards them to the HTTPExecutor.
+// The JWT itself is bound to the connection by handleWebSocket; the gateway
+// extracts the real user from it.
 func (s *Server) defaultLiminalAuthFunc() func(r *http.Request) (string, error) {
 	return func(r *http.Request) (string, error) {
-		// Extract JWT from query param (WebSocket) or Authorization header
-		jwt := r.URL.Query().Get("token")
-		if jwt == "" {
-			auth := r.Header.Get("Authorization")
-			if len(auth) > 7 && auth[:7] == "Bearer " {
-				jwt = auth[7:]
-			}
-		}
-
-		// Forward JWT to HTTPExecutor for API calls
-		if jwt != "" && s.config.LiminalExecutor != nil {
-			s.config.LiminalExecutor.UpdateJWT(jwt)
-		}
-
 		// Return placeholder user ID (gateway extracts real user from JWT)
 		return "user", nil
 	}
 }
 
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Authenticate
-	userID := "default-user"
+// authenticate returns the caller's user ID. WebSocket and HTTP requests
+// are authenticated the same way.
+func (s *Server) authenticate(r *http.Request) (string, error) {
 	authFunc := s.config.AuthFunc
 
-	// Use default Liminal JWT handler if no custom auth provided
-	if authFunc == nil && s.config.LiminalExecutor != nil {
-		authFunc = s.defaultLiminalAuthFunc()
+	// Prefer verified JWT claims, then the shared user if allowed
+	if authFunc == nil && s.config.JWTVerifier != nil {
+		authFunc = s.config.JWTVerifier.AuthFunc()
 	}
-
-	if authFunc != nil {
-		var err error
-		userID, err = authFunc(r)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+	if authFunc == nil {
+		if !s.config.AllowSharedUser {
+			return "", errors.New("no authentication configured")
+		}
+		if s.config.LiminalExecutor != nil {
+			authFunc = s.defaultLiminalAuthFunc()
+		} else {
+			return "default-user", nil
 		}
 	}
+	userID, err := authFunc(r)
+	if err != nil {
+		s.logger.Debug("authentication failed", logging.Error(err))
+	}
+	return userID, err
+}
+
+// requestContext binds the caller's credentials to the request's context,
+// so every tool call made on their behalf is authenticated as them.
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if token := auth.TokenFromRequest(r); token != "" {
+		ctx = core.WithCredentials(ctx, &core.Credentials{Token: token})
+	}
+	return ctx
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	release, ok := s.acquireConnection(r)
+	if !ok {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
 
 	// Upgrade connection
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		s.logger.Warn("websocket upgrade failed", logging.User(userID), logging.Error(err))
 		return
 	}
 	defer conn.Close()
 
-	log.Printf("WebSocket connected for user %s", userID)
+	s.metrics.ConnectionOpened()
+	defer s.metrics.ConnectionClosed()
 
-	var currentSession *session
+	ctx := requestContext(r)
 
-	for {
+	logger := s.logger.With(logging.User(userID))
+	logger.Info("websocket connected")
+
+	cfg := s.connConfig
+	if err := conn.SetCompressionLevel(cfg.CompressionLevel); err != nil {
+		logger.Warn("invalid compression level", logging.Error(err))
+	}
+	sock := newSocket(conn, cfg.ReplayBufferSize+sendQueueSize, cfg.CompressionThreshold)
+	go sock.writeLoop(s, cfg.PingInterval, logger)
+
+	// Every socket starts a new connection. If the client resumes an
+	// earlier one instead, this one is discarded.
+	c := newConnection(uuid.New().String(), userID, cfg)
+	s.connections.Store(c.id, c)
+	c.attach(sock, 0, &ServerMessage{Type: "connected", ResumeToken: c.id, Version: ProtocolVersion, Capabilities: Capabilities})
+
+	clientClosed := false
+	defer func() {
+		sock.close()
+		<-sock.done
+		if clientClosed {
+			s.discardConnection(c, false)
+			return
+		}
+		// Keep the connection, and its turn, for the client to resume
+		c.detach(sock, func() { s.discardConnection(c, true) })
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	})
+
+	// The handshake is an optional hello, then an optional resume
+	handshake := true
+	for first := true; ; first = false {
 		_, msgBytes, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			clientClosed = websocket.IsCloseError(err, websocket.CloseNormalClosure)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				logger.Debug("websocket read failed", logging.Error(err))
 			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
 
-		var msg ClientMessage
-		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			s.sendError(conn, "Invalid message format")
+		if msgBytes, err = sock.codec.JSON(msgBytes); err != nil {
+			s.sendProtocolError(c, &protocolError{code: ErrCodeInvalidMessage, message: "Message is not valid CBOR"})
+			continue
+		}
+		msg, perr := decodeClientMessage(msgBytes, c.currentProtocol().strict)
+		if perr != nil {
+			s.sendProtocolError(c, perr)
 			continue
 		}
 
-		log.Printf("Received message type=%s from user=%s", msg.Type, userID)
+		logger.Debug("received client message", slog.String("type", msg.Type))
+		s.metrics.MessageReceived(messageTypeLabel(msg.Type))
+
+		inHandshake := handshake
+		if msg.Type != "hello" {
+			handshake = false
+		}
 
+		// Turns run in the background and own the session until they
+		// finish, so messages that use it are rejected in the meantime.
+		// Conversation management does not touch the session and is
+		// handled right away.
+		sess := c.currentSession()
 		switch msg.Type {
+		case "hello":
+			if !first {
+				s.sendError(c, ErrCodeOutOfOrder, "hello must be the first message on a connection")
+				continue
+			}
+			// Like the greeting, the answer is not numbered
+			sock.enqueue(s.handleHello(c, msg))
+
+		case "resume":
+			if !inHandshake {
+				s.sendError(c, ErrCodeOutOfOrder, "resume must follow the handshake, before any other message")
+				continue
+			}
+			if resumed := s.resumeConnection(c, sock, userID, msg); resumed != nil {
+				c = resumed
+			}
+
 		case "new_conversation":
-			currentSession = s.handleNewConversation(r.Context(), conn, userID)
+			if c.busy() {
+				s.sendError(c, ErrCodeTurnInProgress, errTurnInProgress)
+				continue
+			}
+			c.setSession(s.handleNewConversation(ctx, c, userID))
 
 		case "resume_conversation":
-			currentSession = s.handleResumeConversation(r.Context(), conn, userID, msg.ConversationID)
+			if c.busy() {
+				s.sendError(c, ErrCodeTurnInProgress, errTurnInProgress)
+				continue
+			}
+			c.setSession(s.handleResumeConversation(ctx, c, userID, msg.ConversationID))
+
+		case "stop":
+			if c.stopTurn() == nil {
+				logger.Debug("nothing to stop")
+			}
 
 		case "message":
-			if currentSession == nil {
-				s.sendError(conn, "No active conversation. Send 'new_conversation' first.")
+			if sess == nil {
+				s.sendError(c, ErrCodeNoConversation, "No active conversation. Send 'new_conversation' first.")
 				continue
 			}
-			s.handleMessage(r.Context(), conn, currentSession, msg.Content)
+			s.startTurn(ctx, c, sess, func(ctx context.Context) {
+				s.handleMessage(ctx, c, sess, msg.Content)
+			})
 
 		case "confirm":
-			if currentSession == nil {
-				s.sendError(conn, "No active conversation")
+			if sess == nil {
+				s.sendError(c, ErrCodeNoConversation, "No active conversation")
+				continue
+			}
+			if len(msg.ActionIDs) > 0 {
+				if !s.requireCapability(c, CapabilityBatchConfirmations) {
+					continue
+				}
+				s.startTurn(ctx, c, sess, func(ctx context.Context) {
+					s.handleConfirmations(ctx, c, sess, userID, msg.ActionIDs, true)
+				})
 				continue
 			}
-			s.handleConfirm(r.Context(), conn, currentSession, userID, msg.ActionID)
+			s.startTurn(ctx, c, sess, func(ctx context.Context) {
+				s.handleConfirm(ctx, c, sess, userID, msg.ActionID)
+			})
 
 		case "cancel":
-			if currentSession == nil {
-				s.sendError(conn, "No active conversation")
+			if sess == nil {
+				s.sendError(c, ErrCodeNoConversation, "No active conversation")
+				continue
+			}
+			if len(msg.ActionIDs) > 0 {
+				if !s.requireCapability(c, CapabilityBatchConfirmations) {
+					continue
+				}
+				s.startTurn(ctx, c, sess, func(ctx context.Context) {
+					s.handleConfirmations(ctx, c, sess, userID, msg.ActionIDs, false)
+				})
 				continue
 			}
-			s.handleCancel(r.Context(), conn, currentSession, userID, msg.ActionID)
+			s.startTurn(ctx, c, sess, func(ctx context.Context) {
+				s.handleCancel(ctx, c, sess, userID, msg.ActionID)
+			})
+
+		case "edit_message":
+			if sess == nil {
+				s.sendError(c, ErrCodeNoConversation, "No active conversation")
+				continue
+			}
+			s.startTurn(ctx, c, sess, func(ctx context.Context) {
+				s.handleEditMessage(ctx, c, sess, msg.MessageID, msg.Content)
+			})
+
+		case "regenerate":
+			if sess == nil {
+				s.sendError(c, ErrCodeNoConversation, "No active conversation")
+				continue
+			}
+			s.startTurn(ctx, c, sess, func(ctx context.Context) {
+				s.handleRegenerate(ctx, c, sess, msg.MessageID)
+			})
+
+		case "list_conversations":
+			s.handleListConversations(ctx, c, userID, msg)
+
+		case "search_conversations":
+			s.handleSearchConversations(ctx, c, userID, msg)
+
+		case "rename_conversation":
+			if sess == nil || sess.ConversationID != msg.ConversationID {
+				s.handleRenameConversation(ctx, c, userID, msg.ConversationID, msg.Title)
+				break
+			}
+			// A title generated meanwhile must not overwrite the user's
+			sess.titleMu.Lock()
+			if s.handleRenameConversation(ctx, c, userID, msg.ConversationID, msg.Title) {
+				sess.TitleLocked.Store(true)
+			}
+			sess.titleMu.Unlock()
+
+		case "archive_conversation", "unarchive_conversation":
+			s.handleArchiveConversation(ctx, c, userID, msg.ConversationID, msg.Type == "archive_conversation")
+
+		case "pin_conversation", "unpin_conversation":
+			s.handlePinConversation(ctx, c, userID, msg.ConversationID, msg.Type == "pin_conversation")
+
+		case "delete_conversation":
+			if sess != nil && sess.ConversationID == msg.ConversationID {
+				// Finish the turn first, so it does not write to the
+				// conversation after it is gone
+				if done := c.stopTurn(); done != nil {
+					<-done
+				}
+			}
+			deleted := s.handleDeleteConversation(ctx, c, userID, msg.ConversationID)
+			if deleted && sess != nil && sess.ConversationID == msg.ConversationID {
+				c.setSession(nil)
+			}
 
-		default:
-			s.sendError(conn, fmt.Sprintf("Unknown message type: %s", msg.Type))
 		}
 	}
 }
 
-func (s *Server) handleNewConversation(ctx context.Context, conn *websocket.Conn, userID string) *session {
+// handleHello negotiates the protocol with the client, returning the
+// answer: the version and capabilities both support, or an error if the
+// client is too old.
+func (s *Server) handleHello(c *connection, msg ClientMessage) *ServerMessage {
+	if msg.Version < MinProtocolVersion {
+		return &ServerMessage{
+			Type:    "error",
+			Code:    ErrCodeUnsupportedVersion,
+			Field:   "version",
+			Content: fmt.Sprintf("Protocol version %d is not supported; the oldest supported is %d", msg.Version, MinProtocolVersion),
+		}
+	}
+
+	p := protocol{version: min(msg.Version, ProtocolVersion), capabilities: map[string]bool{}, strict: true}
+	agreed := []string{}
+	for _, capability := range Capabilities {
+		if slices.Contains(msg.Capabilities, capability) {
+			p.capabilities[capability] = true
+			agreed = append(agreed, capability)
+		}
+	}
+	c.setProtocol(p)
+
+	s.logger.Debug("protocol negotiated", logging.User(c.userID), slog.Int("version", p.version), slog.Any("capabilities", agreed))
+	return &ServerMessage{Type: "hello", Version: p.version, Capabilities: agreed}
+}
+
+// requireCapability reports whether the client negotiated capability, and
+// tells it if not.
+func (s *Server) requireCapability(c *connection, capability string) bool {
+	if c.currentProtocol().capabilities[capability] {
+		return true
+	}
+	s.sendError(c, ErrCodeCapabilityRequired, fmt.Sprintf("This needs the %s capability", capability))
+	return false
+}
+
+// resumeConnection moves sock from the fresh connection c to the one the
+// client is resuming, replaying the messages it missed, and returns it.
+// If that connection is gone, belongs to someone else or can no longer
+// replay everything after msg.LastSeq, it sends "resume_failed" and
+// returns nil; the client carries on with c.
+func (s *Server) resumeConnection(c *connection, sock *socket, userID string, msg ClientMessage) *connection {
+	v, ok := s.connections.Load(msg.ResumeToken)
+	prev, _ := v.(*connection)
+	resumed := &ServerMessage{Type: "resumed", ResumeToken: msg.ResumeToken}
+	if !ok || prev == c || prev.userID != userID || !prev.attach(sock, msg.LastSeq, resumed) {
+		sock.enqueue(&ServerMessage{Type: "resume_failed", Content: "Connection can no longer be resumed"})
+		return nil
+	}
+
+	// The protocol is negotiated per socket
+	prev.setProtocol(c.currentProtocol())
+
+	s.discardConnection(c, false)
+	s.logger.Debug("connection resumed", logging.User(userID), slog.Int64("last_seq", msg.LastSeq))
+	return prev
+}
+
+// discardConnection stops the connection's turn and forgets it. If
+// detached is set, it does nothing when a socket has attached since.
+func (s *Server) discardConnection(c *connection, detached bool) {
+	if !c.discard(detached) {
+		return
+	}
+	if done := c.stopTurn(); done != nil {
+		<-done
+	}
+	s.connections.Delete(c.id)
+}
+
+func (s *Server) handleNewConversation(ctx context.Context, c client, userID string) *session {
 	conv, err := s.conversations.Create(ctx, userID)
 	if err != nil {
-		s.sendError(conn, fmt.Sprintf("Failed to create conversation: %v", err))
+		s.sendError(c, ErrCodeInternal, fmt.Sprintf("Failed to create conversation: %v", err))
 		return nil
 	}
 
@@ -303,68 +767,90 @@ func (s *Server) handleNewConversation(ctx context.Context, conn *websocket.Conn
 		UserID:         userID,
 		ConversationID: conv.ID,
 		History:        []core.Message{},
+		TitledAtTurn:   -1,
 	}
-	s.sessions.Store(conn, sess)
 
-	s.send(conn, ServerMessage{
+	s.send(c, ServerMessage{
 		Type:           "conversation_started",
 		ConversationID: conv.ID,
 	})
 
-	log.Printf("Started conversation %s for user %s", conv.ID, userID)
+	s.sessionLogger(sess).Info("started conversation")
 	return sess
 }
 
-func (s *Server) handleResumeConversation(ctx context.Context, conn *websocket.Conn, userID, conversationID string) *session {
-	conv, err := s.conversations.Get(ctx, conversationID)
+func (s *Server) handleResumeConversation(ctx context.Context, c client, userID, conversationID string) *session {
+	conv, err := s.conversations.Get(ctx, userID, conversationID)
 	if err != nil {
-		s.sendError(conn, "Conversation not found")
+		// Same response whether the conversation is missing or owned by
+		// someone else, so IDs cannot be probed.
+		if !errors.Is(err, store.ErrConversationNotFound) {
+			s.logger.Error("failed to load conversation", logging.User(userID), logging.Conversation(conversationID), logging.Error(err))
+		}
+		s.sendError(c, ErrCodeNotFound, "Conversation not found")
 		return nil
 	}
 
-	// Convert stored messages to core.Message
+	sess := sessionFor(conv)
+	s.send(c, ServerMessage{
+		Type:           "conversation_resumed",
+		ConversationID: conversationID,
+		Messages:       conv.Messages,
+	})
+
+	s.sessionLogger(sess).Info("resumed conversation")
+	return sess
+}
+
+// sessionFor builds a session that continues a stored conversation.
+func sessionFor(conv *store.ConversationWithMessages) *session {
+	// Convert stored messages to core.Message, keeping tool_use and
+	// tool_result blocks so the model retains its tool context
 	history := make([]core.Message, 0, len(conv.Messages))
-	for _, m := range conv.Messages {
-		history = append(history, core.Message{
-			Role:    core.Role(m.Role),
-			Content: m.Content,
-		})
+	ids := make([]string, 0, len(conv.Messages))
+	for i := range conv.Messages {
+		history = append(history, conv.Messages[i].ToMessage())
+		ids = append(ids, conv.Messages[i].ID)
 	}
 
 	sess := &session{
-		ID:             conversationID,
-		UserID:         userID,
-		ConversationID: conversationID,
+		ID:             conv.ID,
+		UserID:         conv.UserID,
+		ConversationID: conv.ID,
 		History:        history,
+		MessageIDs:     ids,
+		TitledAtTurn:   -1,
 	}
-	s.sessions.Store(conn, sess)
-
-	s.send(conn, ServerMessage{
-		Type:           "conversation_resumed",
-		ConversationID: conversationID,
-		Messages:       conv.Messages,
-	})
-
-	log.Printf("Resumed conversation %s for user %s", conversationID, userID)
+	// Titles given before this session, automatic or not, are kept
+	sess.TitleLocked.Store(conv.Title != store.DefaultTitle)
 	return sess
 }
 
-func (s *Server) handleMessage(ctx context.Context, conn *websocket.Conn, sess *session, content string) {
+func (s *Server) handleMessage(ctx context.Context, c client, sess *session, content string) {
 	if content == "" {
 		return
 	}
 
-	log.Printf("[CONVERSATION %s] USER: %s", sess.ConversationID, truncate(content, 50))
+	s.sessionLogger(sess).Debug("user message", slog.String("content", truncate(content, 50)))
 
-	// Add to history
-	sess.History = append(sess.History, core.NewUserMessage(content))
+	// Add to history and tell the client its ID, so it can be edited later
+	parentID := sess.leafID()
+	messageID := s.appendMessage(ctx, sess, core.NewUserMessage(content), nil)
 	sess.TurnCount++
 
-	// Persist user message
-	s.persistMessage(ctx, sess.ConversationID, "user", content)
+	s.send(c, ServerMessage{Type: "message_saved", MessageID: messageID, ParentID: parentID})
+
+	s.runTurn(ctx, c, sess, content)
+}
+
+// runTurn runs the agent on content, which must be the last message in the
+// session's history.
+func (s *Server) runTurn(ctx context.Context, c client, sess *session, content string) {
+	requestID := uuid.New().String()
+	logger := s.sessionLogger(sess).With(logging.Request(requestID))
 
 	// Build input
-	agentCtx := core.NewContext(sess.UserID, sess.ID, sess.ConversationID, sess.ID)
+	agentCtx := core.NewContextWithClock(s.clock, sess.UserID, sess.ID, sess.ConversationID, requestID)
 
 	input := &engine.Input{
 		UserMessage:  content,
@@ -376,109 +862,231 @@ func (s *Server) handleMessage(ctx context.Context, conn *websocket.Conn, sess *
 	}
 
 	// Only enable streaming if not disabled (streaming requires SSE-compatible server)
+	// Streamed text is kept so a stopped turn can record what the user saw
+	var streamed strings.Builder
 	if !s.config.DisableStreaming {
 		input.StreamCallback = func(chunk string, done bool) {
 			if !done && chunk != "" {
-				s.send(conn, ServerMessage{Type: "text_chunk", Content: chunk})
+				streamed.WriteString(chunk)
+				s.send(c, ServerMessage{Type: "text_chunk", Content: chunk})
 			}
 		}
 	}
 
 	// Run agent
 	output, err := s.engine.Run(ctx, input)
+	if ctx.Err() != nil && (err != nil || output == nil || output.Type == engine.OutputError) {
+		s.recordStopped(ctx, c, sess, logger, output, streamed.String())
+		return
+	}
 	if err != nil {
-		log.Printf("Agent error: %v", err)
-		s.sendError(conn, fmt.Sprintf("Agent error: %v", err))
+		logger.Error("agent run failed", logging.Error(err))
+		s.sendError(c, ErrCodeAgentError, fmt.Sprintf("Agent error: %v", err))
 		return
 	}
 
-	s.handleOutput(ctx, conn, sess, output)
+	s.handleOutput(ctx, c, sess, logger, output)
 }
 
-func (s *Server) handleOutput(ctx context.Context, conn *websocket.Conn, sess *session, output *engine.Output) {
+func (s *Server) handleOutput(ctx context.Context, c client, sess *session, logger *slog.Logger, output *engine.Output) {
 	switch output.Type {
 	case engine.OutputComplete:
-		log.Printf("[CONVERSATION %s] ASSISTANT: %s", sess.ConversationID, truncate(output.Text, 200))
+		logger.Debug("assistant message", slog.String("content", truncate(output.Text, 200)))
 
-		sess.History = append(sess.History, core.NewAssistantMessage(output.Text))
+		s.appendMessages(ctx, sess, output.Messages, output.ToolsUsed)
 
-		s.persistMessage(ctx, sess.ConversationID, "assistant", output.Text)
-
-		s.send(conn, ServerMessage{Type: "text", Content: output.Text})
-		s.send(conn, ServerMessage{
+		s.send(c, ServerMessage{Type: "text", Content: output.Text})
+		s.send(c, ServerMessage{
 			Type: "complete",
 			TokenUsage: &TokenUsage{
-				InputTokens:  output.TokensUsed.InputTokens,
-				OutputTokens: output.TokensUsed.OutputTokens,
-				TotalTokens:  output.TokensUsed.TotalTokens(),
+				InputTokens:              output.TokensUsed.InputTokens,
+				OutputTokens:             output.TokensUsed.OutputTokens,
+				CacheCreationInputTokens: output.TokensUsed.CacheCreationInputTokens,
+				CacheReadInputTokens:     output.TokensUsed.CacheReadInputTokens,
+				TotalTokens:              output.TokensUsed.TotalTokens(),
 			},
+			Tools: output.ToolsUsed,
 		})
+		s.maybeGenerateTitle(ctx, c, sess)
 
 	case engine.OutputConfirmationNeeded:
 		pending := output.PendingAction
 
 		// Store confirmation
 		if err := s.confirmations.Store(ctx, pending); err != nil {
-			log.Printf("Failed to store confirmation: %v", err)
+			logger.Error("failed to store confirmation", logging.Action(pending.ID), logging.Error(err))
 		}
+		s.metrics.Confirmation(metrics.ConfirmationRequested)
 
-		sess.History = append(sess.History, core.NewAssistantMessageWithBlocks(output.ResponseBlocks))
+		s.appendMessages(ctx, sess, output.Messages, output.ToolsUsed)
 
-		s.send(conn, ServerMessage{
+		s.send(c, ServerMessage{
 			Type:      "confirm_request",
 			ActionID:  pending.ID,
 			Tool:      pending.Tool,
 			Summary:   pending.Summary,
 			Content:   output.Text,
 			ExpiresAt: time.Unix(pending.ExpiresAt, 0).Format(time.RFC3339),
+			Tools:     output.ToolsUsed,
 		})
 
 	case engine.OutputError:
-		log.Printf("Agent error: %v", output.Error)
-		s.sendError(conn, output.Error.Error())
+		logger.Warn("agent run returned error", logging.Error(output.Error))
+		s.sendError(c, ErrCodeAgentError, output.Error.Error())
+	}
+}
+
+// recordStopped records a turn the user stopped: the rounds the engine
+// completed, then an assistant message with whatever text was streamed
+// before the stop, so the history stays valid and the model knows its
+// reply was cut short.
+func (s *Server) recordStopped(ctx context.Context, c client, sess *session, logger *slog.Logger, output *engine.Output, streamed string) {
+	partial := streamed
+	if output != nil {
+		s.appendMessages(ctx, sess, output.Messages, output.ToolsUsed)
+		for _, msg := range output.Messages {
+			if msg.Role == core.RoleAssistant {
+				partial = strings.TrimPrefix(partial, msg.GetText())
+			}
+		}
+	}
+	text := strings.TrimSpace(partial)
+	if text != "" {
+		text += "\n\n"
 	}
+	s.appendMessage(ctx, sess, core.NewAssistantMessage(text+stoppedMarker), nil)
+
+	logger.Info("turn stopped")
+	s.send(c, ServerMessage{Type: "stopped"})
 }
 
 // THIS IS IMPORTANT!!!!!!
-func (s *Server) handleConfirm(ctx context.Context, conn *websocket.Conn, sess *session, userID, actionID string) {
-	log.Printf("Processing confirmation for action=%s, user=%s", actionID, userID)
+func (s *Server) handleConfirm(ctx context.Context, c client, sess *session, userID, actionID string) {
+	// The user has approved the action and money may already be moving,
+	// so stop does not interrupt it
+	ctx = context.WithoutCancel(ctx)
+
+	text, execution := s.confirmAction(ctx, sess, userID, actionID)
+
+	complete := ServerMessage{Type: "complete"}
+	if execution != nil {
+		complete.Tools = []core.ToolExecution{*execution}
+	}
+	s.send(c, ServerMessage{Type: "text", Content: text})
+	s.send(c, complete)
+	if execution != nil && execution.Error == "" {
+		s.maybeGenerateTitle(ctx, c, sess)
+	}
+}
+
+// handleConfirmations confirms or cancels several actions in one turn, in
+// the order given, and answers with a single reply covering them all.
+func (s *Server) handleConfirmations(ctx context.Context, c client, sess *session, userID string, actionIDs []string, confirm bool) {
+	ctx = context.WithoutCancel(ctx)
+
+	var texts []string
+	var executions []core.ToolExecution
+	succeeded := false
+	for _, actionID := range actionIDs {
+		if !confirm {
+			text, err := s.cancelAction(ctx, sess, userID, actionID)
+			if err != nil {
+				text = fmt.Sprintf("%s: %s", err.message, actionID)
+			}
+			texts = append(texts, text)
+			continue
+		}
+
+		text, execution := s.confirmAction(ctx, sess, userID, actionID)
+		texts = append(texts, text)
+		if execution != nil {
+			executions = append(executions, *execution)
+			succeeded = succeeded || execution.Error == ""
+		}
+	}
+
+	s.send(c, ServerMessage{Type: "text", Content: strings.Join(texts, "\n")})
+	s.send(c, ServerMessage{Type: "complete", Tools: executions})
+	if succeeded {
+		s.maybeGenerateTitle(ctx, c, sess)
+	}
+}
+
+// confirmAction executes a pending action and records its result,
+// returning the reply to show the user and the execution, or nil if the
+// action did not run.
+func (s *Server) confirmAction(ctx context.Context, sess *session, userID, actionID string) (string, *core.ToolExecution) {
+	logger := s.sessionLogger(sess).With(logging.Action(actionID))
+	logger.Info("processing confirmation")
+
+	if s.offBranch(ctx, sess, userID, actionID) {
+		return offBranchReply, nil
+	}
 
 	// Get and remove confirmation
 	action, err := s.confirmations.Confirm(ctx, userID, actionID)
 	if err != nil {
-		log.Printf("[DEBUG] Confirmation not found or expired: action=%s, error=%v", actionID, err)
-		s.send(conn, ServerMessage{
-			Type:    "text",
-			Content: "That action expired. Would you like me to set it up again?",
-		})
-		s.send(conn, ServerMessage{Type: "complete"})
-		return
+		logger.Info("confirmation not found or expired", logging.Error(err))
+		if errors.Is(err, store.ErrActionExpired) {
+			s.metrics.Confirmation(metrics.ConfirmationExpired)
+		}
+		return "That action expired. Would you like me to set it up again?", nil
+	}
+
+	s.metrics.Confirmation(metrics.ConfirmationConfirmed)
+
+	logger = logger.With(logging.Tool(action.Tool))
+	execution, resultContent, isError := s.executeAction(ctx, logger, userID, action)
+
+	// Add tool result to history
+	resultMessage := core.NewToolResultMessage([]core.ToolResultContent{
+		{ToolUseID: action.BlockID, Content: resultContent, IsError: isError},
+	})
+	s.appendMessage(ctx, sess, resultMessage, []core.ToolExecution{execution})
+
+	if isError {
+		return fmt.Sprintf("Sorry, that action failed: %s", resultContent), &execution
 	}
 
-	// Debug: Log tool execution details
-	log.Printf("[DEBUG] Confirmed action details: tool=%s, action_id=%s", action.Tool, action.ID)
-	log.Printf("[DEBUG] Executing confirmed tool with input: %s", string(action.Input))
+	// Format success message
+	resultMsg := formatToolResult(action.Tool, execution.Result)
+	s.appendMessage(ctx, sess, core.NewAssistantMessage(resultMsg), nil)
+	return resultMsg, &execution
+}
+
+// executeAction executes a confirmed action, returning its execution
+// record and the content of its tool_result.
+func (s *Server) executeAction(ctx context.Context, logger *slog.Logger, userID string, action *core.PendingAction) (execution core.ToolExecution, resultContent string, isError bool) {
+	logger.Debug("executing confirmed tool", slog.String("input", string(action.Input)))
 
 	// Execute the confirmed tool
 	// Pass empty confirmationID so ExecutorTool calls ExecuteWrite() directly
 	// instead of executor.Confirm(). The confirmation was already retrieved from
-	// local storage above, so we just need to execute the tool with the original params.
+	// local storage by the caller, so we just need to execute the tool with the original params.
+	startTime := time.Now()
 	result, err := s.engine.ExecuteTool(ctx, userID, action.Tool, action.Input, "")
 
-	var resultContent string
-	var isError bool
+	execution = core.ToolExecution{
+		ToolUseID:  action.BlockID,
+		Tool:       action.Tool,
+		Input:      action.Input,
+		DurationMs: time.Since(startTime).Milliseconds(),
+	}
+
 	if err != nil {
-		log.Printf("[DEBUG] Tool execution error: %v", err)
+		logger.Error("confirmed tool execution error", logging.Error(err))
 		resultContent = fmt.Sprintf("Error: %v", err)
 		isError = true
+		execution.Error = err.Error()
 	} else if !result.Success {
-		log.Printf("[DEBUG] Tool execution failed: %s", result.Error)
+		logger.Warn("confirmed tool execution failed", slog.String("result_error", result.Error))
 		resultContent = result.Error
 		isError = true
+		execution.Error = result.Error
 	} else {
-		// Debug: Log successful execution with details
+		execution.Result = result.Data
 		resultBytes, _ := json.Marshal(result.Data)
-		log.Printf("[DEBUG] Tool execution succeeded: %s", string(resultBytes))
+		logger.Debug("confirmed tool execution succeeded", slog.String("result", string(resultBytes)))
 
 		// Extract transaction ID for payment operations
 		if action.Tool == "send_money" || action.Tool == "deposit_savings" || action.Tool == "withdraw_savings" {
@@ -487,92 +1095,221 @@ func (s *Server) handleConfirm(ctx context.Context, conn *websocket.Conn, sess *
 			dataBytes, _ := json.Marshal(result.Data)
 			if err := json.Unmarshal(dataBytes, &txData); err == nil {
 				if txID, ok := txData["transactionId"].(string); ok && txID != "" {
-					log.Printf("[DEBUG] Transaction ID: %s", txID)
+					logger = logger.With(slog.String("transaction_id", txID))
 				}
 				if txHash, ok := txData["txHash"].(string); ok && txHash != "" {
-					log.Printf("[DEBUG] Transaction Hash: %s", txHash)
+					logger = logger.With(slog.String("tx_hash", txHash))
 				}
 			}
 		}
 
 		resultContent = string(resultBytes)
+		logger.Info("confirmed action completed")
 	}
 
-	// Add tool result to history
-	sess.History = append(sess.History, core.NewToolResultMessage([]core.ToolResultContent{
-		{ToolUseID: action.BlockID, Content: resultContent, IsError: isError},
-	}))
+	return execution, resultContent, isError
+}
 
-	if isError {
-		s.send(conn, ServerMessage{
-			Type:    "text",
-			Content: fmt.Sprintf("Sorry, that action failed: %s", resultContent),
-		})
-		s.send(conn, ServerMessage{Type: "complete"})
+func (s *Server) handleCancel(ctx context.Context, c client, sess *session, userID, actionID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	text, err := s.cancelAction(ctx, sess, userID, actionID)
+	if err != nil {
+		s.sendError(c, err.code, err.message)
 		return
 	}
-
-	// Format success message
-	resultMsg := formatToolResult(action.Tool, result.Data)
-	log.Printf("[DEBUG] Sending formatted result to client: %s", resultMsg)
-	sess.History = append(sess.History, core.NewAssistantMessage(resultMsg))
-
-	s.persistMessage(ctx, sess.ConversationID, "assistant", resultMsg)
-
-	s.send(conn, ServerMessage{Type: "text", Content: resultMsg})
-	s.send(conn, ServerMessage{Type: "complete"})
+	s.send(c, ServerMessage{Type: "text", Content: text})
+	s.send(c, ServerMessage{Type: "complete"})
 }
 
-func (s *Server) handleCancel(ctx context.Context, conn *websocket.Conn, sess *session, userID, actionID string) {
+// cancelAction cancels a pending action and records that in the history,
+// returning the reply to show the user.
+func (s *Server) cancelAction(ctx context.Context, sess *session, userID, actionID string) (string, *protocolError) {
+	if s.offBranch(ctx, sess, userID, actionID) {
+		return offBranchReply, nil
+	}
+
 	// Get action first to have the BlockID for history
 	action, err := s.confirmations.Get(ctx, userID, actionID)
 	if err != nil {
-		s.sendError(conn, "Action not found")
-		return
+		if errors.Is(err, store.ErrActionExpired) {
+			s.metrics.Confirmation(metrics.ConfirmationExpired)
+			s.confirmations.Cancel(ctx, userID, actionID) // drop it so it is counted once
+		}
+		return "", &protocolError{code: ErrCodeNotFound, message: "Action not found"}
 	}
 
 	// Cancel the action
 	if err := s.confirmations.Cancel(ctx, userID, actionID); err != nil {
-		s.sendError(conn, "Failed to cancel action")
-		return
+		return "", &protocolError{code: ErrCodeInternal, message: "Failed to cancel action"}
 	}
+	s.metrics.Confirmation(metrics.ConfirmationCancelled)
 
 	// Add cancelled tool result to history
-	sess.History = append(sess.History, core.NewToolResultMessage([]core.ToolResultContent{
+	cancelled := core.NewToolResultMessage([]core.ToolResultContent{
 		{ToolUseID: action.BlockID, Content: "Cancelled by user", IsError: true},
-	}))
+	})
+	s.appendMessage(ctx, sess, cancelled, nil)
 
-	s.send(conn, ServerMessage{Type: "text", Content: "Action cancelled."})
-	s.send(conn, ServerMessage{Type: "complete"})
+	return "Action cancelled.", nil
+}
+
+// appendMessages adds messages produced by an engine run to the session and
+// stores them, attaching each tool execution to the message that carries
+// its tool_result.
+func (s *Server) appendMessages(ctx context.Context, sess *session, msgs []core.Message, executions []core.ToolExecution) {
+	byToolUse := make(map[string]core.ToolExecution, len(executions))
+	for _, exec := range executions {
+		byToolUse[exec.ToolUseID] = exec
+	}
+
+	for _, msg := range msgs {
+		var tools []core.ToolExecution
+		for _, block := range msg.ContentBlocks {
+			if block.ToolResult == nil {
+				continue
+			}
+			if exec, ok := byToolUse[block.ToolResult.ToolUseID]; ok {
+				tools = append(tools, exec)
+			}
+		}
+		s.appendMessage(ctx, sess, msg, tools)
+	}
 }
 
-func (s *Server) persistMessage(ctx context.Context, conversationID string, role, content string) {
+// appendMessage adds a message to the session's history and stores it,
+// returning its ID. It is stored even if the turn was stopped, so the
+// store always matches the session.
+func (s *Server) appendMessage(ctx context.Context, sess *session, msg core.Message, tools []core.ToolExecution) string {
+	ctx = context.WithoutCancel(ctx)
+	id := uuid.New().String()
+	sess.History = append(sess.History, msg)
+	sess.MessageIDs = append(sess.MessageIDs, id)
+
 	err := s.conversations.Append(ctx, &store.AppendMessage{
-		ConversationID: conversationID,
-		Role:           role,
-		Content:        content,
+		ID:             id,
+		ConversationID: sess.ConversationID,
+		UserID:         sess.UserID,
+		Role:           string(msg.Role),
+		Content:        msg.GetText(),
+		Blocks:         msg.ContentBlocks,
+		Tools:          tools,
+		Usage:          msg.Usage,
 	})
 	if err != nil {
-		log.Printf("Failed to persist message: %v", err)
+		s.sessionLogger(sess).Error("failed to persist message", logging.Error(err))
 	}
+	return id
+}
+
+// client receives server messages: a WebSocket connection or an HTTP
+// event stream.
+type client interface {
+	// send delivers msg, or returns false if the client has gone.
+	send(msg ServerMessage) bool
+}
+
+// send delivers a message to the client. It is safe to call from any
+// goroutine, including turns and title generation.
+func (s *Server) send(c client, msg ServerMessage) {
+	if !c.send(msg) {
+		s.logger.Debug("dropping message for closed connection", slog.String("type", msg.Type))
+	}
+}
+
+// startTurn runs fn as the connection's turn on sess, or tells the client
+// to wait if a turn is already running on the connection or conversation,
+// or that the server is shutting down. Before fn runs, the session catches
+// up with turns other connections and HTTP requests ran on the conversation.
+func (s *Server) startTurn(ctx context.Context, c *connection, sess *session, fn func(ctx context.Context)) {
+	if !s.turns.start() {
+		s.sendError(c, ErrCodeShuttingDown, "The server is shutting down. Reconnect and try again.")
+		return
+	}
+	if c.busy() {
+		s.turns.done()
+		s.sendError(c, ErrCodeTurnInProgress, errTurnInProgress)
+		return
+	}
+	if !s.claimConversation(sess.ConversationID) {
+		s.turns.done()
+		s.sendError(c, ErrCodeTurnInProgress, errConversationBusy)
+		return
+	}
+	started := c.startTurn(ctx, func(ctx context.Context) {
+		defer s.turns.done()
+		defer s.releaseConversation(sess.ConversationID)
+		s.syncSession(ctx, sess)
+		fn(ctx)
+	})
+	if !started {
+		s.releaseConversation(sess.ConversationID)
+		s.turns.done()
+		s.sendError(c, ErrCodeTurnInProgress, errTurnInProgress)
+	}
+}
+
+// claimConversation reserves the conversation for one turn, returning
+// false if a turn is already running on it. WebSocket connections and the
+// HTTP API share the reservation, so turns never interleave their messages.
+func (s *Server) claimConversation(conversationID string) bool {
+	_, busy := s.conversationTurns.LoadOrStore(conversationID, struct{}{})
+	return !busy
+}
+
+func (s *Server) releaseConversation(conversationID string) {
+	s.conversationTurns.Delete(conversationID)
+}
+
+// syncSession reloads the session's history if the stored conversation has
+// moved on, e.g. because another connection ran a turn on it. Call it with
+// the conversation claimed.
+func (s *Server) syncSession(ctx context.Context, sess *session) {
+	conv, err := s.conversations.Get(ctx, sess.UserID, sess.ConversationID)
+	if err != nil {
+		s.sessionLogger(sess).Warn("failed to reload conversation", logging.Error(err))
+		return
+	}
+	if conv.ActiveLeafID == sess.leafID() {
+		return
+	}
+	fresh := sessionFor(conv)
+	sess.History, sess.MessageIDs = fresh.History, fresh.MessageIDs
+	s.sessionLogger(sess).Debug("reloaded conversation changed elsewhere")
+}
+
+// sendError sends an error with one of the ErrCode constants.
+func (s *Server) sendError(c client, code, content string) {
+	s.logger.Debug("sending error", slog.String("code", code), slog.String("content", content))
+	s.send(c, ServerMessage{Type: "error", Code: code, Content: content})
+}
+
+// sendProtocolError tells the client its message broke the protocol.
+func (s *Server) sendProtocolError(c client, err *protocolError) {
+	s.logger.Debug("rejecting client message", slog.String("code", err.code), slog.String("field", err.field), slog.String("content", err.message))
+	s.send(c, ServerMessage{Type: "error", Code: err.code, Field: err.field, Content: err.message})
 }
 
-func (s *Server) send(conn *websocket.Conn, msg ServerMessage) {
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Printf("Failed to send message: %v", err)
+// messageTypeLabel bounds the metric label cardinality for client message types.
+func messageTypeLabel(msgType string) string {
+	if _, ok := clientMessageSpecs[msgType]; ok {
+		return msgType
 	}
+	return "unknown"
 }
 
-func (s *Server) sendError(conn *websocket.Conn, content string) {
-	log.Printf("Sending error: %s", content)
-	s.send(conn, ServerMessage{Type: "error", Content: content})
+// sessionLogger returns a logger carrying the session's user and conversation.
+func (s *Server) sessionLogger(sess *session) *slog.Logger {
+	return s.logger.With(logging.User(sess.UserID), logging.Conversation(sess.ConversationID))
 }
 
+// truncate shortens s to at most maxLen characters, ending it with "..." if
+// it was cut. Multi-byte characters are never split.
 func truncate(s string, maxLen int) string {
-	if len(s) <= maxLen {
+	if utf8.RuneCountInString(s) <= maxLen {
 		return s
 	}
-	return s[:maxLen-3] + "..."
+	return string([]rune(s)[:maxLen-3]) + "..."
 }
 
 func formatToolResult(tool string, result interface{}) string {
@@ -0,0 +1,247 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/executor"
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/tools"
+)
+
+// newServer starts a Nim server backed by llm, returning its base URL.
+// Unless cfg configures them, callers share one user and titles are off,
+// since each title takes a scripted reply.
+func newServer(t *testing.T, llm *llmtest.Server, cfg server.Config) (*server.Server, string) {
+	t.Helper()
+
+	cfg.AnthropicKey = "test-key"
+	cfg.BaseURL = llm.URL
+	if cfg.AuthFunc == nil && cfg.JWTVerifier == nil {
+		cfg.AllowSharedUser = true
+	}
+	if cfg.Titles == (server.TitleConfig{}) {
+		cfg.Titles.Disabled = true
+	}
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	front := httptest.NewServer(srv.Handler())
+	t.Cleanup(front.Close)
+	return srv, front.URL
+}
+
+// payTool is a tool that needs confirmation, and counts its runs.
+func payTool(runs *atomic.Int32) core.Tool {
+	return tools.New("pay").
+		Description("Pay someone").
+		Schema(tools.ObjectSchema(map[string]interface{}{"amount": tools.StringProperty("Amount")}, "amount")).
+		RequiresConfirmation().
+		HandlerFunc(func(ctx context.Context, input json.RawMessage) (interface{}, error) {
+			runs.Add(1)
+			return map[string]interface{}{"message": "Paid."}, nil
+		}).
+		Build()
+}
+
+// wsConn speaks the WebSocket protocol to a test server.
+type wsConn struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+// wsURL returns the WebSocket endpoint of the server at url.
+func wsURL(url string) string {
+	return "ws" + strings.TrimPrefix(url, "http") + "/ws"
+}
+
+// connect opens a WebSocket to the server at url.
+func connect(t *testing.T, url string) *wsConn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(url), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &wsConn{t: t, ws: ws}
+}
+
+// dial opens a WebSocket to the server at url and reads its greeting.
+func dial(t *testing.T, url string) *wsConn {
+	t.Helper()
+	c := connect(t, url)
+	c.until("connected")
+	return c
+}
+
+func (c *wsConn) send(msg server.ClientMessage) {
+	c.t.Helper()
+	if err := c.ws.WriteJSON(msg); err != nil {
+		c.t.Fatalf("send %s: %v", msg.Type, err)
+	}
+}
+
+// until reads messages up to and including the first of type typ,
+// returning them all. Errors fail the test unless typ is "error".
+func (c *wsConn) until(typ string) []server.ServerMessage {
+	c.t.Helper()
+	var msgs []server.ServerMessage
+	c.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg server.ServerMessage
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			c.t.Fatalf("waiting for %s after %v: %v", typ, types(msgs), err)
+		}
+		msgs = append(msgs, msg)
+		if msg.Type == typ {
+			return msgs
+		}
+		if msg.Type == "error" {
+			c.t.Fatalf("waiting for %s: error %s: %s", typ, msg.Code, msg.Content)
+		}
+	}
+}
+
+// last reads messages up to the first of type typ and returns it.
+func (c *wsConn) last(typ string) server.ServerMessage {
+	c.t.Helper()
+	msgs := c.until(typ)
+	return msgs[len(msgs)-1]
+}
+
+// find returns the first message of type typ in msgs.
+func find(msgs []server.ServerMessage, typ string) (server.ServerMessage, bool) {
+	for _, msg := range msgs {
+		if msg.Type == typ {
+			return msg, true
+		}
+	}
+	return server.ServerMessage{}, false
+}
+
+func types(msgs []server.ServerMessage) []string {
+	out := make([]string, len(msgs))
+	for i, msg := range msgs {
+		out[i] = msg.Type
+	}
+	return out
+}
+
+func TestNew_RequiresAuth(t *testing.T) {
+	if _, err := server.New(server.Config{AnthropicKey: "test-key"}); err == nil {
+		t.Error("New without AuthFunc, JWTVerifier or AllowSharedUser succeeded")
+	}
+	srv, err := server.New(server.Config{AnthropicKey: "test-key", AllowSharedUser: true})
+	if err != nil {
+		t.Fatalf("New with AllowSharedUser: %v", err)
+	}
+	srv.Close()
+}
+
+// TestCredentials_PerConnection runs turns for two users at once, each
+// connection carrying its own JWT, and checks every gateway call is made
+// with the token of the user it is for. Run it with -race.
+func TestCredentials_PerConnection(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("for")
+		if got := r.Header.Get("Authorization"); got != "Bearer token-"+user {
+			t.Errorf("call for %s made with Authorization %q", user, got)
+		}
+		mu.Lock()
+		calls[user]++
+		mu.Unlock()
+		io.WriteString(w, `{}`)
+	}))
+	defer gateway.Close()
+
+	// Each turn checks the balance of the user named in the message, then
+	// answers
+	llm := llmtest.New(t)
+	llm.Answer = func(request string) llmtest.Reply {
+		var body struct {
+			Messages []struct {
+				Content []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.Unmarshal([]byte(request), &body); err != nil {
+			t.Errorf("model request: %v", err)
+		}
+		last := body.Messages[len(body.Messages)-1].Content[0]
+		if last.Type == "tool_result" {
+			return llmtest.Reply{Text: "Done."}
+		}
+		user := strings.TrimSuffix(strings.Fields(last.Text)[2], "'s")
+		return llmtest.Reply{Tool: "get_balance", Input: `{"for":"` + user + `"}`}
+	}
+	liminal := executor.NewHTTPExecutor(executor.HTTPExecutorConfig{
+		BaseURL: gateway.URL,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	srv, url := newServer(t, llm, server.Config{
+		LiminalExecutor: liminal,
+		AuthFunc: func(r *http.Request) (string, error) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !strings.HasPrefix(token, "token-") {
+				return "", errors.New("missing token")
+			}
+			return strings.TrimPrefix(token, "token-"), nil
+		},
+	})
+	srv.AddTools(tools.LiminalTools(liminal)...)
+
+	users := []string{"alice", "bob"}
+	conns := make([]*wsConn, len(users))
+	for i, user := range users {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL(url), http.Header{"Authorization": {"Bearer token-" + user}})
+		if err != nil {
+			t.Fatalf("Dial as %s: %v", user, err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		conns[i] = &wsConn{t: t, ws: ws}
+		conns[i].until("connected")
+		conns[i].send(server.ClientMessage{Type: "new_conversation"})
+		conns[i].until("conversation_started")
+	}
+
+	// Both users' turns run at the same time
+	const rounds = 20
+	for range rounds {
+		for i, user := range users {
+			conns[i].send(server.ClientMessage{Type: "message", Content: "What is " + user + "'s balance?"})
+		}
+		for _, c := range conns {
+			c.until("complete")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, user := range users {
+		if calls[user] != rounds {
+			t.Errorf("%d gateway calls for %s, want %d", calls[user], user, rounds)
+		}
+	}
+}
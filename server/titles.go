@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/engine"
+	"github.com/becomeliminal/nim-go-sdk/logging"
+)
+
+// TitleConfig configures automatic conversation titles. The server titles a
+// conversation after its first complete exchange and, if RefreshEvery is
+// set, again as it grows. Titles are generated in the background, saved
+// with Conversations.SetTitle and pushed as "conversation_titled".
+type TitleConfig struct {
+	// Disabled turns automatic titles off.
+	Disabled bool
+
+	// Model, Prompt and MaxTokens configure the title model call.
+	// Zero values use engine.DefaultTitleConfig.
+	Model     string
+	Prompt    string
+	MaxTokens int64
+
+	// RefreshEvery regenerates the title every N turns after the first.
+	// Zero titles each conversation once. Titles the user set, or that
+	// existed before the session began, are never replaced.
+	RefreshEvery int
+
+	// Timeout bounds each title generation. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// maybeGenerateTitle starts title generation in the background if the
+// session is due a title. Call it after a complete exchange.
+func (s *Server) maybeGenerateTitle(ctx context.Context, c client, sess *session) {
+	cfg := s.config.Titles
+	if cfg.Disabled || sess.TitleLocked.Load() {
+		return
+	}
+	if sess.TitledAtTurn >= 0 && (cfg.RefreshEvery <= 0 || sess.TurnCount-sess.TitledAtTurn < cfg.RefreshEvery) {
+		return
+	}
+	sess.TitledAtTurn = sess.TurnCount
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	// The title outlives the request that triggered it, so it is saved
+	// even if the client disconnects first.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	history := append([]core.Message(nil), sess.History...)
+	userID, conversationID := sess.UserID, sess.ConversationID
+	logger := s.sessionLogger(sess)
+
+	started := s.goBackground(func() {
+		defer cancel()
+
+		title, err := s.engine.GenerateTitle(ctx, history)
+		if err != nil {
+			logger.Warn("failed to generate title", logging.Error(err))
+			return
+		}
+		if title == engine.DefaultTitle {
+			return
+		}
+		title = truncate(title, maxTitleLength)
+
+		// The user may have renamed the conversation while the title was
+		// generated
+		sess.titleMu.Lock()
+		defer sess.titleMu.Unlock()
+		if sess.TitleLocked.Load() {
+			logger.Debug("discarded title of renamed conversation")
+			return
+		}
+		if err := s.conversations.SetTitle(ctx, userID, conversationID, title); err != nil {
+			logger.Warn("failed to save title", logging.Error(err))
+			return
+		}
+
+		logger.Debug("titled conversation")
+		s.send(c, ServerMessage{
+			Type:           "conversation_titled",
+			ConversationID: conversationID,
+			Title:          title,
+		})
+	})
+	if !started {
+		cancel()
+		logger.Debug("skipped title while the server closes")
+	}
+}
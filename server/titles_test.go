@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/internal/llmtest"
+	"github.com/becomeliminal/nim-go-sdk/server"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// exchange sends content on c, answered by reply, and waits for the turn
+// to complete.
+func exchange(c *wsConn, llm *llmtest.Server, content, reply string) {
+	c.t.Helper()
+	llm.Script(llmtest.Reply{Text: reply})
+	c.send(server.ClientMessage{Type: "message", Content: content})
+	c.until("complete")
+}
+
+// storedTitle returns the conversation's title in conversations.
+func storedTitle(t *testing.T, conversations store.Conversations, conversationID string) string {
+	t.Helper()
+	conv, err := conversations.Get(context.Background(), sharedUser, conversationID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return conv.Title
+}
+
+func TestTitles(t *testing.T) {
+	conversations := store.NewMemoryConversations()
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{
+		Conversations: conversations,
+		Titles:        server.TitleConfig{Timeout: 5 * time.Second},
+	})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID := c.last("conversation_started").ConversationID
+
+	// The title model's answer is cleaned up before it is saved
+	llm.Script(llmtest.Reply{Text: "Your balance is $10."}, llmtest.Reply{Text: `"Checking the balance."`})
+	c.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	c.until("complete")
+	titled := c.last("conversation_titled")
+	if titled.ConversationID != conversationID || titled.Title != "Checking the balance" {
+		t.Errorf("conversation_titled = %+v, want the cleaned title for %s", titled, conversationID)
+	}
+	if got := storedTitle(t, conversations, conversationID); got != "Checking the balance" {
+		t.Errorf("stored title = %q", got)
+	}
+	if req := lastRequest(llm); !strings.Contains(req, "What's my balance?") || !strings.Contains(req, "Your balance is $10.") {
+		t.Errorf("title request = %s, want the exchange", req)
+	}
+
+	// Without RefreshEvery a conversation is titled once; another title
+	// request would find no scripted reply
+	exchange(c, llm, "And yesterday?", "It was $12.")
+	exchange(c, llm, "Thanks", "You're welcome.")
+}
+
+func TestTitles_RefreshEvery(t *testing.T) {
+	conversations := store.NewMemoryConversations()
+	llm := llmtest.New(t)
+	_, url := newServer(t, llm, server.Config{
+		Conversations: conversations,
+		Titles:        server.TitleConfig{RefreshEvery: 2},
+	})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID := c.last("conversation_started").ConversationID
+
+	llm.Script(llmtest.Reply{Text: "Your balance is $10."}, llmtest.Reply{Text: "Balance"})
+	c.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	if got := c.last("conversation_titled").Title; got != "Balance" {
+		t.Errorf("first title = %q, want Balance", got)
+	}
+
+	exchange(c, llm, "Send $5 to Alice", "Sent.")
+
+	llm.Script(llmtest.Reply{Text: "Done."}, llmtest.Reply{Text: "Balance and payments"})
+	c.send(server.ClientMessage{Type: "message", Content: "And $5 to Bob"})
+	if got := c.last("conversation_titled").Title; got != "Balance and payments" {
+		t.Errorf("title after two more turns = %q, want Balance and payments", got)
+	}
+	if got := storedTitle(t, conversations, conversationID); got != "Balance and payments" {
+		t.Errorf("stored title = %q", got)
+	}
+}
+
+func TestTitles_RenameWins(t *testing.T) {
+	conversations := store.NewMemoryConversations()
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{
+		Conversations: conversations,
+		Titles:        server.TitleConfig{RefreshEvery: 1},
+	})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	conversationID := c.last("conversation_started").ConversationID
+
+	// Rename while the title is being generated
+	release := make(chan struct{})
+	llm.Script(llmtest.Reply{Text: "Your balance is $10."}, llmtest.Reply{Text: "Balance", Release: release})
+	c.send(server.ClientMessage{Type: "message", Content: "What's my balance?"})
+	c.until("complete")
+	for deadline := time.Now().Add(5 * time.Second); len(llm.Requests()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no title request")
+		}
+	}
+	c.send(server.ClientMessage{Type: "rename_conversation", ConversationID: conversationID, Title: "Mine"})
+	c.until("conversation_updated")
+	close(release)
+
+	// A renamed conversation is never titled again
+	exchange(c, llm, "Thanks", "You're welcome.")
+
+	srv.Close() // waits for the title
+	if got := storedTitle(t, conversations, conversationID); got != "Mine" {
+		t.Errorf("stored title = %q, want the user's", got)
+	}
+}
+
+func TestTitles_SkippedAfterClose(t *testing.T) {
+	llm := llmtest.New(t)
+	srv, url := newServer(t, llm, server.Config{Titles: server.TitleConfig{Timeout: 5 * time.Second}})
+
+	c := dial(t, url)
+	c.send(server.ClientMessage{Type: "new_conversation"})
+	c.last("conversation_started")
+
+	// Open connections outlive Close, but their titles are not generated;
+	// a title request would find no scripted reply
+	srv.Close()
+	exchange(c, llm, "What's my balance?", "Your balance is $10.")
+	if n := len(llm.Requests()); n != 1 {
+		t.Errorf("model requests = %d, want only the turn", n)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// messageSpec lists the fields a client message type takes, by JSON name.
+type messageSpec struct {
+	required []string
+	optional []string
+
+	// oneOf fields: exactly one must be set.
+	oneOf []string
+}
+
+func (m messageSpec) takes(field string) bool {
+	return slices.Contains(m.required, field) || slices.Contains(m.optional, field) || slices.Contains(m.oneOf, field)
+}
+
+var listFields = []string{"limit", "cursor", "sort", "archived"}
+
+// clientMessageSpecs defines every client message type.
+var clientMessageSpecs = map[string]messageSpec{
+	"hello":                  {required: []string{"version"}, optional: []string{"capabilities"}},
+	"resume":                 {required: []string{"resumeToken"}, optional: []string{"lastSeq"}},
+	"new_conversation":       {},
+	"resume_conversation":    {required: []string{"conversationId"}},
+	"message":                {required: []string{"content"}},
+	"confirm":                {oneOf: []string{"actionId", "actionIds"}},
+	"cancel":                 {oneOf: []string{"actionId", "actionIds"}},
+	"stop":                   {},
+	"edit_message":           {required: []string{"messageId", "content"}},
+	"regenerate":             {optional: []string{"messageId"}},
+	"list_conversations":     {optional: listFields},
+	"search_conversations":   {required: []string{"query"}, optional: listFields},
+	"rename_conversation":    {required: []string{"conversationId", "title"}},
+	"delete_conversation":    {required: []string{"conversationId"}},
+	"archive_conversation":   {required: []string{"conversationId"}},
+	"unarchive_conversation": {required: []string{"conversationId"}},
+	"pin_conversation":       {required: []string{"conversationId"}},
+	"unpin_conversation":     {required: []string{"conversationId"}},
+}
+
+// fieldEnums are the values string fields may take, when set.
+var fieldEnums = map[string][]string{
+	"sort":     {"updated", "created"},
+	"archived": {"only", "include"},
+}
+
+// fieldMinimums are the least values integer fields may take.
+var fieldMinimums = map[string]int64{
+	"limit":   0,
+	"lastSeq": 0,
+}
+
+// clientFields maps the JSON name of every ClientMessage field to its
+// index in the struct.
+var clientFields = jsonFields(reflect.TypeOf(ClientMessage{}))
+
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+// protocolError is a client message that breaks the protocol.
+type protocolError struct {
+	code    string
+	field   string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.message
+}
+
+// decodeClientMessage decodes and validates a client message. Strict
+// decoding, for clients that negotiated a version with hello, also rejects
+// fields the protocol, or the message's type, does not define; older
+// clients may send them and have them ignored.
+func decodeClientMessage(data []byte, strict bool) (ClientMessage, *protocolError) {
+	var msg ClientMessage
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return msg, &protocolError{code: ErrCodeInvalidMessage, message: "Message must be an object"}
+	}
+
+	var msgType string
+	if err := json.Unmarshal(raw["type"], &msgType); err != nil || msgType == "" {
+		return msg, &protocolError{code: ErrCodeUnknownType, field: "type", message: "Message type is required"}
+	}
+	spec, ok := clientMessageSpecs[msgType]
+	if !ok {
+		return msg, &protocolError{code: ErrCodeUnknownType, field: "type", message: fmt.Sprintf("Unknown message type: %s", msgType)}
+	}
+
+	if strict {
+		for _, field := range sortedKeys(raw) {
+			if _, known := clientFields[field]; !known {
+				return msg, &protocolError{code: ErrCodeUnknownField, field: field, message: fmt.Sprintf("Unknown field %q", field)}
+			}
+			if field != "type" && !spec.takes(field) {
+				return msg, &protocolError{code: ErrCodeUnexpectedField, field: field, message: fmt.Sprintf("%s does not take %q", msgType, field)}
+			}
+		}
+	}
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return msg, &protocolError{code: ErrCodeInvalidField, field: typeErr.Field, message: fmt.Sprintf("Field %q must be a %s", typeErr.Field, jsonTypeName(typeErr.Type))}
+		}
+		return msg, &protocolError{code: ErrCodeInvalidMessage, message: "Message must be an object"}
+	}
+
+	v := reflect.ValueOf(msg)
+	for _, field := range spec.required {
+		if v.Field(clientFields[field]).IsZero() {
+			return msg, &protocolError{code: ErrCodeMissingField, field: field, message: fmt.Sprintf("%s requires %q", msgType, field)}
+		}
+	}
+	if len(spec.oneOf) > 0 {
+		set := 0
+		for _, field := range spec.oneOf {
+			if !v.Field(clientFields[field]).IsZero() {
+				set++
+			}
+		}
+		if set != 1 {
+			return msg, &protocolError{code: ErrCodeMissingField, field: spec.oneOf[0], message: fmt.Sprintf("%s requires one of %s", msgType, strings.Join(spec.oneOf, ", "))}
+		}
+	}
+
+	for field, values := range fieldEnums {
+		if s := v.Field(clientFields[field]).String(); s != "" && !slices.Contains(values, s) {
+			return msg, &protocolError{code: ErrCodeInvalidField, field: field, message: fmt.Sprintf("Field %q must be one of %s", field, strings.Join(values, ", "))}
+		}
+	}
+	for field, minimum := range fieldMinimums {
+		if v.Field(clientFields[field]).Int() < minimum {
+			return msg, &protocolError{code: ErrCodeInvalidField, field: field, message: fmt.Sprintf("Field %q must be at least %d", field, minimum)}
+		}
+	}
+	if slices.Contains(msg.ActionIDs, "") {
+		return msg, &protocolError{code: ErrCodeInvalidField, field: "actionIds", message: `Field "actionIds" must not contain empty IDs`}
+	}
+	if len(slices.Compact(slices.Sorted(slices.Values(msg.ActionIDs)))) != len(msg.ActionIDs) {
+		return msg, &protocolError{code: ErrCodeInvalidField, field: "actionIds", message: `Field "actionIds" must not repeat IDs`}
+	}
+
+	return msg, nil
+}
+
+// jsonTypeName names a Go type as its JSON counterpart.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Slice:
+		return "array of " + jsonTypeName(t.Elem()) + "s"
+	default:
+		return t.Kind().String()
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/becomeliminal/nim-go-sdk/core"
 )
@@ -16,13 +15,16 @@ type MemoryConfirmations struct {
 	mu            sync.RWMutex
 	actions       map[string]*core.PendingAction // actionID -> action
 	byIdempotency map[string]string              // idempotencyKey -> actionID
+	clock         core.Clock
 }
 
 // NewMemoryConfirmations creates an in-memory confirmation store.
-func NewMemoryConfirmations() *MemoryConfirmations {
+func NewMemoryConfirmations(opts ...Option) *MemoryConfirmations {
+	o := applyOptions(opts)
 	return &MemoryConfirmations{
 		actions:       make(map[string]*core.PendingAction),
 		byIdempotency: make(map[string]string),
+		clock:         o.clock,
 	}
 }
 
@@ -43,13 +45,13 @@ func (m *MemoryConfirmations) Get(ctx context.Context, userID, actionID string)
 
 	action, ok := m.actions[actionID]
 	if !ok {
-		return nil, fmt.Errorf("action not found: %s", actionID)
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
 	if action.UserID != userID {
-		return nil, fmt.Errorf("action not found: %s", actionID)
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
-	if action.ExpiresAt < time.Now().Unix() {
-		return nil, fmt.Errorf("action expired: %s", actionID)
+	if action.ExpiresAt < m.clock.Now().Unix() {
+		return nil, fmt.Errorf("%w: %s", ErrActionExpired, actionID)
 	}
 	return action, nil
 }
@@ -70,26 +72,43 @@ func (m *MemoryConfirmations) GetByIdempotency(ctx context.Context, userID, key
 	if action.UserID != userID {
 		return nil, nil
 	}
-	if action.ExpiresAt < time.Now().Unix() {
+	if action.ExpiresAt < m.clock.Now().Unix() {
 		return nil, nil
 	}
 	return action, nil
 }
 
+func (m *MemoryConfirmations) GetByBlock(ctx context.Context, userID, sessionID, blockID string) (*core.PendingAction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.clock.Now().Unix()
+	var found *core.PendingAction
+	for _, action := range m.actions {
+		if action.UserID != userID || action.SessionID != sessionID || action.BlockID != blockID || action.ExpiresAt < now {
+			continue
+		}
+		if found == nil || action.CreatedAt > found.CreatedAt {
+			found = action
+		}
+	}
+	return found, nil
+}
+
 func (m *MemoryConfirmations) Confirm(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	action, ok := m.actions[actionID]
 	if !ok {
-		return nil, fmt.Errorf("action not found: %s", actionID)
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
 	if action.UserID != userID {
-		return nil, fmt.Errorf("action not found: %s", actionID)
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
-	if action.ExpiresAt < time.Now().Unix() {
+	if action.ExpiresAt < m.clock.Now().Unix() {
 		m.deleteUnlocked(action)
-		return nil, fmt.Errorf("action expired: %s", actionID)
+		return nil, fmt.Errorf("%w: %s", ErrActionExpired, actionID)
 	}
 
 	m.deleteUnlocked(action)
@@ -102,10 +121,10 @@ func (m *MemoryConfirmations) Cancel(ctx context.Context, userID, actionID strin
 
 	action, ok := m.actions[actionID]
 	if !ok {
-		return fmt.Errorf("action not found: %s", actionID)
+		return fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
 	if action.UserID != userID {
-		return fmt.Errorf("action not found: %s", actionID)
+		return fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
 
 	m.deleteUnlocked(action)
@@ -116,7 +135,7 @@ func (m *MemoryConfirmations) Cleanup(ctx context.Context) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now().Unix()
+	now := m.clock.Now().Unix()
 	count := 0
 	for _, action := range m.actions {
 		if action.ExpiresAt < now {
@@ -0,0 +1,34 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+	"github.com/becomeliminal/nim-go-sdk/store/storetest"
+)
+
+func TestMemoryConfirmations_Conformance(t *testing.T) {
+	storetest.RunConfirmationsSuite(t, func(t *testing.T, clock core.Clock) store.Confirmations {
+		return store.NewMemoryConfirmations(store.WithClock(clock))
+	})
+}
+
+func TestRistrettoConfirmations_Conformance(t *testing.T) {
+	storetest.RunConfirmationsSuite(t, func(t *testing.T, clock core.Clock) store.Confirmations {
+		cfg := store.DefaultRistrettoConfig()
+		cfg.Clock = clock
+		s, err := store.NewRistrettoConfirmations(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	})
+}
+
+func TestMemoryConversations_Conformance(t *testing.T) {
+	storetest.RunConversationsSuite(t, func(t *testing.T, clock core.Clock) store.Conversations {
+		return store.NewMemoryConversations(store.WithClock(clock))
+	})
+}
@@ -3,10 +3,13 @@ package store
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
 )
 
 // MemoryConversations is an in-memory implementation of Conversations.
@@ -16,13 +19,16 @@ type MemoryConversations struct {
 	mu            sync.RWMutex
 	conversations map[string]*ConversationWithMessages
 	byUser        map[string][]string // userID -> []conversationID
+	clock         core.Clock
 }
 
 // NewMemoryConversations creates a new in-memory conversation store.
-func NewMemoryConversations() *MemoryConversations {
+func NewMemoryConversations(opts ...Option) *MemoryConversations {
+	o := applyOptions(opts)
 	return &MemoryConversations{
 		conversations: make(map[string]*ConversationWithMessages),
 		byUser:        make(map[string][]string),
+		clock:         o.clock,
 	}
 }
 
@@ -30,12 +36,12 @@ func (m *MemoryConversations) Create(ctx context.Context, userID string) (*Conve
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
+	now := m.clock.Now()
 	conv := &ConversationWithMessages{
 		Conversation: Conversation{
 			ID:        uuid.New().String(),
 			UserID:    userID,
-			Title:     "New conversation",
+			Title:     DefaultTitle,
 			CreatedAt: now,
 			UpdatedAt: now,
 		},
@@ -48,82 +54,215 @@ func (m *MemoryConversations) Create(ctx context.Context, userID string) (*Conve
 	return &conv.Conversation, nil
 }
 
-func (m *MemoryConversations) Get(ctx context.Context, conversationID string) (*ConversationWithMessages, error) {
+// owned returns the conversation if it exists and belongs to the user.
+// Callers must hold m.mu.
+func (m *MemoryConversations) owned(userID, conversationID string) (*ConversationWithMessages, error) {
+	conv, ok := m.conversations[conversationID]
+	if !ok || conv.UserID != userID {
+		return nil, fmt.Errorf("%w: %s", ErrConversationNotFound, conversationID)
+	}
+	return conv, nil
+}
+
+func (m *MemoryConversations) Get(ctx context.Context, userID, conversationID string) (*ConversationWithMessages, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	conv, ok := m.conversations[conversationID]
-	if !ok {
-		return nil, fmt.Errorf("conversation not found: %s", conversationID)
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return nil, err
 	}
-	return conv, nil
+
+	// ActiveBranch returns a new slice, so callers never share state with
+	// concurrent writers
+	return &ConversationWithMessages{
+		Conversation: conv.Conversation,
+		Messages:     ActiveBranch(conv.Messages, conv.ActiveLeafID),
+	}, nil
 }
 
 func (m *MemoryConversations) Append(ctx context.Context, msg *AppendMessage) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	conv, ok := m.conversations[msg.ConversationID]
-	if !ok {
-		return fmt.Errorf("conversation not found: %s", msg.ConversationID)
+	conv, err := m.owned(msg.UserID, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	id := msg.ID
+	if id == "" {
+		id = uuid.New().String()
 	}
 
 	stored := StoredMessage{
-		ID:        uuid.New().String(),
+		ID:        id,
+		ParentID:  conv.ActiveLeafID,
 		Role:      msg.Role,
 		Content:   msg.Content,
 		Blocks:    msg.Blocks,
 		Tools:     msg.Tools,
-		CreatedAt: time.Now(),
+		Usage:     msg.Usage,
+		CreatedAt: m.clock.Now(),
 	}
 
+	// Messages holds every branch, in insertion order
 	conv.Messages = append(conv.Messages, stored)
-	conv.UpdatedAt = time.Now()
+	conv.ActiveLeafID = id
+	conv.UpdatedAt = m.clock.Now()
 
 	return nil
 }
 
-func (m *MemoryConversations) SetTitle(ctx context.Context, conversationID, title string) error {
+func (m *MemoryConversations) SetActiveLeaf(ctx context.Context, userID, conversationID, messageID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	conv, ok := m.conversations[conversationID]
-	if !ok {
-		return fmt.Errorf("conversation not found: %s", conversationID)
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	if messageID != "" && !hasMessage(conv, messageID) {
+		return fmt.Errorf("%w: %s", ErrMessageNotFound, messageID)
+	}
+
+	conv.ActiveLeafID = messageID
+	return nil
+}
+
+func hasMessage(conv *ConversationWithMessages, messageID string) bool {
+	for i := range conv.Messages {
+		if conv.Messages[i].ID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryConversations) SetTitle(ctx context.Context, userID, conversationID, title string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return err
 	}
 
 	conv.Title = title
-	conv.UpdatedAt = time.Now()
+	conv.UpdatedAt = m.clock.Now()
 	return nil
 }
 
-func (m *MemoryConversations) List(ctx context.Context, userID string, limit int) ([]*Conversation, error) {
+func (m *MemoryConversations) SetArchived(ctx context.Context, userID, conversationID string, archived bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	conv.Archived = archived
+	return nil
+}
+
+func (m *MemoryConversations) SetPinned(ctx context.Context, userID, conversationID string, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	conv.Pinned = pinned
+	return nil
+}
+
+func (m *MemoryConversations) List(ctx context.Context, userID string, opts ListOptions) (*ConversationPage, error) {
+	return m.page(userID, opts, func(*ConversationWithMessages) bool { return true })
+}
+
+func (m *MemoryConversations) Search(ctx context.Context, userID, query string, opts ListOptions) (*ConversationPage, error) {
+	terms := SearchTerms(query)
+	return m.page(userID, opts, func(conv *ConversationWithMessages) bool {
+		for _, term := range terms {
+			if !containsTerm(conv, term) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// page returns the user's conversations that pass opts and match, in List order.
+func (m *MemoryConversations) page(userID string, opts ListOptions, match func(*ConversationWithMessages) bool) (*ConversationPage, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	cursor, hasCursor, err := DecodeCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	convIDs, ok := m.byUser[userID]
-	if !ok {
-		return []*Conversation{}, nil
+	result := make([]*Conversation, 0)
+	for _, id := range m.byUser[userID] {
+		conv, ok := m.conversations[id]
+		if !ok || !opts.Matches(&conv.Conversation) || !match(conv) {
+			continue
+		}
+		if hasCursor && cursor.Before(conv.Pinned, opts.SortKey(&conv.Conversation), conv.ID) {
+			continue
+		}
+		c := conv.Conversation
+		result = append(result, &c)
 	}
 
-	// Return most recent first
-	result := make([]*Conversation, 0, limit)
-	for i := len(convIDs) - 1; i >= 0 && len(result) < limit; i-- {
-		if conv, ok := m.conversations[convIDs[i]]; ok {
-			result = append(result, &conv.Conversation)
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
 		}
+		if ka, kb := opts.SortKey(a), opts.SortKey(b); !ka.Equal(kb) {
+			return ka.After(kb)
+		}
+		return a.ID > b.ID
+	})
+
+	page := &ConversationPage{Conversations: result}
+	if len(result) > opts.Limit {
+		page.Conversations = result[:opts.Limit]
+		page.NextCursor = CursorAfter(opts, page.Conversations[opts.Limit-1]).Encode()
 	}
+	return page, nil
+}
 
-	return result, nil
+// containsTerm reports whether a lower-cased term appears in the
+// conversation's title or message content.
+func containsTerm(conv *ConversationWithMessages, term string) bool {
+	if strings.Contains(strings.ToLower(conv.Title), term) {
+		return true
+	}
+	for i := range conv.Messages {
+		if strings.Contains(strings.ToLower(conv.Messages[i].Content), term) {
+			return true
+		}
+	}
+	return false
 }
 
-func (m *MemoryConversations) Delete(ctx context.Context, conversationID string) error {
+func (m *MemoryConversations) Delete(ctx context.Context, userID, conversationID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	conv, ok := m.conversations[conversationID]
-	if !ok {
-		return fmt.Errorf("conversation not found: %s", conversationID)
+	conv, err := m.owned(userID, conversationID)
+	if err != nil {
+		return err
 	}
 
 	// Remove from byUser index
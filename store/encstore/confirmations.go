@@ -0,0 +1,190 @@
+package encstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// Confirmations is a store.Confirmations that encrypts action inputs and
+// summaries, which carry recipients, amounts and notes. The encrypted input
+// is stored as a JSON string, so it stays valid JSON for the wrapped store.
+type Confirmations struct {
+	inner store.Confirmations
+	keys  *Keyring
+}
+
+// NewConfirmations wraps inner so that action inputs and summaries are
+// encrypted with the users' data keys in keys.
+func NewConfirmations(inner store.Confirmations, keys *Keyring) *Confirmations {
+	return &Confirmations{inner: inner, keys: keys}
+}
+
+func (c *Confirmations) Store(ctx context.Context, action *core.PendingAction) error {
+	encrypted := *action
+	if err := c.encryptAction(ctx, &encrypted); err != nil {
+		return err
+	}
+	return c.inner.Store(ctx, &encrypted)
+}
+
+func (c *Confirmations) Get(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
+	action, err := c.inner.Get(ctx, userID, actionID)
+	if err != nil {
+		return nil, err
+	}
+	return c.decryptAction(ctx, action)
+}
+
+func (c *Confirmations) GetByIdempotency(ctx context.Context, userID, key string) (*core.PendingAction, error) {
+	action, err := c.inner.GetByIdempotency(ctx, userID, key)
+	if err != nil || action == nil {
+		return action, err
+	}
+	return c.decryptAction(ctx, action)
+}
+
+func (c *Confirmations) GetByBlock(ctx context.Context, userID, sessionID, blockID string) (*core.PendingAction, error) {
+	action, err := c.inner.GetByBlock(ctx, userID, sessionID, blockID)
+	if err != nil || action == nil {
+		return action, err
+	}
+	return c.decryptAction(ctx, action)
+}
+
+func (c *Confirmations) Confirm(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
+	action, err := c.inner.Confirm(ctx, userID, actionID)
+	if err != nil {
+		return nil, err
+	}
+	return c.decryptAction(ctx, action)
+}
+
+func (c *Confirmations) Cancel(ctx context.Context, userID, actionID string) error {
+	return c.inner.Cancel(ctx, userID, actionID)
+}
+
+func (c *Confirmations) Cleanup(ctx context.Context) (int, error) {
+	return c.inner.Cleanup(ctx)
+}
+
+// Reencrypt rewraps the user's data key with the current key and encrypts
+// the user's stored actions that hold plaintext written before encryption
+// was enabled, returning the number rewritten. The wrapped store must
+// implement store.ActionRewriter. Pending actions expire within minutes, so
+// this matters mostly for stores that keep resolved actions as history.
+func (c *Confirmations) Reencrypt(ctx context.Context, userID string) (int, error) {
+	rw, ok := c.inner.(store.ActionRewriter)
+	if !ok {
+		return 0, ErrRewriteUnsupported
+	}
+	if _, err := c.keys.rewrap(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return rw.RewriteActions(ctx, userID, func(action *core.PendingAction) (bool, error) {
+		_, encrypted := encryptedJSON(action.Input)
+		if (len(action.Input) == 0 || encrypted) && !isPlaintext(action.Summary) {
+			return false, nil
+		}
+
+		decrypted, err := c.decryptAction(ctx, action)
+		if err != nil {
+			return false, err
+		}
+		if err := c.encryptAction(ctx, decrypted); err != nil {
+			return false, err
+		}
+		action.Input, action.Summary = decrypted.Input, decrypted.Summary
+		return true, nil
+	})
+}
+
+// PurgeActions purges resolved actions from the wrapped store, or returns
+// store.ErrPurgeUnsupported if it does not implement store.ActionPurger.
+func (c *Confirmations) PurgeActions(ctx context.Context, before time.Time) (int, error) {
+	p, ok := c.inner.(store.ActionPurger)
+	if !ok {
+		return 0, store.ErrPurgeUnsupported
+	}
+	return p.PurgeActions(ctx, before)
+}
+
+// HealthCheck checks the wrapped store, if it implements
+// store.HealthChecker.
+func (c *Confirmations) HealthCheck(ctx context.Context) error {
+	if h, ok := c.inner.(store.HealthChecker); ok {
+		return h.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// ExportUserData exports the user's actions from the wrapped store,
+// decrypted. The wrapped store must implement privacy.UserDataHandler.
+func (c *Confirmations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	h, ok := c.inner.(privacy.UserDataHandler)
+	if !ok {
+		return nil, errors.New("wrapped store does not implement privacy.UserDataHandler")
+	}
+	data, err := h.ExportUserData(ctx, userID)
+	if err != nil || data == nil {
+		return data, err
+	}
+	return c.keys.decryptTree(ctx, userID, data)
+}
+
+// EraseUserData deletes the user's data key, then erases the user's actions
+// from the wrapped store.
+func (c *Confirmations) EraseUserData(ctx context.Context, userID string) error {
+	h, ok := c.inner.(privacy.UserDataHandler)
+	if !ok {
+		return errors.New("wrapped store does not implement privacy.UserDataHandler")
+	}
+	if err := c.keys.erase(ctx, userID); err != nil {
+		return err
+	}
+	return h.EraseUserData(ctx, userID)
+}
+
+// encryptAction encrypts the action's input and summary in place.
+func (c *Confirmations) encryptAction(ctx context.Context, action *core.PendingAction) error {
+	input, err := c.keys.encryptJSON(ctx, action.UserID, action.Input)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt action input: %w", err)
+	}
+	summary, err := c.keys.encryptString(ctx, action.UserID, action.Summary)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt action summary: %w", err)
+	}
+	action.Input, action.Summary = input, summary
+	return nil
+}
+
+// decryptAction returns a decrypted copy of the action. Stores may hand out
+// their stored pointer, so it is never modified.
+func (c *Confirmations) decryptAction(ctx context.Context, action *core.PendingAction) (*core.PendingAction, error) {
+	input, err := c.keys.decryptJSON(ctx, action.UserID, action.Input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt action input: %w", err)
+	}
+	summary, err := c.keys.decryptString(ctx, action.UserID, action.Summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt action summary: %w", err)
+	}
+
+	decrypted := *action
+	decrypted.Input, decrypted.Summary = input, summary
+	return &decrypted, nil
+}
+
+// Verify Confirmations implements the store and privacy interfaces.
+var (
+	_ store.Confirmations     = (*Confirmations)(nil)
+	_ store.ActionPurger      = (*Confirmations)(nil)
+	_ privacy.UserDataHandler = (*Confirmations)(nil)
+)
@@ -0,0 +1,387 @@
+// Package encstore encrypts conversations and pending actions at rest.
+//
+// Conversations and Confirmations wrap any store.Conversations and
+// store.Confirmations and encrypt message content, blocks and tool
+// executions, conversation titles, and action inputs and summaries with
+// AES-256-GCM before they reach the wrapped store. Each user's values are
+// encrypted with that user's own data key, which is wrapped by a key from a
+// KeyProvider and kept in a DataKeyStore by a Keyring:
+//
+//	keys, err := encstore.LocalKeysFromEnv("NIM_ENCRYPTION_KEYS") // "k1:base64key"
+//	keyring := encstore.NewKeyring(keys, sqlstore.NewDataKeys(db, dialect))
+//	conversations := encstore.NewConversations(sqlstore.NewConversations(db, dialect), keyring)
+//	confirmations := encstore.NewConfirmations(sqlstore.NewConfirmations(db, dialect), keyring)
+//
+// Erasing a user deletes their data key, so copies of their records that
+// outlive the erasure, such as backups, cannot be decrypted.
+//
+// To rotate, make a new key current and keep the old one available; data
+// keys are readable under either. Reencrypt rewraps a user's data key with
+// the current key, after which the old key can be retired. Existing
+// plaintext records stay readable and are encrypted by Reencrypt too.
+package encstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// ErrRewriteUnsupported is returned by Reencrypt when the wrapped store
+// cannot rewrite records in place.
+var ErrRewriteUnsupported = errors.New("store does not support rewriting records")
+
+// Conversations is a store.Conversations that encrypts titles and message
+// content. Metadata such as IDs, roles, timestamps and token usage stay in
+// plaintext so the wrapped store can order and page without decrypting.
+//
+// The wrapped store cannot search encrypted content, so Search lists the
+// user's conversations and decrypts them one by one. It matches the active
+// branch only, and its cost grows with the number of conversations scanned.
+type Conversations struct {
+	inner store.Conversations
+	keys  *Keyring
+}
+
+// NewConversations wraps inner so that content is encrypted with the
+// users' data keys in keys.
+func NewConversations(inner store.Conversations, keys *Keyring) *Conversations {
+	return &Conversations{inner: inner, keys: keys}
+}
+
+// messageContent is the encrypted part of a message. It is stored,
+// encrypted, in place of the message's content, with blocks and tools empty.
+type messageContent struct {
+	Content string               `json:"content"`
+	Blocks  []core.ContentBlock  `json:"blocks,omitempty"`
+	Tools   []core.ToolExecution `json:"tools,omitempty"`
+}
+
+func (c *Conversations) Create(ctx context.Context, userID string) (*store.Conversation, error) {
+	return c.inner.Create(ctx, userID)
+}
+
+func (c *Conversations) Get(ctx context.Context, userID, conversationID string) (*store.ConversationWithMessages, error) {
+	conv, err := c.inner.Get(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return c.decryptConversation(ctx, conv)
+}
+
+func (c *Conversations) Append(ctx context.Context, msg *store.AppendMessage) error {
+	content, err := c.encryptMessage(ctx, msg.UserID, messageContent{Content: msg.Content, Blocks: msg.Blocks, Tools: msg.Tools})
+	if err != nil {
+		return err
+	}
+
+	encrypted := *msg
+	encrypted.Content = content
+	encrypted.Blocks = nil
+	encrypted.Tools = nil
+	return c.inner.Append(ctx, &encrypted)
+}
+
+func (c *Conversations) SetActiveLeaf(ctx context.Context, userID, conversationID, messageID string) error {
+	return c.inner.SetActiveLeaf(ctx, userID, conversationID, messageID)
+}
+
+func (c *Conversations) SetTitle(ctx context.Context, userID, conversationID, title string) error {
+	encrypted, err := c.keys.encryptString(ctx, userID, title)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt title: %w", err)
+	}
+	return c.inner.SetTitle(ctx, userID, conversationID, encrypted)
+}
+
+func (c *Conversations) SetArchived(ctx context.Context, userID, conversationID string, archived bool) error {
+	return c.inner.SetArchived(ctx, userID, conversationID, archived)
+}
+
+func (c *Conversations) SetPinned(ctx context.Context, userID, conversationID string, pinned bool) error {
+	return c.inner.SetPinned(ctx, userID, conversationID, pinned)
+}
+
+func (c *Conversations) List(ctx context.Context, userID string, opts store.ListOptions) (*store.ConversationPage, error) {
+	page, err := c.inner.List(ctx, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := &store.ConversationPage{
+		Conversations: make([]*store.Conversation, len(page.Conversations)),
+		NextCursor:    page.NextCursor,
+	}
+	for i, conv := range page.Conversations {
+		cp := *conv
+		if cp.Title, err = c.keys.decryptString(ctx, userID, cp.Title); err != nil {
+			return nil, fmt.Errorf("failed to decrypt title: %w", err)
+		}
+		decrypted.Conversations[i] = &cp
+	}
+	return decrypted, nil
+}
+
+// Search scans the user's conversations in List order, decrypting each,
+// until it has a page of matches. Cursors are store.Cursor values, so the
+// wrapped store must use them for List too, as the SDK's stores do.
+func (c *Conversations) Search(ctx context.Context, userID, query string, opts store.ListOptions) (*store.ConversationPage, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.DecodeCursor(opts); err != nil {
+		return nil, err
+	}
+	terms := store.SearchTerms(query)
+
+	scan := opts
+	scan.Limit = store.MaxListLimit
+	matches := make([]*store.Conversation, 0)
+	for len(matches) <= opts.Limit {
+		page, err := c.List(ctx, userID, scan)
+		if err != nil {
+			return nil, err
+		}
+		for _, conv := range page.Conversations {
+			ok, err := c.matches(ctx, conv, terms)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matches = append(matches, conv)
+			}
+			if len(matches) > opts.Limit {
+				break
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		scan.Cursor = page.NextCursor
+	}
+
+	page := &store.ConversationPage{Conversations: matches}
+	if len(matches) > opts.Limit {
+		page.Conversations = matches[:opts.Limit]
+		page.NextCursor = store.CursorAfter(opts, page.Conversations[opts.Limit-1]).Encode()
+	}
+	return page, nil
+}
+
+// matches reports whether every term appears in the conversation's title or
+// in a message on its active branch. Messages are only decrypted if the
+// title does not match every term.
+func (c *Conversations) matches(ctx context.Context, conv *store.Conversation, terms []string) (bool, error) {
+	title := strings.ToLower(conv.Title)
+	var messages []store.StoredMessage
+	loaded := false
+
+	for _, term := range terms {
+		if strings.Contains(title, term) {
+			continue
+		}
+		if !loaded {
+			full, err := c.Get(ctx, conv.UserID, conv.ID)
+			if errors.Is(err, store.ErrConversationNotFound) {
+				return false, nil // deleted while scanning
+			}
+			if err != nil {
+				return false, err
+			}
+			messages, loaded = full.Messages, true
+		}
+		if !containsTerm(messages, term) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func containsTerm(messages []store.StoredMessage, term string) bool {
+	for i := range messages {
+		if strings.Contains(strings.ToLower(messages[i].Content), term) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conversations) Delete(ctx context.Context, userID, conversationID string) error {
+	return c.inner.Delete(ctx, userID, conversationID)
+}
+
+// Reencrypt rewraps the user's data key with the current key and encrypts
+// the user's conversations on every branch that hold plaintext written
+// before encryption was enabled. It returns the number of conversations
+// rewritten. The wrapped store must implement store.ConversationRewriter.
+func (c *Conversations) Reencrypt(ctx context.Context, userID string) (int, error) {
+	rw, ok := c.inner.(store.ConversationRewriter)
+	if !ok {
+		return 0, ErrRewriteUnsupported
+	}
+	if _, err := c.keys.rewrap(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return rw.RewriteConversations(ctx, userID, func(conv *store.ConversationWithMessages) (bool, error) {
+		changed := false
+		if isPlaintext(conv.Title) {
+			title, err := c.keys.decryptString(ctx, userID, conv.Title)
+			if err != nil {
+				return false, fmt.Errorf("failed to decrypt title: %w", err)
+			}
+			if conv.Title, err = c.keys.encryptString(ctx, userID, title); err != nil {
+				return false, fmt.Errorf("failed to encrypt title: %w", err)
+			}
+			changed = true
+		}
+
+		for i := range conv.Messages {
+			msg := &conv.Messages[i]
+			if !isPlaintext(msg.Content) && len(msg.Blocks) == 0 && len(msg.Tools) == 0 {
+				continue
+			}
+			content, err := c.decryptMessage(ctx, userID, msg)
+			if err != nil {
+				return false, err
+			}
+			if msg.Content, err = c.encryptMessage(ctx, userID, content); err != nil {
+				return false, err
+			}
+			msg.Blocks, msg.Tools = nil, nil
+			changed = true
+		}
+		return changed, nil
+	})
+}
+
+// HealthCheck checks the wrapped store, if it implements
+// store.HealthChecker.
+func (c *Conversations) HealthCheck(ctx context.Context) error {
+	if h, ok := c.inner.(store.HealthChecker); ok {
+		return h.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// PurgeConversations purges old conversations from the wrapped store, or
+// returns store.ErrPurgeUnsupported if it does not implement
+// store.ConversationPurger.
+func (c *Conversations) PurgeConversations(ctx context.Context, before time.Time) (int, error) {
+	p, ok := c.inner.(store.ConversationPurger)
+	if !ok {
+		return 0, store.ErrPurgeUnsupported
+	}
+	return p.PurgeConversations(ctx, before)
+}
+
+// ExportUserData exports the user's conversations from the wrapped store,
+// decrypted. The wrapped store must implement privacy.UserDataHandler.
+func (c *Conversations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	h, ok := c.inner.(privacy.UserDataHandler)
+	if !ok {
+		return nil, errors.New("wrapped store does not implement privacy.UserDataHandler")
+	}
+	data, err := h.ExportUserData(ctx, userID)
+	if err != nil || data == nil {
+		return data, err
+	}
+
+	convs, ok := data.([]store.ConversationWithMessages)
+	if !ok {
+		return c.keys.decryptTree(ctx, userID, data)
+	}
+	export := make([]store.ConversationWithMessages, len(convs))
+	for i := range convs {
+		conv, err := c.decryptConversation(ctx, &convs[i])
+		if err != nil {
+			return nil, err
+		}
+		export[i] = *conv
+	}
+	return export, nil
+}
+
+// EraseUserData deletes the user's data key, then erases the user's
+// conversations from the wrapped store. Without the key, records the wrapped
+// store keeps, and those encrypted by a Confirmations sharing the keyring,
+// cannot be decrypted.
+func (c *Conversations) EraseUserData(ctx context.Context, userID string) error {
+	h, ok := c.inner.(privacy.UserDataHandler)
+	if !ok {
+		return errors.New("wrapped store does not implement privacy.UserDataHandler")
+	}
+	if err := c.keys.erase(ctx, userID); err != nil {
+		return err
+	}
+	return h.EraseUserData(ctx, userID)
+}
+
+// decryptConversation returns a decrypted copy of conv.
+func (c *Conversations) decryptConversation(ctx context.Context, conv *store.ConversationWithMessages) (*store.ConversationWithMessages, error) {
+	userID := conv.UserID
+	title, err := c.keys.decryptString(ctx, userID, conv.Title)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt title: %w", err)
+	}
+
+	decrypted := &store.ConversationWithMessages{
+		Conversation: conv.Conversation,
+		Messages:     make([]store.StoredMessage, len(conv.Messages)),
+	}
+	decrypted.Title = title
+	for i, msg := range conv.Messages {
+		content, err := c.decryptMessage(ctx, userID, &msg)
+		if err != nil {
+			return nil, err
+		}
+		msg.Content, msg.Blocks, msg.Tools = content.Content, content.Blocks, content.Tools
+		decrypted.Messages[i] = msg
+	}
+	return decrypted, nil
+}
+
+func (c *Conversations) encryptMessage(ctx context.Context, userID string, content messageContent) (string, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+	encrypted, err := c.keys.encrypt(ctx, userID, kindJSON, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptMessage returns the message's content, decrypting it if it is
+// encrypted and passing plaintext records through.
+func (c *Conversations) decryptMessage(ctx context.Context, userID string, msg *store.StoredMessage) (messageContent, error) {
+	if !isEncrypted(msg.Content) {
+		return messageContent{Content: msg.Content, Blocks: msg.Blocks, Tools: msg.Tools}, nil
+	}
+
+	_, data, err := c.keys.decrypt(ctx, userID, msg.Content)
+	if err != nil {
+		return messageContent{}, fmt.Errorf("failed to decrypt message %s: %w", msg.ID, err)
+	}
+	var content messageContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return messageContent{}, fmt.Errorf("failed to decode message %s: %w", msg.ID, err)
+	}
+	return content, nil
+}
+
+// Verify Conversations implements the store and privacy interfaces.
+var (
+	_ store.Conversations      = (*Conversations)(nil)
+	_ store.ConversationPurger = (*Conversations)(nil)
+	_ privacy.UserDataHandler  = (*Conversations)(nil)
+)
@@ -0,0 +1,94 @@
+package encstore
+
+import (
+	"context"
+	"sync"
+)
+
+// WrappedKey is a data key encrypted with a KeyProvider key.
+type WrappedKey struct {
+	// KeyID is the ID of the KeyProvider key that wrapped the data key.
+	KeyID string
+
+	// Wrapped is the data key as returned by KeyProvider.WrapKey.
+	Wrapped []byte
+}
+
+// DataKeyStore keeps each user's wrapped data key. Deleting a user's key
+// crypto-shreds their records. The SDK provides MemoryDataKeys for
+// development; sqlstore.DataKeys keeps keys in a table next to the records.
+type DataKeyStore interface {
+	// GetDataKey returns the user's wrapped data key.
+	// Returns nil, nil if the user has none (not an error).
+	GetDataKey(ctx context.Context, userID string) (*WrappedKey, error)
+
+	// CreateDataKey saves key as the user's data key unless they already
+	// have one, and returns the key saved for the user either way.
+	CreateDataKey(ctx context.Context, userID string, key *WrappedKey) (*WrappedKey, error)
+
+	// RewrapDataKey replaces the user's wrapped key old with key, the same
+	// data key wrapped with another KeyProvider key. It does nothing if the
+	// saved key is no longer old.
+	RewrapDataKey(ctx context.Context, userID string, old, key *WrappedKey) error
+
+	// DeleteDataKey deletes the user's data key. Deleting a key that does
+	// not exist is not an error.
+	DeleteDataKey(ctx context.Context, userID string) error
+}
+
+// MemoryDataKeys is an in-memory DataKeyStore. Keys are lost on restart,
+// and with them every record they encrypted, so use it only for
+// development and testing.
+type MemoryDataKeys struct {
+	mu   sync.Mutex
+	keys map[string]WrappedKey // userID -> key
+}
+
+// NewMemoryDataKeys creates an in-memory data key store.
+func NewMemoryDataKeys() *MemoryDataKeys {
+	return &MemoryDataKeys{keys: make(map[string]WrappedKey)}
+}
+
+func (m *MemoryDataKeys) GetDataKey(ctx context.Context, userID string) (*WrappedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (m *MemoryDataKeys) CreateDataKey(ctx context.Context, userID string, key *WrappedKey) (*WrappedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.keys[userID]
+	if !ok {
+		saved = *key
+		m.keys[userID] = saved
+	}
+	return &saved, nil
+}
+
+func (m *MemoryDataKeys) RewrapDataKey(ctx context.Context, userID string, old, key *WrappedKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if saved, ok := m.keys[userID]; ok && saved.KeyID == old.KeyID && string(saved.Wrapped) == string(old.Wrapped) {
+		m.keys[userID] = *key
+	}
+	return nil
+}
+
+func (m *MemoryDataKeys) DeleteDataKey(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, userID)
+	return nil
+}
+
+// Verify MemoryDataKeys implements DataKeyStore.
+var _ DataKeyStore = (*MemoryDataKeys)(nil)
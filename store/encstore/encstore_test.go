@@ -0,0 +1,309 @@
+package encstore_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+	"github.com/becomeliminal/nim-go-sdk/store/encstore"
+	"github.com/becomeliminal/nim-go-sdk/store/storetest"
+)
+
+func TestConversations_Conformance(t *testing.T) {
+	keys := newKeyring(t, "k1")
+	storetest.RunConversationsSuite(t, func(t *testing.T, clock core.Clock) store.Conversations {
+		return encstore.NewConversations(store.NewMemoryConversations(store.WithClock(clock)), keys)
+	})
+}
+
+func TestConfirmations_Conformance(t *testing.T) {
+	keys := newKeyring(t, "k1")
+	storetest.RunConfirmationsSuite(t, func(t *testing.T, clock core.Clock) store.Confirmations {
+		return encstore.NewConfirmations(store.NewMemoryConfirmations(store.WithClock(clock)), keys)
+	})
+}
+
+func TestConversations_EncryptsAtRest(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemoryConversations()
+	s := encstore.NewConversations(inner, newKeyring(t, "k1"))
+
+	conv := mustCreate(t, s, "alice")
+	if err := s.SetTitle(ctx, "alice", conv.ID, "Rent for landlord"); err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID,
+		UserID:         "alice",
+		Role:           "assistant",
+		Content:        "Sending $1200 to your landlord",
+		Blocks:         []core.ContentBlock{{Type: core.TextBlockType, Text: "Sending $1200 to your landlord"}},
+		Tools:          []core.ToolExecution{{Tool: "send_money", Input: map[string]any{"to": "landlord"}}},
+	})
+
+	stored, err := inner.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(stored)
+	if strings.Contains(string(raw), "landlord") || strings.Contains(string(raw), "1200") {
+		t.Errorf("wrapped store holds plaintext: %s", raw)
+	}
+
+	got, err := s.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Title != "Rent for landlord" || got.Messages[0].Content != "Sending $1200 to your landlord" ||
+		len(got.Messages[0].Blocks) != 1 || len(got.Messages[0].Tools) != 1 {
+		t.Errorf("Get() = %+v, want decrypted title and message", got)
+	}
+}
+
+func TestConfirmations_EncryptsAtRest(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemoryConfirmations()
+	s := encstore.NewConfirmations(inner, newKeyring(t, "k1"))
+
+	action := storetest.NewAction("a1", "alice", time.Now(), time.Minute)
+	if err := s.Store(ctx, action); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(action.Input), "@bob") {
+		t.Error("Store() modified the caller's action")
+	}
+
+	stored, err := inner.Get(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stored.Input), "@bob") || strings.Contains(stored.Summary, "@bob") {
+		t.Errorf("wrapped store holds plaintext: %+v", stored)
+	}
+	if !json.Valid(stored.Input) {
+		t.Errorf("stored input %s is not valid JSON", stored.Input)
+	}
+
+	got, err := s.Confirm(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if string(got.Input) != string(action.Input) || got.Summary != action.Summary {
+		t.Errorf("Confirm() = %+v, want %+v", got, action)
+	}
+}
+
+func TestConversations_BindsValuesToUser(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemoryConversations()
+	s := encstore.NewConversations(inner, newKeyring(t, "k1"))
+
+	alice := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: alice.ID, UserID: "alice", Role: "user", Content: "secret"})
+	stored, err := inner.Get(ctx, "alice", alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy alice's ciphertext into bob's conversation
+	bob := mustCreate(t, s, "bob")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: bob.ID, UserID: "bob", Role: "user", Content: "hi"})
+	mustAppend(t, inner, &store.AppendMessage{ConversationID: bob.ID, UserID: "bob", Role: "user", Content: stored.Messages[0].Content})
+
+	if _, err := s.Get(ctx, "bob", bob.ID); !errors.Is(err, encstore.ErrDecrypt) {
+		t.Errorf("Get() of copied ciphertext error = %v, want %v", err, encstore.ErrDecrypt)
+	}
+}
+
+func TestReencrypt_RotatesKeys(t *testing.T) {
+	ctx := context.Background()
+	k1, k2 := mustGenerateKey(t), mustGenerateKey(t)
+	innerConvs := store.NewMemoryConversations()
+	innerActions := store.NewMemoryConfirmations()
+
+	// Records from before encryption, then under k1
+	legacy := mustCreate(t, innerConvs, "alice")
+	mustAppend(t, innerConvs, &store.AppendMessage{ConversationID: legacy.ID, UserID: "alice", Role: "user", Content: "legacy"})
+
+	dataKeys := encstore.NewMemoryDataKeys()
+	old := encstore.NewKeyring(mustParseKeys(t, "k1:"+k1), dataKeys)
+	conv := mustCreate(t, innerConvs, "alice")
+	mustAppend(t, encstore.NewConversations(innerConvs, old), &store.AppendMessage{ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "under k1"})
+	if err := encstore.NewConfirmations(innerActions, old).Store(ctx, storetest.NewAction("a1", "alice", time.Now(), time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: k2 is current, k1 still readable
+	rotated := encstore.NewKeyring(mustParseKeys(t, "k2:"+k2+",k1:"+k1), dataKeys)
+	convs := encstore.NewConversations(innerConvs, rotated)
+	actions := encstore.NewConfirmations(innerActions, rotated)
+	if got, err := convs.Get(ctx, "alice", conv.ID); err != nil || got.Messages[0].Content != "under k1" {
+		t.Fatalf("Get() after rotation = %+v, %v", got, err)
+	}
+
+	// The data key is rewrapped, so only plaintext is rewritten: the legacy
+	// message and both default titles
+	if n, err := convs.Reencrypt(ctx, "alice"); err != nil || n != 2 {
+		t.Fatalf("Conversations.Reencrypt() = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := actions.Reencrypt(ctx, "alice"); err != nil || n != 0 {
+		t.Fatalf("Confirmations.Reencrypt() = %d, %v, want 0, nil", n, err)
+	}
+	if n, err := convs.Reencrypt(ctx, "alice"); err != nil || n != 0 {
+		t.Errorf("second Reencrypt() = %d, %v, want 0, nil", n, err)
+	}
+
+	// Retire k1: everything is readable under k2 alone
+	retired := encstore.NewKeyring(mustParseKeys(t, "k2:"+k2), dataKeys)
+	convs = encstore.NewConversations(innerConvs, retired)
+	for id, want := range map[string]string{legacy.ID: "legacy", conv.ID: "under k1"} {
+		got, err := convs.Get(ctx, "alice", id)
+		if err != nil || got.Messages[0].Content != want {
+			t.Errorf("Get() after retiring k1 = %+v, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := encstore.NewConfirmations(innerActions, retired).Get(ctx, "alice", "a1"); err != nil {
+		t.Errorf("Confirmations.Get() after retiring k1 error = %v", err)
+	}
+
+	stored, _ := innerConvs.Get(ctx, "alice", legacy.ID)
+	if strings.Contains(stored.Messages[0].Content, "legacy") {
+		t.Error("Reencrypt() left a plaintext message")
+	}
+	stale := encstore.NewKeyring(mustParseKeys(t, "k1:"+k1), dataKeys)
+	if _, err := encstore.NewConversations(innerConvs, stale).Get(ctx, "alice", conv.ID); !errors.Is(err, encstore.ErrKeyNotFound) {
+		t.Errorf("Get() without the current key error = %v, want %v", err, encstore.ErrKeyNotFound)
+	}
+}
+
+func TestEraseUserData_ShredsDataKey(t *testing.T) {
+	ctx := context.Background()
+	keys := newKeyring(t, "k1")
+	inner := store.NewMemoryConversations()
+	s := encstore.NewConversations(inner, keys)
+	innerActions := store.NewMemoryConfirmations()
+	actions := encstore.NewConfirmations(innerActions, keys)
+
+	conv := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "secret"})
+	if err := actions.Store(ctx, storetest.NewAction("a1", "alice", time.Now(), time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := inner.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedAction, err := innerActions.Get(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.EraseUserData(ctx, "alice"); err != nil {
+		t.Fatalf("EraseUserData() error = %v", err)
+	}
+
+	// A copy that outlived the erasure, e.g. in a backup, cannot be read
+	restored := mustCreate(t, inner, "alice")
+	mustAppend(t, inner, &store.AppendMessage{ConversationID: restored.ID, UserID: "alice", Role: "user", Content: saved.Messages[0].Content})
+	if _, err := s.Get(ctx, "alice", restored.ID); !errors.Is(err, encstore.ErrDataKeyNotFound) {
+		t.Errorf("Get() of erased user's ciphertext error = %v, want %v", err, encstore.ErrDataKeyNotFound)
+	}
+	if _, err := actions.Get(ctx, "alice", "a1"); !errors.Is(err, encstore.ErrDataKeyNotFound) {
+		t.Errorf("Confirmations.Get() after erasure error = %v, want %v", err, encstore.ErrDataKeyNotFound)
+	}
+
+	// New records get a new key, which does not open the old ones
+	mustAppend(t, s, &store.AppendMessage{ConversationID: restored.ID, UserID: "alice", Role: "user", Content: "hello again"})
+	if _, err := s.Get(ctx, "alice", restored.ID); !errors.Is(err, encstore.ErrDecrypt) {
+		t.Errorf("Get() with a new key error = %v, want %v", err, encstore.ErrDecrypt)
+	}
+	if err := innerActions.Store(ctx, savedAction); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := actions.Get(ctx, "alice", "a1"); !errors.Is(err, encstore.ErrDecrypt) {
+		t.Errorf("Confirmations.Get() with a new key error = %v, want %v", err, encstore.ErrDecrypt)
+	}
+}
+
+func TestParseLocalKeys(t *testing.T) {
+	key := mustGenerateKey(t)
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+
+	tests := []struct {
+		name    string
+		spec    string
+		current string
+		wantErr bool
+	}{
+		{name: "single", spec: "k1:" + key, current: "k1"},
+		{name: "first is current", spec: "k2:" + key + ",k1:" + key, current: "k2"},
+		{name: "file", spec: "# rotated 2026-01\nk2:" + key + "\n\nk1:" + key + "\n", current: "k2"},
+		{name: "empty", spec: "", wantErr: true},
+		{name: "no id", spec: key, wantErr: true},
+		{name: "bad base64", spec: "k1:not base64!", wantErr: true},
+		{name: "short key", spec: "k1:" + short, wantErr: true},
+		{name: "duplicate", spec: "k1:" + key + ",k1:" + key, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := encstore.ParseLocalKeys(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLocalKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := p.CurrentKeyID(context.Background()); got != tt.current {
+				t.Errorf("CurrentKeyID() = %q, want %q", got, tt.current)
+			}
+		})
+	}
+}
+
+// newKeyring returns a keyring with a single new key, id, and in-memory
+// data keys.
+func newKeyring(t *testing.T, id string) *encstore.Keyring {
+	t.Helper()
+	return encstore.NewKeyring(mustParseKeys(t, id+":"+mustGenerateKey(t)), encstore.NewMemoryDataKeys())
+}
+
+func mustParseKeys(t *testing.T, spec string) *encstore.LocalKeyProvider {
+	t.Helper()
+	p, err := encstore.ParseLocalKeys(spec)
+	if err != nil {
+		t.Fatalf("ParseLocalKeys() error = %v", err)
+	}
+	return p
+}
+
+func mustGenerateKey(t *testing.T) string {
+	t.Helper()
+	key, err := encstore.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustCreate(t *testing.T, s store.Conversations, userID string) *store.Conversation {
+	t.Helper()
+	conv, err := s.Create(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return conv
+}
+
+func mustAppend(t *testing.T, s store.Conversations, msg *store.AppendMessage) {
+	t.Helper()
+	if err := s.Append(context.Background(), msg); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+}
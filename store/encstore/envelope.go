@@ -0,0 +1,345 @@
+package encstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrDecrypt is returned when a record cannot be decrypted: it is corrupt,
+// was encrypted for a different user, or was encrypted with a data key the
+// user no longer has.
+var ErrDecrypt = errors.New("decryption failed")
+
+// ErrDataKeyNotFound is returned when a record belongs to a user who has no
+// data key, because it was erased.
+var ErrDataKeyNotFound = errors.New("data key not found")
+
+// prefix marks encrypted values. Values without it are read as plaintext,
+// so stores can be encrypted without migrating existing records first.
+const prefix = "nimenc:v1:"
+
+// Kinds of encrypted value, recorded so exports can restore JSON values.
+const (
+	kindString byte = 's'
+	kindJSON   byte = 'j'
+)
+
+// maxCachedKeys bounds the data key cache. When full it is cleared, which
+// only costs a DataKeyStore and KeyProvider call per active user to refill.
+const maxCachedKeys = 10000
+
+// Keyring encrypts values with per-user data keys. Each user gets one
+// random data key, wrapped with the provider's current key and saved in a
+// DataKeyStore; values hold only a nonce and ciphertext. Deleting the
+// user's data key therefore makes every copy of their values unreadable,
+// including those in backups. Unwrapped keys are cached, so the store and
+// provider are called once per user rather than once per value.
+//
+// Share one Keyring between a Conversations and a Confirmations so a user
+// has a single key. The cache is per process: on other instances an erased
+// user's key stays usable until it is evicted or the instance restarts.
+type Keyring struct {
+	keys     KeyProvider
+	dataKeys DataKeyStore
+
+	mu    sync.Mutex
+	cache map[string]cipher.AEAD // userID -> data key
+}
+
+// NewKeyring creates a keyring that keeps data keys in dataKeys, wrapped
+// with keys.
+func NewKeyring(keys KeyProvider, dataKeys DataKeyStore) *Keyring {
+	return &Keyring{
+		keys:     keys,
+		dataKeys: dataKeys,
+		cache:    make(map[string]cipher.AEAD),
+	}
+}
+
+// encrypt returns the encrypted form of plaintext, bound to the user.
+//
+// The result is prefix followed by base64 of: kind (1) | nonce | ciphertext.
+func (k *Keyring) encrypt(ctx context.Context, userID string, kind byte, plaintext []byte) (string, error) {
+	aead, err := k.dataKey(ctx, userID, true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, plaintext, valueAAD(userID, kind))
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawStdEncoding.EncodeToString(append([]byte{kind}, sealed...)), nil
+}
+
+// decrypt reverses encrypt.
+func (k *Keyring) decrypt(ctx context.Context, userID, value string) (byte, []byte, error) {
+	buf, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil || len(buf) < 1 {
+		return 0, nil, ErrDecrypt
+	}
+	aead, err := k.dataKey(ctx, userID, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	plaintext, err := open(aead, buf[1:], valueAAD(userID, buf[0]))
+	if err != nil {
+		return 0, nil, err
+	}
+	return buf[0], plaintext, nil
+}
+
+// dataKey returns the user's data key. If the user has none, it creates
+// one if create is set and returns ErrDataKeyNotFound otherwise.
+func (k *Keyring) dataKey(ctx context.Context, userID string, create bool) (cipher.AEAD, error) {
+	k.mu.Lock()
+	aead, ok := k.cache[userID]
+	k.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	wrapped, err := k.dataKeys.GetDataKey(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %w", err)
+	}
+	var key []byte
+	switch {
+	case wrapped == nil && !create:
+		return nil, fmt.Errorf("%w: %s", ErrDataKeyNotFound, userID)
+	case wrapped == nil:
+		if key, wrapped, err = k.newDataKey(ctx, userID); err != nil {
+			return nil, err
+		}
+	default:
+		if key, err = k.unwrap(ctx, userID, wrapped); err != nil {
+			return nil, err
+		}
+	}
+	if aead, err = newGCM(key); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	if len(k.cache) >= maxCachedKeys {
+		clear(k.cache)
+	}
+	k.cache[userID] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// newDataKey creates and saves a data key for the user. If another caller
+// saved one first, that key is returned instead.
+func (k *Keyring) newDataKey(ctx context.Context, userID string) ([]byte, *WrappedKey, error) {
+	keyID, err := k.keys.CurrentKeyID(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get current key: %w", err)
+	}
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := k.keys.WrapKey(ctx, keyID, key, keyAAD(userID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	ours := &WrappedKey{KeyID: keyID, Wrapped: wrapped}
+	saved, err := k.dataKeys.CreateDataKey(ctx, userID, ours)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to save data key: %w", err)
+	}
+	if saved.KeyID == ours.KeyID && bytes.Equal(saved.Wrapped, ours.Wrapped) {
+		return key, saved, nil
+	}
+	key, err = k.unwrap(ctx, userID, saved)
+	return key, saved, err
+}
+
+func (k *Keyring) unwrap(ctx context.Context, userID string, wrapped *WrappedKey) ([]byte, error) {
+	key, err := k.keys.UnwrapKey(ctx, wrapped.KeyID, wrapped.Wrapped, keyAAD(userID))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return key, nil
+}
+
+// rewrap wraps the user's data key with the provider's current key, if it
+// is wrapped with another, and reports whether it did. The data key itself
+// is unchanged, so values encrypted with it need no rewriting.
+func (k *Keyring) rewrap(ctx context.Context, userID string) (bool, error) {
+	keyID, err := k.keys.CurrentKeyID(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get current key: %w", err)
+	}
+	old, err := k.dataKeys.GetDataKey(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load data key: %w", err)
+	}
+	if old == nil || old.KeyID == keyID {
+		return false, nil
+	}
+
+	key, err := k.unwrap(ctx, userID, old)
+	if err != nil {
+		return false, err
+	}
+	wrapped, err := k.keys.WrapKey(ctx, keyID, key, keyAAD(userID))
+	if err != nil {
+		return false, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if err := k.dataKeys.RewrapDataKey(ctx, userID, old, &WrappedKey{KeyID: keyID, Wrapped: wrapped}); err != nil {
+		return false, fmt.Errorf("failed to save data key: %w", err)
+	}
+	return true, nil
+}
+
+// erase deletes the user's data key, leaving any of their values that
+// survive unreadable.
+func (k *Keyring) erase(ctx context.Context, userID string) error {
+	k.mu.Lock()
+	delete(k.cache, userID)
+	k.mu.Unlock()
+	if err := k.dataKeys.DeleteDataKey(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+	return nil
+}
+
+// encryptString and decryptString handle string fields. Empty strings
+// are left as they are.
+func (k *Keyring) encryptString(ctx context.Context, userID, s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	return k.encrypt(ctx, userID, kindString, []byte(s))
+}
+
+func (k *Keyring) decryptString(ctx context.Context, userID, s string) (string, error) {
+	if !isEncrypted(s) {
+		return s, nil
+	}
+	_, plaintext, err := k.decrypt(ctx, userID, s)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// encryptJSON and decryptJSON handle JSON fields, storing the encrypted
+// value as a JSON string so it stays valid JSON.
+func (k *Keyring) encryptJSON(ctx context.Context, userID string, raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	value, err := k.encrypt(ctx, userID, kindJSON, raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func (k *Keyring) decryptJSON(ctx context.Context, userID string, raw json.RawMessage) (json.RawMessage, error) {
+	value, ok := encryptedJSON(raw)
+	if !ok {
+		return raw, nil
+	}
+	_, plaintext, err := k.decrypt(ctx, userID, value)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// decryptTree decrypts every encrypted string in a JSON-serializable value,
+// for store exports whose types this package does not know.
+func (k *Keyring) decryptTree(ctx context.Context, userID string, v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return k.walk(ctx, userID, tree)
+}
+
+func (k *Keyring) walk(ctx context.Context, userID string, v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, child := range v {
+			decrypted, err := k.walk(ctx, userID, child)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = decrypted
+		}
+	case []any:
+		for i, child := range v {
+			decrypted, err := k.walk(ctx, userID, child)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = decrypted
+		}
+	case string:
+		if !isEncrypted(v) {
+			return v, nil
+		}
+		kind, plaintext, err := k.decrypt(ctx, userID, v)
+		if err != nil {
+			return nil, err
+		}
+		if kind == kindJSON {
+			return json.RawMessage(plaintext), nil
+		}
+		return string(plaintext), nil
+	}
+	return v, nil
+}
+
+// isPlaintext reports whether a value has yet to be encrypted.
+func isPlaintext(value string) bool {
+	return value != "" && !isEncrypted(value)
+}
+
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// encryptedJSON returns the encrypted value held in a JSON string.
+func encryptedJSON(raw json.RawMessage) (string, bool) {
+	if len(raw) < len(prefix)+2 || raw[0] != '"' {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil || !isEncrypted(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// keyAAD binds a wrapped data key to its user, so it cannot be copied into
+// another user's records.
+func keyAAD(userID string) []byte {
+	return []byte("nim data key\x00" + userID)
+}
+
+// valueAAD binds an encrypted value to its user and kind.
+func valueAAD(userID string, kind byte) []byte {
+	return []byte("nim value\x00" + string(kind) + "\x00" + userID)
+}
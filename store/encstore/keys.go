@@ -0,0 +1,198 @@
+package encstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrKeyNotFound is returned when a record was encrypted under a key the
+// KeyProvider no longer has.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyProvider holds the key-encryption keys that wrap per-user data keys.
+// Implement it with a KMS to keep key material out of the process; the
+// SDK provides LocalKeyProvider for keys supplied by file or environment.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key new data keys are wrapped with.
+	CurrentKeyID(ctx context.Context) (string, error)
+
+	// WrapKey encrypts a data key under the key keyID. aad must be supplied
+	// unchanged to UnwrapKey.
+	WrapKey(ctx context.Context, keyID string, dataKey, aad []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey. Returns an error
+	// wrapping ErrKeyNotFound if keyID is unknown.
+	UnwrapKey(ctx context.Context, keyID string, wrapped, aad []byte) ([]byte, error)
+}
+
+// KeySize is the size of local keys and data keys: AES-256.
+const KeySize = 32
+
+// LocalKeyProvider wraps data keys with AES-256-GCM keys held in memory.
+// Rotate by adding a new key and making it current; keep old keys until
+// Reencrypt has moved every record off them.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider creates a provider from 32-byte keys by ID.
+// currentID names the key new data is encrypted with.
+func NewLocalKeyProvider(currentID string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{current: currentID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if err := validKeyID(id); err != nil {
+			return nil, err
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %q is %d bytes, want %d", id, len(key), KeySize)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[id] = aead
+	}
+	if _, ok := p.keys[currentID]; !ok {
+		return nil, fmt.Errorf("current key %q is not among the keys", currentID)
+	}
+	return p, nil
+}
+
+// ParseLocalKeys creates a provider from a list of "id:base64key" entries
+// separated by commas or newlines. The first entry is the current key.
+// Blank lines and lines starting with '#' are ignored.
+func ParseLocalKeys(spec string) (*LocalKeyProvider, error) {
+	var current string
+	keys := make(map[string][]byte)
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry %q is not id:base64key", line)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		if current == "" {
+			current = id
+		}
+		keys[id] = key
+	}
+	if current == "" {
+		return nil, errors.New("no keys")
+	}
+	return NewLocalKeyProvider(current, keys)
+}
+
+// LocalKeysFromEnv parses the keys in the environment variable name
+// (see ParseLocalKeys).
+func LocalKeysFromEnv(name string) (*LocalKeyProvider, error) {
+	spec := os.Getenv(name)
+	if spec == "" {
+		return nil, fmt.Errorf("%s is not set", name)
+	}
+	p, err := ParseLocalKeys(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+// LocalKeysFromFile parses the keys in a file, one entry per line
+// (see ParseLocalKeys).
+func LocalKeysFromFile(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	p, err := ParseLocalKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// GenerateKey returns a new random key in the base64 form ParseLocalKeys expects.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (p *LocalKeyProvider) CurrentKeyID(ctx context.Context) (string, error) {
+	return p.current, nil
+}
+
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey, aad []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	return seal(aead, dataKey, aad)
+}
+
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped, aad []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	return open(aead, wrapped, aad)
+}
+
+// validKeyID keeps key IDs short enough for the record header and free
+// of the separators ParseLocalKeys uses.
+func validKeyID(id string) error {
+	if id == "" || len(id) > 255 || strings.ContainsAny(id, ":,\n") {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, returning nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Verify LocalKeyProvider implements KeyProvider.
+var _ KeyProvider = (*LocalKeyProvider)(nil)
@@ -0,0 +1,11 @@
+package store
+
+import "context"
+
+// HealthChecker is implemented by stores backed by a service that can
+// become unreachable, such as a database, so servers can report whether
+// they are ready for traffic. In-memory stores do not implement it.
+type HealthChecker interface {
+	// HealthCheck returns an error if the store cannot serve requests.
+	HealthCheck(ctx context.Context) error
+}
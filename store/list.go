@@ -0,0 +1,183 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidListOptions is returned when ListOptions has an unknown sort
+// field or archive filter.
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ErrInvalidCursor is returned when a pagination cursor is malformed or was
+// issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	// DefaultListLimit is the page size used when ListOptions.Limit is not set.
+	DefaultListLimit = 20
+
+	// MaxListLimit caps ListOptions.Limit.
+	MaxListLimit = 100
+)
+
+// SortField selects the timestamp conversations are ordered by.
+// Pinned conversations always come first, and ties are broken by ID.
+type SortField string
+
+const (
+	// SortByUpdated orders by most recent activity. This is the default.
+	SortByUpdated SortField = "updated"
+
+	// SortByCreated orders by creation time, newest first.
+	SortByCreated SortField = "created"
+)
+
+// ArchiveFilter selects conversations by their archived flag.
+type ArchiveFilter string
+
+const (
+	// ExcludeArchived returns only active conversations. This is the default.
+	ExcludeArchived ArchiveFilter = ""
+
+	// OnlyArchived returns only archived conversations.
+	OnlyArchived ArchiveFilter = "only"
+
+	// IncludeArchived returns both.
+	IncludeArchived ArchiveFilter = "include"
+)
+
+// ListOptions controls List and Search.
+type ListOptions struct {
+	// Limit is the maximum number of conversations to return.
+	// Defaults to DefaultListLimit and is capped at MaxListLimit.
+	Limit int
+
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	Cursor string
+
+	// Sort selects the ordering. Defaults to SortByUpdated.
+	Sort SortField
+
+	// Archived selects which conversations are returned.
+	Archived ArchiveFilter
+}
+
+// Normalize fills in defaults and validates the options.
+func (o ListOptions) Normalize() (ListOptions, error) {
+	switch {
+	case o.Limit <= 0:
+		o.Limit = DefaultListLimit
+	case o.Limit > MaxListLimit:
+		o.Limit = MaxListLimit
+	}
+	switch o.Sort {
+	case "":
+		o.Sort = SortByUpdated
+	case SortByUpdated, SortByCreated:
+	default:
+		return o, fmt.Errorf("%w: unknown sort field %q", ErrInvalidListOptions, o.Sort)
+	}
+	switch o.Archived {
+	case ExcludeArchived, OnlyArchived, IncludeArchived:
+	default:
+		return o, fmt.Errorf("%w: unknown archive filter %q", ErrInvalidListOptions, o.Archived)
+	}
+	return o, nil
+}
+
+// Matches reports whether the conversation passes the archive filter.
+func (o ListOptions) Matches(conv *Conversation) bool {
+	switch o.Archived {
+	case OnlyArchived:
+		return conv.Archived
+	case IncludeArchived:
+		return true
+	default:
+		return !conv.Archived
+	}
+}
+
+// SortKey returns the timestamp the conversation is ordered by.
+func (o ListOptions) SortKey(conv *Conversation) time.Time {
+	if o.Sort == SortByCreated {
+		return conv.CreatedAt
+	}
+	return conv.UpdatedAt
+}
+
+// ConversationPage is one page of List or Search results.
+type ConversationPage struct {
+	Conversations []*Conversation `json:"conversations"`
+
+	// NextCursor fetches the following page. Empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Cursor is the position of the last conversation on a page. Stores encode
+// it into ConversationPage.NextCursor and decode it from ListOptions.Cursor;
+// clients treat the encoded form as opaque.
+type Cursor struct {
+	Sort   SortField
+	Pinned bool
+	Key    time.Time
+	ID     string
+}
+
+// CursorAfter returns the cursor positioned at conv.
+func CursorAfter(opts ListOptions, conv *Conversation) Cursor {
+	return Cursor{Sort: opts.Sort, Pinned: conv.Pinned, Key: opts.SortKey(conv), ID: conv.ID}
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c Cursor) Encode() string {
+	pinned := "0"
+	if c.Pinned {
+		pinned = "1"
+	}
+	raw := strings.Join([]string{string(c.Sort), pinned, strconv.FormatInt(c.Key.UnixNano(), 10), c.ID}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses the cursor in opts, which must already be normalized.
+// It returns false if opts has no cursor.
+func DecodeCursor(opts ListOptions) (Cursor, bool, error) {
+	if opts.Cursor == "" {
+		return Cursor{}, false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 4)
+	if len(parts) != 4 || SortField(parts[0]) != opts.Sort || (parts[1] != "0" && parts[1] != "1") {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+	return Cursor{Sort: opts.Sort, Pinned: parts[1] == "1", Key: time.Unix(0, nanos), ID: parts[3]}, true, nil
+}
+
+// Before reports whether a conversation at (pinned, key, id) sorts before
+// the cursor, i.e. was already returned on an earlier page.
+func (c Cursor) Before(pinned bool, key time.Time, id string) bool {
+	if pinned != c.Pinned {
+		return pinned
+	}
+	if !key.Equal(c.Key) {
+		return key.After(c.Key)
+	}
+	return id >= c.ID
+}
+
+// SearchTerms splits a query into lower-cased terms. A conversation matches
+// when every term appears in its title or in the text of one of its messages.
+func SearchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPurgeUnsupported is returned by store wrappers, such as encstore's,
+// when the store they wrap cannot purge.
+var ErrPurgeUnsupported = errors.New("wrapped store does not support purging")
+
+// ConversationPurger is implemented by conversation stores that can delete
+// old conversations in bulk, for retention policies.
+type ConversationPurger interface {
+	// PurgeConversations deletes every user's conversations, with all their
+	// messages, that were last updated before the cutoff. Returns the number
+	// of conversations deleted.
+	PurgeConversations(ctx context.Context, before time.Time) (int, error)
+}
+
+// ActionPurger is implemented by confirmation stores that keep resolved
+// actions as history, so that history can be bounded. Stores that delete
+// actions when they are resolved or expire do not need it.
+type ActionPurger interface {
+	// PurgeActions deletes resolved and expired actions that were resolved
+	// before the cutoff. Pending actions are never deleted. Returns the
+	// number of actions deleted.
+	PurgeActions(ctx context.Context, before time.Time) (int, error)
+}
+
+func (m *MemoryConversations) PurgeConversations(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for userID, ids := range m.byUser {
+		kept := ids[:0]
+		for _, id := range ids {
+			conv, ok := m.conversations[id]
+			if ok && conv.UpdatedAt.Before(before) {
+				delete(m.conversations, id)
+				purged++
+				continue
+			}
+			kept = append(kept, id)
+		}
+		if len(kept) == 0 {
+			delete(m.byUser, userID)
+		} else {
+			m.byUser[userID] = kept
+		}
+	}
+	return purged, nil
+}
+
+// Verify MemoryConversations implements ConversationPurger.
+var _ ConversationPurger = (*MemoryConversations)(nil)
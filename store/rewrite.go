@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// ConversationRewriter is implemented by conversation stores that can
+// rewrite stored content in place, for maintenance such as re-encrypting
+// records after a key rotation.
+type ConversationRewriter interface {
+	// RewriteConversations calls fn with each of the user's conversations
+	// and the messages on all of its branches. If fn returns true, the
+	// conversation's Title and its messages' Content, Blocks and Tools are
+	// saved; nothing else changes, including timestamps. fn must not call
+	// back into the store. Returns the number of conversations saved.
+	RewriteConversations(ctx context.Context, userID string, fn func(conv *ConversationWithMessages) (bool, error)) (int, error)
+}
+
+// ActionRewriter is implemented by confirmation stores that can rewrite
+// stored actions in place.
+type ActionRewriter interface {
+	// RewriteActions calls fn with each of the user's stored actions,
+	// including resolved ones the store keeps for history. If fn returns
+	// true, the action's Input and Summary are saved; nothing else changes.
+	// fn must not call back into the store. Returns the number of actions
+	// saved.
+	RewriteActions(ctx context.Context, userID string, fn func(action *core.PendingAction) (bool, error)) (int, error)
+}
+
+func (m *MemoryConversations) RewriteConversations(ctx context.Context, userID string, fn func(conv *ConversationWithMessages) (bool, error)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := 0
+	for _, id := range m.byUser[userID] {
+		conv, ok := m.conversations[id]
+		if !ok {
+			continue
+		}
+
+		// fn works on a copy so a failed rewrite leaves the store untouched
+		cp := &ConversationWithMessages{
+			Conversation: conv.Conversation,
+			Messages:     append([]StoredMessage(nil), conv.Messages...),
+		}
+		changed, err := fn(cp)
+		if err != nil {
+			return saved, err
+		}
+		if !changed {
+			continue
+		}
+
+		conv.Title = cp.Title
+		messages := append([]StoredMessage(nil), conv.Messages...)
+		for i := range messages {
+			if i < len(cp.Messages) && cp.Messages[i].ID == messages[i].ID {
+				messages[i].Content = cp.Messages[i].Content
+				messages[i].Blocks = cp.Messages[i].Blocks
+				messages[i].Tools = cp.Messages[i].Tools
+			}
+		}
+		conv.Messages = messages
+		saved++
+	}
+	return saved, nil
+}
+
+func (m *MemoryConfirmations) RewriteActions(ctx context.Context, userID string, fn func(action *core.PendingAction) (bool, error)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := 0
+	for id, action := range m.actions {
+		if action.UserID != userID {
+			continue
+		}
+
+		// Replace rather than modify: Get hands out the stored pointer
+		cp := *action
+		changed, err := fn(&cp)
+		if err != nil {
+			return saved, err
+		}
+		if changed {
+			m.actions[id] = &cp
+			saved++
+		}
+	}
+	return saved, nil
+}
+
+func (r *RistrettoConfirmations) RewriteActions(ctx context.Context, userID string, fn func(action *core.PendingAction) (bool, error)) (int, error) {
+	r.resolveMu.Lock()
+	defer r.resolveMu.Unlock()
+
+	saved := 0
+	now := r.clock.Now().Unix()
+	for _, action := range r.userActions(userID) {
+		if action.ExpiresAt < now {
+			continue
+		}
+
+		cp := *action
+		changed, err := fn(&cp)
+		if err != nil {
+			return saved, err
+		}
+		if changed {
+			r.cache.SetWithTTL(r.actionKey(userID, cp.ID), &cp, 1, r.ttlFor(&cp))
+			saved++
+		}
+	}
+	r.cache.Wait()
+	return saved, nil
+}
+
+// Verify the in-memory stores implement the rewriter interfaces.
+var (
+	_ ConversationRewriter = (*MemoryConversations)(nil)
+	_ ActionRewriter       = (*MemoryConfirmations)(nil)
+	_ ActionRewriter       = (*RistrettoConfirmations)(nil)
+)
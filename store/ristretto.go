@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -17,8 +18,10 @@ type RistrettoConfirmations struct {
 	cache         *ristretto.Cache
 	idempotency   *ristretto.Cache
 	defaultTTL    time.Duration
+	resolveMu     sync.Mutex // serializes Confirm and Cancel so each action resolves once
 	mu            sync.RWMutex
-	actionsByUser map[string]map[string]struct{} // userID -> set of actionIDs
+	actionsByUser map[string]map[string]int64 // userID -> actionID -> expiry (unix seconds)
+	clock         core.Clock
 }
 
 // RistrettoConfig configures the Ristretto confirmations store.
@@ -31,6 +34,8 @@ type RistrettoConfig struct {
 	BufferItems int64
 	// DefaultTTL is the default expiration time for pending actions.
 	DefaultTTL time.Duration
+	// Clock is the time source for expiry checks. Defaults to core.SystemClock.
+	Clock core.Clock
 }
 
 // DefaultRistrettoConfig returns sensible defaults for a confirmation store.
@@ -71,7 +76,8 @@ func NewRistrettoConfirmations(cfg *RistrettoConfig) (*RistrettoConfirmations, e
 		cache:         cache,
 		idempotency:   idempotency,
 		defaultTTL:    cfg.DefaultTTL,
-		actionsByUser: make(map[string]map[string]struct{}),
+		actionsByUser: make(map[string]map[string]int64),
+		clock:         core.ClockOrDefault(cfg.Clock),
 	}, nil
 }
 
@@ -88,12 +94,12 @@ func (r *RistrettoConfirmations) Store(ctx context.Context, action *core.Pending
 		r.idempotency.SetWithTTL(idempKey, action.ID, 1, ttl)
 	}
 
-	// Track action for user (for cleanup)
+	// Track action for user (for cleanup, and to tell expiry from absence)
 	r.mu.Lock()
 	if r.actionsByUser[action.UserID] == nil {
-		r.actionsByUser[action.UserID] = make(map[string]struct{})
+		r.actionsByUser[action.UserID] = make(map[string]int64)
 	}
-	r.actionsByUser[action.UserID][action.ID] = struct{}{}
+	r.actionsByUser[action.UserID][action.ID] = r.clock.Now().Add(ttl).Unix()
 	r.mu.Unlock()
 
 	// Wait for value to be set
@@ -107,13 +113,17 @@ func (r *RistrettoConfirmations) Get(ctx context.Context, userID, actionID strin
 	key := r.actionKey(userID, actionID)
 	val, found := r.cache.Get(key)
 	if !found {
-		return nil, fmt.Errorf("action not found: %s", actionID)
+		// Ristretto drops entries once their TTL passes, so a tracked
+		// action that is gone has expired rather than never existed
+		if r.evictedExpired(userID, actionID) {
+			return nil, fmt.Errorf("%w: %s", ErrActionExpired, actionID)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
 	}
 
 	action := val.(*core.PendingAction)
-	if action.ExpiresAt < time.Now().Unix() {
-		r.delete(action)
-		return nil, fmt.Errorf("action expired: %s", actionID)
+	if action.ExpiresAt < r.clock.Now().Unix() {
+		return nil, fmt.Errorf("%w: %s", ErrActionExpired, actionID)
 	}
 
 	return action, nil
@@ -137,9 +147,36 @@ func (r *RistrettoConfirmations) GetByIdempotency(ctx context.Context, userID, k
 	return action, nil
 }
 
+func (r *RistrettoConfirmations) GetByBlock(ctx context.Context, userID, sessionID, blockID string) (*core.PendingAction, error) {
+	r.mu.RLock()
+	actionIDs := make([]string, 0, len(r.actionsByUser[userID]))
+	for actionID := range r.actionsByUser[userID] {
+		actionIDs = append(actionIDs, actionID)
+	}
+	r.mu.RUnlock()
+
+	var found *core.PendingAction
+	for _, actionID := range actionIDs {
+		action, err := r.Get(ctx, userID, actionID)
+		if err != nil || action.SessionID != sessionID || action.BlockID != blockID {
+			continue
+		}
+		if found == nil || action.CreatedAt > found.CreatedAt {
+			found = action
+		}
+	}
+	return found, nil
+}
+
 func (r *RistrettoConfirmations) Confirm(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
+	r.resolveMu.Lock()
+	defer r.resolveMu.Unlock()
+
 	action, err := r.Get(ctx, userID, actionID)
 	if err != nil {
+		if errors.Is(err, ErrActionExpired) {
+			r.forget(userID, actionID)
+		}
 		return nil, err
 	}
 
@@ -148,8 +185,14 @@ func (r *RistrettoConfirmations) Confirm(ctx context.Context, userID, actionID s
 }
 
 func (r *RistrettoConfirmations) Cancel(ctx context.Context, userID, actionID string) error {
+	r.resolveMu.Lock()
+	defer r.resolveMu.Unlock()
+
 	action, err := r.Get(ctx, userID, actionID)
 	if err != nil {
+		if errors.Is(err, ErrActionExpired) {
+			r.forget(userID, actionID)
+		}
 		return err
 	}
 
@@ -164,7 +207,7 @@ func (r *RistrettoConfirmations) Cleanup(ctx context.Context) (int, error) {
 	defer r.mu.Unlock()
 
 	count := 0
-	now := time.Now().Unix()
+	now := r.clock.Now().Unix()
 
 	for userID, actions := range r.actionsByUser {
 		for actionID := range actions {
@@ -219,6 +262,34 @@ func (r *RistrettoConfirmations) delete(action *core.PendingAction) {
 	r.mu.Unlock()
 }
 
+// evictedExpired reports whether an action missing from the cache is
+// still tracked with an expiry that has passed.
+func (r *RistrettoConfirmations) evictedExpired(userID, actionID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	expiresAt, ok := r.actionsByUser[userID][actionID]
+	return ok && expiresAt <= r.clock.Now().Unix()
+}
+
+// forget removes an expired action, whether or not Ristretto has already
+// evicted it, so its expiry is reported once.
+func (r *RistrettoConfirmations) forget(userID, actionID string) {
+	if val, found := r.cache.Get(r.actionKey(userID, actionID)); found {
+		r.delete(val.(*core.PendingAction))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if actions, ok := r.actionsByUser[userID]; ok {
+		delete(actions, actionID)
+		if len(actions) == 0 {
+			delete(r.actionsByUser, userID)
+		}
+	}
+}
+
 func (r *RistrettoConfirmations) actionKey(userID, actionID string) string {
 	return userID + ":" + actionID
 }
@@ -229,7 +300,7 @@ func (r *RistrettoConfirmations) idempotencyKey(userID, key string) string {
 
 func (r *RistrettoConfirmations) ttlFor(action *core.PendingAction) time.Duration {
 	if action.ExpiresAt > 0 {
-		ttl := time.Until(time.Unix(action.ExpiresAt, 0))
+		ttl := time.Unix(action.ExpiresAt, 0).Sub(r.clock.Now())
 		if ttl > 0 {
 			return ttl
 		}
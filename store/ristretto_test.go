@@ -0,0 +1,52 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+func TestRistrettoConfirmations_EvictedActionExpires(t *testing.T) {
+	ctx := context.Background()
+	clock := core.NewFakeClock(time.Now())
+	cfg := store.DefaultRistrettoConfig()
+	cfg.Clock = clock
+	s, err := store.NewRistrettoConfirmations(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expiresAt := clock.Now().Add(time.Second).Unix()
+	for _, id := range []string{"a1", "a2"} {
+		if err := s.Store(ctx, &core.PendingAction{ID: id, UserID: "u1", ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Ristretto evicts by wall-clock TTL, before the store's clock checks
+	time.Sleep(time.Until(time.Unix(expiresAt, 0)) + 50*time.Millisecond)
+	clock.Advance(2 * time.Second)
+
+	// Get leaves the action for Confirm or Cancel to resolve
+	if _, err := s.Get(ctx, "u1", "a1"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Get after TTL eviction: err = %v, want ErrActionExpired", err)
+	}
+	if _, err := s.Confirm(ctx, "u1", "a1"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Confirm after TTL eviction: err = %v, want ErrActionExpired", err)
+	}
+	if err := s.Cancel(ctx, "u1", "a2"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Cancel after TTL eviction: err = %v, want ErrActionExpired", err)
+	}
+	// Resolving forgets the action
+	if _, err := s.Get(ctx, "u1", "a1"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("second Get: err = %v, want ErrActionNotFound", err)
+	}
+	if _, err := s.Get(ctx, "u2", "a2"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Get as another user: err = %v, want ErrActionNotFound", err)
+	}
+}
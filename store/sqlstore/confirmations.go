@@ -0,0 +1,230 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// Action statuses. Resolved actions are kept for history rather than deleted.
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// Confirmations is a SQL implementation of store.Confirmations.
+type Confirmations struct {
+	db      *sql.DB
+	dialect Dialect
+	clock   core.Clock
+}
+
+// NewConfirmations creates a confirmation store. Run Migrate first.
+func NewConfirmations(db *sql.DB, dialect Dialect, opts ...Option) *Confirmations {
+	o := applyOptions(opts)
+	return &Confirmations{db: db, dialect: dialect, clock: o.clock}
+}
+
+const actionColumns = `id, user_id, session_id, idempotency_key, tool, input, summary, block_id, created_at, expires_at, status`
+
+func (c *Confirmations) Store(ctx context.Context, action *core.PendingAction) error {
+	_, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`INSERT INTO pending_actions (`+actionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		action.ID, action.UserID, action.SessionID, action.IdempotencyKey, action.Tool,
+		string(action.Input), action.Summary, action.BlockID, action.CreatedAt, action.ExpiresAt,
+		StatusPending,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store action: %w", err)
+	}
+	return nil
+}
+
+func (c *Confirmations) Get(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
+	action, status, err := c.load(ctx, c.db, userID, actionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pendingErr(action, status, c.clock.Now().Unix()); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+func (c *Confirmations) GetByIdempotency(ctx context.Context, userID, key string) (*core.PendingAction, error) {
+	row := c.db.QueryRowContext(ctx, c.dialect.Rebind(
+		`SELECT `+actionColumns+` FROM pending_actions
+		WHERE user_id = ? AND idempotency_key = ? AND status = ? AND expires_at >= ?
+		ORDER BY created_at DESC LIMIT 1`),
+		userID, key, StatusPending, c.clock.Now().Unix(),
+	)
+	action, _, err := scanAction(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+func (c *Confirmations) GetByBlock(ctx context.Context, userID, sessionID, blockID string) (*core.PendingAction, error) {
+	row := c.db.QueryRowContext(ctx, c.dialect.Rebind(
+		`SELECT `+actionColumns+` FROM pending_actions
+		WHERE user_id = ? AND session_id = ? AND block_id = ? AND status = ? AND expires_at >= ?
+		ORDER BY created_at DESC LIMIT 1`),
+		userID, sessionID, blockID, StatusPending, c.clock.Now().Unix(),
+	)
+	action, _, err := scanAction(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// Confirm atomically moves a pending action to confirmed. Concurrent calls
+// for the same action succeed at most once.
+func (c *Confirmations) Confirm(ctx context.Context, userID, actionID string) (*core.PendingAction, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := c.clock.Now().Unix()
+	res, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE pending_actions SET status = ?, resolved_at = ?
+		WHERE id = ? AND user_id = ? AND status = ? AND expires_at >= ?`),
+		StatusConfirmed, now, actionID, userID, StatusPending, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm action: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	action, status, err := c.load(ctx, tx, userID, actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		// Not confirmable: report why, recording expiry for history.
+		err := pendingErr(action, status, now)
+		if err == nil {
+			err = fmt.Errorf("%w: %s", store.ErrActionNotFound, actionID)
+		}
+		if errors.Is(err, store.ErrActionExpired) && status == StatusPending {
+			if err := c.resolve(ctx, tx, actionID, StatusExpired, now); err != nil {
+				return nil, err
+			}
+			if err := tx.Commit(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to confirm action: %w", err)
+	}
+	return action, nil
+}
+
+func (c *Confirmations) Cancel(ctx context.Context, userID, actionID string) error {
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE pending_actions SET status = ?, resolved_at = ?
+		WHERE id = ? AND user_id = ? AND status = ?`),
+		StatusCancelled, c.clock.Now().Unix(), actionID, userID, StatusPending,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel action: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", store.ErrActionNotFound, actionID)
+	}
+	return nil
+}
+
+// Cleanup marks expired pending actions as expired. The rows are kept for history.
+func (c *Confirmations) Cleanup(ctx context.Context) (int, error) {
+	now := c.clock.Now().Unix()
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE pending_actions SET status = ?, resolved_at = ? WHERE status = ? AND expires_at < ?`),
+		StatusExpired, now, StatusPending, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire actions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// load returns the action owned by the user, regardless of status.
+func (c *Confirmations) load(ctx context.Context, q querier, userID, actionID string) (*core.PendingAction, string, error) {
+	row := q.QueryRowContext(ctx, c.dialect.Rebind(
+		`SELECT `+actionColumns+` FROM pending_actions WHERE id = ? AND user_id = ?`),
+		actionID, userID,
+	)
+	action, status, err := scanAction(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("%w: %s", store.ErrActionNotFound, actionID)
+	}
+	return action, status, err
+}
+
+func (c *Confirmations) resolve(ctx context.Context, tx *sql.Tx, actionID, status string, at int64) error {
+	_, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE pending_actions SET status = ?, resolved_at = ? WHERE id = ?`),
+		status, at, actionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update action status: %w", err)
+	}
+	return nil
+}
+
+// pendingErr reports whether an action can still be acted on.
+// Resolved actions look the same as missing ones, as in the memory store.
+func pendingErr(action *core.PendingAction, status string, now int64) error {
+	switch {
+	case status == StatusExpired || (status == StatusPending && action.ExpiresAt < now):
+		return fmt.Errorf("%w: %s", store.ErrActionExpired, action.ID)
+	case status != StatusPending:
+		return fmt.Errorf("%w: %s", store.ErrActionNotFound, action.ID)
+	}
+	return nil
+}
+
+func scanAction(s scanner) (*core.PendingAction, string, error) {
+	var (
+		action core.PendingAction
+		input  string
+		status string
+	)
+	err := s.Scan(&action.ID, &action.UserID, &action.SessionID, &action.IdempotencyKey, &action.Tool,
+		&input, &action.Summary, &action.BlockID, &action.CreatedAt, &action.ExpiresAt, &status)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan action: %w", err)
+	}
+	action.Input = []byte(input)
+	return &action, status, nil
+}
+
+// Verify Confirmations implements store.Confirmations.
+var _ store.Confirmations = (*Confirmations)(nil)
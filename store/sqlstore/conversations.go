@@ -0,0 +1,427 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// Conversations is a SQL implementation of store.Conversations.
+type Conversations struct {
+	db      *sql.DB
+	dialect Dialect
+	clock   core.Clock
+}
+
+// NewConversations creates a conversation store. Run Migrate first.
+func NewConversations(db *sql.DB, dialect Dialect, opts ...Option) *Conversations {
+	o := applyOptions(opts)
+	return &Conversations{db: db, dialect: dialect, clock: o.clock}
+}
+
+const conversationColumns = `id, user_id, title, archived, pinned, active_leaf_id, created_at, updated_at`
+
+func (c *Conversations) Create(ctx context.Context, userID string) (*store.Conversation, error) {
+	now := c.clock.Now()
+	conv := &store.Conversation{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Title:     store.DefaultTitle,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`INSERT INTO conversations (id, user_id, title, title_search, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		conv.ID, conv.UserID, conv.Title, searchText(conv.Title), now.UnixNano(), now.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return conv, nil
+}
+
+func (c *Conversations) Get(ctx context.Context, userID, conversationID string) (*store.ConversationWithMessages, error) {
+	conv, err := c.owned(ctx, c.db, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := c.messages(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.ConversationWithMessages{
+		Conversation: *conv,
+		Messages:     store.ActiveBranch(messages, conv.ActiveLeafID),
+	}, nil
+}
+
+// messages loads every message in the conversation, on all branches, in
+// the order they were appended.
+func (c *Conversations) messages(ctx context.Context, conversationID string) ([]store.StoredMessage, error) {
+	rows, err := c.db.QueryContext(ctx, c.dialect.Rebind(
+		`SELECT id, parent_id, role, content, blocks, tools, usage, created_at
+		FROM messages WHERE conversation_id = ? ORDER BY seq`),
+		conversationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []store.StoredMessage
+	for rows.Next() {
+		var (
+			msg                  store.StoredMessage
+			blocks, tools, usage sql.NullString
+			createdAt            int64
+		)
+		if err := rows.Scan(&msg.ID, &msg.ParentID, &msg.Role, &msg.Content, &blocks, &tools, &usage, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if err := unmarshalNull(blocks, &msg.Blocks); err != nil {
+			return nil, fmt.Errorf("message %s: invalid blocks: %w", msg.ID, err)
+		}
+		if err := unmarshalNull(tools, &msg.Tools); err != nil {
+			return nil, fmt.Errorf("message %s: invalid tools: %w", msg.ID, err)
+		}
+		if err := unmarshalNull(usage, &msg.Usage); err != nil {
+			return nil, fmt.Errorf("message %s: invalid usage: %w", msg.ID, err)
+		}
+		msg.CreatedAt = time.Unix(0, createdAt)
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (c *Conversations) Append(ctx context.Context, msg *store.AppendMessage) error {
+	blocks, err := marshalNull(len(msg.Blocks) > 0, msg.Blocks)
+	if err != nil {
+		return fmt.Errorf("failed to encode blocks: %w", err)
+	}
+	tools, err := marshalNull(len(msg.Tools) > 0, msg.Tools)
+	if err != nil {
+		return fmt.Errorf("failed to encode tools: %w", err)
+	}
+	usage, err := marshalNull(msg.Usage != nil, msg.Usage)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	conv, err := c.owned(ctx, tx, msg.UserID, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	var seq int64
+	if err := tx.QueryRowContext(ctx, c.dialect.Rebind(
+		`SELECT COALESCE(MAX(seq), 0) + 1 FROM messages WHERE conversation_id = ?`),
+		msg.ConversationID,
+	).Scan(&seq); err != nil {
+		return fmt.Errorf("failed to allocate message sequence: %w", err)
+	}
+
+	id := msg.ID
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	now := c.clock.Now().UnixNano()
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`INSERT INTO messages (id, conversation_id, parent_id, seq, role, content, content_search, blocks, tools, usage, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		id, msg.ConversationID, conv.ActiveLeafID, seq, msg.Role, msg.Content, searchText(msg.Content), blocks, tools, usage, now,
+	); err != nil {
+		return fmt.Errorf("failed to append message: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE conversations SET active_leaf_id = ?, updated_at = ? WHERE id = ?`),
+		id, now, msg.ConversationID,
+	); err != nil {
+		return fmt.Errorf("failed to touch conversation: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (c *Conversations) SetActiveLeaf(ctx context.Context, userID, conversationID, messageID string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := c.owned(ctx, tx, userID, conversationID); err != nil {
+		return err
+	}
+
+	if messageID != "" {
+		var exists int
+		err := tx.QueryRowContext(ctx, c.dialect.Rebind(
+			`SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?`),
+			messageID, conversationID,
+		).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", store.ErrMessageNotFound, messageID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load message: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE conversations SET active_leaf_id = ? WHERE id = ?`),
+		messageID, conversationID,
+	); err != nil {
+		return fmt.Errorf("failed to set active leaf: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (c *Conversations) SetTitle(ctx context.Context, userID, conversationID, title string) error {
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE conversations SET title = ?, title_search = ?, updated_at = ? WHERE id = ? AND user_id = ?`),
+		title, searchText(title), c.clock.Now().UnixNano(), conversationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set title: %w", err)
+	}
+	return requireRow(res, conversationID)
+}
+
+func (c *Conversations) SetArchived(ctx context.Context, userID, conversationID string, archived bool) error {
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE conversations SET archived = ? WHERE id = ? AND user_id = ?`),
+		boolInt(archived), conversationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set archived: %w", err)
+	}
+	return requireRow(res, conversationID)
+}
+
+func (c *Conversations) SetPinned(ctx context.Context, userID, conversationID string, pinned bool) error {
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`UPDATE conversations SET pinned = ? WHERE id = ? AND user_id = ?`),
+		boolInt(pinned), conversationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set pinned: %w", err)
+	}
+	return requireRow(res, conversationID)
+}
+
+func (c *Conversations) List(ctx context.Context, userID string, opts store.ListOptions) (*store.ConversationPage, error) {
+	return c.page(ctx, userID, opts, nil)
+}
+
+func (c *Conversations) Search(ctx context.Context, userID, query string, opts store.ListOptions) (*store.ConversationPage, error) {
+	return c.page(ctx, userID, opts, store.SearchTerms(query))
+}
+
+// page runs a List query, additionally requiring every search term to match.
+func (c *Conversations) page(ctx context.Context, userID string, opts store.ListOptions, terms []string) (*store.ConversationPage, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	cursor, hasCursor, err := store.DecodeCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sortColumn := "updated_at"
+	if opts.Sort == store.SortByCreated {
+		sortColumn = "created_at"
+	}
+
+	query := `SELECT ` + conversationColumns + ` FROM conversations WHERE user_id = ?`
+	args := []interface{}{userID}
+
+	switch opts.Archived {
+	case store.ExcludeArchived:
+		query += ` AND archived = 0`
+	case store.OnlyArchived:
+		query += ` AND archived = 1`
+	}
+
+	if hasCursor {
+		pinned, key := boolInt(cursor.Pinned), cursor.Key.UnixNano()
+		query += ` AND (pinned < ? OR (pinned = ? AND (` + sortColumn + ` < ? OR (` + sortColumn + ` = ? AND id < ?))))`
+		args = append(args, pinned, pinned, key, key, cursor.ID)
+	}
+
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		query += ` AND (title_search LIKE ? ESCAPE '\' OR EXISTS (
+			SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id
+			AND messages.content_search LIKE ? ESCAPE '\'))`
+		args = append(args, pattern, pattern)
+	}
+
+	// Fetch one extra row to learn whether there is a next page.
+	query += ` ORDER BY pinned DESC, ` + sortColumn + ` DESC, id DESC LIMIT ?`
+	args = append(args, opts.Limit+1)
+
+	rows, err := c.db.QueryContext(ctx, c.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]*store.Conversation, 0, opts.Limit+1)
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+
+	page := &store.ConversationPage{Conversations: result}
+	if len(result) > opts.Limit {
+		page.Conversations = result[:opts.Limit]
+		page.NextCursor = store.CursorAfter(opts, page.Conversations[opts.Limit-1]).Encode()
+	}
+	return page, nil
+}
+
+func (c *Conversations) Delete(ctx context.Context, userID, conversationID string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM conversations WHERE id = ? AND user_id = ?`),
+		conversationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	if err := requireRow(res, conversationID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM messages WHERE conversation_id = ?`),
+		conversationID,
+	); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// owned loads the conversation if it exists and belongs to the user.
+func (c *Conversations) owned(ctx context.Context, q querier, userID, conversationID string) (*store.Conversation, error) {
+	row := q.QueryRowContext(ctx, c.dialect.Rebind(
+		`SELECT `+conversationColumns+` FROM conversations WHERE id = ? AND user_id = ?`),
+		conversationID, userID,
+	)
+	conv, err := scanConversation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", store.ErrConversationNotFound, conversationID)
+	}
+	return conv, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConversation(s scanner) (*store.Conversation, error) {
+	var (
+		conv                 store.Conversation
+		createdAt, updatedAt int64
+	)
+	if err := s.Scan(&conv.ID, &conv.UserID, &conv.Title, &conv.Archived, &conv.Pinned, &conv.ActiveLeafID, &createdAt, &updatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan conversation: %w", err)
+	}
+	conv.CreatedAt = time.Unix(0, createdAt)
+	conv.UpdatedAt = time.Unix(0, updatedAt)
+	return &conv, nil
+}
+
+func requireRow(res sql.Result, conversationID string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", store.ErrConversationNotFound, conversationID)
+	}
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// searchText folds s the way store.SearchTerms folds queries, for the
+// title_search and content_search columns.
+func searchText(s string) string {
+	return strings.ToLower(s)
+}
+
+// escapeLike escapes LIKE wildcards so a search term matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// marshalNull encodes v as JSON, or returns SQL NULL if present is false.
+func marshalNull(present bool, v interface{}) (sql.NullString, error) {
+	if !present {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func unmarshalNull(s sql.NullString, v interface{}) error {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.String), v)
+}
+
+// Verify Conversations implements store.Conversations.
+var _ store.Conversations = (*Conversations)(nil)
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/becomeliminal/nim-go-sdk/store/encstore"
+)
+
+// DataKeys is a SQL implementation of encstore.DataKeyStore, keeping each
+// user's wrapped data key in the data_keys table.
+type DataKeys struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewDataKeys creates a data key store. Run Migrate first.
+func NewDataKeys(db *sql.DB, dialect Dialect) *DataKeys {
+	return &DataKeys{db: db, dialect: dialect}
+}
+
+func (d *DataKeys) GetDataKey(ctx context.Context, userID string) (*encstore.WrappedKey, error) {
+	var key encstore.WrappedKey
+	err := d.db.QueryRowContext(ctx, d.dialect.Rebind(
+		`SELECT key_id, wrapped_key FROM data_keys WHERE user_id = ?`),
+		userID,
+	).Scan(&key.KeyID, &key.Wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %w", err)
+	}
+	return &key, nil
+}
+
+// CreateDataKey inserts the key, or returns the one another caller
+// inserted first.
+func (d *DataKeys) CreateDataKey(ctx context.Context, userID string, key *encstore.WrappedKey) (*encstore.WrappedKey, error) {
+	_, insertErr := d.db.ExecContext(ctx, d.dialect.Rebind(
+		`INSERT INTO data_keys (user_id, key_id, wrapped_key) VALUES (?, ?, ?)`),
+		userID, key.KeyID, key.Wrapped,
+	)
+	saved, err := d.GetDataKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		return nil, fmt.Errorf("failed to save data key: %w", insertErr)
+	}
+	return saved, nil
+}
+
+func (d *DataKeys) RewrapDataKey(ctx context.Context, userID string, old, key *encstore.WrappedKey) error {
+	_, err := d.db.ExecContext(ctx, d.dialect.Rebind(
+		`UPDATE data_keys SET key_id = ?, wrapped_key = ? WHERE user_id = ? AND key_id = ? AND wrapped_key = ?`),
+		key.KeyID, key.Wrapped, userID, old.KeyID, old.Wrapped,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rewrap data key: %w", err)
+	}
+	return nil
+}
+
+func (d *DataKeys) DeleteDataKey(ctx context.Context, userID string) error {
+	_, err := d.db.ExecContext(ctx, d.dialect.Rebind(
+		`DELETE FROM data_keys WHERE user_id = ?`),
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+	return nil
+}
+
+// Verify DataKeys implements encstore.DataKeyStore.
+var _ encstore.DataKeyStore = (*DataKeys)(nil)
@@ -0,0 +1,114 @@
+// Package sqlstore implements store.Conversations, store.Confirmations and
+// encstore.DataKeyStore on top of database/sql.
+//
+// The package does not import a driver. Open the database with the driver of
+// your choice, pick the matching Dialect, and run Migrate once at startup:
+//
+//	db, _ := sql.Open("sqlite3", "file:nim.db?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+//	if err := sqlstore.Migrate(ctx, db, sqlstore.SQLite); err != nil { ... }
+//
+//	srv, _ := server.New(server.Config{
+//	    Conversations: sqlstore.NewConversations(db, sqlstore.SQLite),
+//	    Confirmations: sqlstore.NewConfirmations(db, sqlstore.SQLite),
+//	})
+package sqlstore
+
+// Dialect captures the differences between SQL databases.
+// Queries in this package are written with "?" placeholders and rebound by
+// the dialect, and timestamps are stored as plain integers (unix nanoseconds
+// for conversations, unix seconds for pending actions as in
+// core.PendingAction), so a new database only needs its own placeholder
+// style and schema.
+type Dialect interface {
+	// Name identifies the dialect (e.g., "sqlite").
+	Name() string
+
+	// Rebind rewrites "?" placeholders into the dialect's style.
+	Rebind(query string) string
+
+	// Migrations returns the ordered schema migrations. Each entry is applied
+	// once, in its own transaction, and recorded by its 1-based index.
+	Migrations() []string
+}
+
+// SQLite is the dialect for SQLite (e.g., github.com/mattn/go-sqlite3 or
+// the cgo-free modernc.org/sqlite).
+var SQLite Dialect = sqliteDialect{}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) Migrations() []string {
+	return []string{
+		`CREATE TABLE conversations (
+			id         TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL,
+			title      TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
+		CREATE INDEX idx_conversations_user_updated ON conversations (user_id, updated_at);
+
+		CREATE TABLE messages (
+			id              TEXT PRIMARY KEY,
+			conversation_id TEXT NOT NULL,
+			seq             INTEGER NOT NULL,
+			role            TEXT NOT NULL,
+			content         TEXT NOT NULL,
+			blocks          TEXT,
+			tools           TEXT,
+			usage           TEXT,
+			created_at      INTEGER NOT NULL,
+			UNIQUE (conversation_id, seq)
+		);
+
+		CREATE TABLE pending_actions (
+			id              TEXT PRIMARY KEY,
+			user_id         TEXT NOT NULL,
+			session_id      TEXT NOT NULL,
+			idempotency_key TEXT NOT NULL,
+			tool            TEXT NOT NULL,
+			input           TEXT NOT NULL,
+			summary         TEXT NOT NULL,
+			block_id        TEXT NOT NULL,
+			status          TEXT NOT NULL,
+			created_at      INTEGER NOT NULL,
+			expires_at      INTEGER NOT NULL,
+			resolved_at     INTEGER
+		);
+		CREATE INDEX idx_pending_actions_expires ON pending_actions (expires_at);
+		CREATE INDEX idx_pending_actions_idempotency ON pending_actions (user_id, idempotency_key);`,
+
+		`ALTER TABLE conversations ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE conversations ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX idx_conversations_user_list ON conversations (user_id, archived, pinned, updated_at);`,
+
+		// Messages become a tree. Existing conversations are linear, so each
+		// message's parent is the one before it and the leaf is the last.
+		`ALTER TABLE messages ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE conversations ADD COLUMN active_leaf_id TEXT NOT NULL DEFAULT '';
+		UPDATE messages SET parent_id = COALESCE((
+			SELECT p.id FROM messages p WHERE p.conversation_id = messages.conversation_id AND p.seq = messages.seq - 1
+		), '');
+		UPDATE conversations SET active_leaf_id = COALESCE((
+			SELECT id FROM messages WHERE conversation_id = conversations.id ORDER BY seq DESC LIMIT 1
+		), '');`,
+
+		// Case-folded copies for search, written in Go because LOWER() only
+		// folds ASCII. Existing rows start NULL and are filled in by Migrate.
+		`ALTER TABLE conversations ADD COLUMN title_search TEXT;
+		ALTER TABLE messages ADD COLUMN content_search TEXT;`,
+
+		`CREATE INDEX idx_pending_actions_block ON pending_actions (user_id, session_id, block_id);`,
+
+		// Wrapped per-user data keys for encstore
+		`CREATE TABLE data_keys (
+			user_id     TEXT PRIMARY KEY,
+			key_id      TEXT NOT NULL,
+			wrapped_key BLOB NOT NULL
+		);`,
+	}
+}
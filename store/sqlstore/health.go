@@ -0,0 +1,22 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// HealthCheck pings the database.
+func (c *Conversations) HealthCheck(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// HealthCheck pings the database.
+func (c *Confirmations) HealthCheck(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+var (
+	_ store.HealthChecker = (*Conversations)(nil)
+	_ store.HealthChecker = (*Confirmations)(nil)
+)
@@ -0,0 +1,99 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migrate brings the schema up to date by applying any of the dialect's
+// migrations that have not run yet, then fills in search columns left empty
+// by older versions. It is safe to call on every startup.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	migrations := dialect.Migrations()
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		if err := applyMigration(ctx, db, dialect, version, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	if len(migrations) < searchColumnsVersion {
+		return nil
+	}
+	return backfillSearch(ctx, db, dialect)
+}
+
+// searchColumnsVersion is the migration that adds title_search and
+// content_search.
+const searchColumnsVersion = 4
+
+// backfillSearch fills in the search columns of rows written before they
+// existed. The folding happens in Go, so it is the same for every database
+// and covers more than ASCII.
+func backfillSearch(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	for _, col := range []struct{ table, source, target string }{
+		{"conversations", "title", "title_search"},
+		{"messages", "content", "content_search"},
+	} {
+		rows, err := db.QueryContext(ctx,
+			`SELECT id, `+col.source+` FROM `+col.table+` WHERE `+col.target+` IS NULL`)
+		if err != nil {
+			return fmt.Errorf("failed to read %s for search: %w", col.table, err)
+		}
+		values := map[string]string{}
+		for rows.Next() {
+			var id, text string
+			if err := rows.Scan(&id, &text); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read %s for search: %w", col.table, err)
+			}
+			values[id] = searchText(text)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read %s for search: %w", col.table, err)
+		}
+
+		for id, text := range values {
+			if _, err := db.ExecContext(ctx, dialect.Rebind(
+				`UPDATE `+col.table+` SET `+col.target+` = ? WHERE id = ?`),
+				text, id,
+			); err != nil {
+				return fmt.Errorf("failed to backfill %s search: %w", col.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, version int, stmt string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		dialect.Rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+		version, time.Now().UnixNano(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,27 @@
+package sqlstore
+
+import "github.com/becomeliminal/nim-go-sdk/core"
+
+// Option configures the SQL stores.
+type Option func(*options)
+
+type options struct {
+	clock core.Clock
+}
+
+// WithClock sets the time source for expiry checks and timestamps.
+// If not set, core.SystemClock is used.
+func WithClock(c core.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.clock = core.ClockOrDefault(o.clock)
+	return o
+}
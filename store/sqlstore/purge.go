@@ -0,0 +1,55 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+func (c *Conversations) PurgeConversations(ctx context.Context, before time.Time) (int, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := before.UnixNano()
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE updated_at < ?)`),
+		cutoff,
+	); err != nil {
+		return 0, fmt.Errorf("failed to purge messages: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM conversations WHERE updated_at < ?`),
+		cutoff,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge conversations: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+func (c *Confirmations) PurgeActions(ctx context.Context, before time.Time) (int, error) {
+	res, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM pending_actions WHERE status <> ? AND resolved_at < ?`),
+		StatusPending, before.Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge actions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Verify the SQL stores implement the purger interfaces.
+var (
+	_ store.ConversationPurger = (*Conversations)(nil)
+	_ store.ActionPurger       = (*Confirmations)(nil)
+)
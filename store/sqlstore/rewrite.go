@@ -0,0 +1,159 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+func (c *Conversations) RewriteConversations(ctx context.Context, userID string, fn func(conv *store.ConversationWithMessages) (bool, error)) (int, error) {
+	convs, err := c.userConversations(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load conversations: %w", err)
+	}
+
+	saved := 0
+	for _, conv := range convs {
+		messages, err := c.messages(ctx, conv.ID)
+		if err != nil {
+			return saved, err
+		}
+
+		original := make(map[string]storedContent, len(messages))
+		for i := range messages {
+			content, err := encodeContent(&messages[i])
+			if err != nil {
+				return saved, err
+			}
+			original[messages[i].ID] = content
+		}
+
+		rewritten := &store.ConversationWithMessages{Conversation: *conv, Messages: messages}
+		changed, err := fn(rewritten)
+		if err != nil {
+			return saved, err
+		}
+		if !changed {
+			continue
+		}
+		if err := c.saveRewrite(ctx, conv, rewritten, original); err != nil {
+			return saved, err
+		}
+		saved++
+	}
+	return saved, nil
+}
+
+// saveRewrite writes the fields RewriteConversations may change. Updates are
+// conditional on the stored values being unchanged since they were read, so
+// a concurrent SetTitle is not overwritten.
+func (c *Conversations) saveRewrite(ctx context.Context, conv *store.Conversation, rewritten *store.ConversationWithMessages, original map[string]storedContent) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if rewritten.Title != conv.Title {
+		if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+			`UPDATE conversations SET title = ?, title_search = ? WHERE id = ? AND title = ?`),
+			rewritten.Title, searchText(rewritten.Title), conv.ID, conv.Title,
+		); err != nil {
+			return fmt.Errorf("failed to rewrite title: %w", err)
+		}
+	}
+
+	for i := range rewritten.Messages {
+		msg := &rewritten.Messages[i]
+		before, ok := original[msg.ID]
+		if !ok {
+			continue
+		}
+		after, err := encodeContent(msg)
+		if err != nil {
+			return err
+		}
+		if after == before {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+			`UPDATE messages SET content = ?, content_search = ?, blocks = ?, tools = ? WHERE id = ? AND conversation_id = ?`),
+			after.content, searchText(after.content), after.blocks, after.tools, msg.ID, conv.ID,
+		); err != nil {
+			return fmt.Errorf("failed to rewrite message: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// storedContent is a message's rewritable columns as stored.
+type storedContent struct {
+	content string
+	blocks  sql.NullString
+	tools   sql.NullString
+}
+
+func encodeContent(msg *store.StoredMessage) (storedContent, error) {
+	blocks, err := marshalNull(len(msg.Blocks) > 0, msg.Blocks)
+	if err != nil {
+		return storedContent{}, fmt.Errorf("failed to encode blocks: %w", err)
+	}
+	tools, err := marshalNull(len(msg.Tools) > 0, msg.Tools)
+	if err != nil {
+		return storedContent{}, fmt.Errorf("failed to encode tools: %w", err)
+	}
+	return storedContent{content: msg.Content, blocks: blocks, tools: tools}, nil
+}
+
+func (c *Confirmations) RewriteActions(ctx context.Context, userID string, fn func(action *core.PendingAction) (bool, error)) (int, error) {
+	rows, err := c.db.QueryContext(ctx, c.dialect.Rebind(
+		`SELECT `+actionColumns+` FROM pending_actions WHERE user_id = ? ORDER BY created_at, id`),
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load actions: %w", err)
+	}
+	var actions []*core.PendingAction
+	for rows.Next() {
+		action, _, err := scanAction(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		actions = append(actions, action)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to load actions: %w", err)
+	}
+
+	saved := 0
+	for _, action := range actions {
+		input, summary := string(action.Input), action.Summary
+		changed, err := fn(action)
+		if err != nil {
+			return saved, err
+		}
+		if !changed {
+			continue
+		}
+		if _, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+			`UPDATE pending_actions SET input = ?, summary = ? WHERE id = ? AND user_id = ? AND input = ? AND summary = ?`),
+			string(action.Input), action.Summary, action.ID, userID, input, summary,
+		); err != nil {
+			return saved, fmt.Errorf("failed to rewrite action: %w", err)
+		}
+		saved++
+	}
+	return saved, nil
+}
+
+// Verify the SQL stores implement the rewriter interfaces.
+var (
+	_ store.ConversationRewriter = (*Conversations)(nil)
+	_ store.ActionRewriter       = (*Confirmations)(nil)
+)
@@ -0,0 +1,227 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/store"
+	"github.com/becomeliminal/nim-go-sdk/store/encstore"
+	"github.com/becomeliminal/nim-go-sdk/store/storetest"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "nim.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(context.Background(), db, SQLite); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	return db
+}
+
+func TestConversations_Conformance(t *testing.T) {
+	storetest.RunConversationsSuite(t, func(t *testing.T, clock core.Clock) store.Conversations {
+		return NewConversations(openTestDB(t), SQLite, WithClock(clock))
+	})
+}
+
+func TestConfirmations_Conformance(t *testing.T) {
+	storetest.RunConfirmationsSuite(t, func(t *testing.T, clock core.Clock) store.Confirmations {
+		return NewConfirmations(openTestDB(t), SQLite, WithClock(clock))
+	})
+}
+
+func TestMigrate_Idempotent(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(context.Background(), db, SQLite); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+
+	var version int
+	db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if version != len(SQLite.Migrations()) {
+		t.Errorf("schema version = %d, want %d", version, len(SQLite.Migrations()))
+	}
+}
+
+func TestConfirmations_KeepsResolvedHistory(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	clock := core.NewFakeClock(storetest.Epoch)
+	c := NewConfirmations(db, SQLite, WithClock(clock))
+
+	for _, action := range []*core.PendingAction{
+		storetest.NewAction("a1", "alice", clock.Now(), time.Minute),
+		storetest.NewAction("a2", "alice", clock.Now(), time.Minute),
+		storetest.NewAction("old", "alice", clock.Now(), -time.Minute),
+	} {
+		if err := c.Store(ctx, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Confirm(ctx, "alice", "a1")
+	c.Cancel(ctx, "alice", "a2")
+	c.Confirm(ctx, "alice", "old")
+
+	statuses := map[string]string{}
+	rows, err := db.Query(`SELECT id, status FROM pending_actions`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id, status string
+		rows.Scan(&id, &status)
+		statuses[id] = status
+	}
+	rows.Close()
+
+	want := map[string]string{"a1": StatusConfirmed, "a2": StatusCancelled, "old": StatusExpired}
+	for id, status := range want {
+		if statuses[id] != status {
+			t.Errorf("status[%s] = %q, want %q", id, statuses[id], status)
+		}
+	}
+}
+
+// firstMigrations is a dialect with only the first n SQLite migrations,
+// for testing upgrades of existing databases.
+type firstMigrations struct {
+	Dialect
+	n int
+}
+
+func (d firstMigrations) Migrations() []string { return d.Dialect.Migrations()[:d.n] }
+
+func TestMigrate_BackfillsMessageTree(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "nim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Migrate(ctx, db, firstMigrations{SQLite, 2}); err != nil {
+		t.Fatalf("Migrate(v2) error = %v", err)
+	}
+	db.Exec(`INSERT INTO conversations (id, user_id, title, created_at, updated_at) VALUES ('c1', 'alice', 'Old', 1, 1)`)
+	for i, id := range []string{"m1", "m2", "m3"} {
+		db.Exec(`INSERT INTO messages (id, conversation_id, seq, role, content, created_at) VALUES (?, 'c1', ?, 'user', ?, 1)`, id, i+1, id)
+	}
+
+	if err := Migrate(ctx, db, SQLite); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	conv, err := NewConversations(db, SQLite).Get(ctx, "alice", "c1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conv.ActiveLeafID != "m3" || len(conv.Messages) != 3 || conv.Messages[2].ParentID != "m2" || conv.Messages[0].ParentID != "" {
+		t.Errorf("Get() after migration = leaf %q, messages %+v", conv.ActiveLeafID, conv.Messages)
+	}
+}
+
+func TestMigrate_BackfillsSearch(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "nim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Migrate(ctx, db, firstMigrations{SQLite, 3}); err != nil {
+		t.Fatalf("Migrate(v3) error = %v", err)
+	}
+	db.Exec(`INSERT INTO conversations (id, user_id, title, created_at, updated_at) VALUES ('c1', 'alice', 'MÜNCHEN', 1, 1)`)
+	db.Exec(`INSERT INTO conversations (id, user_id, title, created_at, updated_at) VALUES ('c2', 'alice', 'Rent', 1, 1)`)
+	db.Exec(`INSERT INTO messages (id, conversation_id, seq, role, content, created_at) VALUES ('m1', 'c2', 1, 'user', 'Über', 1)`)
+
+	if err := Migrate(ctx, db, SQLite); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	s := NewConversations(db, SQLite)
+	for query, want := range map[string]string{"münchen": "c1", "über": "c2"} {
+		page, err := s.Search(ctx, "alice", query, store.ListOptions{})
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", query, err)
+		}
+		if len(page.Conversations) != 1 || page.Conversations[0].ID != want {
+			t.Errorf("Search(%q) after migration = %+v, want %s", query, page.Conversations, want)
+		}
+	}
+}
+
+func TestDataKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := NewDataKeys(openTestDB(t), SQLite)
+
+	if got, err := keys.GetDataKey(ctx, "alice"); err != nil || got != nil {
+		t.Fatalf("GetDataKey() with no key = %v, %v, want nil, nil", got, err)
+	}
+
+	// The first key saved wins
+	first := &encstore.WrappedKey{KeyID: "k1", Wrapped: []byte("first")}
+	if got, err := keys.CreateDataKey(ctx, "alice", first); err != nil || string(got.Wrapped) != "first" {
+		t.Fatalf("CreateDataKey() = %v, %v", got, err)
+	}
+	if got, err := keys.CreateDataKey(ctx, "alice", &encstore.WrappedKey{KeyID: "k1", Wrapped: []byte("second")}); err != nil || string(got.Wrapped) != "first" {
+		t.Errorf("second CreateDataKey() = %v, %v, want the first key", got, err)
+	}
+
+	// Rewrapping replaces only the key it was given
+	rewrapped := &encstore.WrappedKey{KeyID: "k2", Wrapped: []byte("rewrapped")}
+	if err := keys.RewrapDataKey(ctx, "alice", &encstore.WrappedKey{KeyID: "k1", Wrapped: []byte("stale")}, rewrapped); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := keys.GetDataKey(ctx, "alice"); got.KeyID != "k1" {
+		t.Errorf("GetDataKey() after a stale rewrap = %+v, want unchanged", got)
+	}
+	if err := keys.RewrapDataKey(ctx, "alice", first, rewrapped); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := keys.GetDataKey(ctx, "alice"); got.KeyID != "k2" || string(got.Wrapped) != "rewrapped" {
+		t.Errorf("GetDataKey() after rewrap = %+v, want %+v", got, rewrapped)
+	}
+
+	for range 2 {
+		if err := keys.DeleteDataKey(ctx, "alice"); err != nil {
+			t.Fatalf("DeleteDataKey() error = %v", err)
+		}
+	}
+	if got, err := keys.GetDataKey(ctx, "alice"); err != nil || got != nil {
+		t.Errorf("GetDataKey() after delete = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	checkers := []store.HealthChecker{NewConversations(db, SQLite), NewConfirmations(db, SQLite)}
+
+	for _, h := range checkers {
+		if err := h.HealthCheck(ctx); err != nil {
+			t.Errorf("%T.HealthCheck() error = %v", h, err)
+		}
+	}
+	db.Close()
+	for _, h := range checkers {
+		if err := h.HealthCheck(ctx); err == nil {
+			t.Errorf("%T.HealthCheck() on a closed database succeeded", h)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// ExportUserData returns the user's conversations with the messages on
+// every branch, oldest conversation first.
+func (c *Conversations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	convs, err := c.userConversations(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export conversations: %w", err)
+	}
+	if len(convs) == 0 {
+		return nil, nil
+	}
+
+	export := make([]store.ConversationWithMessages, 0, len(convs))
+	for _, conv := range convs {
+		messages, err := c.messages(ctx, conv.ID)
+		if err != nil {
+			return nil, err
+		}
+		export = append(export, store.ConversationWithMessages{Conversation: *conv, Messages: messages})
+	}
+	return export, nil
+}
+
+// userConversations returns all of the user's conversations, oldest first.
+func (c *Conversations) userConversations(ctx context.Context, userID string) ([]*store.Conversation, error) {
+	rows, err := c.db.QueryContext(ctx, c.dialect.Rebind(
+		`SELECT `+conversationColumns+` FROM conversations WHERE user_id = ? ORDER BY created_at, id`),
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var convs []*store.Conversation
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		convs = append(convs, conv)
+	}
+	return convs, rows.Err()
+}
+
+// EraseUserData deletes all of the user's conversations and messages.
+func (c *Conversations) EraseUserData(ctx context.Context, userID string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE user_id = ?)`),
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to erase messages: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM conversations WHERE user_id = ?`),
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to erase conversations: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ActionRecord is an exported action with its resolution.
+type ActionRecord struct {
+	*core.PendingAction
+	Status     string     `json:"status"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ExportUserData returns the user's actions, pending and resolved, oldest first.
+func (c *Confirmations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	rows, err := c.db.QueryContext(ctx, c.dialect.Rebind(
+		`SELECT `+actionColumns+`, resolved_at FROM pending_actions WHERE user_id = ? ORDER BY created_at, id`),
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export actions: %w", err)
+	}
+	defer rows.Close()
+
+	var export []ActionRecord
+	for rows.Next() {
+		var resolvedAt sql.NullInt64
+		action, status, err := scanAction(rowWithExtra{rows, &resolvedAt})
+		if err != nil {
+			return nil, err
+		}
+		record := ActionRecord{PendingAction: action, Status: status}
+		if resolvedAt.Valid {
+			t := time.Unix(resolvedAt.Int64, 0).UTC()
+			record.ResolvedAt = &t
+		}
+		export = append(export, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to export actions: %w", err)
+	}
+	if len(export) == 0 {
+		return nil, nil
+	}
+	return export, nil
+}
+
+// EraseUserData deletes all of the user's actions, including resolved history.
+func (c *Confirmations) EraseUserData(ctx context.Context, userID string) error {
+	if _, err := c.db.ExecContext(ctx, c.dialect.Rebind(
+		`DELETE FROM pending_actions WHERE user_id = ?`),
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to erase actions: %w", err)
+	}
+	return nil
+}
+
+// rowWithExtra scans trailing columns after those read by a scan helper.
+type rowWithExtra struct {
+	s     scanner
+	extra interface{}
+}
+
+func (r rowWithExtra) Scan(dest ...interface{}) error {
+	return r.s.Scan(append(dest, r.extra)...)
+}
+
+// Verify the SQL stores implement privacy.UserDataHandler.
+var (
+	_ privacy.UserDataHandler = (*Conversations)(nil)
+	_ privacy.UserDataHandler = (*Confirmations)(nil)
+)
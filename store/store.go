@@ -2,10 +2,26 @@ package store
 
 import (
 	"context"
+	"errors"
 
 	"github.com/becomeliminal/nim-go-sdk/core"
 )
 
+// ErrMessageNotFound is returned when a message is not part of the conversation.
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrActionNotFound is returned when a pending action does not exist
+// or belongs to a different user.
+var ErrActionNotFound = errors.New("action not found")
+
+// ErrActionExpired is returned when a pending action exists but has expired.
+var ErrActionExpired = errors.New("action expired")
+
+// ErrConversationNotFound is returned when a conversation does not exist
+// or belongs to a different user. The two cases are deliberately
+// indistinguishable so callers cannot probe for other users' conversations.
+var ErrConversationNotFound = errors.New("conversation not found")
+
 // Confirmations stores pending actions awaiting user approval.
 // The SDK provides MemoryConfirmations for development and RistrettoConfirmations
 // for production single-instance deployments. Distributed deployments (like nim/agent)
@@ -22,6 +38,12 @@ type Confirmations interface {
 	// Returns nil, nil if no action found (not an error).
 	GetByIdempotency(ctx context.Context, userID, key string) (*core.PendingAction, error)
 
+	// GetByBlock retrieves the pending action created for a tool_use block
+	// in the given session, so the history can lead back to its actions.
+	// Block IDs are only unique within a session. Returns nil, nil if no
+	// action is pending (not an error).
+	GetByBlock(ctx context.Context, userID, sessionID, blockID string) (*core.PendingAction, error)
+
 	// Confirm marks an action as confirmed, removes it from pending, and returns it.
 	// The caller should then execute the confirmed action.
 	Confirm(ctx context.Context, userID, actionID string) (*core.PendingAction, error)
@@ -36,22 +58,53 @@ type Confirmations interface {
 // Conversations stores conversation history.
 // The SDK provides MemoryConversations for development.
 // Production deployments should implement with PostgreSQL or similar.
+//
+// Messages form a tree through their parent pointers, so a conversation can
+// be edited and regenerated from any point; the active branch is the path
+// from the root to the conversation's active leaf.
+//
+// Every method is scoped to a user. Implementations must return an error
+// wrapping ErrConversationNotFound when the conversation belongs to a
+// different user, exactly as if it did not exist.
 type Conversations interface {
 	// Create starts a new conversation for the user.
 	Create(ctx context.Context, userID string) (*Conversation, error)
 
-	// Get retrieves a conversation with all messages.
-	Get(ctx context.Context, conversationID string) (*ConversationWithMessages, error)
+	// Get retrieves a conversation owned by the user with the messages on
+	// its active branch, oldest first.
+	Get(ctx context.Context, userID, conversationID string) (*ConversationWithMessages, error)
 
-	// Append adds a message to a conversation.
+	// Append adds a message to a conversation owned by msg.UserID as a child
+	// of the active leaf, and makes it the new active leaf.
 	Append(ctx context.Context, msg *AppendMessage) error
 
-	// SetTitle updates the conversation title.
-	SetTitle(ctx context.Context, conversationID, title string) error
+	// SetActiveLeaf moves the active branch of a conversation owned by the
+	// user so that it ends at messageID, or is empty if messageID is empty.
+	// Messages are never deleted: appending after moving the leaf starts a
+	// new branch, and the old one can be restored by moving back.
+	// Returns ErrMessageNotFound if the message is not in the conversation.
+	SetActiveLeaf(ctx context.Context, userID, conversationID, messageID string) error
+
+	// SetTitle updates the title of a conversation owned by the user.
+	SetTitle(ctx context.Context, userID, conversationID, title string) error
+
+	// SetArchived archives or restores a conversation owned by the user.
+	// Archived conversations are hidden from List and Search by default.
+	SetArchived(ctx context.Context, userID, conversationID string, archived bool) error
+
+	// SetPinned pins or unpins a conversation owned by the user.
+	// Pinned conversations are listed before all others.
+	SetPinned(ctx context.Context, userID, conversationID string, pinned bool) error
+
+	// List returns a page of the user's conversations: pinned first, then
+	// by opts.Sort, newest first. Returns ErrInvalidCursor for a bad cursor.
+	List(ctx context.Context, userID string, opts ListOptions) (*ConversationPage, error)
 
-	// List returns recent conversations for a user.
-	List(ctx context.Context, userID string, limit int) ([]*Conversation, error)
+	// Search returns a page of the user's conversations whose title or
+	// message content, on any branch, contains every term of the query (see SearchTerms),
+	// case-insensitively, in the same order as List.
+	Search(ctx context.Context, userID, query string, opts ListOptions) (*ConversationPage, error)
 
-	// Delete removes a conversation.
-	Delete(ctx context.Context, conversationID string) error
+	// Delete removes a conversation owned by the user.
+	Delete(ctx context.Context, userID, conversationID string) error
 }
@@ -0,0 +1,491 @@
+// Package storetest provides conformance suites for store.Confirmations and
+// store.Conversations implementations.
+//
+// Run them from your implementation's tests:
+//
+//	func TestRedisConfirmations(t *testing.T) {
+//	    storetest.RunConfirmationsSuite(t, func(t *testing.T, clock core.Clock) store.Confirmations {
+//	        return newTestRedisConfirmations(t, clock)
+//	    })
+//	}
+//
+// The factory is called once per subtest and must return an empty store that
+// reads the current time from clock. The suite drives a core.FakeClock, so
+// expiry is tested without sleeping.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// ConfirmationsFactory returns a new, empty store for a single subtest.
+type ConfirmationsFactory func(t *testing.T, clock core.Clock) store.Confirmations
+
+// Epoch is the fake clock's starting time in every subtest.
+var Epoch = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// RunConfirmationsSuite verifies that a store.Confirmations implementation
+// behaves like the SDK's built-in stores.
+func RunConfirmationsSuite(t *testing.T, factory ConfirmationsFactory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Confirmations, clock *core.FakeClock)
+	}{
+		{"StoreAndGet", testStoreAndGet},
+		{"GetMissing", testGetMissing},
+		{"GetIsolatesUsers", testGetIsolatesUsers},
+		{"GetExpired", testGetExpired},
+		{"ExpiresWithClock", testExpiresWithClock},
+		{"GetByIdempotency", testGetByIdempotency},
+		{"GetByBlock", testGetByBlock},
+		{"Confirm", testConfirm},
+		{"ConfirmIsolatesUsers", testConfirmIsolatesUsers},
+		{"ConfirmExpired", testConfirmExpired},
+		{"ConfirmIsExactlyOnce", testConfirmIsExactlyOnce},
+		{"Cancel", testCancel},
+		{"Cleanup", testCleanup},
+		{"UserData", testConfirmationsUserData},
+		{"Rewrite", testConfirmationsRewrite},
+		{"Purge", testConfirmationsPurge},
+		{"ConcurrentStore", testConcurrentStore},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := core.NewFakeClock(Epoch)
+			tt.fn(t, factory(t, clock), clock)
+		})
+	}
+}
+
+// NewAction returns a pending action for userID created at now that
+// expires after ttl. A negative ttl yields an already expired action.
+func NewAction(id, userID string, now time.Time, ttl time.Duration) *core.PendingAction {
+	return &core.PendingAction{
+		ID:             id,
+		IdempotencyKey: "idem-" + id,
+		SessionID:      "session-" + userID,
+		UserID:         userID,
+		Tool:           "send_money",
+		Input:          json.RawMessage(`{"amount":"5.00","recipient":"@bob"}`),
+		Summary:        "Send $5.00 to @bob",
+		BlockID:        "toolu_" + id,
+		CreatedAt:      now.Unix(),
+		ExpiresAt:      now.Add(ttl).Unix(),
+	}
+}
+
+func mustStore(t *testing.T, s store.Confirmations, action *core.PendingAction) {
+	t.Helper()
+	if err := s.Store(context.Background(), action); err != nil {
+		t.Fatalf("Store(%s) error = %v", action.ID, err)
+	}
+}
+
+func testStoreAndGet(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	want := NewAction("a1", "alice", clock.Now(), time.Minute)
+	mustStore(t, s, want)
+
+	got, err := s.Get(context.Background(), "alice", "a1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.ID != want.ID || got.Tool != want.Tool || got.BlockID != want.BlockID ||
+		got.IdempotencyKey != want.IdempotencyKey || got.ExpiresAt != want.ExpiresAt {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+	if string(got.Input) != string(want.Input) {
+		t.Errorf("Get().Input = %s, want %s", got.Input, want.Input)
+	}
+}
+
+func testGetMissing(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	if _, err := s.Get(context.Background(), "alice", "missing"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, store.ErrActionNotFound)
+	}
+}
+
+func testGetIsolatesUsers(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+
+	if _, err := s.Get(context.Background(), "mallory", "a1"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Get() as other user error = %v, want %v", err, store.ErrActionNotFound)
+	}
+}
+
+func testGetExpired(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), -time.Minute))
+
+	if _, err := s.Get(context.Background(), "alice", "a1"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Get() error = %v, want %v", err, store.ErrActionExpired)
+	}
+}
+
+func testExpiresWithClock(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("a2", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("a3", "alice", clock.Now(), time.Minute))
+
+	clock.Advance(59 * time.Second)
+	if _, err := s.Get(ctx, "alice", "a1"); err != nil {
+		t.Fatalf("Get() before expiry error = %v", err)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, err := s.Get(ctx, "alice", "a1"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Get() after expiry error = %v, want %v", err, store.ErrActionExpired)
+	}
+	if got, err := s.GetByIdempotency(ctx, "alice", "idem-a3"); err != nil || got != nil {
+		t.Errorf("GetByIdempotency() after expiry = %v, %v; want nil, nil", got, err)
+	}
+	if _, err := s.Confirm(ctx, "alice", "a2"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Confirm() after expiry error = %v, want %v", err, store.ErrActionExpired)
+	}
+}
+
+func testGetByIdempotency(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustStore(t, s, NewAction("live", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("old", "alice", clock.Now(), -time.Minute))
+
+	got, err := s.GetByIdempotency(ctx, "alice", "idem-live")
+	if err != nil || got == nil || got.ID != "live" {
+		t.Errorf("GetByIdempotency() = %v, %v; want action live", got, err)
+	}
+
+	// Misses are nil, nil rather than errors.
+	for _, tc := range []struct{ name, userID, key string }{
+		{"unknown key", "alice", "idem-missing"},
+		{"other user", "mallory", "idem-live"},
+		{"expired", "alice", "idem-old"},
+	} {
+		got, err := s.GetByIdempotency(ctx, tc.userID, tc.key)
+		if err != nil || got != nil {
+			t.Errorf("GetByIdempotency(%s) = %v, %v; want nil, nil", tc.name, got, err)
+		}
+	}
+
+	// Resolved actions no longer match.
+	if _, err := s.Confirm(ctx, "alice", "live"); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if got, err := s.GetByIdempotency(ctx, "alice", "idem-live"); err != nil || got != nil {
+		t.Errorf("GetByIdempotency() after confirm = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func testGetByBlock(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+
+	// Tool call IDs repeat across sessions, so lookups are per session.
+	first := NewAction("a1", "alice", clock.Now(), time.Minute)
+	second := NewAction("a2", "alice", clock.Now(), time.Minute)
+	first.BlockID, second.BlockID = "toolu_01", "toolu_01"
+	first.SessionID, second.SessionID = "s1", "s2"
+	old := NewAction("old", "alice", clock.Now(), -time.Minute)
+	old.SessionID = "s1"
+	for _, action := range []*core.PendingAction{first, second, old} {
+		mustStore(t, s, action)
+	}
+
+	for _, tc := range []struct{ sessionID, want string }{{"s1", "a1"}, {"s2", "a2"}} {
+		got, err := s.GetByBlock(ctx, "alice", tc.sessionID, "toolu_01")
+		if err != nil || got == nil || got.ID != tc.want {
+			t.Errorf("GetByBlock(%s) = %v, %v; want action %s", tc.sessionID, got, err, tc.want)
+		}
+	}
+
+	// Misses are nil, nil rather than errors.
+	for _, tc := range []struct{ name, userID, sessionID, blockID string }{
+		{"unknown block", "alice", "s1", "toolu_missing"},
+		{"other session", "alice", "s3", "toolu_01"},
+		{"other user", "mallory", "s1", "toolu_01"},
+		{"expired", "alice", "s1", "toolu_old"},
+	} {
+		got, err := s.GetByBlock(ctx, tc.userID, tc.sessionID, tc.blockID)
+		if err != nil || got != nil {
+			t.Errorf("GetByBlock(%s) = %v, %v; want nil, nil", tc.name, got, err)
+		}
+	}
+
+	// Resolved actions no longer match.
+	if err := s.Cancel(ctx, "alice", "a1"); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if got, err := s.GetByBlock(ctx, "alice", "s1", "toolu_01"); err != nil || got != nil {
+		t.Errorf("GetByBlock() after cancel = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func testConfirm(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	want := NewAction("a1", "alice", clock.Now(), time.Minute)
+	mustStore(t, s, want)
+
+	got, err := s.Confirm(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if got.ID != want.ID || got.Tool != want.Tool || string(got.Input) != string(want.Input) {
+		t.Errorf("Confirm() = %+v, want %+v", got, want)
+	}
+
+	if _, err := s.Get(ctx, "alice", "a1"); err == nil {
+		t.Error("Get() after Confirm() succeeded, want error")
+	}
+	if _, err := s.Confirm(ctx, "alice", "a1"); err == nil {
+		t.Error("second Confirm() succeeded, want error")
+	}
+}
+
+func testConfirmIsolatesUsers(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+
+	if _, err := s.Confirm(ctx, "mallory", "a1"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Confirm() as other user error = %v, want %v", err, store.ErrActionNotFound)
+	}
+	if err := s.Cancel(ctx, "mallory", "a1"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Cancel() as other user error = %v, want %v", err, store.ErrActionNotFound)
+	}
+
+	// The owner can still act on it.
+	if _, err := s.Confirm(ctx, "alice", "a1"); err != nil {
+		t.Errorf("Confirm() as owner error = %v", err)
+	}
+}
+
+func testConfirmExpired(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), -time.Minute))
+
+	if _, err := s.Confirm(context.Background(), "alice", "a1"); !errors.Is(err, store.ErrActionExpired) {
+		t.Errorf("Confirm() error = %v, want %v", err, store.ErrActionExpired)
+	}
+}
+
+func testConfirmIsExactlyOnce(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+
+	const callers = 16
+	var confirmed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Confirm(context.Background(), "alice", "a1"); err == nil {
+				confirmed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := confirmed.Load(); got != 1 {
+		t.Errorf("action confirmed %d times by %d concurrent callers, want 1", got, callers)
+	}
+}
+
+func testCancel(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+
+	if err := s.Cancel(ctx, "alice", "a1"); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "alice", "a1"); err == nil {
+		t.Error("Get() after Cancel() succeeded, want error")
+	}
+	if _, err := s.Confirm(ctx, "alice", "a1"); err == nil {
+		t.Error("Confirm() after Cancel() succeeded, want error")
+	}
+	if err := s.Cancel(ctx, "alice", "missing"); !errors.Is(err, store.ErrActionNotFound) {
+		t.Errorf("Cancel() missing error = %v, want %v", err, store.ErrActionNotFound)
+	}
+}
+
+func testCleanup(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustStore(t, s, NewAction("live", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("old1", "alice", clock.Now(), -time.Minute))
+	mustStore(t, s, NewAction("old2", "bob", clock.Now(), -time.Hour))
+
+	n, err := s.Cleanup(ctx)
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Cleanup() = %d, want 2", n)
+	}
+	if _, err := s.Get(ctx, "alice", "live"); err != nil {
+		t.Errorf("Get() live action after Cleanup() error = %v", err)
+	}
+	if _, err := s.Confirm(ctx, "alice", "old1"); err == nil {
+		t.Error("Confirm() expired action after Cleanup() succeeded, want error")
+	}
+}
+
+func testConfirmationsPurge(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	p, ok := s.(store.ActionPurger)
+	if !ok {
+		t.Skip("store does not implement store.ActionPurger")
+	}
+	ctx := context.Background()
+
+	mustStore(t, s, NewAction("confirmed", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("expired", "bob", clock.Now(), -time.Minute))
+	mustStore(t, s, NewAction("pending", "alice", clock.Now(), 24*time.Hour))
+	if _, err := s.Confirm(ctx, "alice", "confirmed"); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if _, err := s.Cleanup(ctx); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	mustStore(t, s, NewAction("recent", "alice", clock.Now(), time.Minute))
+	if err := s.Cancel(ctx, "alice", "recent"); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+
+	n, err := p.PurgeActions(ctx, clock.Now().Add(-time.Hour))
+	if errors.Is(err, store.ErrPurgeUnsupported) {
+		t.Skip("wrapped store does not support purging")
+	}
+	if err != nil || n != 2 {
+		t.Fatalf("PurgeActions() = %d, %v, want 2, nil", n, err)
+	}
+
+	// Pending actions survive any cutoff
+	n, err = p.PurgeActions(ctx, clock.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("PurgeActions() after cutoff = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := s.Get(ctx, "alice", "pending"); err != nil {
+		t.Errorf("Get() pending action after purge error = %v", err)
+	}
+}
+
+func testConfirmationsUserData(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	h, ok := s.(privacy.UserDataHandler)
+	if !ok {
+		t.Skip("store does not implement privacy.UserDataHandler")
+	}
+	ctx := context.Background()
+
+	if data, err := h.ExportUserData(ctx, "alice"); err != nil || data != nil {
+		t.Errorf("ExportUserData() with no data = %v, %v, want nil", data, err)
+	}
+
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("b1", "bob", clock.Now(), time.Minute))
+
+	data, err := h.ExportUserData(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ExportUserData() error = %v", err)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("export is not JSON-serializable: %v", err)
+	}
+	if !strings.Contains(string(b), `"a1"`) || strings.Contains(string(b), `"b1"`) {
+		t.Errorf("ExportUserData() = %s, want only alice's actions", b)
+	}
+
+	if err := h.EraseUserData(ctx, "alice"); err != nil {
+		t.Fatalf("EraseUserData() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "alice", "a1"); err == nil {
+		t.Error("Get() after erasure succeeded")
+	}
+	if _, err := s.Get(ctx, "bob", "b1"); err != nil {
+		t.Errorf("erasure removed another user's action: %v", err)
+	}
+	if err := h.EraseUserData(ctx, "alice"); err != nil {
+		t.Errorf("second EraseUserData() error = %v", err)
+	}
+}
+
+func testConfirmationsRewrite(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	rw, ok := s.(store.ActionRewriter)
+	if !ok {
+		t.Skip("store does not implement store.ActionRewriter")
+	}
+	ctx := context.Background()
+
+	mustStore(t, s, NewAction("a1", "alice", clock.Now(), time.Minute))
+	mustStore(t, s, NewAction("b1", "bob", clock.Now(), time.Minute))
+	held, err := s.Get(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	saved, err := rw.RewriteActions(ctx, "alice", func(action *core.PendingAction) (bool, error) {
+		if action.UserID != "alice" {
+			t.Errorf("RewriteActions() visited %s's action", action.UserID)
+		}
+		action.Input = json.RawMessage(`{"rewritten":true}`)
+		action.Summary = "rewritten"
+		return true, nil
+	})
+	if err != nil || saved != 1 {
+		t.Fatalf("RewriteActions() = %d, %v, want 1, nil", saved, err)
+	}
+
+	got, err := s.Get(ctx, "alice", "a1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got.Input) != `{"rewritten":true}` || got.Summary != "rewritten" {
+		t.Errorf("Get() after rewrite = %+v", got)
+	}
+	if string(held.Input) == `{"rewritten":true}` {
+		t.Error("RewriteActions() modified an action already returned by Get()")
+	}
+	if got.ExpiresAt != held.ExpiresAt || got.IdempotencyKey != held.IdempotencyKey {
+		t.Errorf("rewrite changed other fields: %+v", got)
+	}
+	if other, err := s.Get(ctx, "bob", "b1"); err != nil || other.Summary == "rewritten" {
+		t.Errorf("rewrite affected another user's action: %+v, %v", other, err)
+	}
+	if _, err := s.Confirm(ctx, "alice", "a1"); err != nil {
+		t.Errorf("Confirm() after rewrite error = %v", err)
+	}
+}
+
+func testConcurrentStore(t *testing.T, s store.Confirmations, clock *core.FakeClock) {
+	ctx := context.Background()
+	const users, perUser = 4, 10
+
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		userID := fmt.Sprintf("user-%d", u)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perUser; i++ {
+				action := NewAction(fmt.Sprintf("%s-a%d", userID, i), userID, clock.Now(), time.Minute)
+				if err := s.Store(ctx, action); err != nil {
+					t.Errorf("Store() error = %v", err)
+					return
+				}
+				if _, err := s.Get(ctx, userID, action.ID); err != nil {
+					t.Errorf("Get() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,686 @@
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+	"github.com/becomeliminal/nim-go-sdk/store"
+)
+
+// ConversationsFactory returns a new, empty store for a single subtest.
+type ConversationsFactory func(t *testing.T, clock core.Clock) store.Conversations
+
+// RunConversationsSuite verifies that a store.Conversations implementation
+// behaves like the SDK's built-in stores.
+func RunConversationsSuite(t *testing.T, factory ConversationsFactory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Conversations, clock *core.FakeClock)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"GetMissing", testGetMissingConversation},
+		{"AppendPreservesOrderAndBlocks", testAppendPreservesOrderAndBlocks},
+		{"IsolatesUsers", testConversationsIsolateUsers},
+		{"SetTitle", testSetTitle},
+		{"ListOrderAndLimit", testListOrderAndLimit},
+		{"ListPagination", testListPagination},
+		{"ListInvalidOptions", testListInvalidOptions},
+		{"ArchiveAndPin", testArchiveAndPin},
+		{"Search", testSearch},
+		{"Delete", testDeleteConversation},
+		{"Branching", testBranching},
+		{"UserData", testConversationsUserData},
+		{"Rewrite", testConversationsRewrite},
+		{"Purge", testConversationsPurge},
+		{"ConcurrentAppend", testConcurrentAppend},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := core.NewFakeClock(Epoch)
+			tt.fn(t, factory(t, clock), clock)
+		})
+	}
+}
+
+func mustCreate(t *testing.T, s store.Conversations, userID string) *store.Conversation {
+	t.Helper()
+	conv, err := s.Create(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Create(%s) error = %v", userID, err)
+	}
+	return conv
+}
+
+func mustAppend(t *testing.T, s store.Conversations, msg *store.AppendMessage) {
+	t.Helper()
+	if err := s.Append(context.Background(), msg); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+}
+
+func testCreateAndGet(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	conv := mustCreate(t, s, "alice")
+	if conv.ID == "" || conv.UserID != "alice" || !conv.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("Create() = %+v", conv)
+	}
+
+	got, err := s.Get(context.Background(), "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.ID != conv.ID || got.UserID != "alice" || len(got.Messages) != 0 {
+		t.Errorf("Get() = %+v", got)
+	}
+}
+
+func testGetMissingConversation(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	if _, err := s.Get(context.Background(), "alice", "missing"); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+}
+
+func testAppendPreservesOrderAndBlocks(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	conv := mustCreate(t, s, "alice")
+	usage := &core.TokenUsage{InputTokens: 100, OutputTokens: 20}
+
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "What's my balance?",
+	})
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID, UserID: "alice", Role: "assistant",
+		Blocks: []core.ContentBlock{core.NewToolUseBlock("toolu_1", "get_balance", json.RawMessage(`{"currency":"USD"}`))},
+		Usage:  usage,
+	})
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID, UserID: "alice", Role: "user",
+		Blocks: []core.ContentBlock{core.NewToolResultBlock("toolu_1", `{"balance":"10.00"}`, false)},
+		Tools:  []core.ToolExecution{{ToolUseID: "toolu_1", Tool: "get_balance", DurationMs: 12}},
+	})
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID, UserID: "alice", Role: "assistant", Content: "You have $10.00.",
+	})
+
+	got, err := s.Get(context.Background(), "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got.Messages) != 4 {
+		t.Fatalf("got %d messages, want 4", len(got.Messages))
+	}
+
+	if got.Messages[0].Content != "What's my balance?" || got.Messages[3].Content != "You have $10.00." {
+		t.Errorf("messages out of order: %+v", got.Messages)
+	}
+
+	toolUse := got.Messages[1].ToMessage()
+	if len(toolUse.ContentBlocks) != 1 || toolUse.ContentBlocks[0].ToolUse == nil ||
+		toolUse.ContentBlocks[0].ToolUse.ID != "toolu_1" ||
+		string(toolUse.ContentBlocks[0].ToolUse.Input) != `{"currency":"USD"}` {
+		t.Errorf("tool_use message = %+v", toolUse)
+	}
+	if toolUse.Usage == nil || *toolUse.Usage != *usage {
+		t.Errorf("usage = %+v, want %+v", toolUse.Usage, usage)
+	}
+
+	toolResult := got.Messages[2]
+	if len(toolResult.Blocks) != 1 || toolResult.Blocks[0].ToolResult == nil ||
+		toolResult.Blocks[0].ToolResult.ToolUseID != "toolu_1" {
+		t.Errorf("tool_result message = %+v", toolResult)
+	}
+	if len(toolResult.Tools) != 1 || toolResult.Tools[0].Tool != "get_balance" {
+		t.Errorf("tools = %+v", toolResult.Tools)
+	}
+}
+
+func testConversationsIsolateUsers(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	conv := mustCreate(t, s, "alice")
+
+	calls := map[string]func() error{
+		"Get": func() error { _, err := s.Get(ctx, "mallory", conv.ID); return err },
+		"Append": func() error {
+			return s.Append(ctx, &store.AppendMessage{ConversationID: conv.ID, UserID: "mallory", Role: "user", Content: "hi"})
+		},
+		"SetTitle":      func() error { return s.SetTitle(ctx, "mallory", conv.ID, "pwned") },
+		"SetArchived":   func() error { return s.SetArchived(ctx, "mallory", conv.ID, true) },
+		"SetPinned":     func() error { return s.SetPinned(ctx, "mallory", conv.ID, true) },
+		"SetActiveLeaf": func() error { return s.SetActiveLeaf(ctx, "mallory", conv.ID, "") },
+		"Delete":        func() error { return s.Delete(ctx, "mallory", conv.ID) },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, store.ErrConversationNotFound) {
+			t.Errorf("%s() as other user error = %v, want %v", name, err, store.ErrConversationNotFound)
+		}
+	}
+
+	got, err := s.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() as owner error = %v", err)
+	}
+	if got.Title == "pwned" || got.Archived || got.Pinned || len(got.Messages) != 0 {
+		t.Errorf("conversation modified by other user: %+v", got)
+	}
+
+	list := mustList(t, s, "mallory", store.ListOptions{Archived: store.IncludeArchived})
+	if len(list.Conversations) != 0 {
+		t.Errorf("List() as other user = %+v, want empty", list.Conversations)
+	}
+	found, _ := s.Search(ctx, "mallory", "", store.ListOptions{})
+	if found == nil || len(found.Conversations) != 0 {
+		t.Errorf("Search() as other user = %+v, want empty", found)
+	}
+}
+
+func testSetTitle(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	conv := mustCreate(t, s, "alice")
+
+	if err := s.SetTitle(ctx, "alice", conv.ID, "Balance check"); err != nil {
+		t.Fatalf("SetTitle() error = %v", err)
+	}
+	got, _ := s.Get(ctx, "alice", conv.ID)
+	if got.Title != "Balance check" {
+		t.Errorf("Title = %q, want %q", got.Title, "Balance check")
+	}
+	if err := s.SetTitle(ctx, "alice", "missing", "x"); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("SetTitle() missing error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+}
+
+func testListOrderAndLimit(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	first := mustCreate(t, s, "alice")
+	clock.Advance(time.Second)
+	second := mustCreate(t, s, "alice")
+	clock.Advance(time.Second)
+	third := mustCreate(t, s, "alice")
+	mustCreate(t, s, "bob")
+	clock.Advance(time.Second)
+
+	// Activity moves a conversation to the front.
+	mustAppend(t, s, &store.AppendMessage{ConversationID: first.ID, UserID: "alice", Role: "user", Content: "hi"})
+
+	list := mustList(t, s, "alice", store.ListOptions{})
+	wantIDs(t, "List()", list, first.ID, third.ID, second.ID)
+	if list.NextCursor != "" {
+		t.Errorf("List() NextCursor = %q on the last page", list.NextCursor)
+	}
+
+	limited := mustList(t, s, "alice", store.ListOptions{Limit: 2})
+	wantIDs(t, "List(limit 2)", limited, first.ID, third.ID)
+
+	byCreated := mustList(t, s, "alice", store.ListOptions{Sort: store.SortByCreated})
+	wantIDs(t, "List(sort created)", byCreated, third.ID, second.ID, first.ID)
+}
+
+func testListPagination(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	var want []string
+	for i := 0; i < 7; i++ {
+		conv := mustCreate(t, s, "alice")
+		want = append([]string{conv.ID}, want...)
+		// Every other conversation shares a timestamp, so ties must page correctly.
+		if i%2 == 1 {
+			clock.Advance(time.Second)
+		}
+	}
+
+	for _, sort := range []store.SortField{store.SortByUpdated, store.SortByCreated} {
+		var (
+			got    []string
+			cursor string
+			pages  int
+		)
+		for {
+			page := mustList(t, s, "alice", store.ListOptions{Limit: 3, Cursor: cursor, Sort: sort})
+			for _, conv := range page.Conversations {
+				got = append(got, conv.ID)
+			}
+			pages++
+			if page.NextCursor == "" || pages > 5 {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if pages != 3 || len(got) != len(want) {
+			t.Fatalf("sort %s: paged %d conversations in %d pages, want %d in 3", sort, len(got), pages, len(want))
+		}
+		seen := map[string]bool{}
+		for _, id := range got {
+			if seen[id] {
+				t.Errorf("sort %s: %s returned twice", sort, id)
+			}
+			seen[id] = true
+		}
+	}
+
+	// Pages with distinct timestamps come back newest first.
+	all := mustList(t, s, "alice", store.ListOptions{})
+	if len(all.Conversations) != len(want) || all.Conversations[len(want)-1].CreatedAt.After(all.Conversations[0].CreatedAt) {
+		t.Errorf("List() = %+v, want newest first", all.Conversations)
+	}
+}
+
+func testListInvalidOptions(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	mustCreate(t, s, "alice")
+	mustCreate(t, s, "alice")
+
+	page := mustList(t, s, "alice", store.ListOptions{Limit: 1})
+	if page.NextCursor == "" {
+		t.Fatal("List(limit 1) returned no cursor")
+	}
+
+	if _, err := s.List(ctx, "alice", store.ListOptions{Cursor: "not a cursor"}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("List(bad cursor) error = %v, want %v", err, store.ErrInvalidCursor)
+	}
+	if _, err := s.List(ctx, "alice", store.ListOptions{Cursor: page.NextCursor, Sort: store.SortByCreated}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("List(cursor from other sort) error = %v, want %v", err, store.ErrInvalidCursor)
+	}
+	if _, err := s.List(ctx, "alice", store.ListOptions{Sort: "title"}); !errors.Is(err, store.ErrInvalidListOptions) {
+		t.Errorf("List(unknown sort) error = %v, want %v", err, store.ErrInvalidListOptions)
+	}
+}
+
+func testArchiveAndPin(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	old := mustCreate(t, s, "alice")
+	clock.Advance(time.Second)
+	archived := mustCreate(t, s, "alice")
+	clock.Advance(time.Second)
+	recent := mustCreate(t, s, "alice")
+
+	if err := s.SetArchived(ctx, "alice", archived.ID, true); err != nil {
+		t.Fatalf("SetArchived() error = %v", err)
+	}
+	if err := s.SetPinned(ctx, "alice", old.ID, true); err != nil {
+		t.Fatalf("SetPinned() error = %v", err)
+	}
+
+	wantIDs(t, "List()", mustList(t, s, "alice", store.ListOptions{}), old.ID, recent.ID)
+	wantIDs(t, "List(only archived)", mustList(t, s, "alice", store.ListOptions{Archived: store.OnlyArchived}), archived.ID)
+	wantIDs(t, "List(include archived)", mustList(t, s, "alice", store.ListOptions{Archived: store.IncludeArchived}),
+		old.ID, recent.ID, archived.ID)
+
+	// Paging across the pinned boundary.
+	first := mustList(t, s, "alice", store.ListOptions{Limit: 1})
+	wantIDs(t, "List(page 1)", first, old.ID)
+	wantIDs(t, "List(page 2)", mustList(t, s, "alice", store.ListOptions{Limit: 1, Cursor: first.NextCursor}), recent.ID)
+
+	got, _ := s.Get(ctx, "alice", old.ID)
+	if !got.Pinned || got.Archived || !got.UpdatedAt.Equal(old.UpdatedAt) {
+		t.Errorf("Get() = %+v, want pinned and unchanged UpdatedAt", got.Conversation)
+	}
+
+	if err := s.SetArchived(ctx, "alice", archived.ID, false); err != nil {
+		t.Fatalf("SetArchived(false) error = %v", err)
+	}
+	if err := s.SetPinned(ctx, "alice", old.ID, false); err != nil {
+		t.Fatalf("SetPinned(false) error = %v", err)
+	}
+	wantIDs(t, "List() after restore", mustList(t, s, "alice", store.ListOptions{}), recent.ID, archived.ID, old.ID)
+
+	if err := s.SetPinned(ctx, "alice", "missing", true); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("SetPinned() missing error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+}
+
+func testSearch(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	rent := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: rent.ID, UserID: "alice", Role: "user", Content: "Send $1200 to my landlord"})
+	mustAppend(t, s, &store.AppendMessage{ConversationID: rent.ID, UserID: "alice", Role: "assistant", Content: "Rent sent."})
+	clock.Advance(time.Second)
+
+	savings := mustCreate(t, s, "alice")
+	if err := s.SetTitle(ctx, "alice", savings.ID, "Savings goals"); err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, s, &store.AppendMessage{ConversationID: savings.ID, UserID: "alice", Role: "user", Content: "How much is in savings? 100%_done"})
+	clock.Advance(time.Second)
+
+	other := mustCreate(t, s, "bob")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: other.ID, UserID: "bob", Role: "user", Content: "landlord"})
+
+	search := func(query string, opts store.ListOptions) *store.ConversationPage {
+		t.Helper()
+		page, err := s.Search(ctx, "alice", query, opts)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", query, err)
+		}
+		return page
+	}
+
+	wantIDs(t, `Search("LANDLORD")`, search("LANDLORD", store.ListOptions{}), rent.ID)
+	wantIDs(t, `Search("landlord rent")`, search("landlord rent", store.ListOptions{}), rent.ID)
+	wantIDs(t, `Search("landlord savings")`, search("landlord savings", store.ListOptions{}))
+	wantIDs(t, `Search("goals")`, search("goals", store.ListOptions{}), savings.ID)
+	wantIDs(t, `Search("%_")`, search("%_", store.ListOptions{}), savings.ID)
+	wantIDs(t, `Search("s")`, search("s", store.ListOptions{}), savings.ID, rent.ID)
+
+	page := search("s", store.ListOptions{Limit: 1})
+	wantIDs(t, `Search("s", limit 1)`, page, savings.ID)
+	wantIDs(t, `Search("s", page 2)`, search("s", store.ListOptions{Limit: 1, Cursor: page.NextCursor}), rent.ID)
+
+	if err := s.SetArchived(ctx, "alice", rent.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	wantIDs(t, `Search("landlord") after archive`, search("landlord", store.ListOptions{}))
+	wantIDs(t, `Search("landlord", include archived)`, search("landlord", store.ListOptions{Archived: store.IncludeArchived}), rent.ID)
+
+	// Case folding is not limited to ASCII
+	move := mustCreate(t, s, "alice")
+	if err := s.SetTitle(ctx, "alice", move.ID, "Umzug nach MÜNCHEN"); err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, s, &store.AppendMessage{ConversationID: move.ID, UserID: "alice", Role: "user", Content: "Über die Kaution"})
+	wantIDs(t, `Search("über")`, search("über", store.ListOptions{}), move.ID)
+	wantIDs(t, `Search("ÜBER")`, search("ÜBER", store.ListOptions{}), move.ID)
+	wantIDs(t, `Search("münchen")`, search("münchen", store.ListOptions{}), move.ID)
+}
+
+func mustList(t *testing.T, s store.Conversations, userID string, opts store.ListOptions) *store.ConversationPage {
+	t.Helper()
+	page, err := s.List(context.Background(), userID, opts)
+	if err != nil {
+		t.Fatalf("List(%+v) error = %v", opts, err)
+	}
+	return page
+}
+
+func wantIDs(t *testing.T, call string, page *store.ConversationPage, want ...string) {
+	t.Helper()
+	got := make([]string, len(page.Conversations))
+	for i, conv := range page.Conversations {
+		got[i] = conv.ID
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s = %v, want %v", call, got, want)
+	}
+}
+
+func testBranching(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	conv := mustCreate(t, s, "alice")
+	appendText := func(id, role, content string) {
+		t.Helper()
+		mustAppend(t, s, &store.AppendMessage{ID: id, ConversationID: conv.ID, UserID: "alice", Role: role, Content: content})
+	}
+	branch := func(call string, want ...string) *store.ConversationWithMessages {
+		t.Helper()
+		got, err := s.Get(ctx, "alice", conv.ID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		ids := make([]string, len(got.Messages))
+		for i, msg := range got.Messages {
+			ids[i] = msg.ID
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("%s: active branch = %v, want %v", call, ids, want)
+		}
+		return got
+	}
+
+	appendText("u1", "user", "Send 10 USD to Bob")
+	appendText("a1", "assistant", "Sent.")
+	appendText("u2", "user", "And 5 USD to Carol")
+	appendText("a2", "assistant", "Sent.")
+
+	// Edit u2: branch from its parent.
+	if err := s.SetActiveLeaf(ctx, "alice", conv.ID, "a1"); err != nil {
+		t.Fatalf("SetActiveLeaf() error = %v", err)
+	}
+	branch("after SetActiveLeaf(a1)", "u1", "a1")
+	appendText("u2b", "user", "I meant EUR")
+	appendText("a2b", "assistant", "Sent in EUR.")
+
+	got := branch("after edit", "u1", "a1", "u2b", "a2b")
+	if got.ActiveLeafID != "a2b" || got.Messages[0].ParentID != "" || got.Messages[2].ParentID != "a1" {
+		t.Errorf("Get() = leaf %q, parents %q/%q", got.ActiveLeafID, got.Messages[0].ParentID, got.Messages[2].ParentID)
+	}
+
+	// The abandoned branch is kept and can be restored.
+	if err := s.SetActiveLeaf(ctx, "alice", conv.ID, "a2"); err != nil {
+		t.Fatalf("SetActiveLeaf(a2) error = %v", err)
+	}
+	branch("after restoring", "u1", "a1", "u2", "a2")
+
+	// An empty leaf starts a new root.
+	if err := s.SetActiveLeaf(ctx, "alice", conv.ID, ""); err != nil {
+		t.Fatalf(`SetActiveLeaf("") error = %v`, err)
+	}
+	branch("after clearing")
+	appendText("", "user", "Start over")
+	got, _ = s.Get(ctx, "alice", conv.ID)
+	if len(got.Messages) != 1 || got.Messages[0].ID == "" || got.Messages[0].ParentID != "" || got.Messages[0].Content != "Start over" {
+		t.Errorf("after new root: active branch = %+v", got.Messages)
+	}
+
+	other := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ID: "elsewhere", ConversationID: other.ID, UserID: "alice", Role: "user", Content: "hi"})
+	for _, id := range []string{"missing", "elsewhere"} {
+		if err := s.SetActiveLeaf(ctx, "alice", conv.ID, id); !errors.Is(err, store.ErrMessageNotFound) {
+			t.Errorf("SetActiveLeaf(%s) error = %v, want %v", id, err, store.ErrMessageNotFound)
+		}
+	}
+}
+
+func testDeleteConversation(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	conv := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "hi"})
+
+	if err := s.Delete(ctx, "alice", conv.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "alice", conv.ID); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+	if list := mustList(t, s, "alice", store.ListOptions{}); len(list.Conversations) != 0 {
+		t.Errorf("List() after Delete() = %+v, want empty", list.Conversations)
+	}
+	if err := s.Delete(ctx, "alice", conv.ID); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("second Delete() error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+}
+
+func testConversationsPurge(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	p, ok := s.(store.ConversationPurger)
+	if !ok {
+		t.Skip("store does not implement store.ConversationPurger")
+	}
+	ctx := context.Background()
+
+	stale := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: stale.ID, UserID: "alice", Role: "user", Content: "old"})
+	active := mustCreate(t, s, "alice")
+	other := mustCreate(t, s, "bob")
+
+	// Activity keeps a conversation, however old it is
+	clock.Advance(2 * time.Hour)
+	mustAppend(t, s, &store.AppendMessage{ConversationID: active.ID, UserID: "alice", Role: "user", Content: "new"})
+	mustAppend(t, s, &store.AppendMessage{ConversationID: other.ID, UserID: "bob", Role: "user", Content: "new"})
+
+	n, err := p.PurgeConversations(ctx, clock.Now().Add(-time.Hour))
+	if errors.Is(err, store.ErrPurgeUnsupported) {
+		t.Skip("wrapped store does not support purging")
+	}
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeConversations() = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := s.Get(ctx, "alice", stale.ID); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("Get() purged conversation error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+	list := mustList(t, s, "alice", store.ListOptions{})
+	if len(list.Conversations) != 1 || list.Conversations[0].ID != active.ID {
+		t.Errorf("List() after purge = %+v, want only %s", list.Conversations, active.ID)
+	}
+	if _, err := s.Get(ctx, "bob", other.ID); err != nil {
+		t.Errorf("Get() active conversation after purge error = %v", err)
+	}
+
+	if n, err := p.PurgeConversations(ctx, clock.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("second PurgeConversations() = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func testConversationsUserData(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	h, ok := s.(privacy.UserDataHandler)
+	if !ok {
+		t.Skip("store does not implement privacy.UserDataHandler")
+	}
+	ctx := context.Background()
+
+	if data, err := h.ExportUserData(ctx, "alice"); err != nil || data != nil {
+		t.Errorf("ExportUserData() with no data = %v, %v, want nil", data, err)
+	}
+
+	conv := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "alice's secret"})
+	other := mustCreate(t, s, "bob")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: other.ID, UserID: "bob", Role: "user", Content: "bob's secret"})
+
+	data, err := h.ExportUserData(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ExportUserData() error = %v", err)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("export is not JSON-serializable: %v", err)
+	}
+	if !strings.Contains(string(b), "alice's secret") || strings.Contains(string(b), "bob's secret") {
+		t.Errorf("ExportUserData() = %s, want only alice's messages", b)
+	}
+
+	if err := h.EraseUserData(ctx, "alice"); err != nil {
+		t.Fatalf("EraseUserData() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "alice", conv.ID); !errors.Is(err, store.ErrConversationNotFound) {
+		t.Errorf("Get() after erasure error = %v, want %v", err, store.ErrConversationNotFound)
+	}
+	if data, err := h.ExportUserData(ctx, "alice"); err != nil || data != nil {
+		t.Errorf("ExportUserData() after erasure = %v, %v, want nil", data, err)
+	}
+	if _, err := s.Get(ctx, "bob", other.ID); err != nil {
+		t.Errorf("erasure removed another user's conversation: %v", err)
+	}
+	if err := h.EraseUserData(ctx, "alice"); err != nil {
+		t.Errorf("second EraseUserData() error = %v", err)
+	}
+}
+
+func testConversationsRewrite(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	rw, ok := s.(store.ConversationRewriter)
+	if !ok {
+		t.Skip("store does not implement store.ConversationRewriter")
+	}
+	ctx := context.Background()
+
+	conv := mustCreate(t, s, "alice")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: conv.ID, UserID: "alice", Role: "user", Content: "first"})
+	mustAppend(t, s, &store.AppendMessage{
+		ConversationID: conv.ID,
+		UserID:         "alice",
+		Role:           "assistant",
+		Content:        "second",
+		Blocks:         []core.ContentBlock{{Type: core.TextBlockType, Text: "second"}},
+	})
+	other := mustCreate(t, s, "bob")
+	mustAppend(t, s, &store.AppendMessage{ConversationID: other.ID, UserID: "bob", Role: "user", Content: "bob's"})
+	before, err := s.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	clock.Advance(time.Minute)
+
+	saved, err := rw.RewriteConversations(ctx, "alice", func(c *store.ConversationWithMessages) (bool, error) {
+		if c.UserID != "alice" {
+			t.Errorf("RewriteConversations() visited %s's conversation", c.UserID)
+		}
+		c.Title = "rewritten title"
+		for i := range c.Messages {
+			c.Messages[i].Content = "rewritten " + c.Messages[i].Content
+			c.Messages[i].Blocks = nil
+		}
+		return true, nil
+	})
+	if err != nil || saved != 1 {
+		t.Fatalf("RewriteConversations() = %d, %v, want 1, nil", saved, err)
+	}
+
+	after, err := s.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if after.Title != "rewritten title" {
+		t.Errorf("Title = %q, want %q", after.Title, "rewritten title")
+	}
+	if !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v, want unchanged %v", after.UpdatedAt, before.UpdatedAt)
+	}
+	if len(after.Messages) != 2 || after.Messages[0].Content != "rewritten first" ||
+		after.Messages[1].Content != "rewritten second" || len(after.Messages[1].Blocks) != 0 {
+		t.Errorf("Messages = %+v, want rewritten content without blocks", after.Messages)
+	}
+	if after.Messages[1].ID != before.Messages[1].ID || after.Messages[1].ParentID != before.Messages[1].ParentID {
+		t.Errorf("rewrite changed message identity: %+v", after.Messages[1])
+	}
+
+	// Unchanged conversations and failures are not saved
+	if saved, err := rw.RewriteConversations(ctx, "alice", func(*store.ConversationWithMessages) (bool, error) {
+		return false, nil
+	}); err != nil || saved != 0 {
+		t.Errorf("RewriteConversations() with no changes = %d, %v, want 0, nil", saved, err)
+	}
+	errRewrite := errors.New("rewrite failed")
+	if _, err := rw.RewriteConversations(ctx, "alice", func(c *store.ConversationWithMessages) (bool, error) {
+		c.Title = "lost"
+		return true, errRewrite
+	}); !errors.Is(err, errRewrite) {
+		t.Errorf("RewriteConversations() error = %v, want %v", err, errRewrite)
+	}
+	if got, _ := s.Get(ctx, "alice", conv.ID); got.Title != "rewritten title" {
+		t.Errorf("failed rewrite saved title %q", got.Title)
+	}
+}
+
+func testConcurrentAppend(t *testing.T, s store.Conversations, clock *core.FakeClock) {
+	ctx := context.Background()
+	conv := mustCreate(t, s, "alice")
+
+	const writers, perWriter = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				err := s.Append(ctx, &store.AppendMessage{
+					ConversationID: conv.ID, UserID: "alice", Role: "user", Content: fmt.Sprintf("%d", i),
+				})
+				if err != nil {
+					t.Errorf("Append() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.Get(ctx, "alice", conv.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got.Messages) != writers*perWriter {
+		t.Errorf("got %d messages, want %d", len(got.Messages), writers*perWriter)
+	}
+}
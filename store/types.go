@@ -1,14 +1,27 @@
 package store
 
-import "time"
+import (
+	"time"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+)
+
+// DefaultTitle is the title of a newly created conversation.
+const DefaultTitle = "New conversation"
 
 // Conversation represents conversation metadata.
 type Conversation struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	Title     string    `json:"title"`
+	Archived  bool      `json:"archived"`
+	Pinned    bool      `json:"pinned"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// ActiveLeafID is the last message on the active branch, or empty if
+	// the branch has no messages.
+	ActiveLeafID string `json:"active_leaf_id,omitempty"`
 }
 
 // ConversationWithMessages includes the full message history.
@@ -19,19 +32,67 @@ type ConversationWithMessages struct {
 
 // StoredMessage represents a persisted message.
 type StoredMessage struct {
-	ID        string        `json:"id"`
-	Role      string        `json:"role"`
-	Content   string        `json:"content"`
-	Blocks    []interface{} `json:"blocks,omitempty"`
-	Tools     []interface{} `json:"tools,omitempty"`
-	CreatedAt time.Time     `json:"created_at"`
+	ID        string               `json:"id"`
+	ParentID  string               `json:"parent_id,omitempty"`
+	Role      string               `json:"role"`
+	Content   string               `json:"content"`
+	Blocks    []core.ContentBlock  `json:"blocks,omitempty"`
+	Tools     []core.ToolExecution `json:"tools,omitempty"`
+	Usage     *core.TokenUsage     `json:"usage,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// ToMessage converts the stored message back into a core.Message,
+// including its content blocks so tool context survives a resume.
+func (m *StoredMessage) ToMessage() core.Message {
+	return core.Message{
+		Role:          core.Role(m.Role),
+		Content:       m.Content,
+		ContentBlocks: m.Blocks,
+		Usage:         m.Usage,
+	}
+}
+
+// ActiveBranch returns the messages on the path from the root to leafID,
+// oldest first. messages may be in any order and include other branches.
+func ActiveBranch(messages []StoredMessage, leafID string) []StoredMessage {
+	byID := make(map[string]int, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = i
+	}
+
+	branch := []StoredMessage{}
+	for id := leafID; id != "" && len(branch) < len(messages); {
+		i, ok := byID[id]
+		if !ok {
+			break
+		}
+		branch = append(branch, messages[i])
+		id = messages[i].ParentID
+	}
+
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
 }
 
 // AppendMessage contains data for adding a message to a conversation.
 type AppendMessage struct {
+	// ID is the new message's ID. If empty, the store generates one.
+	ID string
+
 	ConversationID string
+	UserID         string
 	Role           string
 	Content        string
-	Blocks         []interface{}
-	Tools          []interface{}
+
+	// Blocks are the message's content blocks (text, tool_use, tool_result).
+	Blocks []core.ContentBlock
+
+	// Tools records the executions whose results are in Blocks.
+	Tools []core.ToolExecution
+
+	// Usage is the token usage of the API call that produced the message.
+	Usage *core.TokenUsage
 }
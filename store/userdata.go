@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"sort"
+
+	"github.com/becomeliminal/nim-go-sdk/core"
+	"github.com/becomeliminal/nim-go-sdk/privacy"
+)
+
+// ExportUserData returns the user's conversations with the messages on
+// every branch, oldest conversation first.
+func (m *MemoryConversations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := m.byUser[userID]
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	export := make([]ConversationWithMessages, 0, len(ids))
+	for _, id := range ids {
+		if conv, ok := m.conversations[id]; ok {
+			export = append(export, ConversationWithMessages{
+				Conversation: conv.Conversation,
+				Messages:     append([]StoredMessage(nil), conv.Messages...),
+			})
+		}
+	}
+	sort.SliceStable(export, func(i, j int) bool {
+		return export[i].CreatedAt.Before(export[j].CreatedAt)
+	})
+	return export, nil
+}
+
+// EraseUserData deletes all of the user's conversations.
+func (m *MemoryConversations) EraseUserData(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range m.byUser[userID] {
+		delete(m.conversations, id)
+	}
+	delete(m.byUser, userID)
+	return nil
+}
+
+// ExportUserData returns the user's stored actions, oldest first.
+func (m *MemoryConfirmations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var export []*core.PendingAction
+	for _, action := range m.actions {
+		if action.UserID == userID {
+			a := *action
+			export = append(export, &a)
+		}
+	}
+	if len(export) == 0 {
+		return nil, nil
+	}
+	sortActions(export)
+	return export, nil
+}
+
+// EraseUserData deletes all of the user's actions.
+func (m *MemoryConfirmations) EraseUserData(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, action := range m.actions {
+		if action.UserID != userID {
+			continue
+		}
+		if m.byIdempotency[action.IdempotencyKey] == id {
+			delete(m.byIdempotency, action.IdempotencyKey)
+		}
+		delete(m.actions, id)
+	}
+	return nil
+}
+
+// ExportUserData returns the user's cached actions, oldest first.
+func (r *RistrettoConfirmations) ExportUserData(ctx context.Context, userID string) (any, error) {
+	var export []*core.PendingAction
+	for _, action := range r.userActions(userID) {
+		a := *action
+		export = append(export, &a)
+	}
+	if len(export) == 0 {
+		return nil, nil
+	}
+	sortActions(export)
+	return export, nil
+}
+
+// EraseUserData deletes all of the user's cached actions.
+func (r *RistrettoConfirmations) EraseUserData(ctx context.Context, userID string) error {
+	r.resolveMu.Lock()
+	defer r.resolveMu.Unlock()
+
+	for _, action := range r.userActions(userID) {
+		r.delete(action)
+	}
+
+	r.mu.Lock()
+	delete(r.actionsByUser, userID)
+	r.mu.Unlock()
+
+	r.cache.Wait()
+	r.idempotency.Wait()
+	return nil
+}
+
+// userActions returns the user's actions that are still in the cache.
+func (r *RistrettoConfirmations) userActions(userID string) []*core.PendingAction {
+	r.mu.RLock()
+	ids := make([]string, 0, len(r.actionsByUser[userID]))
+	for id := range r.actionsByUser[userID] {
+		ids = append(ids, id)
+	}
+	r.mu.RUnlock()
+
+	actions := make([]*core.PendingAction, 0, len(ids))
+	for _, id := range ids {
+		if val, found := r.cache.Get(r.actionKey(userID, id)); found {
+			actions = append(actions, val.(*core.PendingAction))
+		}
+	}
+	return actions
+}
+
+func sortActions(actions []*core.PendingAction) {
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].CreatedAt != actions[j].CreatedAt {
+			return actions[i].CreatedAt < actions[j].CreatedAt
+		}
+		return actions[i].ID < actions[j].ID
+	})
+}
+
+// Verify the in-memory stores implement privacy.UserDataHandler.
+var (
+	_ privacy.UserDataHandler = (*MemoryConversations)(nil)
+	_ privacy.UserDataHandler = (*MemoryConfirmations)(nil)
+	_ privacy.UserDataHandler = (*RistrettoConfirmations)(nil)
+)